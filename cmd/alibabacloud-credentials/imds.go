@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/server"
+)
+
+func runIMDS(args []string, stderr io.Writer) int {
+	var pf providerFlags
+	var listen, roleName string
+	var disableIMDSv1, allowRemote bool
+	flagSet := newFlagSet("imds", stderr)
+	pf.register(flagSet)
+	flagSet.StringVar(&listen, "listen", "", "the tcp address to listen on, such as 127.0.0.1:8080")
+	flagSet.StringVar(&roleName, "role-name", "EmulatedRole", "the name of the RAM role attached to the emulated instance")
+	flagSet.BoolVar(&disableIMDSv1, "disable-imdsv1", false, "require the metadata token in the requests")
+	flagSet.BoolVar(&allowRemote, "allow-remote", false, "allow listening on a non-loopback address, the credentials are served to anyone who can connect")
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+
+	if flagSet.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected arguments: %s\n", strings.Join(flagSet.Args(), " "))
+		return 2
+	}
+
+	handler, err := buildIMDSHandler(&pf, listen, roleName, disableIMDSv1, allowRemote)
+	if err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return 2
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Fprintf(stderr, "listen failed: %s\n", err.Error())
+		return 1
+	}
+
+	httpServer := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return serveUntilShutdown(httpServer, listener, stderr)
+}
+
+func buildIMDSHandler(pf *providerFlags, listen string, roleName string, disableIMDSv1 bool, allowRemote bool) (handler *server.IMDSHandler, err error) {
+	if listen == "" {
+		err = errors.New("-listen is required")
+		return
+	}
+
+	// the metadata service has no authentication, any caller which can connect gets the credentials, so only the loopback addresses are allowed by default
+	if !allowRemote && !isLoopbackAddress(listen) {
+		err = fmt.Errorf("%s is not a loopback address, the credentials are served without authentication, set -allow-remote to listen on it", listen)
+		return
+	}
+
+	provider, err := pf.getCredentialsProvider()
+	if err != nil {
+		return
+	}
+
+	return server.NewIMDSHandlerBuilder().
+		WithCredentialsProvider(provider).
+		WithRoleName(roleName).
+		WithDisableIMDSv1(disableIMDSv1).
+		Build()
+}
+
+// isLoopbackAddress reports whether the host of address is localhost or a loopback ip,
+// the empty host listens on all the interfaces
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunIMDSError(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, run([]string{"imds"}, nil, &stdout, &stderr))
+	assert.Equal(t, "-listen is required\n", stderr.String())
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"imds", "-listen", "127.0.0.1:0", "-role-name", ""}, nil, &stdout, &stderr))
+	assert.Equal(t, "the role name is invalid\n", stderr.String())
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"imds", "-listen", "127.0.0.1:0", "extra"}, nil, &stdout, &stderr))
+	assert.Equal(t, "unexpected arguments: extra\n", stderr.String())
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"imds", "-listen", ":0"}, nil, &stdout, &stderr))
+	assert.Equal(t, ":0 is not a loopback address, the credentials are served without authentication, set -allow-remote to listen on it\n", stderr.String())
+}
+
+func TestIsLoopbackAddress(t *testing.T) {
+	assert.True(t, isLoopbackAddress("127.0.0.1:8080"))
+	assert.True(t, isLoopbackAddress("127.0.0.2:8080"))
+	assert.True(t, isLoopbackAddress("[::1]:8080"))
+	assert.True(t, isLoopbackAddress("localhost:8080"))
+	assert.False(t, isLoopbackAddress(":8080"))
+	assert.False(t, isLoopbackAddress("0.0.0.0:8080"))
+	assert.False(t, isLoopbackAddress("[::]:8080"))
+	assert.False(t, isLoopbackAddress("192.168.0.1:8080"))
+	assert.False(t, isLoopbackAddress("example.com:8080"))
+	assert.False(t, isLoopbackAddress("127.0.0.1"))
+}
+
+func TestRunIMDSWithAllowRemote(t *testing.T) {
+	rollback := mockDefaultCredentialsProvider(newTestSTSProvider())
+	defer rollback()
+
+	signals := make(chan chan<- os.Signal, 1)
+	originNotifyShutdown := notifyShutdown
+	notifyShutdown = func(c chan<- os.Signal) {
+		signals <- c
+	}
+	defer func() {
+		notifyShutdown = originNotifyShutdown
+	}()
+
+	var stdout, stderr bytes.Buffer
+	done := make(chan int, 1)
+	go func() {
+		done <- run([]string{"imds", "-listen", ":0", "-allow-remote"}, nil, &stdout, &stderr)
+	}()
+	shutdown := <-signals
+	assert.True(t, strings.HasPrefix(stderr.String(), "serving credentials on tcp://"))
+
+	shutdown <- os.Interrupt
+	select {
+	case code := <-done:
+		assert.Equal(t, 0, code)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the server is not stopped")
+	}
+}
+
+func TestRunIMDS(t *testing.T) {
+	rollback := mockDefaultCredentialsProvider(newTestSTSProvider())
+	defer rollback()
+
+	signals := make(chan chan<- os.Signal, 1)
+	originNotifyShutdown := notifyShutdown
+	notifyShutdown = func(c chan<- os.Signal) {
+		signals <- c
+	}
+	defer func() {
+		notifyShutdown = originNotifyShutdown
+	}()
+
+	var stdout, stderr bytes.Buffer
+	done := make(chan int, 1)
+	go func() {
+		done <- run([]string{"imds", "-listen", "127.0.0.1:0", "-role-name", "role"}, nil, &stdout, &stderr)
+	}()
+	shutdown := <-signals
+
+	output := stderr.String()
+	assert.True(t, strings.HasPrefix(output, "serving credentials on tcp://127.0.0.1:"))
+	address := strings.TrimSpace(strings.TrimPrefix(output, "serving credentials on tcp://"))
+
+	res, err := http.Get("http://" + address + "/latest/meta-data/ram/security-credentials/role")
+	assert.Nil(t, err)
+	content, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Contains(t, string(content), `"AccessKeyId":"akid","AccessKeySecret":"aksecret","Expiration":"2121-01-02T03:04:05Z","SecurityToken":"ststoken"`)
+
+	shutdown <- os.Interrupt
+	select {
+	case code := <-done:
+		assert.Equal(t, 0, code)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the server is not stopped")
+	}
+}
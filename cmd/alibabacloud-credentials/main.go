@@ -0,0 +1,311 @@
+// Command alibabacloud-credentials resolves the credentials by the default credentials provider chain or a profile,
+// prints them, runs a command with them, or serves them as a credentials URI or an emulated ECS metadata service.
+//
+// Usage:
+//
+//	alibabacloud-credentials get [-profile name | -cli-profile name] [-format json|export|process] [-show-secrets]
+//	alibabacloud-credentials exec [-profile name | -cli-profile name] -- command [args...]
+//	alibabacloud-credentials serve [-profile name | -cli-profile name] (-listen addr | -socket path) [-token-file path] [-allow-uid uids] [-allow-remote]
+//	alibabacloud-credentials imds [-profile name | -cli-profile name] -listen addr [-role-name name] [-disable-imdsv1] [-allow-remote]
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/providers"
+)
+
+const maskedValue = "******"
+
+const usage = `Usage:
+  alibabacloud-credentials get [-profile name | -cli-profile name] [-format json|export|process] [-show-secrets]
+  alibabacloud-credentials exec [-profile name | -cli-profile name] -- command [args...]
+  alibabacloud-credentials serve [-profile name | -cli-profile name] (-listen addr | -socket path) [-token-file path] [-allow-uid uids] [-allow-remote]
+  alibabacloud-credentials imds [-profile name | -cli-profile name] -listen addr [-role-name name] [-disable-imdsv1] [-allow-remote]
+
+The credentials are resolved by the default credentials provider chain unless a profile is specified.
+The access key secret and the security token are masked unless -show-secrets is set,
+the export and process formats require -show-secrets.
+The serve command requires the callers to send the bearer token of -token-file or ALIBABA_CLOUD_CREDENTIALS_URI_TOKEN,
+or to connect to the unix socket by the uids of -allow-uid. It only listens on the loopback addresses unless -allow-remote is set.
+The imds command emulates the ECS metadata service, set ALIBABA_CLOUD_IMDS_ENDPOINT to its address to use it.
+It serves the credentials to anyone who can connect without authentication, so it only listens on the loopback
+addresses unless -allow-remote is set.
+`
+
+// the hook for test
+var newDefaultCredentialsProvider = func() providers.CredentialsProvider {
+	return providers.NewDefaultCredentialsProvider()
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the subcommand in args and returns the exit code
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	switch args[0] {
+	case "get":
+		return runGet(args[1:], stdout, stderr)
+	case "exec":
+		return runExec(args[1:], stdin, stdout, stderr)
+	case "serve":
+		return runServe(args[1:], stderr)
+	case "imds":
+		return runIMDS(args[1:], stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n%s", args[0], usage)
+		return 2
+	}
+}
+
+// providerFlags are the flags to choose the credentials provider
+type providerFlags struct {
+	profileName    string
+	cliProfileName string
+}
+
+func (f *providerFlags) register(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&f.profileName, "profile", "", "the profile name in the credentials file (~/.alibabacloud/credentials)")
+	flagSet.StringVar(&f.cliProfileName, "cli-profile", "", "the profile name in the config file of Aliyun CLI (~/.aliyun/config.json)")
+}
+
+func (f *providerFlags) getCredentialsProvider() (provider providers.CredentialsProvider, err error) {
+	switch {
+	case f.profileName != "" && f.cliProfileName != "":
+		err = errors.New("-profile and -cli-profile cannot be used together")
+	case f.profileName != "":
+		provider, err = providers.NewProfileCredentialsProviderBuilder().WithProfileName(f.profileName).Build()
+	case f.cliProfileName != "":
+		provider, err = providers.NewCLIProfileCredentialsProviderBuilder().WithProfileName(f.cliProfileName).Build()
+	default:
+		provider = newDefaultCredentialsProvider()
+	}
+	return
+}
+
+func (f *providerFlags) getCredentials() (cc *providers.Credentials, err error) {
+	provider, err := f.getCredentialsProvider()
+	if err != nil {
+		return
+	}
+	return provider.GetCredentials()
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagSet.SetOutput(stderr)
+	flagSet.Usage = func() {
+		fmt.Fprint(stderr, usage)
+	}
+	return flagSet
+}
+
+func runGet(args []string, stdout io.Writer, stderr io.Writer) int {
+	var pf providerFlags
+	var format string
+	var showSecrets bool
+	flagSet := newFlagSet("get", stderr)
+	pf.register(flagSet)
+	flagSet.StringVar(&format, "format", "json", "the output format: json, export or process")
+	flagSet.BoolVar(&showSecrets, "show-secrets", false, "print the access key secret and the security token without mask")
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+
+	if flagSet.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected arguments: %s\n", strings.Join(flagSet.Args(), " "))
+		return 2
+	}
+
+	// validate the format before resolving the credentials to avoid a remote call on invalid flags
+	if err := checkFormat(format, showSecrets); err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return 2
+	}
+
+	cc, err := pf.getCredentials()
+	if err != nil {
+		fmt.Fprintf(stderr, "get credentials failed: %s\n", err.Error())
+		return 1
+	}
+
+	if !showSecrets {
+		cc = maskCredentials(cc)
+	}
+
+	output, err := formatCredentials(cc, format)
+	if err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return 2
+	}
+	fmt.Fprint(stdout, output)
+	return 0
+}
+
+// checkFormat validates the format, the masked credentials are only printed as JSON,
+// the export and process formats are consumed by the programs which cannot use the masked secrets
+func checkFormat(format string, showSecrets bool) error {
+	switch format {
+	case "json":
+		return nil
+	case "export", "process":
+		if !showSecrets {
+			return fmt.Errorf("-format %s requires -show-secrets, the masked secrets cannot be used", format)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported format %q, support: json, export, process", format)
+	}
+}
+
+func runExec(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	var pf providerFlags
+	flagSet := newFlagSet("exec", stderr)
+	pf.register(flagSet)
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+
+	if flagSet.NArg() == 0 {
+		fmt.Fprintf(stderr, "the command is missing\n%s", usage)
+		return 2
+	}
+
+	cc, err := pf.getCredentials()
+	if err != nil {
+		fmt.Fprintf(stderr, "get credentials failed: %s\n", err.Error())
+		return 1
+	}
+
+	cmd := exec.Command(flagSet.Arg(0), flagSet.Args()[1:]...)
+	cmd.Env = buildEnv(os.Environ(), cc)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+		fmt.Fprintf(stderr, "run command failed: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
+// buildEnv replaces the credentials in environ, the security token is removed for the access key
+func buildEnv(environ []string, cc *providers.Credentials) (env []string) {
+	values := map[string]string{
+		"ALIBABA_CLOUD_ACCESS_KEY_ID":     cc.AccessKeyId,
+		"ALIBABA_CLOUD_ACCESS_KEY_SECRET": cc.AccessKeySecret,
+		"ALIBABA_CLOUD_SECURITY_TOKEN":    cc.SecurityToken,
+	}
+	for _, item := range environ {
+		key := strings.SplitN(item, "=", 2)[0]
+		if _, ok := values[key]; ok {
+			continue
+		}
+		env = append(env, item)
+	}
+
+	for _, key := range []string{"ALIBABA_CLOUD_ACCESS_KEY_ID", "ALIBABA_CLOUD_ACCESS_KEY_SECRET", "ALIBABA_CLOUD_SECURITY_TOKEN"} {
+		if values[key] != "" {
+			env = append(env, key+"="+values[key])
+		}
+	}
+	return
+}
+
+func maskCredentials(cc *providers.Credentials) *providers.Credentials {
+	masked := *cc
+	if masked.AccessKeySecret != "" {
+		masked.AccessKeySecret = maskedValue
+	}
+	if masked.SecurityToken != "" {
+		masked.SecurityToken = maskedValue
+	}
+	return &masked
+}
+
+type credentialsOutput struct {
+	AccessKeyId     string `json:"AccessKeyId"`
+	AccessKeySecret string `json:"AccessKeySecret"`
+	SecurityToken   string `json:"SecurityToken,omitempty"`
+	Expiration      string `json:"Expiration,omitempty"`
+	ProviderName    string `json:"ProviderName"`
+}
+
+// processOutput is the output format of the credential_process command, see providers.ProcessCredentialsProvider
+type processOutput struct {
+	Version         int    `json:"Version"`
+	AccessKeyId     string `json:"AccessKeyId"`
+	AccessKeySecret string `json:"AccessKeySecret"`
+	SecurityToken   string `json:"SecurityToken,omitempty"`
+	Expiration      string `json:"Expiration,omitempty"`
+}
+
+func formatCredentials(cc *providers.Credentials, format string) (output string, err error) {
+	var expiration string
+	if !cc.Expiration.IsZero() {
+		expiration = cc.Expiration.UTC().Format(time.RFC3339)
+	}
+
+	switch format {
+	case "json":
+		var content []byte
+		content, err = json.MarshalIndent(&credentialsOutput{
+			AccessKeyId:     cc.AccessKeyId,
+			AccessKeySecret: cc.AccessKeySecret,
+			SecurityToken:   cc.SecurityToken,
+			Expiration:      expiration,
+			ProviderName:    cc.ProviderName,
+		}, "", "  ")
+		output = string(content) + "\n"
+	case "process":
+		var content []byte
+		content, err = json.MarshalIndent(&processOutput{
+			Version:         1,
+			AccessKeyId:     cc.AccessKeyId,
+			AccessKeySecret: cc.AccessKeySecret,
+			SecurityToken:   cc.SecurityToken,
+			Expiration:      expiration,
+		}, "", "  ")
+		output = string(content) + "\n"
+	case "export":
+		var builder strings.Builder
+		fmt.Fprintf(&builder, "export ALIBABA_CLOUD_ACCESS_KEY_ID=%s\n", shellQuote(cc.AccessKeyId))
+		fmt.Fprintf(&builder, "export ALIBABA_CLOUD_ACCESS_KEY_SECRET=%s\n", shellQuote(cc.AccessKeySecret))
+		if cc.SecurityToken != "" {
+			fmt.Fprintf(&builder, "export ALIBABA_CLOUD_SECURITY_TOKEN=%s\n", shellQuote(cc.SecurityToken))
+		} else {
+			builder.WriteString("unset ALIBABA_CLOUD_SECURITY_TOKEN\n")
+		}
+		output = builder.String()
+	default:
+		err = fmt.Errorf("unsupported format %q, support: json, export, process", format)
+	}
+	return
+}
+
+// shellQuote quotes the value for the POSIX shells
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+type testProvider struct {
+	credentials *providers.Credentials
+	err         error
+}
+
+func (provider *testProvider) GetCredentials() (*providers.Credentials, error) {
+	return provider.credentials, provider.err
+}
+
+func (provider *testProvider) GetProviderName() string {
+	return "test"
+}
+
+func mockDefaultCredentialsProvider(provider providers.CredentialsProvider) (rollback func()) {
+	origin := newDefaultCredentialsProvider
+	newDefaultCredentialsProvider = func() providers.CredentialsProvider {
+		return provider
+	}
+	return func() {
+		newDefaultCredentialsProvider = origin
+	}
+}
+
+func newTestSTSProvider() providers.CredentialsProvider {
+	return &testProvider{
+		credentials: &providers.Credentials{
+			AccessKeyId:     "akid",
+			AccessKeySecret: "aksecret",
+			SecurityToken:   "ststoken",
+			Expiration:      time.Date(2121, 1, 2, 3, 4, 5, 0, time.UTC),
+			ProviderName:    "test",
+		},
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, run(nil, nil, &stdout, &stderr))
+	assert.True(t, strings.HasPrefix(stderr.String(), "Usage:"))
+
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{"help"}, nil, &stdout, &stderr))
+	assert.True(t, strings.HasPrefix(stdout.String(), "Usage:"))
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"invalid"}, nil, &stdout, &stderr))
+	assert.True(t, strings.HasPrefix(stderr.String(), "unknown command \"invalid\"\nUsage:"))
+}
+
+func TestRunGet(t *testing.T) {
+	rollback := mockDefaultCredentialsProvider(newTestSTSProvider())
+	defer rollback()
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, run([]string{"get"}, nil, &stdout, &stderr))
+	assert.Equal(t, `{
+  "AccessKeyId": "akid",
+  "AccessKeySecret": "******",
+  "SecurityToken": "******",
+  "Expiration": "2121-01-02T03:04:05Z",
+  "ProviderName": "test"
+}
+`, stdout.String())
+
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{"get", "-show-secrets", "-format", "process"}, nil, &stdout, &stderr))
+	assert.Equal(t, `{
+  "Version": 1,
+  "AccessKeyId": "akid",
+  "AccessKeySecret": "aksecret",
+  "SecurityToken": "ststoken",
+  "Expiration": "2121-01-02T03:04:05Z"
+}
+`, stdout.String())
+
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{"get", "-show-secrets", "-format", "export"}, nil, &stdout, &stderr))
+	assert.Equal(t, "export ALIBABA_CLOUD_ACCESS_KEY_ID='akid'\n"+
+		"export ALIBABA_CLOUD_ACCESS_KEY_SECRET='aksecret'\n"+
+		"export ALIBABA_CLOUD_SECURITY_TOKEN='ststoken'\n", stdout.String())
+	assert.Equal(t, "", stderr.String())
+
+	stdout.Reset()
+	assert.Equal(t, 2, run([]string{"get", "-format", "yaml"}, nil, &stdout, &stderr))
+	assert.Equal(t, "", stdout.String())
+	assert.Equal(t, "unsupported format \"yaml\", support: json, export, process\n", stderr.String())
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"get", "-format", "export"}, nil, &stdout, &stderr))
+	assert.Equal(t, "", stdout.String())
+	assert.Equal(t, "-format export requires -show-secrets, the masked secrets cannot be used\n", stderr.String())
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"get", "-format", "process"}, nil, &stdout, &stderr))
+	assert.Equal(t, "", stdout.String())
+	assert.Equal(t, "-format process requires -show-secrets, the masked secrets cannot be used\n", stderr.String())
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"get", "extra"}, nil, &stdout, &stderr))
+	assert.Equal(t, "unexpected arguments: extra\n", stderr.String())
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"get", "-invalid"}, nil, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "flag provided but not defined: -invalid")
+}
+
+func TestRunGetError(t *testing.T) {
+	rollback := mockDefaultCredentialsProvider(&testProvider{err: errors.New("no credentials")})
+	defer rollback()
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 1, run([]string{"get"}, nil, &stdout, &stderr))
+	assert.Equal(t, "", stdout.String())
+	assert.Equal(t, "get credentials failed: no credentials\n", stderr.String())
+
+	stderr.Reset()
+	assert.Equal(t, 1, run([]string{"get", "-profile", "a", "-cli-profile", "b"}, nil, &stdout, &stderr))
+	assert.Equal(t, "get credentials failed: -profile and -cli-profile cannot be used together\n", stderr.String())
+
+	// the format is checked before getting the credentials
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"get", "-format", "yaml"}, nil, &stdout, &stderr))
+	assert.Equal(t, "unsupported format \"yaml\", support: json, export, process\n", stderr.String())
+}
+
+func TestRunGetWithProfile(t *testing.T) {
+	file := path.Join(os.TempDir(), "alibabacloud-credentials-cmd-test")
+	err := ioutil.WriteFile(file, []byte(`[custom]
+type = access_key
+access_key_id = foo
+access_key_secret = bar
+`), 0600)
+	assert.Nil(t, err)
+	defer os.Remove(file)
+
+	origin := os.Getenv("ALIBABA_CLOUD_CREDENTIALS_FILE")
+	os.Setenv("ALIBABA_CLOUD_CREDENTIALS_FILE", file)
+	defer os.Setenv("ALIBABA_CLOUD_CREDENTIALS_FILE", origin)
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, run([]string{"get", "-profile", "custom", "-format", "export", "-show-secrets"}, nil, &stdout, &stderr))
+	assert.Equal(t, "export ALIBABA_CLOUD_ACCESS_KEY_ID='foo'\n"+
+		"export ALIBABA_CLOUD_ACCESS_KEY_SECRET='bar'\n"+
+		"unset ALIBABA_CLOUD_SECURITY_TOKEN\n", stdout.String())
+
+	stdout.Reset()
+	assert.Equal(t, 1, run([]string{"get", "-profile", "notexist"}, nil, &stdout, &stderr))
+	assert.Equal(t, "", stdout.String())
+	assert.Contains(t, stderr.String(), "get credentials failed:")
+}
+
+func TestRunExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test uses sh")
+	}
+
+	rollback := mockDefaultCredentialsProvider(newTestSTSProvider())
+	defer rollback()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"exec", "--", "sh", "-c", `echo "$ALIBABA_CLOUD_ACCESS_KEY_ID $ALIBABA_CLOUD_ACCESS_KEY_SECRET $ALIBABA_CLOUD_SECURITY_TOKEN"; read line; echo "$line"; exit 3`}
+	assert.Equal(t, 3, run(args, strings.NewReader("input\n"), &stdout, &stderr))
+	assert.Equal(t, "akid aksecret ststoken\ninput\n", stdout.String())
+	assert.Equal(t, "", stderr.String())
+
+	stdout.Reset()
+	assert.Equal(t, 2, run([]string{"exec"}, nil, &stdout, &stderr))
+	assert.True(t, strings.HasPrefix(stderr.String(), "the command is missing\nUsage:"))
+
+	stderr.Reset()
+	assert.Equal(t, 1, run([]string{"exec", "--", "/notexist/command"}, nil, &stdout, &stderr))
+	assert.True(t, strings.HasPrefix(stderr.String(), "run command failed:"))
+}
+
+func TestBuildEnv(t *testing.T) {
+	env := buildEnv([]string{
+		"PATH=/usr/bin",
+		"ALIBABA_CLOUD_ACCESS_KEY_ID=old",
+		"ALIBABA_CLOUD_SECURITY_TOKEN=old",
+	}, &providers.Credentials{
+		AccessKeyId:     "akid",
+		AccessKeySecret: "aksecret",
+	})
+	assert.Equal(t, []string{
+		"PATH=/usr/bin",
+		"ALIBABA_CLOUD_ACCESS_KEY_ID=akid",
+		"ALIBABA_CLOUD_ACCESS_KEY_SECRET=aksecret",
+	}, env)
+}
+
+func TestMaskCredentials(t *testing.T) {
+	cc := &providers.Credentials{
+		AccessKeyId:     "akid",
+		AccessKeySecret: "aksecret",
+	}
+	masked := maskCredentials(cc)
+	assert.Equal(t, "akid", masked.AccessKeyId)
+	assert.Equal(t, "******", masked.AccessKeySecret)
+	assert.Equal(t, "", masked.SecurityToken)
+	// the origin credentials are not modified
+	assert.Equal(t, "aksecret", cc.AccessKeySecret)
+}
+
+func TestFormatCredentialsWithoutExpiration(t *testing.T) {
+	output, err := formatCredentials(&providers.Credentials{
+		AccessKeyId:     "akid",
+		AccessKeySecret: "it's",
+		ProviderName:    "static_ak",
+	}, "json")
+	assert.Nil(t, err)
+	var result map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(output), &result))
+	assert.Equal(t, map[string]interface{}{
+		"AccessKeyId":     "akid",
+		"AccessKeySecret": "it's",
+		"ProviderName":    "static_ak",
+	}, result)
+
+	output, err = formatCredentials(&providers.Credentials{
+		AccessKeyId:     "akid",
+		AccessKeySecret: "it's",
+	}, "export")
+	assert.Nil(t, err)
+	assert.Contains(t, output, `export ALIBABA_CLOUD_ACCESS_KEY_SECRET='it'\''s'`)
+}
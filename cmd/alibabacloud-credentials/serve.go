@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/server"
+)
+
+// the hook for test
+var notifyShutdown = func(c chan<- os.Signal) {
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+}
+
+func runServe(args []string, stderr io.Writer) int {
+	var pf providerFlags
+	var listen, socket, tokenFile, allowUID string
+	var allowRemote bool
+	flagSet := newFlagSet("serve", stderr)
+	pf.register(flagSet)
+	flagSet.StringVar(&listen, "listen", "", "the tcp address to listen on, such as 127.0.0.1:8080")
+	flagSet.StringVar(&socket, "socket", "", "the path of the unix socket to listen on")
+	flagSet.StringVar(&tokenFile, "token-file", "", "the file of the bearer token, default is the environment variable ALIBABA_CLOUD_CREDENTIALS_URI_TOKEN")
+	flagSet.StringVar(&allowUID, "allow-uid", "", "the comma separated uids which are allowed to connect to the unix socket")
+	flagSet.BoolVar(&allowRemote, "allow-remote", false, "allow listening on a non-loopback address, the bearer token and the credentials are sent in plain text")
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+
+	if flagSet.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected arguments: %s\n", strings.Join(flagSet.Args(), " "))
+		return 2
+	}
+
+	handler, err := buildHandler(&pf, listen, socket, tokenFile, allowUID, allowRemote)
+	if err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return 2
+	}
+
+	network, address := "tcp", listen
+	if socket != "" {
+		network, address = "unix", socket
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		fmt.Fprintf(stderr, "listen failed: %s\n", err.Error())
+		return 1
+	}
+
+	return serveUntilShutdown(handler.NewServer(), listener, stderr)
+}
+
+// serveUntilShutdown serves httpServer on listener until SIGINT or SIGTERM is received
+func serveUntilShutdown(httpServer *http.Server, listener net.Listener, stderr io.Writer) int {
+	fmt.Fprintf(stderr, "serving credentials on %s://%s\n", listener.Addr().Network(), listener.Addr().String())
+	signals := make(chan os.Signal, 1)
+	notifyShutdown(signals)
+	go func() {
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(ctx)
+	}()
+
+	err := httpServer.Serve(listener)
+	if err != nil && err != http.ErrServerClosed {
+		fmt.Fprintf(stderr, "serve failed: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
+func buildHandler(pf *providerFlags, listen string, socket string, tokenFile string, allowUID string, allowRemote bool) (handler *server.Handler, err error) {
+	if (listen == "") == (socket == "") {
+		err = errors.New("one of -listen and -socket is required")
+		return
+	}
+
+	if listen != "" && !allowRemote && !isLoopbackAddress(listen) {
+		err = fmt.Errorf("%s is not a loopback address, the bearer token and the credentials are sent in plain text, set -allow-remote to listen on it", listen)
+		return
+	}
+
+	if allowUID != "" && socket == "" {
+		err = errors.New("-allow-uid can only be used with -socket")
+		return
+	}
+
+	token := os.Getenv("ALIBABA_CLOUD_CREDENTIALS_URI_TOKEN")
+	if tokenFile != "" {
+		var content []byte
+		content, err = ioutil.ReadFile(tokenFile)
+		if err != nil {
+			err = fmt.Errorf("read the token file failed: %s", err.Error())
+			return
+		}
+		token = strings.TrimSpace(string(content))
+		if token == "" {
+			err = fmt.Errorf("the token file %s is empty", tokenFile)
+			return
+		}
+	}
+
+	if token == "" && allowUID == "" {
+		err = errors.New("the bearer token or -allow-uid is required to authenticate the callers")
+		return
+	}
+
+	provider, err := pf.getCredentialsProvider()
+	if err != nil {
+		return
+	}
+
+	builder := server.NewHandlerBuilder().
+		WithCredentialsProvider(provider).
+		WithBearerToken(token)
+	if allowUID != "" {
+		var uids []int
+		for _, item := range strings.Split(allowUID, ",") {
+			var uid int
+			uid, err = strconv.Atoi(strings.TrimSpace(item))
+			if err != nil {
+				err = fmt.Errorf("invalid uid %q in -allow-uid", item)
+				return
+			}
+			uids = append(uids, uid)
+		}
+		builder.WithAllowedUIDs(uids...)
+	}
+	return builder.Build()
+}
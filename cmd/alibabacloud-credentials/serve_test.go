@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunServeError(t *testing.T) {
+	origin := os.Getenv("ALIBABA_CLOUD_CREDENTIALS_URI_TOKEN")
+	defer os.Setenv("ALIBABA_CLOUD_CREDENTIALS_URI_TOKEN", origin)
+	os.Unsetenv("ALIBABA_CLOUD_CREDENTIALS_URI_TOKEN")
+
+	cases := []struct {
+		args   []string
+		stderr string
+	}{
+		{[]string{"serve"}, "one of -listen and -socket is required\n"},
+		{[]string{"serve", "-listen", "127.0.0.1:0", "-socket", "a.sock"}, "one of -listen and -socket is required\n"},
+		{[]string{"serve", "-listen", "127.0.0.1:0", "-allow-uid", "0"}, "-allow-uid can only be used with -socket\n"},
+		{[]string{"serve", "-listen", "127.0.0.1:0"}, "the bearer token or -allow-uid is required to authenticate the callers\n"},
+		{[]string{"serve", "-socket", "a.sock", "-allow-uid", "0,root"}, "invalid uid \"root\" in -allow-uid\n"},
+		{[]string{"serve", "-listen", "127.0.0.1:0", "-token-file", "/notexist/token"}, "read the token file failed: open /notexist/token: no such file or directory\n"},
+		{[]string{"serve", "-listen", "127.0.0.1:0", "extra"}, "unexpected arguments: extra\n"},
+		{[]string{"serve", "-listen", "0.0.0.0:0"}, "0.0.0.0:0 is not a loopback address, the bearer token and the credentials are sent in plain text, set -allow-remote to listen on it\n"},
+		{[]string{"serve", "-listen", ":0", "-allow-remote"}, "the bearer token or -allow-uid is required to authenticate the callers\n"},
+	}
+	for _, c := range cases {
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, 2, run(c.args, nil, &stdout, &stderr))
+		assert.Equal(t, c.stderr, stderr.String())
+	}
+
+	file, err := ioutil.TempFile("", "token")
+	assert.Nil(t, err)
+	file.Close()
+	defer os.Remove(file.Name())
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, run([]string{"serve", "-listen", "127.0.0.1:0", "-token-file", file.Name()}, nil, &stdout, &stderr))
+	assert.Equal(t, "the token file "+file.Name()+" is empty\n", stderr.String())
+}
+
+func TestRunServe(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the peer uid check is only supported on Linux")
+	}
+
+	rollback := mockDefaultCredentialsProvider(newTestSTSProvider())
+	defer rollback()
+
+	signals := make(chan chan<- os.Signal, 1)
+	originNotifyShutdown := notifyShutdown
+	notifyShutdown = func(c chan<- os.Signal) {
+		signals <- c
+	}
+	defer func() {
+		notifyShutdown = originNotifyShutdown
+	}()
+
+	dir, err := ioutil.TempDir("", "credentials-serve")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	socket := path.Join(dir, "credentials.sock")
+
+	var stdout, stderr bytes.Buffer
+	done := make(chan int, 1)
+	go func() {
+		done <- run([]string{"serve", "-socket", socket, "-allow-uid", strconv.Itoa(os.Getuid())}, nil, &stdout, &stderr)
+	}()
+	shutdown := <-signals
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	res, err := client.Get("http://localhost/")
+	assert.Nil(t, err)
+	content, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, `{"AccessKeyId":"akid","AccessKeySecret":"aksecret","SecurityToken":"ststoken","Expiration":"2121-01-02T03:04:05Z"}`, string(content))
+
+	shutdown <- os.Interrupt
+	select {
+	case code := <-done:
+		assert.Equal(t, 0, code)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the server is not stopped")
+	}
+	assert.Equal(t, "serving credentials on unix://"+socket+"\n", stderr.String())
+}
@@ -2,23 +2,66 @@ package credentials
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
 	"os"
 	"strings"
 	"time"
 
-	"github.com/alibabacloud-go/debug/debug"
 	"github.com/alibabacloud-go/tea/tea"
+	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
 	"github.com/aliyun/credentials-go/credentials/internal/utils"
 	"github.com/aliyun/credentials-go/credentials/providers"
 	"github.com/aliyun/credentials-go/credentials/request"
 	"github.com/aliyun/credentials-go/credentials/response"
 )
 
-var debuglog = debug.Init("credential")
+// Logger is the structured logger, it is compatible with *slog.Logger of log/slog, see providers.Logger
+type Logger = providers.Logger
+
+// SetLogger sets the global logger of the credentials and the providers, the default logger is restored if logger is nil
+func SetLogger(logger Logger) {
+	providers.SetLogger(logger)
+}
+
+// Clock tells the current time for the expiry and refresh decisions, see providers.Clock
+type Clock = providers.Clock
+
+// OnRefreshFunc is called after the session credentials are refreshed, see providers.OnRefreshFunc
+type OnRefreshFunc = providers.OnRefreshFunc
+
+// OnRefreshErrorFunc is called after the session credentials failed to refresh, see providers.OnRefreshErrorFunc
+type OnRefreshErrorFunc = providers.OnRefreshErrorFunc
+
+// Instrumentation receives the events of the credential refreshes and the http requests, see providers.Instrumentation
+type Instrumentation = providers.Instrumentation
+
+// ServiceError is returned when the service responds with an unexpected status, see providers.ServiceError
+type ServiceError = providers.ServiceError
+
+var (
+	// ErrProviderNotConfigured is matched by the errors caused by the missing configuration, see providers.ErrProviderNotConfigured
+	ErrProviderNotConfigured = providers.ErrProviderNotConfigured
+	// ErrIMDSDisabled is matched by the errors when the ECS metadata service is disabled
+	ErrIMDSDisabled = providers.ErrIMDSDisabled
+	// ErrThrottled is matched by the ServiceError caused by flow control
+	ErrThrottled = providers.ErrThrottled
+)
+
+// notConfiguredError keeps the error text and matches ErrProviderNotConfigured by errors.Is
+type notConfiguredError struct {
+	message string
+}
+
+func (e *notConfiguredError) Error() string {
+	return e.message
+}
+
+func (e *notConfiguredError) Unwrap() error {
+	return ErrProviderNotConfigured
+}
 
 var hookParse = func(err error) error {
 	return err
@@ -39,7 +82,7 @@ type Credential interface {
 
 // Config is important when call NewCredential
 type Config struct {
-	// Credential type, including access_key, sts, bearer, ecs_ram_role, ram_role_arn, rsa_key_pair, oidc_role_arn, credentials_uri
+	// Credential type, including access_key, sts, bearer, ecs_ram_role, ram_role_arn, rsa_key_pair, oidc_role_arn, credentials_uri, credential_process
 	Type            *string `json:"type"`
 	AccessKeyId     *string `json:"access_key_id"`
 	AccessKeySecret *string `json:"access_key_secret"`
@@ -67,6 +110,9 @@ type Config struct {
 	// Used when the type is credentials_uri
 	Url *string `json:"url"`
 
+	// Used when the type is credential_process, the timeout of the command is specified by Timeout
+	CredentialProcess *string `json:"credential_process"`
+
 	// Deprecated
 	// Used when the type is rsa_key_pair
 	SessionExpiration *int    `json:"session_expiration"`
@@ -83,6 +129,21 @@ type Config struct {
 
 	Proxy          *string  `json:"proxy"`
 	InAdvanceScale *float64 `json:"inAdvanceScale"`
+
+	// The client to send the requests, Proxy, ConnectTimeout and ReadTimeout are ignored if it is set, its own timeout is used.
+	HttpClient *http.Client `json:"-"`
+	// The transport to send the requests if HttpClient is not set, a shared pooled transport is used by default.
+	Transport http.RoundTripper `json:"-"`
+	// The logger of the credential, the global logger set by SetLogger is used if it is not set.
+	Logger Logger `json:"-"`
+	// The callback which is called after the session credentials are refreshed.
+	OnRefresh OnRefreshFunc `json:"-"`
+	// The callback which is called after the session credentials failed to refresh.
+	OnRefreshError OnRefreshErrorFunc `json:"-"`
+	// The clock to decide whether the session credentials are expired or due for a refresh, the system clock is used if it is not set.
+	Clock Clock `json:"-"`
+	// The instrumentation which receives the events of the credential refreshes and the http requests.
+	Instrumentation Instrumentation `json:"-"`
 }
 
 func (s Config) String() string {
@@ -188,6 +249,41 @@ func (s *Config) SetProxy(v string) *Config {
 	return s
 }
 
+func (s *Config) SetHttpClient(v *http.Client) *Config {
+	s.HttpClient = v
+	return s
+}
+
+func (s *Config) SetTransport(v http.RoundTripper) *Config {
+	s.Transport = v
+	return s
+}
+
+func (s *Config) SetLogger(v Logger) *Config {
+	s.Logger = v
+	return s
+}
+
+func (s *Config) SetClock(v Clock) *Config {
+	s.Clock = v
+	return s
+}
+
+func (s *Config) SetInstrumentation(v Instrumentation) *Config {
+	s.Instrumentation = v
+	return s
+}
+
+func (s *Config) SetOnRefresh(v OnRefreshFunc) *Config {
+	s.OnRefresh = v
+	return s
+}
+
+func (s *Config) SetOnRefreshError(v OnRefreshErrorFunc) *Config {
+	s.OnRefreshError = v
+	return s
+}
+
 func (s *Config) SetType(v string) *Config {
 	s.Type = &v
 	return s
@@ -211,6 +307,11 @@ func (s *Config) SetURLCredential(v string) *Config {
 	return s
 }
 
+func (s *Config) SetCredentialProcess(v string) *Config {
+	s.CredentialProcess = &v
+	return s
+}
+
 func (s *Config) SetSTSEndpoint(v string) *Config {
 	s.STSEndpoint = &v
 	return s
@@ -234,10 +335,17 @@ func NewCredential(config *Config) (credential Credential, err error) {
 	case "credentials_uri":
 		provider, err := providers.NewURLCredentialsProviderBuilder().
 			WithUrl(tea.StringValue(config.Url)).
+			WithOnRefresh(config.OnRefresh).
+			WithOnRefreshError(config.OnRefreshError).
+			WithClock(config.Clock).
 			WithHttpOptions(&providers.HttpOptions{
-				Proxy:          tea.StringValue(config.Proxy),
-				ReadTimeout:    tea.IntValue(config.Timeout),
-				ConnectTimeout: tea.IntValue(config.ConnectTimeout),
+				Proxy:           tea.StringValue(config.Proxy),
+				ReadTimeout:     tea.IntValue(config.Timeout),
+				ConnectTimeout:  tea.IntValue(config.ConnectTimeout),
+				Client:          config.HttpClient,
+				Transport:       config.Transport,
+				Logger:          config.Logger,
+				Instrumentation: config.Instrumentation,
 			}).
 			Build()
 
@@ -245,6 +353,20 @@ func NewCredential(config *Config) (credential Credential, err error) {
 			return nil, err
 		}
 		credential = FromCredentialsProvider("credentials_uri", provider)
+	case "credential_process":
+		provider, err := providers.NewProcessCredentialsProviderBuilder().
+			WithCommand(tea.StringValue(config.CredentialProcess)).
+			WithTimeout(time.Duration(tea.IntValue(config.Timeout)) * time.Millisecond).
+			WithOnRefresh(config.OnRefresh).
+			WithOnRefreshError(config.OnRefreshError).
+			WithClock(config.Clock).
+			WithInstrumentation(config.Instrumentation).
+			Build()
+
+		if err != nil {
+			return nil, err
+		}
+		credential = FromCredentialsProvider("credential_process", provider)
 	case "oidc_role_arn":
 		provider, err := providers.NewOIDCCredentialsProviderBuilder().
 			WithRoleArn(tea.StringValue(config.RoleArn)).
@@ -254,10 +376,17 @@ func NewCredential(config *Config) (credential Credential, err error) {
 			WithPolicy(tea.StringValue(config.Policy)).
 			WithRoleSessionName(tea.StringValue(config.RoleSessionName)).
 			WithSTSEndpoint(tea.StringValue(config.STSEndpoint)).
+			WithOnRefresh(config.OnRefresh).
+			WithOnRefreshError(config.OnRefreshError).
+			WithClock(config.Clock).
 			WithHttpOptions(&providers.HttpOptions{
-				Proxy:          tea.StringValue(config.Proxy),
-				ReadTimeout:    tea.IntValue(config.Timeout),
-				ConnectTimeout: tea.IntValue(config.ConnectTimeout),
+				Proxy:           tea.StringValue(config.Proxy),
+				ReadTimeout:     tea.IntValue(config.Timeout),
+				ConnectTimeout:  tea.IntValue(config.ConnectTimeout),
+				Client:          config.HttpClient,
+				Transport:       config.Transport,
+				Logger:          config.Logger,
+				Instrumentation: config.Instrumentation,
 			}).
 			Build()
 
@@ -290,6 +419,15 @@ func NewCredential(config *Config) (credential Credential, err error) {
 		provider, err := providers.NewECSRAMRoleCredentialsProviderBuilder().
 			WithRoleName(tea.StringValue(config.RoleName)).
 			WithDisableIMDSv1(tea.BoolValue(config.DisableIMDSv1)).
+			WithOnRefresh(config.OnRefresh).
+			WithOnRefreshError(config.OnRefreshError).
+			WithClock(config.Clock).
+			WithHttpOptions(&providers.HttpOptions{
+				Client:          config.HttpClient,
+				Transport:       config.Transport,
+				Logger:          config.Logger,
+				Instrumentation: config.Instrumentation,
+			}).
 			Build()
 
 		if err != nil {
@@ -324,10 +462,17 @@ func NewCredential(config *Config) (credential Credential, err error) {
 			WithDurationSeconds(tea.IntValue(config.RoleSessionExpiration)).
 			WithExternalId(tea.StringValue(config.ExternalId)).
 			WithStsEndpoint(tea.StringValue(config.STSEndpoint)).
+			WithOnRefresh(config.OnRefresh).
+			WithOnRefreshError(config.OnRefreshError).
+			WithClock(config.Clock).
 			WithHttpOptions(&providers.HttpOptions{
-				Proxy:          tea.StringValue(config.Proxy),
-				ReadTimeout:    tea.IntValue(config.Timeout),
-				ConnectTimeout: tea.IntValue(config.ConnectTimeout),
+				Proxy:           tea.StringValue(config.Proxy),
+				ReadTimeout:     tea.IntValue(config.Timeout),
+				ConnectTimeout:  tea.IntValue(config.ConnectTimeout),
+				Client:          config.HttpClient,
+				Transport:       config.Transport,
+				Logger:          config.Logger,
+				Instrumentation: config.Instrumentation,
 			}).
 			Build()
 		if err != nil {
@@ -360,20 +505,28 @@ func NewCredential(config *Config) (credential Credential, err error) {
 			ReadTimeout:    tea.IntValue(config.Timeout),
 			ConnectTimeout: tea.IntValue(config.ConnectTimeout),
 			STSEndpoint:    tea.StringValue(config.STSEndpoint),
+			Client:         config.HttpClient,
+			Transport:      config.Transport,
+			Logger:         config.Logger,
 		}
-		credential = newRsaKeyPairCredential(
+		rsaKeyPairCredential := newRsaKeyPairCredential(
 			privateKey,
 			tea.StringValue(config.PublicKeyId),
 			tea.IntValue(config.SessionExpiration),
 			runtime)
+		rsaKeyPairCredential.onRefresh = config.OnRefresh
+		rsaKeyPairCredential.onRefreshError = config.OnRefreshError
+		rsaKeyPairCredential.clock = config.Clock
+		rsaKeyPairCredential.instrumentation = config.Instrumentation
+		credential = rsaKeyPairCredential
 	case "bearer":
 		if tea.StringValue(config.BearerToken) == "" {
-			err = errors.New("BearerToken cannot be empty")
+			err = &notConfiguredError{message: "BearerToken cannot be empty"}
 			return
 		}
 		credential = newBearerTokenCredential(tea.StringValue(config.BearerToken))
 	default:
-		err = errors.New("invalid type option, support: access_key, sts, bearer, ecs_ram_role, ram_role_arn, rsa_key_pair, oidc_role_arn, credentials_uri")
+		err = errors.New("invalid type option, support: access_key, sts, bearer, ecs_ram_role, ram_role_arn, rsa_key_pair, oidc_role_arn, credentials_uri, credential_process")
 		return
 	}
 	return credential, nil
@@ -381,11 +534,11 @@ func NewCredential(config *Config) (credential Credential, err error) {
 
 func checkRSAKeyPair(config *Config) (err error) {
 	if tea.StringValue(config.PrivateKeyFile) == "" {
-		err = errors.New("PrivateKeyFile cannot be empty")
+		err = &notConfiguredError{message: "PrivateKeyFile cannot be empty"}
 		return
 	}
 	if tea.StringValue(config.PublicKeyId) == "" {
-		err = errors.New("PublicKeyId cannot be empty")
+		err = &notConfiguredError{message: "PublicKeyId cannot be empty"}
 		return
 	}
 	return
@@ -402,48 +555,55 @@ func doAction(request *request.CommonRequest, runtime *utils.Runtime) (content [
 	}
 	httpRequest.Proto = "HTTP/1.1"
 	httpRequest.Host = request.Domain
-	debuglog("> %s %s %s", httpRequest.Method, httpRequest.URL.RequestURI(), httpRequest.Proto)
-	debuglog("> Host: %s", httpRequest.Host)
 	for key, value := range request.Headers {
 		if value != "" {
-			debuglog("> %s: %s", key, value)
 			httpRequest.Header[key] = []string{value}
 		}
 	}
-	debuglog(">")
-	httpClient := &http.Client{}
-	httpClient.Timeout = time.Duration(runtime.ReadTimeout) * time.Second
-	proxy := &url.URL{}
-	if runtime.Proxy != "" {
-		proxy, err = url.Parse(runtime.Proxy)
-		if err != nil {
-			return
-		}
+
+	var logger Logger
+	if runtime != nil {
+		logger = runtime.Logger
 	}
-	transport := &http.Transport{}
-	if proxy != nil && runtime.Proxy != "" {
-		transport.Proxy = http.ProxyURL(proxy)
+	logger = utils.GetLogger(logger)
+	logger.DebugContext(context.Background(), "send request", "method", httpRequest.Method, "url", utils.RedactURL(request.URL), "host", httpRequest.Host, "headers", utils.RedactHeaders(request.Headers))
+	// 使用调用方的 client 时不修改其超时设置
+	httpClient := runtime.Client
+	if httpClient == nil {
+		httpClient = &http.Client{
+			Timeout: time.Duration(runtime.ReadTimeout) * time.Second,
+		}
+		if runtime.Transport != nil {
+			httpClient.Transport = runtime.Transport
+		} else {
+			// 与新的凭证提供程序共享连接池
+			httpClient.Transport, err = httputil.GetTransport(runtime.Proxy, time.Duration(runtime.ConnectTimeout)*time.Second)
+			if err != nil {
+				return
+			}
+		}
 	}
-	transport.DialContext = utils.Timeout(time.Duration(runtime.ConnectTimeout) * time.Second)
-	httpClient.Transport = transport
 	httpResponse, err := hookDo(httpClient.Do)(httpRequest)
 	if err != nil {
 		return
 	}
-	debuglog("< %s %s", httpResponse.Proto, httpResponse.Status)
-	for key, value := range httpResponse.Header {
-		debuglog("< %s: %v", key, strings.Join(value, ""))
-	}
-	debuglog("<")
 
 	resp := &response.CommonResponse{}
 	err = hookParse(resp.ParseFromHTTPResponse(httpResponse))
 	if err != nil {
 		return
 	}
-	debuglog("%s", resp.GetHTTPContentString())
+	logger.DebugContext(context.Background(), "receive response", "status", resp.GetHTTPStatus(), "body", utils.RedactJSON(resp.GetHTTPContentString()))
 	if resp.GetHTTPStatus() != http.StatusOK {
-		err = fmt.Errorf("httpStatus: %d, message = %s", resp.GetHTTPStatus(), resp.GetHTTPContentString())
+		code, message, requestId := utils.ParseErrorBody(resp.GetHTTPContentBytes())
+		err = &ServiceError{
+			StatusCode: resp.GetHTTPStatus(),
+			Code:       code,
+			Message:    message,
+			RequestId:  requestId,
+			Host:       httpRequest.URL.Host,
+			Body:       resp.GetHTTPContentString(),
+		}
 		return
 	}
 	return resp.GetHTTPContentBytes(), nil
@@ -503,6 +663,9 @@ func (cp *credentialsProviderWrap) GetCredential() (cm *CredentialModel, err err
 		Type:            &cp.typeName,
 		ProviderName:    &c.ProviderName,
 	}
+	if !c.Expiration.IsZero() {
+		cm.Expiration = &c.Expiration
+	}
 	return
 }
 
@@ -1,6 +1,10 @@
 package credentials
 
-import "github.com/alibabacloud-go/tea/tea"
+import (
+	"time"
+
+	"github.com/alibabacloud-go/tea/tea"
+)
 
 // CredentialModel is a model
 type CredentialModel struct {
@@ -24,6 +28,8 @@ type CredentialModel struct {
 	//
 	// cli_profile/static_ak
 	ProviderName *string `json:"providerName,omitempty" xml:"providerName,omitempty"`
+	// expiration time, it is nil for the credentials which never expire
+	Expiration *time.Time `json:"expiration,omitempty" xml:"expiration,omitempty"`
 }
 
 func (s CredentialModel) String() string {
@@ -63,3 +69,8 @@ func (s *CredentialModel) SetProviderName(v string) *CredentialModel {
 	s.ProviderName = &v
 	return s
 }
+
+func (s *CredentialModel) SetExpiration(v time.Time) *CredentialModel {
+	s.Expiration = &v
+	return s
+}
@@ -2,6 +2,7 @@ package credentials
 
 import (
 	"testing"
+	"time"
 
 	"github.com/alibabacloud-go/tea/tea"
 	"github.com/stretchr/testify/assert"
@@ -47,3 +48,12 @@ func Test_Credential2(t *testing.T) {
 	assert.Equal(t, "bearertoken", *cred.Type)
 	assert.Equal(t, "bearertoken", *cred.ProviderName)
 }
+
+func Test_CredentialExpiration(t *testing.T) {
+	cred := &CredentialModel{}
+	assert.Nil(t, cred.Expiration)
+	expiration := time.Date(2021, 10, 20, 4, 27, 9, 0, time.UTC)
+	cred.SetExpiration(expiration)
+	assert.Equal(t, expiration, *cred.Expiration)
+	assert.Contains(t, cred.String(), "\"expiration\": \"2021-10-20T04:27:09Z\"")
+}
@@ -1,10 +1,17 @@
 package credentials
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alibabacloud-go/tea/tea"
+	"github.com/aliyun/credentials-go/credentials/credentialstest"
 	"github.com/aliyun/credentials-go/credentials/internal/utils"
 	"github.com/aliyun/credentials-go/credentials/request"
 	"github.com/stretchr/testify/assert"
@@ -15,8 +22,8 @@ this is privatekey`
 
 func TestConfig(t *testing.T) {
 	config := new(Config)
-	assert.Equal(t, "{\n   \"type\": null,\n   \"access_key_id\": null,\n   \"access_key_secret\": null,\n   \"security_token\": null,\n   \"bearer_token\": null,\n   \"oidc_provider_arn\": null,\n   \"oidc_token\": null,\n   \"role_arn\": null,\n   \"role_session_name\": null,\n   \"role_session_expiration\": null,\n   \"policy\": null,\n   \"external_id\": null,\n   \"sts_endpoint\": null,\n   \"role_name\": null,\n   \"enable_imds_v2\": null,\n   \"disable_imds_v1\": null,\n   \"metadata_token_duration\": null,\n   \"url\": null,\n   \"session_expiration\": null,\n   \"public_key_id\": null,\n   \"private_key_file\": null,\n   \"host\": null,\n   \"timeout\": null,\n   \"connect_timeout\": null,\n   \"proxy\": null,\n   \"inAdvanceScale\": null\n}", config.String())
-	assert.Equal(t, "{\n   \"type\": null,\n   \"access_key_id\": null,\n   \"access_key_secret\": null,\n   \"security_token\": null,\n   \"bearer_token\": null,\n   \"oidc_provider_arn\": null,\n   \"oidc_token\": null,\n   \"role_arn\": null,\n   \"role_session_name\": null,\n   \"role_session_expiration\": null,\n   \"policy\": null,\n   \"external_id\": null,\n   \"sts_endpoint\": null,\n   \"role_name\": null,\n   \"enable_imds_v2\": null,\n   \"disable_imds_v1\": null,\n   \"metadata_token_duration\": null,\n   \"url\": null,\n   \"session_expiration\": null,\n   \"public_key_id\": null,\n   \"private_key_file\": null,\n   \"host\": null,\n   \"timeout\": null,\n   \"connect_timeout\": null,\n   \"proxy\": null,\n   \"inAdvanceScale\": null\n}", config.GoString())
+	assert.Equal(t, "{\n   \"type\": null,\n   \"access_key_id\": null,\n   \"access_key_secret\": null,\n   \"security_token\": null,\n   \"bearer_token\": null,\n   \"oidc_provider_arn\": null,\n   \"oidc_token\": null,\n   \"role_arn\": null,\n   \"role_session_name\": null,\n   \"role_session_expiration\": null,\n   \"policy\": null,\n   \"external_id\": null,\n   \"sts_endpoint\": null,\n   \"role_name\": null,\n   \"enable_imds_v2\": null,\n   \"disable_imds_v1\": null,\n   \"metadata_token_duration\": null,\n   \"url\": null,\n   \"credential_process\": null,\n   \"session_expiration\": null,\n   \"public_key_id\": null,\n   \"private_key_file\": null,\n   \"host\": null,\n   \"timeout\": null,\n   \"connect_timeout\": null,\n   \"proxy\": null,\n   \"inAdvanceScale\": null\n}", config.String())
+	assert.Equal(t, "{\n   \"type\": null,\n   \"access_key_id\": null,\n   \"access_key_secret\": null,\n   \"security_token\": null,\n   \"bearer_token\": null,\n   \"oidc_provider_arn\": null,\n   \"oidc_token\": null,\n   \"role_arn\": null,\n   \"role_session_name\": null,\n   \"role_session_expiration\": null,\n   \"policy\": null,\n   \"external_id\": null,\n   \"sts_endpoint\": null,\n   \"role_name\": null,\n   \"enable_imds_v2\": null,\n   \"disable_imds_v1\": null,\n   \"metadata_token_duration\": null,\n   \"url\": null,\n   \"credential_process\": null,\n   \"session_expiration\": null,\n   \"public_key_id\": null,\n   \"private_key_file\": null,\n   \"host\": null,\n   \"timeout\": null,\n   \"connect_timeout\": null,\n   \"proxy\": null,\n   \"inAdvanceScale\": null\n}", config.GoString())
 
 	config.SetSTSEndpoint("sts.cn-hangzhou.aliyuncs.com")
 	assert.Equal(t, "sts.cn-hangzhou.aliyuncs.com", *config.STSEndpoint)
@@ -67,6 +74,7 @@ func TestNewCredentialWithAK(t *testing.T) {
 	assert.Equal(t, "AccessKeyId", *cm.AccessKeyId)
 	assert.Equal(t, "AccessKeySecret", *cm.AccessKeySecret)
 	assert.Equal(t, "", *cm.SecurityToken)
+	assert.Nil(t, cm.Expiration)
 
 	// test deprecated methods
 	accessKeyId, err := cred.GetAccessKeyId()
@@ -187,6 +195,16 @@ func TestNewCredentialWithRSAKeyPair(t *testing.T) {
 	cred, err = NewCredential(config)
 	assert.Nil(t, err)
 	assert.NotNil(t, cred)
+
+	clock := credentialstest.NewFakeClock(time.Unix(1000, 0))
+	cred, err = NewCredential(config.SetClock(clock))
+	assert.Nil(t, err)
+	assert.Equal(t, clock, cred.(*RsaKeyPairCredentialsProvider).clock)
+
+	instrumentation := &recordingInstrumentation{}
+	cred, err = NewCredential(config.SetInstrumentation(instrumentation))
+	assert.Nil(t, err)
+	assert.Equal(t, instrumentation, cred.(*RsaKeyPairCredentialsProvider).instrumentation)
 }
 
 func TestNewCredentialWithRAMRoleARN(t *testing.T) {
@@ -304,12 +322,26 @@ func TestNewCredentialWithCredentialsURI(t *testing.T) {
 	assert.Equal(t, "", tea.StringValue(config.Url))
 }
 
+func TestNewCredentialWithCredentialProcess(t *testing.T) {
+	config := new(Config)
+	config.SetType("credential_process")
+	cred, err := NewCredential(config)
+	assert.EqualError(t, err, "the command is empty")
+	assert.Nil(t, cred)
+
+	config.SetCredentialProcess("/path/to/broker").
+		SetTimeout(2000)
+	cred, err = NewCredential(config)
+	assert.Nil(t, err)
+	assert.Equal(t, "credential_process", tea.StringValue(cred.GetType()))
+}
+
 func TestNewCredentialWithInvalidType(t *testing.T) {
 	config := new(Config)
 	config.SetType("sdk")
 	cred, err := NewCredential(config)
 	assert.NotNil(t, err)
-	assert.Equal(t, "invalid type option, support: access_key, sts, bearer, ecs_ram_role, ram_role_arn, rsa_key_pair, oidc_role_arn, credentials_uri", err.Error())
+	assert.Equal(t, "invalid type option, support: access_key, sts, bearer, ecs_ram_role, ram_role_arn, rsa_key_pair, oidc_role_arn, credentials_uri, credential_process", err.Error())
 	assert.Nil(t, cred)
 }
 
@@ -330,3 +362,199 @@ func Test_doaction(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Nil(t, content)
 }
+
+type countingTransport struct {
+	count int
+}
+
+func (transport *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport.count++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func Test_doactionWithTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	request := request.NewCommonRequest()
+	request.Method = "GET"
+	request.URL = server.URL
+	transport := &countingTransport{}
+	content, err := doAction(request, &utils.Runtime{Transport: transport})
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", string(content))
+	assert.Equal(t, 1, transport.count)
+
+	clientTransport := &countingTransport{}
+	content, err = doAction(request, &utils.Runtime{Client: &http.Client{Transport: clientTransport}, Transport: transport})
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", string(content))
+	assert.Equal(t, 1, transport.count)
+	assert.Equal(t, 1, clientTransport.count)
+}
+
+func Test_doactionWithClientTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	request := request.NewCommonRequest()
+	request.Method = "GET"
+	request.URL = server.URL
+	// the timeout of the client is honoured even if the read timeout is set
+	client := &http.Client{Timeout: 10 * time.Millisecond}
+	_, err := doAction(request, &utils.Runtime{Client: client, ReadTimeout: 10})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Client.Timeout exceeded")
+	assert.Equal(t, 10*time.Millisecond, client.Timeout)
+
+	// the pooled transport is used by default
+	content, err := doAction(request, &utils.Runtime{ReadTimeout: 10, ConnectTimeout: 5})
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", string(content))
+}
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) DebugContext(ctx context.Context, msg string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf("%s %v", msg, args))
+}
+
+func (l *recordingLogger) InfoContext(ctx context.Context, msg string, args ...interface{}) {}
+
+func (l *recordingLogger) WarnContext(ctx context.Context, msg string, args ...interface{}) {}
+
+func (l *recordingLogger) ErrorContext(ctx context.Context, msg string, args ...interface{}) {}
+
+func Test_doactionWithLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Credentials":{"AccessKeyId":"akid","AccessKeySecret":"aksecret","SecurityToken":"token"}}`))
+	}))
+	defer server.Close()
+
+	request := request.NewCommonRequest()
+	request.Method = "GET"
+	request.URL = server.URL + "/?Signature=sign"
+	logger := &recordingLogger{}
+	_, err := doAction(request, &utils.Runtime{Logger: logger})
+	assert.Nil(t, err)
+	assert.Len(t, logger.lines, 2)
+	assert.True(t, strings.Contains(logger.lines[0], "Signature=%2A%2A%2A%2A%2A%2A"))
+	assert.Equal(t, `receive response [status 200 body {"Credentials":{"AccessKeyId":"akid","AccessKeySecret":"******","SecurityToken":"******"}}]`, logger.lines[1])
+
+	// the global logger
+	defer SetLogger(nil)
+	SetLogger(logger)
+	_, err = doAction(request, &utils.Runtime{})
+	assert.Nil(t, err)
+	assert.Len(t, logger.lines, 4)
+
+	config := new(Config).SetLogger(logger)
+	assert.Equal(t, logger, config.Logger)
+}
+
+func Test_doactionWithLoggerRedactsSessionAccessKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"RequestId":"B1D2A4F3-5C6E-4A7B-8D9E-0F1A2B3C4D5E","SessionAccessKey":{"SessionAccessKeyId":"TMPSK.akid","SessionAccessKeySecret":"sessionsecret","Expiration":"2121-01-01T00:00:00Z"}}`))
+	}))
+	defer server.Close()
+
+	request := request.NewCommonRequest()
+	request.Method = "GET"
+	request.URL = server.URL + "/?Action=GenerateSessionAccessKey"
+	logger := &recordingLogger{}
+	_, err := doAction(request, &utils.Runtime{Logger: logger})
+	assert.Nil(t, err)
+	assert.Len(t, logger.lines, 2)
+	assert.False(t, strings.Contains(logger.lines[1], "sessionsecret"))
+	assert.Equal(t, `receive response [status 200 body {"RequestId":"B1D2A4F3-5C6E-4A7B-8D9E-0F1A2B3C4D5E","SessionAccessKey":{"SessionAccessKeyId":"TMPSK.akid","SessionAccessKeySecret":"******","Expiration":"2121-01-01T00:00:00Z"}}]`, logger.lines[1])
+}
+
+func TestNewCredentialWithTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expiration := time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		w.Write([]byte(`{"AccessKeyId":"akid","AccessKeySecret":"aksecret","SecurityToken":"token","Expiration":"` + expiration + `"}`))
+	}))
+	defer server.Close()
+
+	transport := &countingTransport{}
+	config := new(Config).
+		SetType("credentials_uri").
+		SetURLCredential(server.URL).
+		SetTransport(transport)
+	cred, err := NewCredential(config)
+	assert.Nil(t, err)
+	cm, err := cred.GetCredential()
+	assert.Nil(t, err)
+	assert.Equal(t, "akid", tea.StringValue(cm.AccessKeyId))
+	assert.Equal(t, 1, transport.count)
+
+	clientTransport := &countingTransport{}
+	config.SetHttpClient(&http.Client{Transport: clientTransport})
+	cred, err = NewCredential(config)
+	assert.Nil(t, err)
+	_, err = cred.GetCredential()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, transport.count)
+	assert.Equal(t, 1, clientTransport.count)
+}
+
+func TestNewCredentialWithRefreshCallbacks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"AccessKeyId":"akid","AccessKeySecret":"aksecret","SecurityToken":"token","Expiration":"2121-10-20T04:27:09Z"}`))
+	}))
+	defer server.Close()
+
+	var refreshedProvider string
+	var expiration time.Time
+	config := new(Config).
+		SetType("credentials_uri").
+		SetURLCredential(server.URL).
+		SetOnRefresh(func(providerName string, exp time.Time) {
+			refreshedProvider = providerName
+			expiration = exp
+		}).
+		SetOnRefreshError(func(providerName string, err error) {
+			assert.Fail(t, "unexpected refresh error", err.Error())
+		})
+	cred, err := NewCredential(config)
+	assert.Nil(t, err)
+	_, err = cred.GetCredential()
+	assert.Nil(t, err)
+	assert.Equal(t, "credential_uri", refreshedProvider)
+	assert.Equal(t, time.Date(2121, 10, 20, 4, 27, 9, 0, time.UTC), expiration)
+}
+
+func TestNewCredentialWithClock(t *testing.T) {
+	var count int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		w.Write([]byte(`{"AccessKeyId":"akid","AccessKeySecret":"aksecret","SecurityToken":"token","Expiration":"2024-01-01T01:00:00Z"}`))
+	}))
+	defer server.Close()
+
+	clock := credentialstest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	cred, err := NewCredential(new(Config).
+		SetType("credentials_uri").
+		SetURLCredential(server.URL).
+		SetClock(clock))
+	assert.Nil(t, err)
+	_, err = cred.GetCredential()
+	assert.Nil(t, err)
+
+	// the credentials are refreshed when they expire in 180 seconds by the clock
+	clock.Advance(57*time.Minute - time.Second)
+	_, err = cred.GetCredential()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	clock.Advance(time.Second)
+	_, err = cred.GetCredential()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+}
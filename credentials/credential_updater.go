@@ -3,6 +3,8 @@ package credentials
 import (
 	"net/http"
 	"time"
+
+	"github.com/aliyun/credentials-go/credentials/providers"
 )
 
 const defaultInAdvanceScale = 0.95
@@ -15,11 +17,27 @@ type credentialUpdater struct {
 	credentialExpiration int
 	lastUpdateTimestamp  int64
 	inAdvanceScale       float64
+	// for expiry and refresh decisions, default is the system clock
+	clock providers.Clock
+}
+
+// now returns the current time of the clock
+func (updater *credentialUpdater) now() time.Time {
+	if updater.clock == nil {
+		return time.Now()
+	}
+	return updater.clock.Now()
 }
 
 func (updater *credentialUpdater) needUpdateCredential() (result bool) {
 	if updater.inAdvanceScale == 0 {
 		updater.inAdvanceScale = defaultInAdvanceScale
 	}
-	return time.Now().Unix()-updater.lastUpdateTimestamp >= int64(float64(updater.credentialExpiration)*updater.inAdvanceScale)
+	return updater.now().Unix()-updater.lastUpdateTimestamp >= int64(float64(updater.credentialExpiration)*updater.inAdvanceScale)
+}
+
+// expiration returns the expiration time of the session
+func (updater *credentialUpdater) expiration() *time.Time {
+	expiration := time.Unix(updater.lastUpdateTimestamp+int64(updater.credentialExpiration), 0).UTC()
+	return &expiration
 }
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"net/http"
 	"testing"
+	"time"
 
+	"github.com/aliyun/credentials-go/credentials/credentialstest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,6 +19,21 @@ func Test_needUpdateCredential(t *testing.T) {
 	assert.True(t, isNeed)
 }
 
+func TestCredentialUpdaterWithClock(t *testing.T) {
+	clock := credentialstest.NewFakeClock(time.Unix(1000, 0))
+	updater := &credentialUpdater{
+		lastUpdateTimestamp:  1000,
+		credentialExpiration: 3600,
+		clock:                clock,
+	}
+	// refreshed at 95% of the expiration
+	clock.Advance(3419*time.Second + 999*time.Millisecond)
+	assert.False(t, updater.needUpdateCredential())
+	clock.Advance(time.Millisecond)
+	assert.True(t, updater.needUpdateCredential())
+	assert.Equal(t, time.Unix(4600, 0).UTC(), *updater.expiration())
+}
+
 func Test_hookdo(t *testing.T) {
 	fn := func(req *http.Request) (*http.Response, error) {
 		return nil, errors.New("hookdo")
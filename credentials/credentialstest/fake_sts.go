@@ -0,0 +1,430 @@
+// Package credentialstest provides the fake services and the test doubles of the credentials providers
+// for the hermetic tests of the code which uses the credentials providers.
+package credentialstest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/internal/utils"
+	"github.com/aliyun/credentials-go/credentials/providers"
+)
+
+// AccountId is the id of the account which owns the access keys and the roles of FakeSTS
+const AccountId = "1234567890123456"
+
+const (
+	defaultDurationSeconds = 3600
+	minDurationSeconds     = 900
+	maxDurationSeconds     = 43200
+)
+
+// Failure is a scripted error response of FakeSTS
+type Failure struct {
+	// The action to fail, such as AssumeRole, the failure matches all the actions if it is empty
+	Action string
+	// Default is 400
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+// Call is a request received by FakeSTS
+type Call struct {
+	Action string
+	// The parameters in the query string and the form body
+	Params     map[string]string
+	StatusCode int
+	// The error code of the response, it is empty for the successful calls
+	Code string
+}
+
+type stsSession struct {
+	accessKeySecret string
+	securityToken   string
+	arn             string
+	assumedRoleId   string
+	expiration      time.Time
+}
+
+// FakeSTS is an in-process STS which implements AssumeRole, AssumeRoleWithOIDC and GetCallerIdentity over TLS.
+// The signatures of AssumeRole and GetCallerIdentity are verified with the access keys added by AddAccessKey()
+// and the STS credentials issued by FakeSTS itself, so that the role chaining works.
+// The issued STS credentials expire by the clock set by SetClock(), InvalidSecurityToken.Expired is returned for them after that.
+//
+//	sts := credentialstest.NewFakeSTS()
+//	defer sts.Close()
+//	sts.AddAccessKey("akid", "aksecret")
+//	provider, err := providers.NewRAMRoleARNCredentialsProviderBuilder().
+//		WithAccessKeyId("akid").
+//		WithAccessKeySecret("aksecret").
+//		WithRoleArn("acs:ram::1234567890123456:role/test").
+//		WithStsEndpoint(sts.Endpoint()).
+//		WithHttpOptions(sts.HttpOptions()).
+//		Build()
+type FakeSTS struct {
+	server *httptest.Server
+	// guards the fields below
+	mutex      sync.Mutex
+	accessKeys map[string]string
+	sessions   map[string]*stsSession
+	oidcTokens map[string]bool
+	usedNonces map[string]bool
+	failures   []Failure
+	calls      []Call
+	counter    int
+	clock      providers.Clock
+}
+
+// NewFakeSTS starts a FakeSTS, call Close() to stop it
+func NewFakeSTS() *FakeSTS {
+	sts := &FakeSTS{
+		accessKeys: make(map[string]string),
+		sessions:   make(map[string]*stsSession),
+		oidcTokens: make(map[string]bool),
+		usedNonces: make(map[string]bool),
+		clock:      systemClock{},
+	}
+	sts.server = httptest.NewTLSServer(http.HandlerFunc(sts.serveHTTP))
+	return sts
+}
+
+// Close stops the server
+func (sts *FakeSTS) Close() {
+	sts.server.Close()
+}
+
+// Endpoint returns the host and the port of the server, which is used as the STS endpoint of the providers
+func (sts *FakeSTS) Endpoint() string {
+	return strings.TrimPrefix(sts.server.URL, "https://")
+}
+
+// HttpOptions returns the http options of the providers, the client trusts the certificate of the server
+func (sts *FakeSTS) HttpOptions() *providers.HttpOptions {
+	return &providers.HttpOptions{
+		Client: sts.server.Client(),
+	}
+}
+
+// AddAccessKey adds the access key which is allowed to call AssumeRole and GetCallerIdentity
+func (sts *FakeSTS) AddAccessKey(accessKeyId string, accessKeySecret string) {
+	sts.mutex.Lock()
+	defer sts.mutex.Unlock()
+	sts.accessKeys[accessKeyId] = accessKeySecret
+}
+
+// AddOIDCToken adds the OIDC token which is accepted by AssumeRoleWithOIDC
+func (sts *FakeSTS) AddOIDCToken(oidcToken string) {
+	sts.mutex.Lock()
+	defer sts.mutex.Unlock()
+	sts.oidcTokens[oidcToken] = true
+}
+
+// SetClock sets the clock which is used to calculate and check the expiration of the credentials, default is the system clock.
+// The same FakeClock can be set to the providers, so that one clock drives both of them.
+func (sts *FakeSTS) SetClock(clock providers.Clock) {
+	sts.mutex.Lock()
+	defer sts.mutex.Unlock()
+	if clock == nil {
+		clock = systemClock{}
+	}
+	sts.clock = clock
+}
+
+// FailNext makes the next matching call fail with failure, the failures are used in order
+func (sts *FakeSTS) FailNext(failures ...Failure) {
+	sts.mutex.Lock()
+	defer sts.mutex.Unlock()
+	sts.failures = append(sts.failures, failures...)
+}
+
+// Throttle makes the next times calls fail with the Throttling error
+func (sts *FakeSTS) Throttle(times int) {
+	for i := 0; i < times; i++ {
+		sts.FailNext(Failure{
+			StatusCode: http.StatusBadRequest,
+			Code:       "Throttling",
+			Message:    "Request was denied due to request throttling.",
+		})
+	}
+}
+
+// Calls returns the calls received in order
+func (sts *FakeSTS) Calls() []Call {
+	sts.mutex.Lock()
+	defer sts.mutex.Unlock()
+	calls := make([]Call, len(sts.calls))
+	copy(calls, sts.calls)
+	return calls
+}
+
+// CallsOf returns the calls of the action in order
+func (sts *FakeSTS) CallsOf(action string) (calls []Call) {
+	for _, call := range sts.Calls() {
+		if call.Action == action {
+			calls = append(calls, call)
+		}
+	}
+	return
+}
+
+type errorResponse struct {
+	RequestId string `json:"RequestId"`
+	HostId    string `json:"HostId"`
+	Code      string `json:"Code"`
+	Message   string `json:"Message"`
+}
+
+type assumedRoleUser struct {
+	Arn           string `json:"Arn"`
+	AssumedRoleId string `json:"AssumedRoleId"`
+}
+
+type stsCredentials struct {
+	AccessKeyId     string `json:"AccessKeyId"`
+	AccessKeySecret string `json:"AccessKeySecret"`
+	SecurityToken   string `json:"SecurityToken"`
+	Expiration      string `json:"Expiration"`
+}
+
+type assumeRoleResponse struct {
+	RequestId       string           `json:"RequestId"`
+	AssumedRoleUser *assumedRoleUser `json:"AssumedRoleUser"`
+	Credentials     *stsCredentials  `json:"Credentials"`
+}
+
+type getCallerIdentityResponse struct {
+	RequestId    string `json:"RequestId"`
+	AccountId    string `json:"AccountId"`
+	UserId       string `json:"UserId"`
+	Arn          string `json:"Arn"`
+	IdentityType string `json:"IdentityType"`
+}
+
+// stsError is the error response of an action
+type stsError struct {
+	statusCode int
+	code       string
+	message    string
+}
+
+func newSTSError(statusCode int, code string, message string) *stsError {
+	return &stsError{statusCode: statusCode, code: code, message: message}
+}
+
+func (sts *FakeSTS) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	params := make(map[string]string)
+	for key, values := range r.URL.Query() {
+		params[key] = values[0]
+	}
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err == nil {
+			for key, values := range r.PostForm {
+				params[key] = values[0]
+			}
+		}
+	}
+
+	sts.mutex.Lock()
+	defer sts.mutex.Unlock()
+
+	sts.counter++
+	requestId := "fake-request-" + strconv.Itoa(sts.counter)
+	action := params["Action"]
+	result, stsErr := sts.handle(r.Method, action, params, requestId)
+	call := Call{
+		Action:     action,
+		Params:     params,
+		StatusCode: http.StatusOK,
+	}
+	if stsErr != nil {
+		call.StatusCode = stsErr.statusCode
+		call.Code = stsErr.code
+		result = &errorResponse{
+			RequestId: requestId,
+			HostId:    "sts.aliyuncs.com",
+			Code:      stsErr.code,
+			Message:   stsErr.message,
+		}
+	}
+	sts.calls = append(sts.calls, call)
+
+	content, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(call.StatusCode)
+	w.Write(content)
+}
+
+// handle returns the response of the action, the mutex is locked
+func (sts *FakeSTS) handle(method string, action string, params map[string]string, requestId string) (result interface{}, stsErr *stsError) {
+	if method != http.MethodGet && method != http.MethodPost {
+		return nil, newSTSError(http.StatusMethodNotAllowed, "UnsupportedHTTPMethod", "This http method is not supported.")
+	}
+
+	if stsErr = sts.popFailure(action); stsErr != nil {
+		return
+	}
+
+	switch action {
+	case "AssumeRole":
+		_, stsErr = sts.verifySignature(method, params)
+		if stsErr != nil {
+			return
+		}
+		return sts.assumeRole(params, requestId)
+	case "AssumeRoleWithOIDC":
+		if params["OIDCProviderArn"] == "" {
+			return nil, newSTSError(http.StatusBadRequest, "MissingOIDCProviderArn", "OIDCProviderArn is mandatory for this action.")
+		}
+		if params["OIDCToken"] == "" {
+			return nil, newSTSError(http.StatusBadRequest, "MissingOIDCToken", "OIDCToken is mandatory for this action.")
+		}
+		if !sts.oidcTokens[params["OIDCToken"]] {
+			return nil, newSTSError(http.StatusBadRequest, "AuthenticationFail.OIDCToken.Invalid", "The OIDCToken is invalid.")
+		}
+		return sts.assumeRole(params, requestId)
+	case "GetCallerIdentity":
+		var session *stsSession
+		session, stsErr = sts.verifySignature(method, params)
+		if stsErr != nil {
+			return
+		}
+		if session != nil {
+			return &getCallerIdentityResponse{
+				RequestId:    requestId,
+				AccountId:    AccountId,
+				UserId:       session.assumedRoleId,
+				Arn:          session.arn,
+				IdentityType: "AssumedRoleUser",
+			}, nil
+		}
+		return &getCallerIdentityResponse{
+			RequestId:    requestId,
+			AccountId:    AccountId,
+			UserId:       params["AccessKeyId"],
+			Arn:          "acs:ram::" + AccountId + ":user/" + params["AccessKeyId"],
+			IdentityType: "RAMUser",
+		}, nil
+	default:
+		return nil, newSTSError(http.StatusNotFound, "InvalidAction.NotFound", "Specified api is not found, please check your url and method.")
+	}
+}
+
+func (sts *FakeSTS) popFailure(action string) *stsError {
+	for index, failure := range sts.failures {
+		if failure.Action != "" && failure.Action != action {
+			continue
+		}
+
+		sts.failures = append(sts.failures[:index], sts.failures[index+1:]...)
+		statusCode := failure.StatusCode
+		if statusCode == 0 {
+			statusCode = http.StatusBadRequest
+		}
+		return newSTSError(statusCode, failure.Code, failure.Message)
+	}
+	return nil
+}
+
+// verifySignature checks the HMAC-SHA1 signature, session is not nil for the STS credentials issued by FakeSTS
+func (sts *FakeSTS) verifySignature(method string, params map[string]string) (session *stsSession, stsErr *stsError) {
+	accessKeyId := params["AccessKeyId"]
+	if accessKeyId == "" {
+		return nil, newSTSError(http.StatusBadRequest, "MissingAccessKeyId", "AccessKeyId is mandatory for this action.")
+	}
+
+	if params["SignatureMethod"] != "HMAC-SHA1" || params["SignatureVersion"] != "1.0" {
+		return nil, newSTSError(http.StatusBadRequest, "InvalidParameter.SignatureMethod", "The signature method or version is not supported.")
+	}
+
+	accessKeySecret, ok := sts.accessKeys[accessKeyId]
+	if !ok {
+		session, ok = sts.sessions[accessKeyId]
+		if !ok {
+			return nil, newSTSError(http.StatusNotFound, "InvalidAccessKeyId.NotFound", "Specified access key is not found.")
+		}
+		if params["SecurityToken"] != session.securityToken {
+			return nil, newSTSError(http.StatusBadRequest, "InvalidSecurityToken.Mismatch", "Specified SecurityToken mismatch with the AccessKey.")
+		}
+		if !sts.clock.Now().Before(session.expiration) {
+			return nil, newSTSError(http.StatusBadRequest, "InvalidSecurityToken.Expired", "Specified SecurityToken is expired.")
+		}
+		accessKeySecret = session.accessKeySecret
+	}
+
+	signParams := make(map[string]string)
+	for key, value := range params {
+		if key != "Signature" {
+			signParams[key] = value
+		}
+	}
+	stringToSign := utils.GetRPCStringToSign(method, signParams)
+	if utils.GetRPCSignature(stringToSign, accessKeySecret) != params["Signature"] {
+		return nil, newSTSError(http.StatusBadRequest, "SignatureDoesNotMatch", "Specified signature is not matched with our calculation. server string to sign is:"+stringToSign)
+	}
+
+	nonce := params["SignatureNonce"]
+	if sts.usedNonces[nonce] {
+		return nil, newSTSError(http.StatusBadRequest, "SignatureNonceUsed", "Specified signature nonce was used already.")
+	}
+	sts.usedNonces[nonce] = true
+	return
+}
+
+func (sts *FakeSTS) assumeRole(params map[string]string, requestId string) (result interface{}, stsErr *stsError) {
+	roleArn := params["RoleArn"]
+	if roleArn == "" {
+		return nil, newSTSError(http.StatusBadRequest, "MissingRoleArn", "RoleArn is mandatory for this action.")
+	}
+
+	roleSessionName := params["RoleSessionName"]
+	if roleSessionName == "" {
+		return nil, newSTSError(http.StatusBadRequest, "MissingRoleSessionName", "RoleSessionName is mandatory for this action.")
+	}
+
+	durationSeconds := defaultDurationSeconds
+	if value := params["DurationSeconds"]; value != "" {
+		var err error
+		durationSeconds, err = strconv.Atoi(value)
+		if err != nil || durationSeconds < minDurationSeconds || durationSeconds > maxDurationSeconds {
+			return nil, newSTSError(http.StatusBadRequest, "InvalidParameter.DurationSeconds", "The parameter DurationSeconds is wrongly formed.")
+		}
+	}
+
+	// 角色名称取 RoleArn 中 role/ 之后的部分
+	roleName := roleArn
+	if index := strings.LastIndex(roleArn, "/"); index >= 0 {
+		roleName = roleArn[index+1:]
+	}
+
+	suffix := strconv.Itoa(sts.counter)
+	assumedRoleId := "3000000000000000" + suffix + ":" + roleSessionName
+	session := &stsSession{
+		accessKeySecret: "FakeAccessKeySecret" + suffix,
+		securityToken:   "FakeSecurityToken" + suffix,
+		arn:             "acs:ram::" + AccountId + ":assumed-role/" + roleName + "/" + roleSessionName,
+		assumedRoleId:   assumedRoleId,
+		expiration:      sts.clock.Now().Add(time.Duration(durationSeconds) * time.Second),
+	}
+	accessKeyId := "STS.FakeAccessKeyId" + suffix
+	sts.sessions[accessKeyId] = session
+
+	return &assumeRoleResponse{
+		RequestId: requestId,
+		AssumedRoleUser: &assumedRoleUser{
+			Arn:           session.arn,
+			AssumedRoleId: assumedRoleId,
+		},
+		Credentials: &stsCredentials{
+			AccessKeyId:     accessKeyId,
+			AccessKeySecret: session.accessKeySecret,
+			SecurityToken:   session.securityToken,
+			Expiration:      session.expiration.UTC().Format("2006-01-02T15:04:05Z"),
+		},
+	}, nil
+}
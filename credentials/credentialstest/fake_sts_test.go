@@ -0,0 +1,332 @@
+package credentialstest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/internal/utils"
+	"github.com/aliyun/credentials-go/credentials/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRoleArn = "acs:ram::1234567890123456:role/test"
+
+func newRAMRoleARNProvider(t *testing.T, sts *FakeSTS, accessKeySecret string, retry *providers.RetryOptions) *providers.RAMRoleARNCredentialsProvider {
+	httpOptions := sts.HttpOptions()
+	httpOptions.Retry = retry
+	provider, err := providers.NewRAMRoleARNCredentialsProviderBuilder().
+		WithAccessKeyId("akid").
+		WithAccessKeySecret(accessKeySecret).
+		WithRoleArn(testRoleArn).
+		WithRoleSessionName("session").
+		WithStsEndpoint(sts.Endpoint()).
+		WithHttpOptions(httpOptions).
+		Build()
+	assert.Nil(t, err)
+	return provider
+}
+
+// callSTS calls the action with the signed parameters, accessKeySecret is empty for the anonymous actions
+func callSTS(t *testing.T, sts *FakeSTS, method string, params map[string]string, accessKeySecret string) (status int, body map[string]interface{}) {
+	if accessKeySecret != "" {
+		params["SignatureMethod"] = "HMAC-SHA1"
+		params["SignatureVersion"] = "1.0"
+		params["SignatureNonce"] = utils.GetNonce()
+		params["Signature"] = utils.GetRPCSignature(utils.GetRPCStringToSign(method, params), accessKeySecret)
+	}
+
+	values := url.Values{}
+	for key, value := range params {
+		values.Set(key, value)
+	}
+	req, err := http.NewRequest(method, "https://"+sts.Endpoint()+"/?"+values.Encode(), nil)
+	assert.Nil(t, err)
+	res, err := sts.HttpOptions().Client.Do(req)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	content, err := ioutil.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(content, &body))
+	return res.StatusCode, body
+}
+
+func TestFakeSTSAssumeRole(t *testing.T) {
+	sts := NewFakeSTS()
+	defer sts.Close()
+	sts.AddAccessKey("akid", "aksecret")
+	sts.SetClock(NewFakeClock(time.Date(2121, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	provider := newRAMRoleARNProvider(t, sts, "aksecret", nil)
+	cc, err := provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "STS.FakeAccessKeyId1", cc.AccessKeyId)
+	assert.Equal(t, "FakeAccessKeySecret1", cc.AccessKeySecret)
+	assert.Equal(t, "FakeSecurityToken1", cc.SecurityToken)
+	assert.Equal(t, time.Date(2121, 1, 1, 1, 0, 0, 0, time.UTC), cc.Expiration)
+
+	calls := sts.Calls()
+	assert.Len(t, calls, 1)
+	assert.Equal(t, "AssumeRole", calls[0].Action)
+	assert.Equal(t, 200, calls[0].StatusCode)
+	assert.Equal(t, "", calls[0].Code)
+	assert.Equal(t, "akid", calls[0].Params["AccessKeyId"])
+	assert.Equal(t, testRoleArn, calls[0].Params["RoleArn"])
+	assert.Equal(t, "session", calls[0].Params["RoleSessionName"])
+	assert.Equal(t, "3600", calls[0].Params["DurationSeconds"])
+
+	// the role chaining with the issued credentials
+	status, body := callSTS(t, sts, "POST", map[string]string{
+		"Action":          "AssumeRole",
+		"AccessKeyId":     cc.AccessKeyId,
+		"SecurityToken":   cc.SecurityToken,
+		"RoleArn":         "acs:ram::1234567890123456:role/chained",
+		"RoleSessionName": "chained",
+		"DurationSeconds": "900",
+	}, cc.AccessKeySecret)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "acs:ram::1234567890123456:assumed-role/chained/chained", body["AssumedRoleUser"].(map[string]interface{})["Arn"])
+	assert.Equal(t, "2121-01-01T00:15:00Z", body["Credentials"].(map[string]interface{})["Expiration"])
+
+	status, body = callSTS(t, sts, "POST", map[string]string{
+		"Action":          "AssumeRole",
+		"AccessKeyId":     cc.AccessKeyId,
+		"SecurityToken":   "invalid",
+		"RoleArn":         testRoleArn,
+		"RoleSessionName": "chained",
+	}, cc.AccessKeySecret)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "InvalidSecurityToken.Mismatch", body["Code"])
+}
+
+func TestFakeSTSExpiredSecurityToken(t *testing.T) {
+	sts := NewFakeSTS()
+	defer sts.Close()
+	sts.AddAccessKey("akid", "aksecret")
+	clock := NewFakeClock(time.Date(2121, 1, 1, 0, 0, 0, 0, time.UTC))
+	sts.SetClock(clock)
+
+	provider := newRAMRoleARNProvider(t, sts, "aksecret", nil)
+	cc, err := provider.GetCredentials()
+	assert.Nil(t, err)
+
+	getCallerIdentity := func() (int, map[string]interface{}) {
+		return callSTS(t, sts, "GET", map[string]string{
+			"Action":        "GetCallerIdentity",
+			"AccessKeyId":   cc.AccessKeyId,
+			"SecurityToken": cc.SecurityToken,
+		}, cc.AccessKeySecret)
+	}
+
+	clock.Advance(time.Hour - time.Second)
+	status, body := getCallerIdentity()
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "AssumedRoleUser", body["IdentityType"])
+
+	clock.Advance(time.Second)
+	status, body = getCallerIdentity()
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "InvalidSecurityToken.Expired", body["Code"])
+
+	// the access keys never expire
+	status, _ = callSTS(t, sts, "GET", map[string]string{"Action": "GetCallerIdentity", "AccessKeyId": "akid"}, "aksecret")
+	assert.Equal(t, 200, status)
+}
+
+func TestFakeSTSWithProviderClock(t *testing.T) {
+	sts := NewFakeSTS()
+	defer sts.Close()
+	sts.AddAccessKey("akid", "aksecret")
+	clock := NewFakeClock(time.Date(2121, 1, 1, 0, 0, 0, 0, time.UTC))
+	sts.SetClock(clock)
+
+	provider, err := providers.NewRAMRoleARNCredentialsProviderBuilder().
+		WithAccessKeyId("akid").
+		WithAccessKeySecret("aksecret").
+		WithRoleArn(testRoleArn).
+		WithRoleSessionName("session").
+		WithStsEndpoint(sts.Endpoint()).
+		WithHttpOptions(sts.HttpOptions()).
+		WithClock(clock).
+		Build()
+	assert.Nil(t, err)
+
+	cc, err := provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "STS.FakeAccessKeyId1", cc.AccessKeyId)
+	assert.Equal(t, time.Date(2121, 1, 1, 1, 0, 0, 0, time.UTC), cc.Expiration)
+
+	// one clock drives both the provider and the sts
+	clock.Advance(58 * time.Minute)
+	cc, err = provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "STS.FakeAccessKeyId2", cc.AccessKeyId)
+	assert.Equal(t, time.Date(2121, 1, 1, 1, 58, 0, 0, time.UTC), cc.Expiration)
+	assert.Len(t, sts.CallsOf("AssumeRole"), 2)
+}
+
+func TestFakeSTSAssumeRoleError(t *testing.T) {
+	sts := NewFakeSTS()
+	defer sts.Close()
+	sts.AddAccessKey("akid", "aksecret")
+
+	provider := newRAMRoleARNProvider(t, sts, "invalid", nil)
+	_, err := provider.GetCredentials()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "SignatureDoesNotMatch")
+	assert.Equal(t, "SignatureDoesNotMatch", sts.Calls()[0].Code)
+
+	cases := []struct {
+		params map[string]string
+		status int
+		code   string
+	}{
+		{map[string]string{"Action": "AssumeRole", "AccessKeyId": "notexist", "RoleArn": testRoleArn, "RoleSessionName": "s"}, 404, "InvalidAccessKeyId.NotFound"},
+		{map[string]string{"Action": "AssumeRole", "AccessKeyId": "akid", "RoleSessionName": "s"}, 400, "MissingRoleArn"},
+		{map[string]string{"Action": "AssumeRole", "AccessKeyId": "akid", "RoleArn": testRoleArn}, 400, "MissingRoleSessionName"},
+		{map[string]string{"Action": "AssumeRole", "AccessKeyId": "akid", "RoleArn": testRoleArn, "RoleSessionName": "s", "DurationSeconds": "899"}, 400, "InvalidParameter.DurationSeconds"},
+		{map[string]string{"Action": "AssumeRole", "AccessKeyId": "akid", "RoleArn": testRoleArn, "RoleSessionName": "s", "DurationSeconds": "43201"}, 400, "InvalidParameter.DurationSeconds"},
+		{map[string]string{"Action": "Invalid"}, 404, "InvalidAction.NotFound"},
+	}
+	for _, c := range cases {
+		status, body := callSTS(t, sts, "GET", c.params, "aksecret")
+		assert.Equal(t, c.status, status)
+		assert.Equal(t, c.code, body["Code"])
+	}
+
+	status, body := callSTS(t, sts, "GET", map[string]string{"Action": "AssumeRole", "RoleArn": testRoleArn, "RoleSessionName": "s"}, "")
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "MissingAccessKeyId", body["Code"])
+
+	// the nonce can not be used again
+	params := map[string]string{"Action": "GetCallerIdentity", "AccessKeyId": "akid"}
+	status, _ = callSTS(t, sts, "GET", params, "aksecret")
+	assert.Equal(t, 200, status)
+	status, body = callSTS(t, sts, "GET", params, "")
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "SignatureNonceUsed", body["Code"])
+}
+
+func TestFakeSTSFailures(t *testing.T) {
+	sts := NewFakeSTS()
+	defer sts.Close()
+	sts.AddAccessKey("akid", "aksecret")
+
+	// the throttled calls are retried
+	sts.Throttle(2)
+	provider := newRAMRoleARNProvider(t, sts, "aksecret", &providers.RetryOptions{
+		MaxAttempts: 3,
+		BaseBackoff: time.Millisecond,
+	})
+	cc, err := provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "STS.FakeAccessKeyId3", cc.AccessKeyId)
+	calls := sts.CallsOf("AssumeRole")
+	assert.Len(t, calls, 3)
+	assert.Equal(t, "Throttling", calls[0].Code)
+	assert.Equal(t, "Throttling", calls[1].Code)
+	assert.Equal(t, "", calls[2].Code)
+	// the retries are signed again
+	assert.NotEqual(t, calls[0].Params["SignatureNonce"], calls[2].Params["SignatureNonce"])
+
+	// the failure only matches the action
+	sts.FailNext(Failure{
+		Action:     "AssumeRole",
+		StatusCode: 403,
+		Code:       "NoPermission",
+		Message:    "You are not authorized to do this action.",
+	})
+	status, _ := callSTS(t, sts, "GET", map[string]string{"Action": "GetCallerIdentity", "AccessKeyId": "akid"}, "aksecret")
+	assert.Equal(t, 200, status)
+
+	provider = newRAMRoleARNProvider(t, sts, "aksecret", nil)
+	_, err = provider.GetCredentials()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "NoPermission")
+	assert.Equal(t, 403, sts.Calls()[4].StatusCode)
+
+	// the status code is 400 by default
+	sts.FailNext(Failure{Code: "InternalError"})
+	status, body := callSTS(t, sts, "GET", map[string]string{"Action": "GetCallerIdentity", "AccessKeyId": "akid"}, "aksecret")
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "InternalError", body["Code"])
+	assert.Equal(t, "sts.aliyuncs.com", body["HostId"])
+}
+
+func TestFakeSTSAssumeRoleWithOIDC(t *testing.T) {
+	sts := NewFakeSTS()
+	defer sts.Close()
+	sts.AddOIDCToken("oidctoken")
+
+	dir, err := ioutil.TempDir("", "credentialstest")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := path.Join(dir, "token")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("oidctoken"), 0600))
+
+	provider, err := providers.NewOIDCCredentialsProviderBuilder().
+		WithOIDCTokenFilePath(tokenFile).
+		WithOIDCProviderARN("acs:ram::1234567890123456:oidc-provider/test").
+		WithRoleArn(testRoleArn).
+		WithRoleSessionName("oidc").
+		WithSTSEndpoint(sts.Endpoint()).
+		WithHttpOptions(sts.HttpOptions()).
+		Build()
+	assert.Nil(t, err)
+	cc, err := provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(cc.AccessKeyId, "STS.FakeAccessKeyId"))
+	calls := sts.CallsOf("AssumeRoleWithOIDC")
+	assert.Len(t, calls, 1)
+	assert.Equal(t, "oidctoken", calls[0].Params["OIDCToken"])
+
+	// the issued credentials can be used to call GetCallerIdentity
+	status, body := callSTS(t, sts, "POST", map[string]string{
+		"Action":        "GetCallerIdentity",
+		"AccessKeyId":   cc.AccessKeyId,
+		"SecurityToken": cc.SecurityToken,
+	}, cc.AccessKeySecret)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "AssumedRoleUser", body["IdentityType"])
+	assert.Equal(t, "acs:ram::1234567890123456:assumed-role/test/oidc", body["Arn"])
+	assert.Equal(t, AccountId, body["AccountId"])
+
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("invalid"), 0600))
+	provider.Invalidate()
+	_, err = provider.GetCredentials()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "AuthenticationFail.OIDCToken.Invalid")
+
+	status, body = callSTS(t, sts, "POST", map[string]string{"Action": "AssumeRoleWithOIDC", "OIDCToken": "oidctoken"}, "")
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "MissingOIDCProviderArn", body["Code"])
+
+	status, body = callSTS(t, sts, "POST", map[string]string{"Action": "AssumeRoleWithOIDC", "OIDCProviderArn": "arn"}, "")
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "MissingOIDCToken", body["Code"])
+}
+
+func TestFakeSTSGetCallerIdentity(t *testing.T) {
+	sts := NewFakeSTS()
+	defer sts.Close()
+	sts.AddAccessKey("akid", "aksecret")
+
+	status, body := callSTS(t, sts, "GET", map[string]string{"Action": "GetCallerIdentity", "AccessKeyId": "akid"}, "aksecret")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "RAMUser", body["IdentityType"])
+	assert.Equal(t, "acs:ram::1234567890123456:user/akid", body["Arn"])
+	assert.Equal(t, "fake-request-1", body["RequestId"])
+
+	req, err := http.NewRequest("DELETE", "https://"+sts.Endpoint()+"/?Action=GetCallerIdentity", nil)
+	assert.Nil(t, err)
+	res, err := sts.HttpOptions().Client.Do(req)
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, 405, res.StatusCode)
+}
@@ -0,0 +1,277 @@
+package credentialstest
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/internal/clocktest"
+	"github.com/aliyun/credentials-go/credentials/providers"
+)
+
+// ErrScriptExhausted is returned by ScriptedCredentialsProvider when all the scripted results are returned
+var ErrScriptExhausted = errors.New("the scripted results are exhausted")
+
+// ErrEmptyResult is returned by ScriptedCredentialsProvider for a scripted result without credentials and error
+var ErrEmptyResult = errors.New("the scripted result has neither credentials nor error")
+
+var _ providers.TimerClock = (*FakeClock)(nil)
+
+// Result is a scripted result of ScriptedCredentialsProvider, one of Credentials and Err is set
+type Result struct {
+	Credentials *providers.Credentials
+	Err         error
+}
+
+// ScriptedCredentialsProvider returns the scripted credentials and errors in order
+type ScriptedCredentialsProvider struct {
+	providerName string
+	// guards the fields below
+	mutex   sync.Mutex
+	results []Result
+	index   int
+}
+
+// NewScriptedCredentialsProvider returns a provider which returns results in order,
+// ErrScriptExhausted is returned after all of them are returned
+func NewScriptedCredentialsProvider(results ...Result) *ScriptedCredentialsProvider {
+	return &ScriptedCredentialsProvider{
+		providerName: "scripted",
+		results:      results,
+	}
+}
+
+// Append adds the results to the end of the script
+func (provider *ScriptedCredentialsProvider) Append(results ...Result) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.results = append(provider.results, results...)
+}
+
+// Remaining returns the number of the results which are not returned yet
+func (provider *ScriptedCredentialsProvider) Remaining() int {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	return len(provider.results) - provider.index
+}
+
+func (provider *ScriptedCredentialsProvider) GetCredentials() (cc *providers.Credentials, err error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if provider.index >= len(provider.results) {
+		err = ErrScriptExhausted
+		return
+	}
+
+	result := provider.results[provider.index]
+	provider.index++
+	if result.Err != nil {
+		err = result.Err
+		return
+	}
+	if result.Credentials == nil {
+		err = ErrEmptyResult
+		return
+	}
+
+	cc = copyCredentials(result.Credentials)
+	if cc.ProviderName == "" {
+		cc.ProviderName = provider.providerName
+	}
+	return
+}
+
+// GetCredentialsWithContext returns the error of ctx without consuming a result if ctx is done
+func (provider *ScriptedCredentialsProvider) GetCredentialsWithContext(ctx context.Context) (cc *providers.Credentials, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return provider.GetCredentials()
+}
+
+func (provider *ScriptedCredentialsProvider) GetProviderName() string {
+	return provider.providerName
+}
+
+// CallRecord is a call of RecordingCredentialsProvider
+type CallRecord struct {
+	Start       time.Time
+	Duration    time.Duration
+	Credentials *providers.Credentials
+	Err         error
+}
+
+// RecordingCredentialsProvider wraps a provider, it counts the calls and records the latency of them
+type RecordingCredentialsProvider struct {
+	provider providers.CredentialsProvider
+	// guards the fields below
+	mutex           sync.Mutex
+	records         []CallRecord
+	invalidateCount int
+}
+
+func NewRecordingCredentialsProvider(provider providers.CredentialsProvider) *RecordingCredentialsProvider {
+	return &RecordingCredentialsProvider{
+		provider: provider,
+	}
+}
+
+func (provider *RecordingCredentialsProvider) GetCredentials() (*providers.Credentials, error) {
+	return provider.GetCredentialsWithContext(context.Background())
+}
+
+func (provider *RecordingCredentialsProvider) GetCredentialsWithContext(ctx context.Context) (cc *providers.Credentials, err error) {
+	start := time.Now()
+	cc, err = providers.GetCredentialsWithContext(ctx, provider.provider)
+	record := CallRecord{
+		Start:       start,
+		Duration:    time.Since(start),
+		Credentials: copyCredentials(cc),
+		Err:         err,
+	}
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.records = append(provider.records, record)
+	return
+}
+
+func (provider *RecordingCredentialsProvider) GetProviderName() string {
+	return provider.provider.GetProviderName()
+}
+
+// Invalidate counts the call and invalidates the wrapped provider
+func (provider *RecordingCredentialsProvider) Invalidate() {
+	provider.mutex.Lock()
+	provider.invalidateCount++
+	provider.mutex.Unlock()
+	providers.InvalidateCredentials(provider.provider)
+}
+
+// CallCount returns the number of the calls of GetCredentials() and GetCredentialsWithContext()
+func (provider *RecordingCredentialsProvider) CallCount() int {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	return len(provider.records)
+}
+
+// ErrorCount returns the number of the failed calls
+func (provider *RecordingCredentialsProvider) ErrorCount() (count int) {
+	for _, record := range provider.Records() {
+		if record.Err != nil {
+			count++
+		}
+	}
+	return
+}
+
+// InvalidateCount returns the number of the calls of Invalidate()
+func (provider *RecordingCredentialsProvider) InvalidateCount() int {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	return provider.invalidateCount
+}
+
+// Records returns the calls in order
+func (provider *RecordingCredentialsProvider) Records() []CallRecord {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	records := make([]CallRecord, len(provider.records))
+	copy(records, provider.records)
+	return records
+}
+
+// Reset clears the records and the counters
+func (provider *RecordingCredentialsProvider) Reset() {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.records = nil
+	provider.invalidateCount = 0
+}
+
+// FakeClock is a clock which only moves when it is set or advanced,
+// it can be set by WithClock() of the provider builders to test the refreshes, including the background ones, without sleeping
+type FakeClock = clocktest.FakeClock
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return clocktest.NewFakeClock(now)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ExpiringCredentialsProvider issues the STS credentials which expire in ttl by the clock,
+// the credentials are cached until they expire or Invalidate() is called, like the session providers.
+// The issued credentials are numbered from 1, such as STS.ExpiringAccessKeyId1.
+type ExpiringCredentialsProvider struct {
+	clock providers.Clock
+	ttl   time.Duration
+	// guards the fields below
+	mutex   sync.Mutex
+	current *providers.Credentials
+	issued  int
+}
+
+// NewExpiringCredentialsProvider returns a provider which issues the credentials by clock, the system clock is used if clock is nil
+func NewExpiringCredentialsProvider(clock providers.Clock, ttl time.Duration) *ExpiringCredentialsProvider {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	return &ExpiringCredentialsProvider{
+		clock: clock,
+		ttl:   ttl,
+	}
+}
+
+func (provider *ExpiringCredentialsProvider) GetCredentials() (cc *providers.Credentials, err error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	now := provider.clock.Now()
+	if provider.current == nil || !now.Before(provider.current.Expiration) {
+		provider.issued++
+		suffix := strconv.Itoa(provider.issued)
+		provider.current = &providers.Credentials{
+			AccessKeyId:     "STS.ExpiringAccessKeyId" + suffix,
+			AccessKeySecret: "ExpiringAccessKeySecret" + suffix,
+			SecurityToken:   "ExpiringSecurityToken" + suffix,
+			ProviderName:    provider.GetProviderName(),
+			Expiration:      now.Add(provider.ttl),
+		}
+	}
+
+	cc = copyCredentials(provider.current)
+	return
+}
+
+func (provider *ExpiringCredentialsProvider) GetProviderName() string {
+	return "expiring"
+}
+
+// Invalidate drops the cached credentials, the next GetCredentials() issues new credentials
+func (provider *ExpiringCredentialsProvider) Invalidate() {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.current = nil
+}
+
+// Issued returns the number of the issued credentials
+func (provider *ExpiringCredentialsProvider) Issued() int {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	return provider.issued
+}
+
+func copyCredentials(cc *providers.Credentials) *providers.Credentials {
+	if cc == nil {
+		return nil
+	}
+	copied := *cc
+	return &copied
+}
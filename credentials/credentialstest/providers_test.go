@@ -0,0 +1,183 @@
+package credentialstest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials"
+	"github.com/aliyun/credentials-go/credentials/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScriptedCredentialsProvider(t *testing.T) {
+	provider := NewScriptedCredentialsProvider(
+		Result{Credentials: &providers.Credentials{AccessKeyId: "akid1", AccessKeySecret: "aksecret1"}},
+		Result{Err: errors.New("refresh failed")},
+	)
+	assert.Equal(t, "scripted", provider.GetProviderName())
+	assert.Equal(t, 2, provider.Remaining())
+
+	cc, err := provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "akid1", cc.AccessKeyId)
+	assert.Equal(t, "scripted", cc.ProviderName)
+	// the returned credentials are copies
+	cc.AccessKeyId = "modified"
+
+	_, err = provider.GetCredentials()
+	assert.EqualError(t, err, "refresh failed")
+
+	_, err = provider.GetCredentials()
+	assert.Equal(t, ErrScriptExhausted, err)
+	assert.Equal(t, 0, provider.Remaining())
+
+	provider.Append(Result{Credentials: &providers.Credentials{AccessKeyId: "akid2", ProviderName: "custom"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = provider.GetCredentialsWithContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, provider.Remaining())
+
+	cc, err = provider.GetCredentialsWithContext(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "akid2", cc.AccessKeyId)
+	assert.Equal(t, "custom", cc.ProviderName)
+	assert.Equal(t, "akid1", provider.results[0].Credentials.AccessKeyId)
+}
+
+func TestScriptedCredentialsProviderWithEmptyResult(t *testing.T) {
+	provider := NewScriptedCredentialsProvider(Result{})
+	cc, err := provider.GetCredentials()
+	assert.Nil(t, cc)
+	assert.Equal(t, ErrEmptyResult, err)
+	assert.Equal(t, 0, provider.Remaining())
+}
+
+func TestRecordingCredentialsProvider(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	provider := NewRecordingCredentialsProvider(NewScriptedCredentialsProvider(
+		Result{Credentials: &providers.Credentials{AccessKeyId: "akid"}},
+		Result{Err: errors.New("refresh failed")},
+	))
+	assert.Equal(t, "scripted", provider.GetProviderName())
+
+	cc, err := provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "akid", cc.AccessKeyId)
+	_, err = provider.GetCredentialsWithContext(context.Background())
+	assert.EqualError(t, err, "refresh failed")
+
+	assert.Equal(t, 2, provider.CallCount())
+	assert.Equal(t, 1, provider.ErrorCount())
+	records := provider.Records()
+	assert.Equal(t, "akid", records[0].Credentials.AccessKeyId)
+	assert.Nil(t, records[0].Err)
+	assert.True(t, records[0].Duration >= 0)
+	assert.False(t, records[0].Start.IsZero())
+	assert.Nil(t, records[1].Credentials)
+	assert.EqualError(t, records[1].Err, "refresh failed")
+
+	// the scripted provider has nothing to invalidate
+	provider.Invalidate()
+	assert.Equal(t, 1, provider.InvalidateCount())
+
+	provider.Reset()
+	assert.Equal(t, 0, provider.CallCount())
+	assert.Equal(t, 0, provider.InvalidateCount())
+
+	// the wrapped provider is invalidated
+	expiring := NewExpiringCredentialsProvider(clock, time.Hour)
+	provider = NewRecordingCredentialsProvider(expiring)
+	_, err = provider.GetCredentials()
+	assert.Nil(t, err)
+	providers.InvalidateCredentials(provider)
+	_, err = provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, expiring.Issued())
+	assert.Equal(t, 1, provider.InvalidateCount())
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), clock.Now())
+	clock.Advance(1500 * time.Millisecond)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 1, 500000000, time.UTC), clock.Now())
+	clock.Set(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), clock.Now())
+}
+
+func TestExpiringCredentialsProvider(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	provider := NewExpiringCredentialsProvider(clock, time.Hour)
+	assert.Equal(t, "expiring", provider.GetProviderName())
+
+	cc, err := provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, &providers.Credentials{
+		AccessKeyId:     "STS.ExpiringAccessKeyId1",
+		AccessKeySecret: "ExpiringAccessKeySecret1",
+		SecurityToken:   "ExpiringSecurityToken1",
+		ProviderName:    "expiring",
+		Expiration:      time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC),
+	}, cc)
+
+	clock.Advance(time.Hour - time.Millisecond)
+	cc, err = provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "STS.ExpiringAccessKeyId1", cc.AccessKeyId)
+	assert.Equal(t, 1, provider.Issued())
+
+	clock.Advance(time.Millisecond)
+	cc, err = provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "STS.ExpiringAccessKeyId2", cc.AccessKeyId)
+	assert.Equal(t, time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC), cc.Expiration)
+
+	provider.Invalidate()
+	cc, err = provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "STS.ExpiringAccessKeyId3", cc.AccessKeyId)
+	assert.Equal(t, 3, provider.Issued())
+}
+
+func TestExpiringCredentialsProviderWithSystemClock(t *testing.T) {
+	provider := NewExpiringCredentialsProvider(nil, time.Hour)
+	before := time.Now()
+	cc, err := provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.False(t, cc.Expiration.Before(before.Add(time.Hour)))
+	assert.False(t, cc.Expiration.After(time.Now().Add(time.Hour)))
+}
+
+func TestFromCredentialsProviderWithTestDoubles(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	recording := NewRecordingCredentialsProvider(NewExpiringCredentialsProvider(clock, 15*time.Minute))
+	credential := credentials.FromCredentialsProvider("expiring", recording)
+
+	cm, err := credential.GetCredential()
+	assert.Nil(t, err)
+	assert.Equal(t, "STS.ExpiringAccessKeyId1", *cm.AccessKeyId)
+	assert.Equal(t, "expiring", *cm.Type)
+	assert.Equal(t, "expiring", *cm.ProviderName)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 15, 0, 0, time.UTC), *cm.Expiration)
+
+	// the wrapper gets the credentials from the provider for every call
+	clock.Advance(15 * time.Minute)
+	cm, err = credential.GetCredential()
+	assert.Nil(t, err)
+	assert.Equal(t, "STS.ExpiringAccessKeyId2", *cm.AccessKeyId)
+	assert.Equal(t, 2, recording.CallCount())
+
+	credential = credentials.FromCredentialsProvider("scripted", NewScriptedCredentialsProvider(
+		Result{Err: errors.New("refresh failed")},
+		Result{Credentials: &providers.Credentials{AccessKeyId: "akid", AccessKeySecret: "aksecret"}},
+	))
+	_, err = credential.GetCredential()
+	assert.EqualError(t, err, "refresh failed")
+	cm, err = credential.GetCredential()
+	assert.Nil(t, err)
+	assert.Equal(t, "akid", *cm.AccessKeyId)
+	assert.Nil(t, cm.Expiration)
+}
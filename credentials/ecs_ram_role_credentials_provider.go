@@ -54,7 +54,7 @@ func (e *ECSRAMRoleCredentialsProvider) GetCredential() (credentials *Credential
 	if e.sessionCredential == nil || e.needUpdateCredential() {
 		err = e.updateCredential()
 		if err != nil {
-			if e.credentialExpiration > (int(time.Now().Unix()) - int(e.lastUpdateTimestamp)) {
+			if e.credentialExpiration > (int(e.now().Unix()) - int(e.lastUpdateTimestamp)) {
 				// 虽然有错误，但是已有的 credentials 还有效
 			} else {
 				return
@@ -67,6 +67,7 @@ func (e *ECSRAMRoleCredentialsProvider) GetCredential() (credentials *Credential
 		AccessKeySecret: tea.String(e.sessionCredential.AccessKeySecret),
 		SecurityToken:   tea.String(e.sessionCredential.SecurityToken),
 		Type:            tea.String("ecs_ram_role"),
+		Expiration:      e.expiration(),
 	}
 
 	return
@@ -135,7 +136,7 @@ func (e *ECSRAMRoleCredentialsProvider) getMetadataToken() (err error) {
 		if e.MetadataTokenDuration <= 0 {
 			e.MetadataTokenDuration = defaultMetadataTokenDuration
 		}
-		tmpTime := time.Now().Unix() + int64(e.MetadataTokenDuration*1000)
+		tmpTime := e.now().Unix() + int64(e.MetadataTokenDuration*1000)
 		request := request.NewCommonRequest()
 		request.URL = securityCredTokenURL
 		request.Method = "PUT"
@@ -158,13 +159,13 @@ func (e *ECSRAMRoleCredentialsProvider) updateCredential() (err error) {
 	if e.RoleName == "" {
 		e.RoleName, err = getRoleName()
 		if err != nil {
-			return fmt.Errorf("refresh Ecs sts token err: %s", err.Error())
+			return fmt.Errorf("refresh Ecs sts token err: %w", err)
 		}
 	}
 	if e.EnableIMDSv2 {
 		err = e.getMetadataToken()
 		if err != nil {
-			return fmt.Errorf("failed to get token from ECS Metadata Service: %s", err.Error())
+			return fmt.Errorf("failed to get token from ECS Metadata Service: %w", err)
 		}
 		request.Headers["X-aliyun-ecs-metadata-token"] = e.metadataToken
 	}
@@ -172,12 +173,12 @@ func (e *ECSRAMRoleCredentialsProvider) updateCredential() (err error) {
 	request.Method = "GET"
 	content, err := doAction(request, e.runtime)
 	if err != nil {
-		return fmt.Errorf("refresh Ecs sts token err: %s", err.Error())
+		return fmt.Errorf("refresh Ecs sts token err: %w", err)
 	}
 	var resp *ecsRAMRoleResponse
 	err = json.Unmarshal(content, &resp)
 	if err != nil {
-		return fmt.Errorf("refresh Ecs sts token err: Json Unmarshal fail: %s", err.Error())
+		return fmt.Errorf("refresh Ecs sts token err: Json Unmarshal fail: %w", err)
 	}
 	if resp.Code != "Success" {
 		return fmt.Errorf("refresh Ecs sts token err: Code is not Success")
@@ -187,8 +188,8 @@ func (e *ECSRAMRoleCredentialsProvider) updateCredential() (err error) {
 	}
 
 	expirationTime, err := time.Parse("2006-01-02T15:04:05Z", resp.Expiration)
-	e.lastUpdateTimestamp = time.Now().Unix()
-	e.credentialExpiration = int(expirationTime.Unix() - time.Now().Unix())
+	e.lastUpdateTimestamp = e.now().Unix()
+	e.credentialExpiration = int(expirationTime.Unix() - e.now().Unix())
 	e.sessionCredential = &sessionCredential{
 		AccessKeyId:     resp.AccessKeyId,
 		AccessKeySecret: resp.AccessKeySecret,
@@ -199,6 +200,6 @@ func (e *ECSRAMRoleCredentialsProvider) updateCredential() (err error) {
 }
 
 func (e *ECSRAMRoleCredentialsProvider) needToRefresh() (needToRefresh bool) {
-	needToRefresh = time.Now().Unix() >= e.staleTime
+	needToRefresh = e.now().Unix() >= e.staleTime
 	return
 }
@@ -62,6 +62,10 @@ func Test_EcsRAmRoleCredential(t *testing.T) {
 	_, err = auth.GetAccessKeyId()
 	assert.NotNil(t, err)
 	assert.Equal(t, "refresh Ecs sts token err: httpStatus: 400, message = role", err.Error())
+	var serviceErr *ServiceError
+	assert.True(t, errors.As(err, &serviceErr))
+	assert.Equal(t, 400, serviceErr.StatusCode)
+	assert.Equal(t, "role", serviceErr.Body)
 
 	hookDo = func(fn func(req *http.Request) (*http.Response, error)) func(req *http.Request) (*http.Response, error) {
 		return func(req *http.Request) (*http.Response, error) {
@@ -1,7 +1,6 @@
 package credentials
 
 import (
-	"errors"
 	"os"
 
 	"github.com/alibabacloud-go/tea/tea"
@@ -33,10 +32,10 @@ func (p *envProvider) resolve() (config *Config, err error) {
 		return nil, nil
 	}
 	if accessKeyId == "" {
-		return nil, errors.New(EnvVarAccessKeyIdNew + " or " + EnvVarAccessKeyId + " cannot be empty")
+		return nil, &notConfiguredError{message: EnvVarAccessKeyIdNew + " or " + EnvVarAccessKeyId + " cannot be empty"}
 	}
 	if accessKeySecret == "" {
-		return nil, errors.New(EnvVarAccessKeySecret + " cannot be empty")
+		return nil, &notConfiguredError{message: EnvVarAccessKeySecret + " cannot be empty"}
 	}
 
 	securityToken := os.Getenv("ALIBABA_CLOUD_SECURITY_TOKEN")
@@ -0,0 +1,98 @@
+// Package clocktest provides the fake clock shared by the tests of the providers and the credentialstest package
+package clocktest
+
+import (
+	"sync"
+	"time"
+)
+
+type waiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+// FakeClock is a clock which only moves when it is set or advanced,
+// the channels returned by After() receive when the clock is moved past their deadlines
+type FakeClock struct {
+	mutex sync.Mutex
+	// signaled when the waiters change
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	clock := &FakeClock{
+		now: now,
+	}
+	clock.cond = sync.NewCond(&clock.mutex)
+	return clock
+}
+
+func (clock *FakeClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+// After returns a channel which receives the time of the clock once the clock is moved by d
+func (clock *FakeClock) After(d time.Duration) <-chan time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	w := &waiter{
+		deadline: clock.now.Add(d),
+		c:        make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		w.c <- clock.now
+		return w.c
+	}
+	clock.waiters = append(clock.waiters, w)
+	clock.cond.Broadcast()
+	return w.c
+}
+
+// Advance moves the clock forward by d
+func (clock *FakeClock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.setLocked(clock.now.Add(d))
+}
+
+func (clock *FakeClock) Set(now time.Time) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.setLocked(now)
+}
+
+// Waiters returns the number of the channels returned by After() which are not fired yet
+func (clock *FakeClock) Waiters() int {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return len(clock.waiters)
+}
+
+// BlockUntil blocks until there are at least n channels returned by After() which are not fired yet,
+// such as the wait of a background refresh, so that the next Advance() fires them
+func (clock *FakeClock) BlockUntil(n int) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	for len(clock.waiters) < n {
+		clock.cond.Wait()
+	}
+}
+
+func (clock *FakeClock) setLocked(now time.Time) {
+	clock.now = now
+	pending := clock.waiters[:0]
+	for _, w := range clock.waiters {
+		if now.Before(w.deadline) {
+			pending = append(pending, w)
+			continue
+		}
+		w.c <- now
+	}
+	clock.waiters = pending
+	clock.cond.Broadcast()
+}
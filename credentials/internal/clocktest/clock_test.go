@@ -0,0 +1,54 @@
+package clocktest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), clock.Now())
+	clock.Advance(time.Minute)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC), clock.Now())
+	clock.Set(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), clock.Now())
+}
+
+func TestFakeClockAfter(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), <-clock.After(0))
+	assert.Equal(t, 0, clock.Waiters())
+
+	c1 := clock.After(time.Minute)
+	c2 := clock.After(time.Hour)
+	assert.Equal(t, 2, clock.Waiters())
+
+	clock.Advance(time.Minute - time.Millisecond)
+	select {
+	case <-c1:
+		assert.Fail(t, "fired before the deadline")
+	default:
+	}
+
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC), <-c1)
+	assert.Equal(t, 1, clock.Waiters())
+
+	clock.Set(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), <-c2)
+	assert.Equal(t, 0, clock.Waiters())
+}
+
+func TestFakeClockBlockUntil(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	fired := make(chan time.Time)
+	go func() {
+		fired <- <-clock.After(time.Minute)
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC), <-fired)
+}
@@ -11,9 +11,9 @@ import (
 	"net/url"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/alibabacloud-go/debug/debug"
 	credentials_go "github.com/aliyun/credentials-go"
 	"github.com/aliyun/credentials-go/credentials/internal/utils"
 )
@@ -33,6 +33,12 @@ type Request struct {
 	Path           string
 	Queries        map[string]string
 	Headers        map[string]string
+	// Client is used to send the request if it is set, Proxy and ConnectTimeout are ignored
+	Client *http.Client
+	// Transport is used to send the request if it is set and Client is nil, Proxy and ConnectTimeout are ignored
+	Transport http.RoundTripper
+	// Logger is used to log the request and the response, the global logger is used if it is nil
+	Logger utils.Logger
 }
 
 func (req *Request) BuildRequestURL() string {
@@ -63,9 +69,61 @@ var hookDo = func(fn do) do {
 	return fn
 }
 
-var debuglog = debug.Init("credential")
+type transportKey struct {
+	proxy          string
+	connectTimeout time.Duration
+}
+
+// transports are shared by the requests with the same proxy and connect timeout, so that the connections can be reused
+var transports = struct {
+	sync.Mutex
+	cache map[transportKey]*http.Transport
+}{
+	cache: make(map[transportKey]*http.Transport),
+}
+
+// GetTransport returns the shared transport of the proxy and the connect timeout
+func GetTransport(proxy string, connectTimeout time.Duration) (transport *http.Transport, err error) {
+	key := transportKey{
+		proxy:          proxy,
+		connectTimeout: connectTimeout,
+	}
+
+	transports.Lock()
+	defer transports.Unlock()
+	if transport = transports.cache[key]; transport != nil {
+		return
+	}
+
+	transport = http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		var proxyURL *url.URL
+		proxyURL, err = url.Parse(proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if connectTimeout != 0 {
+		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return (&net.Dialer{
+				Timeout:   connectTimeout,
+				DualStack: true,
+			}).DialContext(ctx, network, address)
+		}
+	}
+
+	transports.cache[key] = transport
+	return
+}
 
 func Do(req *Request) (res *Response, err error) {
+	return DoWithContext(context.Background(), req)
+}
+
+// DoWithContext sends the request and aborts it when ctx is cancelled or its deadline is exceeded.
+func DoWithContext(ctx context.Context, req *Request) (res *Response, err error) {
 	querystring := utils.GetURLFormedMap(req.Queries)
 	// do request
 	httpUrl := fmt.Sprintf("%s://%s%s?%s", req.Protocol, req.Host, req.Path, querystring)
@@ -86,6 +144,7 @@ func Do(req *Request) (res *Response, err error) {
 	if err != nil {
 		return
 	}
+	httpRequest = httpRequest.WithContext(ctx)
 
 	httpRequest.Header["User-Agent"] = []string{defaultUserAgent}
 
@@ -95,42 +154,36 @@ func Do(req *Request) (res *Response, err error) {
 
 	for key, value := range req.Headers {
 		if value != "" {
-			debuglog("> %s: %s", key, value)
 			httpRequest.Header.Set(key, value)
 		}
 	}
 
-	httpClient := &http.Client{}
-
-	if req.ReadTimeout != 0 {
-		httpClient.Timeout = req.ReadTimeout + req.ConnectTimeout
-	}
+	logger := utils.GetLogger(req.Logger)
+	logger.DebugContext(ctx, "send request", "method", req.Method, "url", utils.RedactURL(httpUrl), "headers", utils.RedactHeaders(req.Headers))
 
-	transport := http.DefaultTransport.(*http.Transport).Clone()
-	if req.Proxy != "" {
-		var proxy *url.URL
-		proxy, err = url.Parse(req.Proxy)
-		if err != nil {
-			return
+	// 使用调用方的 client 时不修改其超时设置
+	httpClient := req.Client
+	if httpClient == nil {
+		httpClient = &http.Client{}
+		if req.ReadTimeout != 0 {
+			httpClient.Timeout = req.ReadTimeout + req.ConnectTimeout
 		}
-		transport.Proxy = http.ProxyURL(proxy)
-	}
-
-	if req.ConnectTimeout != 0 {
-		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
-			return (&net.Dialer{
-				Timeout:   req.ConnectTimeout,
-				DualStack: true,
-			}).DialContext(ctx, network, address)
+		if req.Transport != nil {
+			httpClient.Transport = req.Transport
+		} else {
+			httpClient.Transport, err = GetTransport(req.Proxy, req.ConnectTimeout)
+			if err != nil {
+				return
+			}
 		}
 	}
 
-	httpClient.Transport = transport
-
 	httpResponse, err := hookDo(httpClient.Do)(httpRequest)
 	if err != nil {
+		logger.DebugContext(ctx, "request failed", "method", req.Method, "url", utils.RedactURL(httpUrl))
 		return
 	}
+	logger.DebugContext(ctx, "receive response", "method", req.Method, "url", utils.RedactURL(httpUrl), "status", httpResponse.StatusCode)
 
 	defer httpResponse.Body.Close()
 
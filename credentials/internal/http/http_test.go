@@ -1,11 +1,15 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -215,3 +219,158 @@ func TestDoWithReadTimeout(t *testing.T) {
 	_, err := Do(req)
 	assert.Contains(t, err.Error(), "(Client.Timeout exceeded while awaiting headers)")
 }
+
+func TestDoWithContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	req := &Request{
+		Method:      "GET",
+		URL:         server.URL,
+		ReadTimeout: 10 * time.Second,
+	}
+
+	// case 1: cancelled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := DoWithContext(ctx, req)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "context canceled")
+
+	// case 2: deadline exceeded before read timeout
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = DoWithContext(ctx, req)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "context deadline exceeded")
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+type recordingTransport struct {
+	requests int
+	next     http.RoundTripper
+}
+
+func (transport *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport.requests++
+	return transport.next.RoundTrip(req)
+}
+
+func TestDoWithTransportAndClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// custom transport
+	transport := &recordingTransport{next: http.DefaultTransport}
+	req := &Request{
+		Method:    "GET",
+		URL:       server.URL,
+		Transport: transport,
+		// ignored when the transport is specified
+		Proxy: "http://localhost:9999/",
+	}
+	res, err := Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", string(res.Body))
+	assert.Equal(t, 1, transport.requests)
+
+	// custom client takes precedence over transport
+	clientTransport := &recordingTransport{next: http.DefaultTransport}
+	client := &http.Client{Transport: clientTransport}
+	req.Client = client
+	req.ReadTimeout = 5 * time.Second
+	res, err = Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", string(res.Body))
+	assert.Equal(t, 1, transport.requests)
+	assert.Equal(t, 1, clientTransport.requests)
+	// the timeout of the client is not modified
+	assert.Equal(t, time.Duration(0), client.Timeout)
+}
+
+func TestDoWithClientTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// the timeout of the client is honoured even if the read timeout is set
+	req := &Request{
+		Method:      "GET",
+		URL:         server.URL,
+		Client:      &http.Client{Timeout: 10 * time.Millisecond},
+		ReadTimeout: 10 * time.Second,
+	}
+	_, err := Do(req)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Client.Timeout exceeded")
+}
+
+func TestGetTransport(t *testing.T) {
+	transport1, err := GetTransport("", time.Second)
+	assert.Nil(t, err)
+	transport2, err := GetTransport("", time.Second)
+	assert.Nil(t, err)
+	assert.True(t, transport1 == transport2)
+
+	transport3, err := GetTransport("http://localhost:9999/", time.Second)
+	assert.Nil(t, err)
+	assert.True(t, transport1 != transport3)
+
+	transport4, err := GetTransport("", 2*time.Second)
+	assert.Nil(t, err)
+	assert.True(t, transport1 != transport4)
+
+	_, err = GetTransport(string([]byte{0x7f}), time.Second)
+	assert.Contains(t, err.Error(), "net/url: invalid control character in URL")
+}
+
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) DebugContext(ctx context.Context, msg string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf("%s %v", msg, args))
+}
+
+func (l *testLogger) InfoContext(ctx context.Context, msg string, args ...interface{}) {}
+
+func (l *testLogger) WarnContext(ctx context.Context, msg string, args ...interface{}) {}
+
+func (l *testLogger) ErrorContext(ctx context.Context, msg string, args ...interface{}) {}
+
+func TestDoWithLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"AccessKeySecret":"aksecret"}`))
+	}))
+	defer server.Close()
+
+	logger := &testLogger{}
+	req := &Request{
+		Method: "GET",
+		URL:    server.URL + "/?AccessKeyId=akid&SecurityToken=token&Signature=sign",
+		Headers: map[string]string{
+			"Authorization":        "Bearer token",
+			"x-acs-metadata-token": "mtoken",
+		},
+		Logger: logger,
+	}
+	res, err := Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Len(t, logger.lines, 2)
+	assert.True(t, strings.HasPrefix(logger.lines[0], "send request [method GET url "+server.URL+"/?AccessKeyId=akid&SecurityToken=%2A%2A%2A%2A%2A%2A&Signature=%2A%2A%2A%2A%2A%2A headers map["))
+	assert.True(t, strings.Contains(logger.lines[0], "Authorization:******"))
+	assert.False(t, strings.Contains(logger.lines[0], "Bearer token"))
+	assert.False(t, strings.Contains(logger.lines[0], "mtoken"))
+	assert.Equal(t, "receive response [method GET url "+server.URL+"/?AccessKeyId=akid&SecurityToken=%2A%2A%2A%2A%2A%2A&Signature=%2A%2A%2A%2A%2A%2A status 200]", logger.lines[1])
+}
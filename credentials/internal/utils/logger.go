@@ -0,0 +1,211 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Logger is the structured logger, it is compatible with *slog.Logger of log/slog.
+// args are the alternating keys and values, as the args of slog.
+type Logger interface {
+	DebugContext(ctx context.Context, msg string, args ...interface{})
+	InfoContext(ctx context.Context, msg string, args ...interface{})
+	WarnContext(ctx context.Context, msg string, args ...interface{})
+	ErrorContext(ctx context.Context, msg string, args ...interface{})
+}
+
+const redactedValue = "******"
+
+// the keys of the sensitive values, they are compared in lower case without '-' and '_'
+var sensitiveKeys = map[string]bool{
+	"accesskeysecret":   true,
+	"securitytoken":     true,
+	"xacssecuritytoken": true,
+	"xacsmetadatatoken": true,
+	"oidctoken":         true,
+	"authorization":     true,
+	"signature":         true,
+	"bearertoken":       true,
+	"accesstoken":       true,
+	"refreshtoken":      true,
+	"privatekey":        true,
+}
+
+// IsSensitiveKey reports whether the value of the key must be redacted, such as AccessKeySecret or Authorization.
+// The keys end with AccessKeySecret are sensitive too, such as SessionAccessKeySecret.
+func IsSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	key = strings.Replace(key, "-", "", -1)
+	key = strings.Replace(key, "_", "", -1)
+	return sensitiveKeys[key] || strings.HasSuffix(key, "accesskeysecret")
+}
+
+var sensitiveJSONFieldRegexp = regexp.MustCompile(`"([A-Za-z_\-]+)"\s*:\s*"[^"]*"`)
+
+// RedactJSON replaces the values of the sensitive fields in the json text
+func RedactJSON(content string) string {
+	return sensitiveJSONFieldRegexp.ReplaceAllStringFunc(content, func(field string) string {
+		key := sensitiveJSONFieldRegexp.FindStringSubmatch(field)[1]
+		if !IsSensitiveKey(key) {
+			return field
+		}
+		return fmt.Sprintf(`"%s":"%s"`, key, redactedValue)
+	})
+}
+
+// RedactURL replaces the values of the sensitive query parameters in the url
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+
+	query := u.Query()
+	redacted := false
+	for key := range query {
+		if IsSensitiveKey(key) {
+			query.Set(key, redactedValue)
+			redacted = true
+		}
+	}
+	if redacted {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// RedactHeaders returns a copy of the headers with the sensitive values redacted
+func RedactHeaders(headers map[string]string) map[string]string {
+	result := make(map[string]string, len(headers))
+	for key, value := range headers {
+		if IsSensitiveKey(key) {
+			value = redactedValue
+		}
+		result[key] = value
+	}
+	return result
+}
+
+func redactArgs(args []interface{}) []interface{} {
+	result := make([]interface{}, len(args))
+	copy(result, args)
+	for i := 0; i+1 < len(result); i += 2 {
+		if key, ok := result[i].(string); ok && IsSensitiveKey(key) {
+			result[i+1] = redactedValue
+		}
+	}
+	return result
+}
+
+// redactingLogger redacts the values of the sensitive keys before they are passed to the inner logger
+type redactingLogger struct {
+	logger Logger
+}
+
+func (l *redactingLogger) DebugContext(ctx context.Context, msg string, args ...interface{}) {
+	l.logger.DebugContext(ctx, msg, redactArgs(args)...)
+}
+
+func (l *redactingLogger) InfoContext(ctx context.Context, msg string, args ...interface{}) {
+	l.logger.InfoContext(ctx, msg, redactArgs(args)...)
+}
+
+func (l *redactingLogger) WarnContext(ctx context.Context, msg string, args ...interface{}) {
+	l.logger.WarnContext(ctx, msg, redactArgs(args)...)
+}
+
+func (l *redactingLogger) ErrorContext(ctx context.Context, msg string, args ...interface{}) {
+	l.logger.ErrorContext(ctx, msg, redactArgs(args)...)
+}
+
+// stdLogger writes the logs to stderr, the debug logs are enabled when DEBUG contains credential, such as DEBUG=sdk,credential
+type stdLogger struct {
+	logger       *log.Logger
+	debugEnabled bool
+}
+
+func newStdLogger(out io.Writer, debugEnv string) *stdLogger {
+	debugEnabled := false
+	for _, item := range strings.Split(debugEnv, ",") {
+		if strings.TrimSpace(item) == "credential" {
+			debugEnabled = true
+		}
+	}
+
+	return &stdLogger{
+		logger:       log.New(out, "", log.LstdFlags),
+		debugEnabled: debugEnabled,
+	}
+}
+
+func (l *stdLogger) output(level string, msg string, args []interface{}) {
+	var builder strings.Builder
+	builder.WriteString("credential ")
+	builder.WriteString(level)
+	builder.WriteString(" ")
+	builder.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&builder, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&builder, " %v", args[i])
+		}
+	}
+	l.logger.Print(builder.String())
+}
+
+func (l *stdLogger) DebugContext(ctx context.Context, msg string, args ...interface{}) {
+	if l.debugEnabled {
+		l.output("DEBUG", msg, args)
+	}
+}
+
+func (l *stdLogger) InfoContext(ctx context.Context, msg string, args ...interface{}) {
+	if l.debugEnabled {
+		l.output("INFO", msg, args)
+	}
+}
+
+func (l *stdLogger) WarnContext(ctx context.Context, msg string, args ...interface{}) {
+	l.output("WARN", msg, args)
+}
+
+func (l *stdLogger) ErrorContext(ctx context.Context, msg string, args ...interface{}) {
+	l.output("ERROR", msg, args)
+}
+
+var (
+	defaultLogger Logger = newStdLogger(os.Stderr, os.Getenv("DEBUG"))
+	globalLogger         = defaultLogger
+	// guards globalLogger
+	loggerMutex sync.RWMutex
+)
+
+// SetLogger sets the global logger, the default logger is restored if logger is nil
+func SetLogger(logger Logger) {
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+	if logger == nil {
+		logger = defaultLogger
+	}
+	globalLogger = logger
+}
+
+// GetLogger returns the redacting wrapper of logger, the global logger is used if logger is nil
+func GetLogger(logger Logger) Logger {
+	if logger == nil {
+		loggerMutex.RLock()
+		logger = globalLogger
+		loggerMutex.RUnlock()
+	}
+	return &redactingLogger{
+		logger: logger,
+	}
+}
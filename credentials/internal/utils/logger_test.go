@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSensitiveKey(t *testing.T) {
+	assert.True(t, IsSensitiveKey("AccessKeySecret"))
+	assert.True(t, IsSensitiveKey("access_key_secret"))
+	assert.True(t, IsSensitiveKey("SecurityToken"))
+	assert.True(t, IsSensitiveKey("x-acs-security-token"))
+	assert.True(t, IsSensitiveKey("Authorization"))
+	assert.True(t, IsSensitiveKey("OIDCToken"))
+	assert.True(t, IsSensitiveKey("Signature"))
+	assert.True(t, IsSensitiveKey("SessionAccessKeySecret"))
+	assert.False(t, IsSensitiveKey("SessionAccessKeyId"))
+	assert.False(t, IsSensitiveKey("AccessKeyId"))
+	assert.False(t, IsSensitiveKey("RoleArn"))
+}
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t, `{"Credentials":{"AccessKeyId":"akid","AccessKeySecret":"******","SecurityToken":"******"}}`,
+		RedactJSON(`{"Credentials":{"AccessKeyId":"akid","AccessKeySecret":"aksecret","SecurityToken": "token"}}`))
+	assert.Equal(t, "not json", RedactJSON("not json"))
+
+	assert.Equal(t, "https://sts.aliyuncs.com/?AccessKeyId=akid&SecurityToken=%2A%2A%2A%2A%2A%2A&Signature=%2A%2A%2A%2A%2A%2A",
+		RedactURL("https://sts.aliyuncs.com/?AccessKeyId=akid&SecurityToken=token&Signature=sign"))
+	assert.Equal(t, "http://100.100.100.200/latest/meta-data", RedactURL("http://100.100.100.200/latest/meta-data"))
+
+	headers := map[string]string{
+		"Authorization":        "Bearer token",
+		"x-acs-security-token": "token",
+		"Content-Type":         "application/json",
+	}
+	assert.Equal(t, map[string]string{
+		"Authorization":        "******",
+		"x-acs-security-token": "******",
+		"Content-Type":         "application/json",
+	}, RedactHeaders(headers))
+	// the origin headers are not changed
+	assert.Equal(t, "Bearer token", headers["Authorization"])
+}
+
+type recordingLogger struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) record(level string, msg string, args []interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf("%s %s %v", level, msg, args))
+}
+
+func (l *recordingLogger) DebugContext(ctx context.Context, msg string, args ...interface{}) {
+	l.record("DEBUG", msg, args)
+}
+
+func (l *recordingLogger) InfoContext(ctx context.Context, msg string, args ...interface{}) {
+	l.record("INFO", msg, args)
+}
+
+func (l *recordingLogger) WarnContext(ctx context.Context, msg string, args ...interface{}) {
+	l.record("WARN", msg, args)
+}
+
+func (l *recordingLogger) ErrorContext(ctx context.Context, msg string, args ...interface{}) {
+	l.record("ERROR", msg, args)
+}
+
+func TestGetLogger(t *testing.T) {
+	defer SetLogger(nil)
+
+	global := &recordingLogger{}
+	SetLogger(global)
+	GetLogger(nil).WarnContext(context.Background(), "refresh failed", "AccessKeyId", "akid", "AccessKeySecret", "aksecret")
+	assert.Equal(t, []string{"WARN refresh failed [AccessKeyId akid AccessKeySecret ******]"}, global.lines)
+
+	custom := &recordingLogger{}
+	logger := GetLogger(custom)
+	logger.DebugContext(context.Background(), "debug", "security_token", "token")
+	logger.InfoContext(context.Background(), "info", "odd")
+	logger.ErrorContext(context.Background(), "error", "Authorization", "Bearer token")
+	assert.Equal(t, []string{"DEBUG debug [security_token ******]", "INFO info [odd]", "ERROR error [Authorization ******]"}, custom.lines)
+	assert.Len(t, global.lines, 1)
+
+	SetLogger(nil)
+	assert.Equal(t, defaultLogger, GetLogger(nil).(*redactingLogger).logger)
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newStdLogger(&buf, "")
+	logger.DebugContext(context.Background(), "debug")
+	logger.InfoContext(context.Background(), "info")
+	assert.Equal(t, "", buf.String())
+	logger.WarnContext(context.Background(), "warn", "key", "value", "odd")
+	assert.True(t, strings.HasSuffix(buf.String(), "credential WARN warn key=value odd\n"))
+
+	buf.Reset()
+	logger = newStdLogger(&buf, "sdk, credential")
+	logger.DebugContext(context.Background(), "debug", "status", 200)
+	assert.True(t, strings.HasSuffix(buf.String(), "credential DEBUG debug status=200\n"))
+	logger.ErrorContext(context.Background(), "error")
+	assert.True(t, strings.HasSuffix(buf.String(), "credential ERROR error\n"))
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"context"
 	"net"
+	"net/http"
 	"time"
 )
 
@@ -13,6 +14,12 @@ type Runtime struct {
 	Proxy          string
 	Host           string
 	STSEndpoint    string
+	// Client is used to send the requests if it is set
+	Client *http.Client
+	// Transport is used to send the requests if it is set and Client is nil
+	Transport http.RoundTripper
+	// Logger is used to log the requests, the global logger is used if it is nil
+	Logger Logger
 }
 
 // NewRuntime returns a Runtime
@@ -11,6 +11,8 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"hash"
 	"io"
@@ -18,7 +20,9 @@ import (
 	"net/url"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 )
@@ -61,6 +65,36 @@ func ShaHmac1(source, secret string) string {
 	return signedString
 }
 
+// PercentEncode encodes the value by RFC 3986, the space is encoded as %20 and '~' is not encoded
+func PercentEncode(value string) string {
+	value = url.QueryEscape(value)
+	value = strings.Replace(value, "+", "%20", -1)
+	value = strings.Replace(value, "*", "%2A", -1)
+	value = strings.Replace(value, "%7E", "~", -1)
+	return value
+}
+
+// GetRPCStringToSign returns the string to sign of the RPC signature (HMAC-SHA1 v1),
+// params are all the query and form parameters of the request except Signature.
+func GetRPCStringToSign(method string, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, PercentEncode(key)+"="+PercentEncode(params[key]))
+	}
+	return method + "&" + PercentEncode("/") + "&" + PercentEncode(strings.Join(pairs, "&"))
+}
+
+// GetRPCSignature returns the RPC signature (HMAC-SHA1 v1) of the string to sign
+func GetRPCSignature(stringToSign, accessKeySecret string) string {
+	return ShaHmac1(stringToSign, accessKeySecret+"&")
+}
+
 // Sha256WithRsa return a string which has been hashed with Rsa
 func Sha256WithRsa(source, secret string) string {
 	decodeString, err := base64.StdEncoding.DecodeString(secret)
@@ -95,9 +129,14 @@ func GetMD5Base64(bytes []byte) (base64Value string) {
 
 // GetTimeInFormatISO8601 returns a time string
 func GetTimeInFormatISO8601() (timeStr string) {
+	return FormatTimeInISO8601(time.Now())
+}
+
+// FormatTimeInISO8601 returns the time string of t
+func FormatTimeInISO8601(t time.Time) (timeStr string) {
 	gmt := time.FixedZone("GMT", 0)
 
-	return time.Now().In(gmt).Format("2006-01-02T15:04:05Z")
+	return t.In(gmt).Format("2006-01-02T15:04:05Z")
 }
 
 // GetURLFormedMap returns a url encoded string
@@ -186,6 +225,32 @@ func GetDefaultString(values ...string) string {
 	return ""
 }
 
+type errorBody struct {
+	Code             string `json:"Code" xml:"Code"`
+	ErrorCode        string `json:"ErrorCode"`
+	OAuthError       string `json:"error"`
+	Message          string `json:"Message" xml:"Message"`
+	ErrorMessage     string `json:"ErrorMessage"`
+	OAuthDescription string `json:"error_description"`
+	RequestId        string `json:"RequestId" xml:"RequestId"`
+	LowerRequestId   string `json:"requestId"`
+}
+
+// ParseErrorBody gets the error code, message and request id from the error response of STS, SSO, OAuth or OSS.
+// The values are empty if the body is neither JSON nor XML.
+func ParseErrorBody(body []byte) (code, message, requestId string) {
+	var data errorBody
+	// OSS 的错误响应是 XML 格式
+	if json.Unmarshal(body, &data) != nil && xml.Unmarshal(body, &data) != nil {
+		return
+	}
+
+	code = GetDefaultString(data.Code, data.ErrorCode, data.OAuthError)
+	message = GetDefaultString(data.Message, data.ErrorMessage, data.OAuthDescription)
+	requestId = GetDefaultString(data.RequestId, data.LowerRequestId)
+	return
+}
+
 // set back the memoried enviroment variables
 type Rollback func()
 
@@ -8,6 +8,7 @@ import (
 	"os"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -35,6 +36,10 @@ func TestGetTimeInFormatISO8601(t *testing.T) {
 	assert.True(t, re.MatchString(s))
 }
 
+func TestFormatTimeInISO8601(t *testing.T) {
+	assert.Equal(t, "2024-01-02T03:04:05Z", FormatTimeInISO8601(time.Date(2024, 1, 2, 11, 4, 5, 999000000, time.FixedZone("CST", 8*3600))))
+}
+
 func TestGetURLFormedMap(t *testing.T) {
 	m := make(map[string]string)
 	m["key"] = "value"
@@ -52,6 +57,30 @@ func TestShaHmac1(t *testing.T) {
 	assert.Equal(t, "CqCYIa39h9SSWuXnTz8F5hh9UPA=", ShaHmac1("中文", "secret"))
 }
 
+func TestPercentEncode(t *testing.T) {
+	assert.Equal(t, "a%20b%2Ac~d%2F%3D", PercentEncode("a b*c~d/="))
+	assert.Equal(t, "%E4%B8%AD%E6%96%87", PercentEncode("中文"))
+}
+
+func TestGetRPCSignature(t *testing.T) {
+	// the example of the V1 signature in the document of ECS
+	stringToSign := GetRPCStringToSign("GET", map[string]string{
+		"AccessKeyId":      "testid",
+		"Action":           "DescribeRegions",
+		"Format":           "XML",
+		"SignatureMethod":  "HMAC-SHA1",
+		"SignatureNonce":   "3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf",
+		"SignatureVersion": "1.0",
+		"Timestamp":        "2016-02-23T12:46:24Z",
+		"Version":          "2014-05-26",
+	})
+	assert.Equal(t, "GET&%2F&AccessKeyId%3Dtestid%26Action%3DDescribeRegions%26Format%3DXML%26SignatureMethod%3DHMAC-SHA1%26SignatureNonce%3D3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf%26SignatureVersion%3D1.0%26Timestamp%3D2016-02-23T12%253A46%253A24Z%26Version%3D2014-05-26", stringToSign)
+	assert.Equal(t, "OLeaidS1JvxuMvnyHOwuJ+uX5qY=", GetRPCSignature(stringToSign, "testsecret"))
+
+	assert.Equal(t, "POST&%2F&key%3Da%2520b%252A~", GetRPCStringToSign("POST", map[string]string{"key": "a b*~"}))
+	assert.Equal(t, "GET&%2F&", GetRPCStringToSign("GET", map[string]string{}))
+}
+
 func TestSha256WithRsa(t *testing.T) {
 	secret := `
 MIICeQIBADANBgkqhkiG9w0BAQEFAASCAmMwggJfAgEAAoGBAOJC+2WXtkXZ+6sa
@@ -171,3 +200,35 @@ func TestGetNonce(t *testing.T) {
 	assert.Equal(t, 32, len(GetNonce()))
 	assert.NotEqual(t, GetNonce(), GetNonce())
 }
+
+func TestParseErrorBody(t *testing.T) {
+	code, message, requestId := ParseErrorBody([]byte(`{"RequestId":"reqid","Code":"EntityNotExist.Role","Message":"The role not exists."}`))
+	assert.Equal(t, "EntityNotExist.Role", code)
+	assert.Equal(t, "The role not exists.", message)
+	assert.Equal(t, "reqid", requestId)
+
+	code, message, requestId = ParseErrorBody([]byte(`{"RequestId":"reqid","ErrorCode":"InvalidCredential","ErrorMessage":"invalid token"}`))
+	assert.Equal(t, "InvalidCredential", code)
+	assert.Equal(t, "invalid token", message)
+	assert.Equal(t, "reqid", requestId)
+
+	code, message, requestId = ParseErrorBody([]byte(`{"error":"invalid_grant","error_description":"refresh token expired"}`))
+	assert.Equal(t, "invalid_grant", code)
+	assert.Equal(t, "refresh token expired", message)
+	assert.Equal(t, "", requestId)
+
+	code, message, requestId = ParseErrorBody([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Error>
+  <Code>SecurityTokenExpired</Code>
+  <Message>The security token you provided has expired.</Message>
+  <RequestId>reqid</RequestId>
+</Error>`))
+	assert.Equal(t, "SecurityTokenExpired", code)
+	assert.Equal(t, "The security token you provided has expired.", message)
+	assert.Equal(t, "reqid", requestId)
+
+	code, message, requestId = ParseErrorBody([]byte(`not found`))
+	assert.Equal(t, "", code)
+	assert.Equal(t, "", message)
+	assert.Equal(t, "", requestId)
+}
@@ -1,9 +1,5 @@
 package credentials
 
-import (
-	"errors"
-)
-
 type providerChain struct {
 	Providers []Provider
 }
@@ -27,6 +23,6 @@ func (p *providerChain) resolve() (*Config, error) {
 		}
 		return config, err
 	}
-	return nil, errors.New("no credential found")
+	return nil, &notConfiguredError{message: "no credential found"}
 
 }
@@ -0,0 +1,149 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// ProviderReport is the diagnostics of a provider in the chain
+type ProviderReport struct {
+	// The name of the provider, such as env, oidc_role_arn or cli_profile
+	ProviderName string
+	// Whether the provider is built and added to the chain
+	Built bool
+	// The reason why the provider is skipped when building the chain
+	SkipReason string
+	// The environment variable which disabled the provider, such as ALIBABA_CLOUD_ECS_METADATA_DISABLED
+	DisabledBy string
+	// Whether the provider is tried to get the credentials
+	Tried bool
+	// The error returned by the provider
+	Err error
+	// The time spent on getting the credentials
+	Latency time.Duration
+	// Whether the credentials are provided by the provider
+	Chosen bool
+	// The profile name of the CLI profile and the profile provider
+	ProfileName string
+	// The path of the profile file of the CLI profile and the profile provider
+	ProfileFile string
+}
+
+// ChainReport is the result of resolving the credentials from the provider chain
+type ChainReport struct {
+	// The reports of the providers in order, including the skipped ones
+	Providers []*ProviderReport
+	// The name of the provider which provided the credentials, it is empty if no provider succeeded
+	ChosenProvider string
+	// The credentials provided by the chosen provider
+	Credentials *Credentials
+	// The error when no provider succeeded
+	Err error
+}
+
+// profileInfoProvider is implemented by the providers which load the credentials from a profile
+type profileInfoProvider interface {
+	profileInfo() (profileName, profileFile string)
+}
+
+func (provider *DefaultCredentialsProvider) getMembers() []*chainMember {
+	if provider.members != nil {
+		return provider.members
+	}
+	return newChainMembers(provider.providerChain)
+}
+
+// Resolve tries the providers in the chain in order and reports what happened to each of them.
+// Unlike GetCredentials(), it always starts from the first provider and it does not change the last used provider.
+func (provider *DefaultCredentialsProvider) Resolve(ctx context.Context) (report *ChainReport) {
+	report = &ChainReport{}
+	errs := []error{}
+	errMessages := []string{}
+	for _, member := range provider.getMembers() {
+		pr := &ProviderReport{
+			ProviderName: member.providerName,
+			Built:        member.provider != nil,
+			SkipReason:   member.skipReason,
+			DisabledBy:   member.disabledBy,
+		}
+		report.Providers = append(report.Providers, pr)
+		if member.provider == nil {
+			continue
+		}
+
+		if p, ok := member.provider.(profileInfoProvider); ok {
+			pr.ProfileName, pr.ProfileFile = p.profileInfo()
+		}
+
+		// 已经找到凭证或调用方已取消，后续 provider 不再尝试
+		if report.Credentials != nil || report.Err != nil {
+			continue
+		}
+
+		pr.Tried = true
+		start := nowOf(provider.clock)
+		inner, err := GetCredentialsWithContext(ctx, member.provider)
+		pr.Latency = nowOf(provider.clock).Sub(start)
+		// CLI profile 在首次获取凭证时才会确定当前 profile
+		if p, ok := member.provider.(profileInfoProvider); ok {
+			pr.ProfileName, pr.ProfileFile = p.profileInfo()
+		}
+		if err != nil {
+			pr.Err = err
+			if ctx.Err() != nil {
+				report.Err = err
+				continue
+			}
+			errs = append(errs, err)
+			errMessages = append(errMessages, err.Error())
+			continue
+		}
+
+		pr.Chosen = true
+		report.ChosenProvider = member.providerName
+		report.Credentials = provider.wrapCredentials(inner, member.provider)
+	}
+
+	if report.Credentials == nil && report.Err == nil {
+		report.Err = newChainError(errMessages, errs)
+	}
+	return
+}
+
+// Explain resolves the credentials and returns the human readable report
+func (provider *DefaultCredentialsProvider) Explain(ctx context.Context) string {
+	return provider.Resolve(ctx).String()
+}
+
+func (report *ChainReport) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("credentials provider chain:\n")
+	for i, pr := range report.Providers {
+		fmt.Fprintf(&buf, "  %d. %s", i+1, pr.ProviderName)
+		if pr.ProfileName != "" || pr.ProfileFile != "" {
+			fmt.Fprintf(&buf, " (profile: %s, file: %s)", pr.ProfileName, pr.ProfileFile)
+		}
+
+		switch {
+		case !pr.Built && pr.DisabledBy != "":
+			fmt.Fprintf(&buf, ": skipped, disabled by %s\n", pr.DisabledBy)
+		case !pr.Built:
+			fmt.Fprintf(&buf, ": skipped, %s\n", pr.SkipReason)
+		case !pr.Tried:
+			buf.WriteString(": not tried\n")
+		case pr.Chosen:
+			fmt.Fprintf(&buf, ": chosen in %s\n", pr.Latency)
+		default:
+			fmt.Fprintf(&buf, ": failed in %s, %s\n", pr.Latency, pr.Err.Error())
+		}
+	}
+
+	if report.Credentials != nil {
+		fmt.Fprintf(&buf, "credentials are provided by %s", report.ChosenProvider)
+	} else {
+		fmt.Fprintf(&buf, "no credentials: %s", report.Err.Error())
+	}
+	return buf.String()
+}
@@ -0,0 +1,138 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/aliyun/credentials-go/credentials/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultCredentialsProviderResolve(t *testing.T) {
+	rollback := utils.Memory("ALIBABA_CLOUD_ACCESS_KEY_ID",
+		"ALIBABA_CLOUD_ACCESS_KEY_SECRET",
+		"ALIBABA_CLOUD_OIDC_TOKEN_FILE",
+		"ALIBABA_CLOUD_OIDC_PROVIDER_ARN",
+		"ALIBABA_CLOUD_ROLE_ARN",
+		"ALIBABA_CLOUD_CLI_PROFILE_DISABLED",
+		"ALIBABA_CLOUD_ECS_METADATA_DISABLED",
+		"ALIBABA_CLOUD_CREDENTIALS_URI",
+		"ALIBABA_CLOUD_CREDENTIALS_FILE",
+		"ALIBABA_CLOUD_PROFILE")
+	defer rollback()
+
+	tempDir, err := ioutil.TempDir("", "chain_report_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	profileFile := path.Join(tempDir, "credentials")
+	err = ioutil.WriteFile(profileFile, []byte("[default]\ntype = access_key\naccess_key_id = akid\naccess_key_secret = aksecret\n"), 0600)
+	assert.Nil(t, err)
+
+	os.Unsetenv("ALIBABA_CLOUD_ACCESS_KEY_ID")
+	os.Unsetenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET")
+	os.Unsetenv("ALIBABA_CLOUD_OIDC_TOKEN_FILE")
+	os.Unsetenv("ALIBABA_CLOUD_OIDC_PROVIDER_ARN")
+	os.Unsetenv("ALIBABA_CLOUD_ROLE_ARN")
+	os.Unsetenv("ALIBABA_CLOUD_CREDENTIALS_URI")
+	os.Unsetenv("ALIBABA_CLOUD_PROFILE")
+	os.Setenv("ALIBABA_CLOUD_CLI_PROFILE_DISABLED", "true")
+	os.Setenv("ALIBABA_CLOUD_ECS_METADATA_DISABLED", "true")
+	os.Setenv("ALIBABA_CLOUD_CREDENTIALS_FILE", profileFile)
+
+	provider := NewDefaultCredentialsProvider()
+	report := provider.Resolve(context.Background())
+	assert.Nil(t, report.Err)
+	assert.Equal(t, "profile", report.ChosenProvider)
+	assert.Equal(t, "default/profile/static_ak", report.Credentials.ProviderName)
+	assert.Equal(t, "akid", report.Credentials.AccessKeyId)
+	assert.Len(t, report.Providers, 6)
+
+	assert.Equal(t, "env", report.Providers[0].ProviderName)
+	assert.True(t, report.Providers[0].Built)
+	assert.True(t, report.Providers[0].Tried)
+	assert.EqualError(t, report.Providers[0].Err, "unable to get credentials from enviroment variables, Access key ID must be specified via environment variable (ALIBABA_CLOUD_ACCESS_KEY_ID)")
+
+	assert.Equal(t, "oidc_role_arn", report.Providers[1].ProviderName)
+	assert.False(t, report.Providers[1].Built)
+
+	assert.Equal(t, "cli_profile", report.Providers[2].ProviderName)
+	assert.False(t, report.Providers[2].Built)
+	assert.Equal(t, "ALIBABA_CLOUD_CLI_PROFILE_DISABLED", report.Providers[2].DisabledBy)
+
+	assert.Equal(t, "profile", report.Providers[3].ProviderName)
+	assert.True(t, report.Providers[3].Built)
+	assert.True(t, report.Providers[3].Tried)
+	assert.True(t, report.Providers[3].Chosen)
+	assert.Nil(t, report.Providers[3].Err)
+	assert.Equal(t, "default", report.Providers[3].ProfileName)
+	assert.Equal(t, profileFile, report.Providers[3].ProfileFile)
+
+	assert.Equal(t, "ecs_ram_role", report.Providers[4].ProviderName)
+	assert.False(t, report.Providers[4].Built)
+	assert.Equal(t, "ALIBABA_CLOUD_ECS_METADATA_DISABLED", report.Providers[4].DisabledBy)
+
+	assert.Equal(t, "credential_uri", report.Providers[5].ProviderName)
+	assert.False(t, report.Providers[5].Built)
+	assert.Equal(t, "the environment variable ALIBABA_CLOUD_CREDENTIALS_URI is not set", report.Providers[5].SkipReason)
+
+	// resolve does not change the last used provider
+	assert.Nil(t, provider.lastUsedProvider)
+
+	explain := provider.Explain(context.Background())
+	assert.True(t, strings.Contains(explain, "  1. env: failed in "))
+	assert.True(t, strings.Contains(explain, "  3. cli_profile: skipped, disabled by ALIBABA_CLOUD_CLI_PROFILE_DISABLED\n"))
+	assert.True(t, strings.Contains(explain, "  4. profile (profile: default, file: "+profileFile+"): chosen in "))
+	assert.True(t, strings.HasSuffix(explain, "credentials are provided by profile"))
+}
+
+func TestDefaultCredentialsProviderResolveFailed(t *testing.T) {
+	provider, err := NewDefaultCredentialsProviderBuilder().
+		WithProviders(new(testErrorProvider), &switchableProvider{failed: true}).
+		Build()
+	assert.Nil(t, err)
+
+	report := provider.Resolve(context.Background())
+	assert.Nil(t, report.Credentials)
+	assert.Equal(t, "", report.ChosenProvider)
+	assert.EqualError(t, report.Err, "unable to get credentials from any of the providers in the chain: error, failed")
+	assert.Len(t, report.Providers, 2)
+	for _, pr := range report.Providers {
+		assert.True(t, pr.Built)
+		assert.True(t, pr.Tried)
+		assert.False(t, pr.Chosen)
+		assert.NotNil(t, pr.Err)
+	}
+
+	explain := provider.Explain(context.Background())
+	assert.True(t, strings.Contains(explain, "  1. test: failed in "))
+	assert.True(t, strings.HasSuffix(explain, "no credentials: unable to get credentials from any of the providers in the chain: error, failed"))
+
+	// the providers after the chosen one are not tried
+	provider, err = NewDefaultCredentialsProviderBuilder().
+		WithProviders(new(testProvider), new(testErrorProvider)).
+		Build()
+	assert.Nil(t, err)
+	report = provider.Resolve(context.Background())
+	assert.Nil(t, report.Err)
+	assert.Equal(t, "test", report.ChosenProvider)
+	assert.True(t, report.Providers[0].Chosen)
+	assert.False(t, report.Providers[1].Tried)
+	assert.True(t, strings.Contains(report.String(), "  2. test: not tried\n"))
+
+	// cancelled by caller
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	provider, err = NewDefaultCredentialsProviderBuilder().
+		WithProviders(&customCredentialsProvider{}, new(testProvider)).
+		Build()
+	assert.Nil(t, err)
+	report = provider.Resolve(ctx)
+	assert.True(t, errors.Is(report.Err, context.Canceled))
+	assert.True(t, report.Providers[0].Tried)
+	assert.False(t, report.Providers[1].Tried)
+}
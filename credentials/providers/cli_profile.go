@@ -1,8 +1,8 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -19,9 +19,12 @@ import (
 type CLIProfileCredentialsProvider struct {
 	profileFile   string
 	profileName   string
+	httpOptions   *HttpOptions
 	innerProvider CredentialsProvider
 	// 文件锁，用于并发安全
 	fileMutex sync.RWMutex
+	// 保护 innerProvider 的延迟初始化
+	mutex sync.Mutex
 }
 
 type CLIProfileCredentialsProviderBuilder struct {
@@ -38,6 +41,12 @@ func (b *CLIProfileCredentialsProviderBuilder) WithProfileName(profileName strin
 	return b
 }
 
+// WithHttpOptions sets the http options of the providers which are created from the profile
+func (b *CLIProfileCredentialsProviderBuilder) WithHttpOptions(httpOptions *HttpOptions) *CLIProfileCredentialsProviderBuilder {
+	b.provider.httpOptions = httpOptions
+	return b
+}
+
 func (b *CLIProfileCredentialsProviderBuilder) Build() (provider *CLIProfileCredentialsProvider, err error) {
 	// 优先级：
 	// 1. 使用显示指定的 profileFile
@@ -55,7 +64,7 @@ func (b *CLIProfileCredentialsProviderBuilder) Build() (provider *CLIProfileCred
 	}
 
 	if strings.ToLower(os.Getenv("ALIBABA_CLOUD_CLI_PROFILE_DISABLED")) == "true" {
-		err = errors.New("the CLI profile is disabled")
+		err = newNotConfiguredError("the CLI profile is disabled")
 		return
 	}
 
@@ -97,6 +106,8 @@ type profile struct {
 	OauthAccessToken       string `json:"oauth_access_token"`
 	OauthAccessTokenExpire int64  `json:"oauth_access_token_expire"`
 	StsExpire              int64  `json:"sts_expiration"`
+	ProcessCommand         string `json:"process_command"`
+	CredentialProcess      string `json:"credential_process"`
 }
 
 type configuration struct {
@@ -107,6 +118,10 @@ type configuration struct {
 func newConfigurationFromPath(cfgPath string) (conf *configuration, err error) {
 	bytes, err := ioutil.ReadFile(cfgPath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			err = newNotConfiguredError(fmt.Sprintf("reading aliyun cli config from '%s' failed %v", cfgPath, err))
+			return
+		}
 		err = fmt.Errorf("reading aliyun cli config from '%s' failed %v", cfgPath, err)
 		return
 	}
@@ -120,7 +135,7 @@ func newConfigurationFromPath(cfgPath string) (conf *configuration, err error) {
 	}
 
 	if conf.Profiles == nil || len(conf.Profiles) == 0 {
-		err = fmt.Errorf("no any configured profiles in '%s'", cfgPath)
+		err = newNotConfiguredError(fmt.Sprintf("no any configured profiles in '%s'", cfgPath))
 		return
 	}
 
@@ -135,7 +150,7 @@ func (conf *configuration) getProfile(name string) (profile *profile, err error)
 		}
 	}
 
-	err = fmt.Errorf("unable to get profile with '%s'", name)
+	err = newNotConfiguredError(fmt.Sprintf("unable to get profile with '%s'", name))
 	return
 }
 
@@ -185,9 +200,10 @@ func (provider *CLIProfileCredentialsProvider) getCredentialsProvider(conf *conf
 			WithEnableVpc(p.EnableVpc).
 			WithPolicy(p.Policy).
 			WithExternalId(p.ExternalId).
+			WithHttpOptions(provider.httpOptions).
 			Build()
 	case "EcsRamRole":
-		credentialsProvider, err = NewECSRAMRoleCredentialsProviderBuilder().WithRoleName(p.RoleName).Build()
+		credentialsProvider, err = NewECSRAMRoleCredentialsProviderBuilder().WithRoleName(p.RoleName).WithHttpOptions(provider.httpOptions).Build()
 	case "OIDC":
 		credentialsProvider, err = NewOIDCCredentialsProviderBuilder().
 			WithOIDCTokenFilePath(p.OIDCTokenFile).
@@ -198,6 +214,7 @@ func (provider *CLIProfileCredentialsProvider) getCredentialsProvider(conf *conf
 			WithDurationSeconds(p.DurationSeconds).
 			WithRoleSessionName(p.RoleSessionName).
 			WithPolicy(p.Policy).
+			WithHttpOptions(provider.httpOptions).
 			Build()
 	case "ChainableRamRoleArn":
 		previousProvider, err1 := provider.getCredentialsProvider(conf, p.SourceProfile)
@@ -214,6 +231,7 @@ func (provider *CLIProfileCredentialsProvider) getCredentialsProvider(conf *conf
 			WithEnableVpc(p.EnableVpc).
 			WithPolicy(p.Policy).
 			WithExternalId(p.ExternalId).
+			WithHttpOptions(provider.httpOptions).
 			Build()
 	case "CloudSSO":
 		credentialsProvider, err = NewCloudSSOCredentialsProviderBuilder().
@@ -222,6 +240,7 @@ func (provider *CLIProfileCredentialsProvider) getCredentialsProvider(conf *conf
 			WithAccessConfig(p.AccessConfig).
 			WithAccessToken(p.AccessToken).
 			WithAccessTokenExpire(p.AccessTokenExpire).
+			WithHttpOptions(provider.httpOptions).
 			Build()
 	case "OAuth":
 		siteType := strings.ToUpper(p.OauthSiteType)
@@ -239,6 +258,22 @@ func (provider *CLIProfileCredentialsProvider) getCredentialsProvider(conf *conf
 			WithAccessToken(p.OauthAccessToken).
 			WithAccessTokenExpire(p.OauthAccessTokenExpire).
 			WithTokenUpdateCallback(provider.getOAuthTokenUpdateCallback()).
+			WithHttpOptions(provider.httpOptions).
+			Build()
+	case "External":
+		var processProvider *ProcessCredentialsProvider
+		processProvider, err = NewProcessCredentialsProviderBuilder().
+			WithCommand(p.ProcessCommand).
+			Build()
+		if err != nil {
+			return
+		}
+		// process_command 输出的是 aliyun cli 的 profile 格式
+		processProvider.cliExternalOutput = true
+		credentialsProvider = processProvider
+	case "CredentialProcess":
+		credentialsProvider, err = NewProcessCredentialsProviderBuilder().
+			WithCommand(p.CredentialProcess).
 			Build()
 	default:
 		err = fmt.Errorf("unsupported profile mode '%s'", p.Mode)
@@ -251,6 +286,50 @@ func (provider *CLIProfileCredentialsProvider) getCredentialsProvider(conf *conf
 var getHomePath = utils.GetHomePath
 
 func (provider *CLIProfileCredentialsProvider) GetCredentials() (cc *Credentials, err error) {
+	return provider.GetCredentialsWithContext(context.Background())
+}
+
+func (provider *CLIProfileCredentialsProvider) GetCredentialsWithContext(ctx context.Context) (cc *Credentials, err error) {
+	innerProvider, err := provider.getInnerProvider()
+	if err != nil {
+		return
+	}
+
+	innerCC, err := GetCredentialsWithContext(ctx, innerProvider)
+	if err != nil {
+		return
+	}
+
+	providerName := innerCC.ProviderName
+	if providerName == "" {
+		providerName = innerProvider.GetProviderName()
+	}
+
+	cc = &Credentials{
+		AccessKeyId:     innerCC.AccessKeyId,
+		AccessKeySecret: innerCC.AccessKeySecret,
+		SecurityToken:   innerCC.SecurityToken,
+		ProviderName:    fmt.Sprintf("%s/%s", provider.GetProviderName(), providerName),
+		Expiration:      innerCC.Expiration,
+	}
+
+	return
+}
+
+// Invalidate drops the cached credentials of the provider of the profile
+func (provider *CLIProfileCredentialsProvider) Invalidate() {
+	provider.mutex.Lock()
+	innerProvider := provider.innerProvider
+	provider.mutex.Unlock()
+	if innerProvider != nil {
+		InvalidateCredentials(innerProvider)
+	}
+}
+
+func (provider *CLIProfileCredentialsProvider) getInnerProvider() (innerProvider CredentialsProvider, err error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
 	if provider.innerProvider == nil {
 		cfgPath := provider.profileFile
 		if cfgPath == "" {
@@ -280,23 +359,23 @@ func (provider *CLIProfileCredentialsProvider) GetCredentials() (cc *Credentials
 		}
 	}
 
-	innerCC, err := provider.innerProvider.GetCredentials()
-	if err != nil {
-		return
-	}
-
-	providerName := innerCC.ProviderName
-	if providerName == "" {
-		providerName = provider.innerProvider.GetProviderName()
-	}
+	innerProvider = provider.innerProvider
+	return
+}
 
-	cc = &Credentials{
-		AccessKeyId:     innerCC.AccessKeyId,
-		AccessKeySecret: innerCC.AccessKeySecret,
-		SecurityToken:   innerCC.SecurityToken,
-		ProviderName:    fmt.Sprintf("%s/%s", provider.GetProviderName(), providerName),
+// profileInfo returns the profile name and the path of the profile file for the chain report,
+// the profile name is empty before the current profile is loaded from the file.
+func (provider *CLIProfileCredentialsProvider) profileInfo() (profileName, profileFile string) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	profileName = provider.profileName
+	profileFile = provider.profileFile
+	if profileFile == "" {
+		if homeDir := getHomePath(); homeDir != "" {
+			profileFile = path.Join(homeDir, ".aliyun/config.json")
+		}
 	}
-
 	return
 }
 
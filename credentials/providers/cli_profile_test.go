@@ -1,6 +1,7 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -163,6 +164,24 @@ func TestCLIProfileCredentialsProvider_getCredentialsProvider(t *testing.T) {
 				OauthAccessToken:       "access_token",
 				OauthAccessTokenExpire: time.Now().Unix() + 1000,
 			},
+			{
+				Mode:              "CredentialProcess",
+				Name:              "CredentialProcess",
+				CredentialProcess: "/path/to/broker --profile dev",
+			},
+			{
+				Mode: "CredentialProcess",
+				Name: "EmptyCredentialProcess",
+			},
+			{
+				Mode:           "External",
+				Name:           "External",
+				ProcessCommand: "/path/to/broker --profile prod",
+			},
+			{
+				Mode: "External",
+				Name: "EmptyExternal",
+			},
 			{
 				Mode: "Unsupported",
 				Name: "Unsupported",
@@ -228,6 +247,28 @@ func TestCLIProfileCredentialsProvider_getCredentialsProvider(t *testing.T) {
 	_, ok = cp.(*OAuthCredentialsProvider)
 	assert.True(t, ok)
 
+	// CredentialProcess
+	cp, err = provider.getCredentialsProvider(conf, "CredentialProcess")
+	assert.Nil(t, err)
+	pcp, ok := cp.(*ProcessCredentialsProvider)
+	assert.True(t, ok)
+	assert.Equal(t, "/path/to/broker --profile dev", pcp.command)
+	assert.False(t, pcp.cliExternalOutput)
+
+	_, err = provider.getCredentialsProvider(conf, "EmptyCredentialProcess")
+	assert.EqualError(t, err, "the command is empty")
+
+	// External
+	cp, err = provider.getCredentialsProvider(conf, "External")
+	assert.Nil(t, err)
+	pcp, ok = cp.(*ProcessCredentialsProvider)
+	assert.True(t, ok)
+	assert.Equal(t, "/path/to/broker --profile prod", pcp.command)
+	assert.True(t, pcp.cliExternalOutput)
+
+	_, err = provider.getCredentialsProvider(conf, "EmptyExternal")
+	assert.EqualError(t, err, "the command is empty")
+
 	// ChainableRamRoleArn with invalid source profile
 	_, err = provider.getCredentialsProvider(conf, "ChainableRamRoleArn2")
 	assert.EqualError(t, err, "get source profile failed: unable to get profile with 'InvalidSource'")
@@ -867,7 +908,7 @@ func TestCLIProfileCredentialsProvider_GetCredentials(t *testing.T) {
 	_, err = provider.GetCredentials()
 	assert.Contains(t, err.Error(), "InvalidAccessKeyId.NotFound")
 
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"Credentials": {"AccessKeyId":"akid","AccessKeySecret":"aksecret","Expiration":"2021-10-20T04:27:09Z","SecurityToken":"ststoken"}}`),
@@ -891,6 +932,38 @@ func TestCLIProfileCredentialsProvider_GetCredentials(t *testing.T) {
 	assert.Equal(t, "cli_profile/test", cc.ProviderName)
 }
 
+func TestCLIProfileCredentialsProvider_GetCredentialsWithExternalProfile(t *testing.T) {
+	skipProcessTestOnWindows(t)
+
+	tempDir, err := ioutil.TempDir("", "cli_external_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	configPath := path.Join(tempDir, "config.json")
+	// process_command 输出 aliyun cli 的 profile 格式
+	content := `{
+	"current": "external",
+	"profiles": [
+		{
+			"name": "external",
+			"mode": "External",
+			"process_command": "echo '{\"mode\":\"StsToken\",\"access_key_id\":\"STS.akid\",\"access_key_secret\":\"aksecret\",\"sts_token\":\"ststoken\"}'"
+		}
+	]
+}`
+	assert.Nil(t, ioutil.WriteFile(configPath, []byte(content), 0600))
+
+	provider, err := NewCLIProfileCredentialsProviderBuilder().WithProfileFile(configPath).Build()
+	assert.Nil(t, err)
+	cc, err := provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, &Credentials{
+		AccessKeyId:     "STS.akid",
+		AccessKeySecret: "aksecret",
+		SecurityToken:   "ststoken",
+		ProviderName:    "cli_profile/credential_process",
+	}, cc)
+}
+
 func TestCLIProfileCredentialsProvider_writeConfigurationToFile_RenameError(t *testing.T) {
 	// 创建临时配置文件用于测试
 	tempDir, err := ioutil.TempDir("", "oauth_rename_error_test")
@@ -1502,3 +1575,24 @@ func TestCLIProfileCredentialsProvider_writeConfigurationToFile_Concurrent(t *te
 	assert.NotEmpty(t, loadedConf.Current)
 	assert.NotEmpty(t, loadedConf.Profiles)
 }
+
+func TestCLIProfileCredentialsProvider_profileInfo(t *testing.T) {
+	defer func() {
+		getHomePath = utils.GetHomePath
+	}()
+
+	getHomePath = func() string {
+		return "/home/user"
+	}
+	provider, err := NewCLIProfileCredentialsProviderBuilder().WithProfileName("dev").Build()
+	assert.Nil(t, err)
+	profileName, profileFile := provider.profileInfo()
+	assert.Equal(t, "dev", profileName)
+	assert.Equal(t, "/home/user/.aliyun/config.json", profileFile)
+
+	provider, err = NewCLIProfileCredentialsProviderBuilder().WithProfileFile("/path/to/config.json").Build()
+	assert.Nil(t, err)
+	profileName, profileFile = provider.profileInfo()
+	assert.Equal(t, "", profileName)
+	assert.Equal(t, "/path/to/config.json", profileFile)
+}
@@ -0,0 +1,39 @@
+package providers
+
+import (
+	"time"
+)
+
+// Clock tells the current time, the providers use it to decide whether the credentials are expired or due for a refresh.
+// A fake clock can be set by WithClock() of the builders to test the refreshes without sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// TimerClock is a Clock which also drives the waits of the background refresh (see AsyncRefreshOptions),
+// so that advancing a fake clock triggers the refresh ahead of the expiration. The system timer is used
+// for the clocks which do not implement it.
+type TimerClock interface {
+	Clock
+	// After returns a channel which receives the time of the clock after d elapsed by the clock
+	After(d time.Duration) <-chan time.Time
+}
+
+// nowOf returns the current time of clock, the system time is used if clock is nil
+func nowOf(clock Clock) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+	return clock.Now()
+}
+
+// afterOf returns a channel which receives after d elapsed by clock, stop must be called if it is not received
+func afterOf(clock Clock, d time.Duration) (c <-chan time.Time, stop func()) {
+	if timerClock, ok := clock.(TimerClock); ok {
+		return timerClock.After(d), func() {}
+	}
+	timer := time.NewTimer(d)
+	return timer.C, func() {
+		timer.Stop()
+	}
+}
@@ -0,0 +1,30 @@
+package providers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/internal/clocktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNowOf(t *testing.T) {
+	before := time.Now()
+	now := nowOf(nil)
+	assert.False(t, now.Before(before))
+	assert.False(t, now.After(time.Now()))
+
+	clock := clocktest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), nowOf(clock))
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 1000000, time.UTC), nowOf(clock))
+}
+
+// nowOnlyClock hides After() of the fake clock, so that the background refresh waits on the system timer
+type nowOnlyClock struct {
+	clock *clocktest.FakeClock
+}
+
+func (c nowOnlyClock) Now() time.Time {
+	return c.clock.Now()
+}
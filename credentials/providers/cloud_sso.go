@@ -1,11 +1,12 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
@@ -23,6 +24,15 @@ type CloudSSOCredentialsProvider struct {
 	sessionCredentials  *sessionCredentials
 	// for http options
 	httpOptions *HttpOptions
+	// for expiry and refresh decisions
+	clock Clock
+	// guards the inner session fields
+	mutex        sync.RWMutex
+	refreshGroup refreshGroup
+	// for async refresh
+	asyncRefreshOptions *AsyncRefreshOptions
+	asyncRefresher      *asyncRefresher
+	refreshListener     refreshListener
 }
 
 type CloudSSOCredentialsProviderBuilder struct {
@@ -77,27 +87,58 @@ func (b *CloudSSOCredentialsProviderBuilder) WithAccessTokenExpire(accessTokenEx
 	return b
 }
 
+// WithOnRefresh sets the callback which is called after the credentials are refreshed
+func (b *CloudSSOCredentialsProviderBuilder) WithOnRefresh(onRefresh OnRefreshFunc) *CloudSSOCredentialsProviderBuilder {
+	b.provider.refreshListener.onRefresh = onRefresh
+	return b
+}
+
+// WithOnRefreshError sets the callback which is called after the credentials failed to refresh
+func (b *CloudSSOCredentialsProviderBuilder) WithOnRefreshError(onRefreshError OnRefreshErrorFunc) *CloudSSOCredentialsProviderBuilder {
+	b.provider.refreshListener.onRefreshError = onRefreshError
+	return b
+}
+
 func (b *CloudSSOCredentialsProviderBuilder) WithHttpOptions(httpOptions *HttpOptions) *CloudSSOCredentialsProviderBuilder {
 	b.provider.httpOptions = httpOptions
 	return b
 }
 
+// WithAsyncRefresh enables refreshing the credentials in background, call Close() to stop it
+func (b *CloudSSOCredentialsProviderBuilder) WithAsyncRefresh(options *AsyncRefreshOptions) *CloudSSOCredentialsProviderBuilder {
+	b.provider.asyncRefreshOptions = options
+	return b
+}
+
+// WithClock sets the clock to decide whether the credentials are expired or due for a refresh, default is the system clock
+func (b *CloudSSOCredentialsProviderBuilder) WithClock(clock Clock) *CloudSSOCredentialsProviderBuilder {
+	b.provider.clock = clock
+	return b
+}
+
 func (b *CloudSSOCredentialsProviderBuilder) Build() (provider *CloudSSOCredentialsProvider, err error) {
-	if b.provider.accessToken == "" || b.provider.accessTokenExpire == 0 || b.provider.accessTokenExpire-time.Now().Unix() <= 0 {
-		err = errors.New("CloudSSO access token is empty or expired, please re-login with cli")
+	if b.provider.accessToken == "" || b.provider.accessTokenExpire == 0 || b.provider.accessTokenExpire-nowOf(b.provider.clock).Unix() <= 0 {
+		err = newNotConfiguredError("CloudSSO access token is empty or expired, please re-login with cli")
 		return
 	}
 
 	if b.provider.signInUrl == "" || b.provider.accountId == "" || b.provider.accessConfig == "" {
-		err = errors.New("CloudSSO sign in url or account id or access config is empty")
+		err = newNotConfiguredError("CloudSSO sign in url or account id or access config is empty")
 		return
 	}
 
+	if b.provider.asyncRefreshOptions != nil {
+		p := b.provider
+		p.asyncRefresher = newAsyncRefresher(p.asyncRefreshOptions, p.clock, func(ctx context.Context) error {
+			return p.refreshGroup.do(ctx, p.updateCredential)
+		}, p.getExpirationTimestamp)
+	}
+
 	provider = b.provider
 	return
 }
 
-func (provider *CloudSSOCredentialsProvider) getCredentials() (session *sessionCredentials, err error) {
+func (provider *CloudSSOCredentialsProvider) getCredentials(ctx context.Context) (session *sessionCredentials, err error) {
 	url, err := url.Parse(provider.signInUrl)
 	if err != nil {
 		return nil, err
@@ -123,6 +164,11 @@ func (provider *CloudSSOCredentialsProvider) getCredentials() (session *sessionC
 	if provider.httpOptions != nil && provider.httpOptions.Proxy != "" {
 		req.Proxy = provider.httpOptions.Proxy
 	}
+	if provider.httpOptions != nil {
+		req.Client = provider.httpOptions.Client
+		req.Transport = provider.httpOptions.Transport
+		req.Logger = provider.httpOptions.Logger
+	}
 	req.ConnectTimeout = connectTimeout
 	req.ReadTimeout = readTimeout
 
@@ -142,14 +188,14 @@ func (provider *CloudSSOCredentialsProvider) getCredentials() (session *sessionC
 	req.Headers["Accept"] = "application/json"
 	req.Headers["Content-Type"] = "application/json"
 	req.Headers["Authorization"] = fmt.Sprintf("Bearer %s", provider.accessToken)
-	res, err := httpDo(req)
+	res, err := doWithRetry(ctx, req, provider.httpOptions, nil)
 	if err != nil {
 		return
 	}
 
 	if res.StatusCode != http.StatusOK {
 		message := "get session token from sso failed: "
-		err = errors.New(message + string(res.Body))
+		err = newServiceError(req, res, message+string(res.Body))
 		return
 	}
 	var data cloudCredentialResponse
@@ -182,35 +228,92 @@ func (provider *CloudSSOCredentialsProvider) needUpdateCredential() (result bool
 		return true
 	}
 
-	return provider.expirationTimestamp-time.Now().Unix() <= 180
+	return provider.expirationTimestamp-nowOf(provider.clock).Unix() <= provider.asyncRefresher.staleSeconds()
 }
 
 func (provider *CloudSSOCredentialsProvider) GetCredentials() (cc *Credentials, err error) {
-	if provider.sessionCredentials == nil || provider.needUpdateCredential() {
-		sessionCredentials, err1 := provider.getCredentials()
-		if err1 != nil {
-			return nil, err1
-		}
+	return provider.GetCredentialsWithContext(context.Background())
+}
 
-		provider.sessionCredentials = sessionCredentials
-		expirationTime, err2 := time.Parse("2006-01-02T15:04:05Z", sessionCredentials.Expiration)
-		if err2 != nil {
-			return nil, err2
+func (provider *CloudSSOCredentialsProvider) GetCredentialsWithContext(ctx context.Context) (cc *Credentials, err error) {
+	provider.mutex.RLock()
+	needUpdate := provider.sessionCredentials == nil || provider.needUpdateCredential()
+	provider.mutex.RUnlock()
+
+	// 刷新失败后的重试间隔内直接使用尚未过期的缓存凭证，避免每次调用都等待失败的刷新
+	if needUpdate && !provider.asyncRefresher.inRetryBackoff(provider.getExpirationTimestamp()) {
+		err = provider.refreshGroup.do(ctx, provider.updateCredential)
+		provider.asyncRefresher.recordRefresh(ctx, err)
+		if err != nil {
+			// 异步刷新模式下，刷新失败时继续使用尚未过期的缓存凭证
+			provider.mutex.RLock()
+			canUseStaleSession := provider.asyncRefresher.canUseStaleSession(provider.expirationTimestamp)
+			provider.mutex.RUnlock()
+			if !canUseStaleSession {
+				return
+			}
+			err = nil
 		}
-
-		provider.lastUpdateTimestamp = time.Now().Unix()
-		provider.expirationTimestamp = expirationTime.Unix()
 	}
+	provider.asyncRefresher.start()
 
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
 	cc = &Credentials{
 		AccessKeyId:     provider.sessionCredentials.AccessKeyId,
 		AccessKeySecret: provider.sessionCredentials.AccessKeySecret,
 		SecurityToken:   provider.sessionCredentials.SecurityToken,
 		ProviderName:    provider.GetProviderName(),
+		Expiration:      time.Unix(provider.expirationTimestamp, 0).UTC(),
 	}
 	return
 }
 
+func (provider *CloudSSOCredentialsProvider) updateCredential(ctx context.Context) (err error) {
+	ctx, endRefresh := startRefresh(ctx, getInstrumentation(provider.httpOptions), provider.clock, provider.GetProviderName())
+	defer func() {
+		expirationTimestamp := provider.getExpirationTimestamp()
+		endRefresh(expirationTimestamp, err)
+		provider.refreshListener.notify(provider.GetProviderName(), expirationTimestamp, err)
+	}()
+
+	sessionCredentials, err := provider.getCredentials(ctx)
+	if err != nil {
+		return
+	}
+
+	expirationTime, err := time.Parse("2006-01-02T15:04:05Z", sessionCredentials.Expiration)
+	if err != nil {
+		return
+	}
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.lastUpdateTimestamp = nowOf(provider.clock).Unix()
+	provider.expirationTimestamp = expirationTime.Unix()
+	provider.sessionCredentials = sessionCredentials
+	return
+}
+
+func (provider *CloudSSOCredentialsProvider) getExpirationTimestamp() int64 {
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
+	return provider.expirationTimestamp
+}
+
+// Invalidate drops the cached credentials, the next GetCredentials() gets new credentials from the service
+func (provider *CloudSSOCredentialsProvider) Invalidate() {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.expirationTimestamp = 0
+}
+
 func (provider *CloudSSOCredentialsProvider) GetProviderName() string {
 	return "cloud_sso"
 }
+
+// Close stops the background refresh, it is a no-op if async refresh is not enabled
+func (provider *CloudSSOCredentialsProvider) Close() error {
+	provider.asyncRefresher.close()
+	return nil
+}
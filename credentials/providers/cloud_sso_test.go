@@ -1,7 +1,10 @@
 package providers
 
 import (
+	"context"
 	"errors"
+	"net/http"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -70,71 +73,71 @@ func TestCloudSSOCredentialsProvider_getCredentials(t *testing.T) {
 	assert.Nil(t, err)
 
 	// case 1: mock new http request failed
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "mock server error", err.Error())
 
 	// case 2: 4xx error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 400,
 			Body:       []byte("4xx error"),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get session token from sso failed: 4xx error", err.Error())
 
 	// case 3: invalid json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte("invalid json"),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get session token from sso failed, json.Unmarshal fail: invalid character 'i' looking for beginning of value", err.Error())
 
 	// case 4: empty response json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte("null"),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get session token from sso failed, fail to get credentials", err.Error())
 
 	// case 5: empty session ak response json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"Credentials": {}}`),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get session token from sso failed, fail to get credentials", err.Error())
 
 	// case 6: mock ok value
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"RequestId": "123", "CloudCredential": {"AccessKeyId":"ak","AccessKeySecret":"sk","Expiration":"2021-10-20T04:27:09Z","SecurityToken":"token"}}`),
 		}
 		return
 	}
-	creds, err := p.getCredentials()
+	creds, err := p.getCredentials(context.TODO())
 	assert.Nil(t, err)
 	assert.Equal(t, "ak", creds.AccessKeyId)
 	assert.Equal(t, "sk", creds.AccessKeySecret)
@@ -173,7 +176,7 @@ func TestCloudSSOCredentialsProviderGetCredentials(t *testing.T) {
 	defer func() { httpDo = originHttpDo }()
 
 	// case 1: mock new http request failed
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
@@ -182,7 +185,7 @@ func TestCloudSSOCredentialsProviderGetCredentials(t *testing.T) {
 	assert.Equal(t, "mock server error", err.Error())
 
 	// case 2: get invalid expiration
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"CloudCredential": {"AccessKeyId":"akid","AccessKeySecret":"aksecret","Expiration":"invalidexpiration","SecurityToken":"ststoken"}}`),
@@ -194,7 +197,7 @@ func TestCloudSSOCredentialsProviderGetCredentials(t *testing.T) {
 	assert.Equal(t, "parsing time \"invalidexpiration\" as \"2006-01-02T15:04:05Z\": cannot parse \"invalidexpiration\" as \"2006\"", err.Error())
 
 	// case 3: happy result
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"CloudCredential": {"AccessKeyId":"akid","AccessKeySecret":"aksecret","Expiration":"2021-10-20T04:27:09Z","SecurityToken":"ststoken"}}`),
@@ -229,3 +232,31 @@ func TestCloudSSOCredentialsProviderGetCredentialsWithHttpOptions(t *testing.T)
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "proxyconnect tcp:")
 }
+
+func TestCloudSSOCredentialsProviderGetCredentialsConcurrently(t *testing.T) {
+	var count int32
+	server, rollback := mockLocalEndpoint(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		time.Sleep(50 * time.Millisecond)
+		expiration := time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		w.Write([]byte(`{"CloudCredential": {"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"` + expiration + `","SecurityToken":"token"}}`))
+	})
+	defer rollback()
+
+	p, err := NewCloudSSOCredentialsProviderBuilder().
+		WithSignInUrl(server.URL).
+		WithAccountId("uid").
+		WithAccessConfig("config").
+		WithAccessToken("token").
+		WithAccessTokenExpire(time.Now().Unix() + 3600).
+		Build()
+	assert.Nil(t, err)
+
+	hammer(t, p, func(cc *Credentials) {
+		assert.Equal(t, "saki", cc.AccessKeyId)
+		assert.Equal(t, "saks", cc.AccessKeySecret)
+		assert.Equal(t, "token", cc.SecurityToken)
+		assert.Equal(t, "cloud_sso", cc.ProviderName)
+	})
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
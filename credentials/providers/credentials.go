@@ -1,5 +1,10 @@
 package providers
 
+import (
+	"context"
+	"time"
+)
+
 // 下一版本 Credentials 包
 // - 分离 bearer token
 // - 从 config 传递迁移到真正的 credentials provider 模式
@@ -11,6 +16,8 @@ type Credentials struct {
 	AccessKeySecret string
 	SecurityToken   string
 	ProviderName    string
+	// The expiration time, it is zero for the credentials which never expire, such as static access key
+	Expiration time.Time
 }
 
 // The credentials provider interface, return credentials and provider name
@@ -20,3 +27,39 @@ type CredentialsProvider interface {
 	// Get credentials provider name
 	GetProviderName() string
 }
+
+// The credentials provider interface which supports cancellation and deadlines via context
+type CredentialsProviderWithContext interface {
+	CredentialsProvider
+	// Get credentials, the in-flight requests will be aborted when ctx is done
+	GetCredentialsWithContext(ctx context.Context) (*Credentials, error)
+}
+
+// The credentials provider interface which caches the credentials
+type CredentialsProviderWithInvalidate interface {
+	CredentialsProvider
+	// Invalidate drops the cached credentials, the next call of GetCredentials() gets new credentials.
+	// It is used when the service rejects the cached credentials as expired.
+	Invalidate()
+}
+
+// InvalidateCredentials drops the cached credentials of the provider if it implements CredentialsProviderWithInvalidate
+func InvalidateCredentials(provider CredentialsProvider) {
+	if p, ok := provider.(CredentialsProviderWithInvalidate); ok {
+		p.Invalidate()
+	}
+}
+
+// GetCredentialsWithContext gets credentials from the provider with ctx.
+// If the provider does not implement CredentialsProviderWithContext, it falls back to GetCredentials().
+func GetCredentialsWithContext(ctx context.Context, provider CredentialsProvider) (*Credentials, error) {
+	if p, ok := provider.(CredentialsProviderWithContext); ok {
+		return p.GetCredentialsWithContext(ctx)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return provider.GetCredentials()
+}
@@ -0,0 +1,100 @@
+package providers
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type customCredentialsProvider struct {
+}
+
+func (provider *customCredentialsProvider) GetCredentials() (*Credentials, error) {
+	return &Credentials{
+		AccessKeyId:     "akid",
+		AccessKeySecret: "aksecret",
+		ProviderName:    provider.GetProviderName(),
+	}, nil
+}
+
+func (provider *customCredentialsProvider) GetProviderName() string {
+	return "custom"
+}
+
+func TestGetCredentialsWithContext(t *testing.T) {
+	// case 1: provider without context support
+	p := &customCredentialsProvider{}
+	cc, err := GetCredentialsWithContext(context.Background(), p)
+	assert.Nil(t, err)
+	assert.Equal(t, "akid", cc.AccessKeyId)
+	assert.Equal(t, "custom", cc.ProviderName)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = GetCredentialsWithContext(ctx, p)
+	assert.Equal(t, context.Canceled, err)
+
+	// case 2: provider with context support
+	akProvider, err := NewStaticAKCredentialsProviderBuilder().
+		WithAccessKeyId("akid").
+		WithAccessKeySecret("aksecret").
+		Build()
+	assert.Nil(t, err)
+	var _ CredentialsProviderWithContext = akProvider
+	cc, err = GetCredentialsWithContext(context.Background(), akProvider)
+	assert.Nil(t, err)
+	assert.Equal(t, "static_ak", cc.ProviderName)
+}
+
+func TestInvalidateCredentials(t *testing.T) {
+	// the provider without cache
+	InvalidateCredentials(&customCredentialsProvider{})
+
+	var count int32
+	server, rollback := mockLocalEndpoint(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		expiration := time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		w.Write([]byte(`{"Code":"Success","AccessKeyId":"akid","AccessKeySecret":"aksecret","SecurityToken":"token","Expiration":"` + expiration + `"}`))
+	})
+	defer rollback()
+
+	p, err := NewURLCredentialsProviderBuilder().WithUrl(server.URL).Build()
+	assert.Nil(t, err)
+	var _ CredentialsProviderWithInvalidate = p
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+
+	InvalidateCredentials(p)
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+
+	// the chain invalidates the provider which provided the credentials
+	chain, err := NewDefaultCredentialsProviderBuilder().WithProviders(p, &customCredentialsProvider{}).Build()
+	assert.Nil(t, err)
+	_, err = chain.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+	chain.Invalidate()
+	_, err = chain.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&count))
+
+	// all the providers are invalidated if the last used provider is not kept
+	chain, err = NewDefaultCredentialsProviderBuilder().
+		WithProviders(&customCredentialsProvider{}, p).
+		WithReuseLastProviderEnabled(false).
+		Build()
+	assert.Nil(t, err)
+	chain.Invalidate()
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&count))
+}
@@ -1,113 +1,372 @@
 package providers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"strings"
+	"sync"
+	"time"
 )
 
+const defaultFailoverCoolDown = time.Minute
+
 type DefaultCredentialsProvider struct {
-	providerChain    []CredentialsProvider
-	lastUsedProvider CredentialsProvider
+	providerChain []CredentialsProvider
+	// the providers in the chain including the skipped ones, for the chain report
+	members []*chainMember
+	// do not reuse the provider which provided the credentials last time
+	reuseLastProviderDisabled bool
+	lastUsedProvider          CredentialsProvider
+	// the index of lastUsedProvider in providerChain
+	lastUsedIndex int
+	// a failed provider is tried after the others until the cool-down elapses
+	failoverCoolDown time.Duration
+	// the time when the provider at the index failed
+	failedAt map[int]time.Time
+	// for the cool-down decisions
+	clock Clock
+	// guards lastUsedProvider, lastUsedIndex and failedAt
+	mutex sync.RWMutex
 }
 
-func NewDefaultCredentialsProvider() (provider *DefaultCredentialsProvider) {
-	providers := []CredentialsProvider{}
+type DefaultCredentialsProviderBuilder struct {
+	provider    *DefaultCredentialsProvider
+	providers   []CredentialsProvider
+	httpOptions *HttpOptions
+}
+
+func NewDefaultCredentialsProviderBuilder() *DefaultCredentialsProviderBuilder {
+	return &DefaultCredentialsProviderBuilder{
+		provider: &DefaultCredentialsProvider{},
+	}
+}
+
+// WithProviders replaces the default provider chain, the providers are tried in order.
+// Use NewDefaultProviderChain() to get the default providers to reorder, drop or insert providers.
+func (builder *DefaultCredentialsProviderBuilder) WithProviders(providers ...CredentialsProvider) *DefaultCredentialsProviderBuilder {
+	builder.providers = append([]CredentialsProvider{}, providers...)
+	return builder
+}
+
+// WithHttpOptions sets the http options of the providers in the default provider chain,
+// it takes no effect on the providers set by WithProviders().
+func (builder *DefaultCredentialsProviderBuilder) WithHttpOptions(httpOptions *HttpOptions) *DefaultCredentialsProviderBuilder {
+	builder.httpOptions = httpOptions
+	return builder
+}
+
+// WithReuseLastProviderEnabled sets whether to reuse the provider which provided the credentials last time.
+// If it is disabled, the chain is tried from the first provider every time.
+func (builder *DefaultCredentialsProviderBuilder) WithReuseLastProviderEnabled(reuseLastProviderEnabled bool) *DefaultCredentialsProviderBuilder {
+	builder.provider.reuseLastProviderDisabled = !reuseLastProviderEnabled
+	return builder
+}
 
+// WithFailoverCoolDown sets how long a failed provider is tried after the other providers in the chain, default is 1 minute.
+// When the last used provider fails, the chain is walked again to find another provider.
+func (builder *DefaultCredentialsProviderBuilder) WithFailoverCoolDown(coolDown time.Duration) *DefaultCredentialsProviderBuilder {
+	builder.provider.failoverCoolDown = coolDown
+	return builder
+}
+
+// WithClock sets the clock to decide whether the cool-down of a failed provider elapsed, default is the system clock
+func (builder *DefaultCredentialsProviderBuilder) WithClock(clock Clock) *DefaultCredentialsProviderBuilder {
+	builder.provider.clock = clock
+	return builder
+}
+
+func (builder *DefaultCredentialsProviderBuilder) Build() (provider *DefaultCredentialsProvider, err error) {
+	if builder.provider.failoverCoolDown <= 0 {
+		builder.provider.failoverCoolDown = defaultFailoverCoolDown
+	}
+
+	if builder.providers == nil {
+		builder.provider.members = newDefaultChainMembers(builder.httpOptions)
+		builder.provider.providerChain = getBuiltProviders(builder.provider.members)
+	} else {
+		if len(builder.providers) == 0 {
+			err = errors.New("the provider chain is empty")
+			return
+		}
+
+		for _, p := range builder.providers {
+			if p == nil {
+				err = errors.New("the provider in the chain cannot be nil")
+				return
+			}
+		}
+		builder.provider.providerChain = builder.providers
+		builder.provider.members = newChainMembers(builder.providers)
+	}
+
+	provider = builder.provider
+	return
+}
+
+// NewDefaultProviderChain returns the providers of the default provider chain in order:
+// environment variables, OIDC, CLI profile, profile, ECS RAM role and credentials URI.
+// The providers disabled or not configured by the environment variables are skipped.
+func NewDefaultProviderChain(httpOptions *HttpOptions) []CredentialsProvider {
+	return getBuiltProviders(newDefaultChainMembers(httpOptions))
+}
+
+// chainMember is a provider of the chain, provider is nil if it is skipped when building the chain
+type chainMember struct {
+	providerName string
+	provider     CredentialsProvider
+	skipReason   string
+	// the environment variable which disabled the provider
+	disabledBy string
+}
+
+func newSkippedChainMember(providerName string, err error, disabledBy string) *chainMember {
+	return &chainMember{
+		providerName: providerName,
+		skipReason:   err.Error(),
+		disabledBy:   disabledBy,
+	}
+}
+
+func newChainMembers(providers []CredentialsProvider) (members []*chainMember) {
+	for _, p := range providers {
+		members = append(members, &chainMember{
+			providerName: p.GetProviderName(),
+			provider:     p,
+		})
+	}
+	return
+}
+
+func getBuiltProviders(members []*chainMember) (providers []CredentialsProvider) {
+	providers = []CredentialsProvider{}
+	for _, member := range members {
+		if member.provider != nil {
+			providers = append(providers, member.provider)
+		}
+	}
+	return
+}
+
+func newDefaultChainMembers(httpOptions *HttpOptions) (members []*chainMember) {
 	// Add static ak or sts credentials provider
 	envProvider, err := NewEnvironmentVariableCredentialsProviderBuilder().Build()
 	if err == nil {
-		providers = append(providers, envProvider)
+		members = append(members, &chainMember{providerName: envProvider.GetProviderName(), provider: envProvider})
+	} else {
+		members = append(members, newSkippedChainMember("env", err, ""))
 	}
 
 	// oidc check
-	oidcProvider, err := NewOIDCCredentialsProviderBuilder().Build()
+	oidcProvider, err := NewOIDCCredentialsProviderBuilder().WithHttpOptions(httpOptions).Build()
 	if err == nil {
-		providers = append(providers, oidcProvider)
+		members = append(members, &chainMember{providerName: oidcProvider.GetProviderName(), provider: oidcProvider})
+	} else {
+		members = append(members, newSkippedChainMember("oidc_role_arn", err, ""))
 	}
 
 	// cli credentials provider
-	cliProfileProvider, err := NewCLIProfileCredentialsProviderBuilder().Build()
+	cliProfileProvider, err := NewCLIProfileCredentialsProviderBuilder().WithHttpOptions(httpOptions).Build()
 	if err == nil {
-		providers = append(providers, cliProfileProvider)
+		members = append(members, &chainMember{providerName: cliProfileProvider.GetProviderName(), provider: cliProfileProvider})
+	} else {
+		members = append(members, newSkippedChainMember("cli_profile", err, "ALIBABA_CLOUD_CLI_PROFILE_DISABLED"))
 	}
 
 	// profile credentials provider
-	profileProvider, err := NewProfileCredentialsProviderBuilder().Build()
+	profileProvider, err := NewProfileCredentialsProviderBuilder().WithHttpOptions(httpOptions).Build()
 	if err == nil {
-		providers = append(providers, profileProvider)
+		members = append(members, &chainMember{providerName: profileProvider.GetProviderName(), provider: profileProvider})
+	} else {
+		members = append(members, newSkippedChainMember("profile", err, ""))
 	}
 
 	// Add IMDS
-	ecsRamRoleProvider, err := NewECSRAMRoleCredentialsProviderBuilder().Build()
+	ecsRamRoleProvider, err := NewECSRAMRoleCredentialsProviderBuilder().WithHttpOptions(httpOptions).Build()
 	if err == nil {
-		providers = append(providers, ecsRamRoleProvider)
+		members = append(members, &chainMember{providerName: ecsRamRoleProvider.GetProviderName(), provider: ecsRamRoleProvider})
+	} else {
+		disabledBy := ""
+		if errors.Is(err, ErrIMDSDisabled) {
+			disabledBy = "ALIBABA_CLOUD_ECS_METADATA_DISABLED"
+		}
+		members = append(members, newSkippedChainMember("ecs_ram_role", err, disabledBy))
 	}
 
 	// credentials uri
 	if os.Getenv("ALIBABA_CLOUD_CREDENTIALS_URI") != "" {
-		credentialsUriProvider, err := NewURLCredentialsProviderBuilder().Build()
+		credentialsUriProvider, err := NewURLCredentialsProviderBuilder().WithHttpOptions(httpOptions).Build()
 		if err == nil {
-			providers = append(providers, credentialsUriProvider)
+			members = append(members, &chainMember{providerName: credentialsUriProvider.GetProviderName(), provider: credentialsUriProvider})
+		} else {
+			members = append(members, newSkippedChainMember("credential_uri", err, ""))
 		}
+	} else {
+		members = append(members, newSkippedChainMember("credential_uri", errors.New("the environment variable ALIBABA_CLOUD_CREDENTIALS_URI is not set"), ""))
 	}
 
-	return &DefaultCredentialsProvider{
-		providerChain: providers,
-	}
+	return
+}
+
+func NewDefaultCredentialsProvider() (provider *DefaultCredentialsProvider) {
+	// 使用默认的 provider 链构建时不会出错
+	provider, _ = NewDefaultCredentialsProviderBuilder().Build()
+	return
 }
 
 func (provider *DefaultCredentialsProvider) GetCredentials() (cc *Credentials, err error) {
-	if provider.lastUsedProvider != nil {
-		inner, err1 := provider.lastUsedProvider.GetCredentials()
-		if err1 != nil {
-			err = err1
+	return provider.GetCredentialsWithContext(context.Background())
+}
+
+func (provider *DefaultCredentialsProvider) GetCredentialsWithContext(ctx context.Context) (cc *Credentials, err error) {
+	provider.mutex.RLock()
+	lastUsedProvider := provider.lastUsedProvider
+	lastUsedIndex := provider.lastUsedIndex
+	provider.mutex.RUnlock()
+
+	errors := []string{}
+	errs := []error{}
+	triedIndex := -1
+	if !provider.reuseLastProviderDisabled && lastUsedProvider != nil {
+		inner, err1 := GetCredentialsWithContext(ctx, lastUsedProvider)
+		if err1 == nil {
+			cc = provider.wrapCredentials(inner, lastUsedProvider)
 			return
 		}
 
-		providerName := inner.ProviderName
-		if providerName == "" {
-			providerName = provider.lastUsedProvider.GetProviderName()
+		// 调用方已取消，不再切换 provider
+		if ctx.Err() != nil {
+			err = err1
+			return
 		}
 
-		cc = &Credentials{
-			AccessKeyId:     inner.AccessKeyId,
-			AccessKeySecret: inner.AccessKeySecret,
-			SecurityToken:   inner.SecurityToken,
-			ProviderName:    fmt.Sprintf("%s/%s", provider.GetProviderName(), providerName),
+		// 上次使用的 provider 失败，重新遍历 provider 链
+		provider.mutex.Lock()
+		if provider.lastUsedIndex == lastUsedIndex {
+			provider.lastUsedProvider = nil
 		}
-		return
+		provider.mutex.Unlock()
+		provider.markFailed(lastUsedIndex)
+		errors = append(errors, err1.Error())
+		errs = append(errs, err1)
+		triedIndex = lastUsedIndex
 	}
 
-	errors := []string{}
-	for _, p := range provider.providerChain {
-		provider.lastUsedProvider = p
-		inner, errInLoop := p.GetCredentials()
+	for _, i := range provider.getFailoverOrder() {
+		if i == triedIndex {
+			continue
+		}
+
+		p := provider.providerChain[i]
+		inner, errInLoop := GetCredentialsWithContext(ctx, p)
 		if errInLoop != nil {
+			// 调用方已取消，不再尝试后续 provider
+			if ctx.Err() != nil {
+				err = errInLoop
+				return
+			}
+			provider.markFailed(i)
 			errors = append(errors, errInLoop.Error())
+			errs = append(errs, errInLoop)
 			// 如果有错误，进入下一个获取过程
 			continue
 		}
 
 		if inner != nil {
-			providerName := inner.ProviderName
-			if providerName == "" {
-				providerName = p.GetProviderName()
-			}
-			cc = &Credentials{
-				AccessKeyId:     inner.AccessKeyId,
-				AccessKeySecret: inner.AccessKeySecret,
-				SecurityToken:   inner.SecurityToken,
-				ProviderName:    fmt.Sprintf("%s/%s", provider.GetProviderName(), providerName),
+			provider.mutex.Lock()
+			delete(provider.failedAt, i)
+			if !provider.reuseLastProviderDisabled {
+				provider.lastUsedProvider = p
+				provider.lastUsedIndex = i
 			}
+			provider.mutex.Unlock()
+			cc = provider.wrapCredentials(inner, p)
 			return
 		}
 	}
 
-	err = fmt.Errorf("unable to get credentials from any of the providers in the chain: %s", strings.Join(errors, ", "))
+	err = newChainError(errors, errs)
 	return
 }
 
+func (provider *DefaultCredentialsProvider) markFailed(index int) {
+	if index < 0 || index >= len(provider.providerChain) {
+		return
+	}
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if provider.failedAt == nil {
+		provider.failedAt = make(map[int]time.Time)
+	}
+	provider.failedAt[index] = nowOf(provider.clock)
+}
+
+// getFailoverOrder returns the indexes of the providers to try, the providers in cool-down are moved to the end
+func (provider *DefaultCredentialsProvider) getFailoverOrder() []int {
+	coolDown := provider.failoverCoolDown
+	if coolDown <= 0 {
+		coolDown = defaultFailoverCoolDown
+	}
+
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
+	now := nowOf(provider.clock)
+	order := make([]int, 0, len(provider.providerChain))
+	coolingDown := []int{}
+	for i := range provider.providerChain {
+		if failedAt, ok := provider.failedAt[i]; ok && now.Sub(failedAt) < coolDown {
+			coolingDown = append(coolingDown, i)
+			continue
+		}
+		order = append(order, i)
+	}
+	return append(order, coolingDown...)
+}
+
+// GetSelectedProviderName returns the name of the provider which provided the credentials last time,
+// it is empty if no provider is selected, such as before the first call or after the selected provider failed.
+func (provider *DefaultCredentialsProvider) GetSelectedProviderName() string {
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
+	if provider.lastUsedProvider == nil {
+		return ""
+	}
+	return provider.lastUsedProvider.GetProviderName()
+}
+
+// Invalidate drops the cached credentials of the provider which provided the credentials last time,
+// the cached credentials of all the providers in the chain are dropped if there is no such provider.
+func (provider *DefaultCredentialsProvider) Invalidate() {
+	provider.mutex.RLock()
+	lastUsedProvider := provider.lastUsedProvider
+	provider.mutex.RUnlock()
+	if lastUsedProvider != nil {
+		InvalidateCredentials(lastUsedProvider)
+		return
+	}
+
+	for _, p := range provider.providerChain {
+		InvalidateCredentials(p)
+	}
+}
+
+// wrapCredentials prefixes the provider name of the inner credentials with the name of the chain
+func (provider *DefaultCredentialsProvider) wrapCredentials(inner *Credentials, p CredentialsProvider) *Credentials {
+	providerName := inner.ProviderName
+	if providerName == "" {
+		providerName = p.GetProviderName()
+	}
+
+	return &Credentials{
+		AccessKeyId:     inner.AccessKeyId,
+		AccessKeySecret: inner.AccessKeySecret,
+		SecurityToken:   inner.SecurityToken,
+		ProviderName:    fmt.Sprintf("%s/%s", provider.GetProviderName(), providerName),
+		Expiration:      inner.Expiration,
+	}
+}
+
 func (provider *DefaultCredentialsProvider) GetProviderName() string {
 	return "default"
 }
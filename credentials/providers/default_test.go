@@ -1,11 +1,14 @@
 package providers
 
 import (
+	"context"
 	"errors"
 	"os"
 	"path"
 	"testing"
+	"time"
 
+	"github.com/aliyun/credentials-go/credentials/internal/clocktest"
 	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
 	"github.com/aliyun/credentials-go/credentials/internal/utils"
 	"github.com/stretchr/testify/assert"
@@ -145,7 +148,7 @@ func TestDefaultCredentialsProvider_GetCredentials(t *testing.T) {
 	os.Setenv("ALIBABA_CLOUD_ACCESS_KEY_ID", "")
 	os.Setenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET", "")
 	os.Setenv("ALIBABA_CLOUD_PROFILE", "ChainableRamRoleArn")
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"Credentials": {"AccessKeyId":"akid","AccessKeySecret":"aksecret","Expiration":"2021-10-20T04:27:09Z","SecurityToken":"ststoken"}}`),
@@ -155,7 +158,7 @@ func TestDefaultCredentialsProvider_GetCredentials(t *testing.T) {
 	provider = NewDefaultCredentialsProvider()
 	cc, err = provider.GetCredentials()
 	assert.Nil(t, err)
-	assert.Equal(t, &Credentials{AccessKeyId: "akid", AccessKeySecret: "aksecret", SecurityToken: "ststoken", ProviderName: "default/cli_profile/ram_role_arn/ram_role_arn/static_ak"}, cc)
+	assert.Equal(t, &Credentials{AccessKeyId: "akid", AccessKeySecret: "aksecret", SecurityToken: "ststoken", ProviderName: "default/cli_profile/ram_role_arn/ram_role_arn/static_ak", Expiration: time.Date(2021, 10, 20, 4, 27, 9, 0, time.UTC)}, cc)
 
 	provider.lastUsedProvider = new(testProvider)
 	cc, err = provider.GetCredentials()
@@ -164,9 +167,13 @@ func TestDefaultCredentialsProvider_GetCredentials(t *testing.T) {
 	assert.Equal(t, "test", cc.AccessKeySecret)
 	assert.Equal(t, "default/test", cc.ProviderName)
 
+	// fail over to the chain when the last used provider fails
 	provider.lastUsedProvider = new(testErrorProvider)
-	_, err = provider.GetCredentials()
-	assert.Equal(t, "error", err.Error())
+	provider.lastUsedIndex = -1
+	cc, err = provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "default/cli_profile/ram_role_arn/ram_role_arn/static_ak", cc.ProviderName)
+	assert.Equal(t, "cli_profile", provider.GetSelectedProviderName())
 }
 
 type testProvider struct {
@@ -196,3 +203,209 @@ func (provider *testErrorProvider) GetCredentials() (cc *Credentials, err error)
 func (provider *testErrorProvider) GetProviderName() string {
 	return "test"
 }
+
+func TestDefaultCredentialsProviderWithCancelledContext(t *testing.T) {
+	akProvider, err := NewStaticAKCredentialsProviderBuilder().
+		WithAccessKeyId("akid").
+		WithAccessKeySecret("aksecret").
+		Build()
+	assert.Nil(t, err)
+
+	provider := &DefaultCredentialsProvider{
+		providerChain: []CredentialsProvider{&customCredentialsProvider{}, akProvider},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = provider.GetCredentialsWithContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	cc, err := provider.GetCredentialsWithContext(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "default/custom", cc.ProviderName)
+}
+
+func TestDefaultCredentialsProviderGetCredentialsConcurrently(t *testing.T) {
+	rollback := utils.Memory("ALIBABA_CLOUD_ACCESS_KEY_ID", "ALIBABA_CLOUD_ACCESS_KEY_SECRET")
+	defer rollback()
+	os.Setenv("ALIBABA_CLOUD_ACCESS_KEY_ID", "akid")
+	os.Setenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET", "aksecret")
+
+	provider := NewDefaultCredentialsProvider()
+	hammer(t, provider, func(cc *Credentials) {
+		assert.Equal(t, "akid", cc.AccessKeyId)
+		assert.Equal(t, "aksecret", cc.AccessKeySecret)
+		assert.Equal(t, "default/env", cc.ProviderName)
+	})
+}
+
+func TestDefaultCredentialsProviderBuilder(t *testing.T) {
+	rollback := utils.Memory("ALIBABA_CLOUD_ECS_METADATA_DISABLED", "ALIBABA_CLOUD_CREDENTIALS_URI")
+	defer rollback()
+
+	// default chain
+	os.Setenv("ALIBABA_CLOUD_CREDENTIALS_URI", "http://localhost/credentials")
+	httpOptions := &HttpOptions{ConnectTimeout: 1000, ReadTimeout: 2000}
+	provider, err := NewDefaultCredentialsProviderBuilder().WithHttpOptions(httpOptions).Build()
+	assert.Nil(t, err)
+	assert.False(t, provider.reuseLastProviderDisabled)
+	assert.Len(t, provider.providerChain, 5)
+	assert.Equal(t, httpOptions, provider.providerChain[1].(*CLIProfileCredentialsProvider).httpOptions)
+	assert.Equal(t, httpOptions, provider.providerChain[2].(*ProfileCredentialsProvider).httpOptions)
+	assert.Equal(t, httpOptions, provider.providerChain[3].(*ECSRAMRoleCredentialsProvider).httpOptions)
+	assert.Equal(t, httpOptions, provider.providerChain[4].(*URLCredentialsProvider).httpOptions)
+
+	// custom chain
+	_, err = NewDefaultCredentialsProviderBuilder().WithProviders().Build()
+	assert.EqualError(t, err, "the provider chain is empty")
+
+	_, err = NewDefaultCredentialsProviderBuilder().WithProviders(new(testProvider), nil).Build()
+	assert.EqualError(t, err, "the provider in the chain cannot be nil")
+
+	os.Setenv("ALIBABA_CLOUD_ECS_METADATA_DISABLED", "true")
+	chain := NewDefaultProviderChain(nil)
+	assert.Len(t, chain, 4)
+	provider, err = NewDefaultCredentialsProviderBuilder().
+		WithProviders(append([]CredentialsProvider{new(testErrorProvider), new(testProvider)}, chain...)...).
+		Build()
+	assert.Nil(t, err)
+	assert.Len(t, provider.providerChain, 6)
+	cc, err := provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "default/test", cc.ProviderName)
+}
+
+type switchableProvider struct {
+	failed bool
+}
+
+func (provider *switchableProvider) GetCredentials() (cc *Credentials, err error) {
+	if provider.failed {
+		err = errors.New("failed")
+		return
+	}
+	cc = &Credentials{
+		AccessKeyId:     "first",
+		AccessKeySecret: "first",
+	}
+	return
+}
+
+func (provider *switchableProvider) GetProviderName() string {
+	return "switchable"
+}
+
+func TestDefaultCredentialsProviderReuseLastProvider(t *testing.T) {
+	first := &switchableProvider{}
+
+	// sticky
+	provider, err := NewDefaultCredentialsProviderBuilder().
+		WithProviders(new(testErrorProvider), first).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, "", provider.GetSelectedProviderName())
+	cc, err := provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "default/switchable", cc.ProviderName)
+	assert.Equal(t, "switchable", provider.GetSelectedProviderName())
+	assert.Equal(t, first, provider.lastUsedProvider)
+
+	// not sticky
+	provider, err = NewDefaultCredentialsProviderBuilder().
+		WithProviders(new(testErrorProvider), first).
+		WithReuseLastProviderEnabled(false).
+		Build()
+	assert.Nil(t, err)
+	cc, err = provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "default/switchable", cc.ProviderName)
+	assert.Nil(t, provider.lastUsedProvider)
+	assert.Equal(t, "", provider.GetSelectedProviderName())
+}
+
+func TestDefaultCredentialsProviderFailover(t *testing.T) {
+	first := &switchableProvider{}
+	provider, err := NewDefaultCredentialsProviderBuilder().
+		WithProviders(first, new(testProvider)).
+		WithFailoverCoolDown(100 * time.Millisecond).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, 100*time.Millisecond, provider.failoverCoolDown)
+
+	cc, err := provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "default/switchable", cc.ProviderName)
+	assert.Equal(t, "switchable", provider.GetSelectedProviderName())
+
+	// the selected provider breaks, fail over to the next one
+	first.failed = true
+	cc, err = provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "default/test", cc.ProviderName)
+	assert.Equal(t, "test", provider.GetSelectedProviderName())
+
+	// the failed provider is tried after the others during the cool-down
+	first.failed = false
+	provider.lastUsedProvider = nil
+	cc, err = provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "default/test", cc.ProviderName)
+
+	time.Sleep(150 * time.Millisecond)
+	provider.lastUsedProvider = nil
+	cc, err = provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "default/switchable", cc.ProviderName)
+
+	// all providers fail
+	provider, err = NewDefaultCredentialsProviderBuilder().
+		WithProviders(new(testErrorProvider), &switchableProvider{failed: true}).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, provider.failoverCoolDown)
+	for i := 0; i < 2; i++ {
+		_, err = provider.GetCredentials()
+		assert.EqualError(t, err, "unable to get credentials from any of the providers in the chain: error, failed")
+	}
+
+	// the cancelled call does not fail over
+	provider, err = NewDefaultCredentialsProviderBuilder().
+		WithProviders(&customCredentialsProvider{}, new(testProvider)).
+		Build()
+	assert.Nil(t, err)
+	provider.lastUsedProvider = &customCredentialsProvider{}
+	provider.lastUsedIndex = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = provider.GetCredentialsWithContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, "custom", provider.GetSelectedProviderName())
+}
+
+func TestDefaultCredentialsProviderFailoverWithClock(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	first := &switchableProvider{failed: true}
+	provider, err := NewDefaultCredentialsProviderBuilder().
+		WithProviders(first, new(testProvider)).
+		WithClock(clock).
+		Build()
+	assert.Nil(t, err)
+
+	cc, err := provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "default/test", cc.ProviderName)
+
+	// the failed provider is tried after the others until the cool-down elapses
+	first.failed = false
+	clock.Advance(time.Minute - time.Millisecond)
+	provider.lastUsedProvider = nil
+	cc, err = provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "default/test", cc.ProviderName)
+
+	clock.Advance(time.Millisecond)
+	provider.lastUsedProvider = nil
+	cc, err = provider.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "default/switchable", cc.ProviderName)
+}
@@ -1,12 +1,13 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
@@ -15,11 +16,24 @@ import (
 type ECSRAMRoleCredentialsProvider struct {
 	roleName      string
 	disableIMDSv1 bool
+	// the endpoint of the metadata service
+	endpoint string
+	protocol string
+	host     string
 	// for sts
 	session             *sessionCredentials
 	expirationTimestamp int64
 	// for http options
 	httpOptions *HttpOptions
+	// for expiry and refresh decisions
+	clock Clock
+	// guards the inner session fields
+	mutex        sync.RWMutex
+	refreshGroup refreshGroup
+	// for async refresh
+	asyncRefreshOptions *AsyncRefreshOptions
+	asyncRefresher      *asyncRefresher
+	refreshListener     refreshListener
 }
 
 type ECSRAMRoleCredentialsProviderBuilder struct {
@@ -42,6 +56,24 @@ func (builder *ECSRAMRoleCredentialsProviderBuilder) WithDisableIMDSv1(disableIM
 	return builder
 }
 
+// WithEndpoint sets the endpoint of the metadata service, such as http://127.0.0.1:8080, default is 100.100.100.200
+func (builder *ECSRAMRoleCredentialsProviderBuilder) WithEndpoint(endpoint string) *ECSRAMRoleCredentialsProviderBuilder {
+	builder.provider.endpoint = endpoint
+	return builder
+}
+
+// WithOnRefresh sets the callback which is called after the credentials are refreshed
+func (builder *ECSRAMRoleCredentialsProviderBuilder) WithOnRefresh(onRefresh OnRefreshFunc) *ECSRAMRoleCredentialsProviderBuilder {
+	builder.provider.refreshListener.onRefresh = onRefresh
+	return builder
+}
+
+// WithOnRefreshError sets the callback which is called after the credentials failed to refresh
+func (builder *ECSRAMRoleCredentialsProviderBuilder) WithOnRefreshError(onRefreshError OnRefreshErrorFunc) *ECSRAMRoleCredentialsProviderBuilder {
+	builder.provider.refreshListener.onRefreshError = onRefreshError
+	return builder
+}
+
 func (builder *ECSRAMRoleCredentialsProviderBuilder) WithHttpOptions(httpOptions *HttpOptions) *ECSRAMRoleCredentialsProviderBuilder {
 	builder.provider.httpOptions = httpOptions
 	return builder
@@ -49,10 +81,27 @@ func (builder *ECSRAMRoleCredentialsProviderBuilder) WithHttpOptions(httpOptions
 
 const defaultMetadataTokenDuration = 21600 // 6 hours
 
+const defaultMetadataEndpoint = "100.100.100.200"
+
+// WithAsyncRefresh enables refreshing the credentials in background, call Close() to stop it
+func (builder *ECSRAMRoleCredentialsProviderBuilder) WithAsyncRefresh(options *AsyncRefreshOptions) *ECSRAMRoleCredentialsProviderBuilder {
+	builder.provider.asyncRefreshOptions = options
+	return builder
+}
+
+// WithClock sets the clock to decide whether the credentials are expired or due for a refresh, default is the system clock
+func (builder *ECSRAMRoleCredentialsProviderBuilder) WithClock(clock Clock) *ECSRAMRoleCredentialsProviderBuilder {
+	builder.provider.clock = clock
+	return builder
+}
+
 func (builder *ECSRAMRoleCredentialsProviderBuilder) Build() (provider *ECSRAMRoleCredentialsProvider, err error) {
 
 	if strings.ToLower(os.Getenv("ALIBABA_CLOUD_ECS_METADATA_DISABLED")) == "true" {
-		err = errors.New("IMDS credentials is disabled")
+		err = &sentinelError{
+			message:  "IMDS credentials is disabled",
+			sentinel: ErrIMDSDisabled,
+		}
 		return
 	}
 
@@ -65,6 +114,35 @@ func (builder *ECSRAMRoleCredentialsProviderBuilder) Build() (provider *ECSRAMRo
 		builder.provider.disableIMDSv1 = strings.ToLower(os.Getenv("ALIBABA_CLOUD_IMDSV1_DISABLED")) == "true"
 	}
 
+	if builder.provider.endpoint == "" {
+		builder.provider.endpoint = os.Getenv("ALIBABA_CLOUD_IMDS_ENDPOINT")
+	}
+
+	if builder.provider.endpoint == "" {
+		builder.provider.endpoint = defaultMetadataEndpoint
+	}
+
+	// 支持 host[:port] 和 http(s)://host[:port] 两种格式
+	builder.provider.protocol = "http"
+	builder.provider.host = builder.provider.endpoint
+	if index := strings.Index(builder.provider.host, "://"); index >= 0 {
+		builder.provider.protocol = strings.ToLower(builder.provider.host[:index])
+		builder.provider.host = builder.provider.host[index+3:]
+	}
+	builder.provider.host = strings.TrimSuffix(builder.provider.host, "/")
+
+	if builder.provider.protocol != "http" && builder.provider.protocol != "https" || builder.provider.host == "" {
+		err = fmt.Errorf("invalid metadata endpoint: %s", builder.provider.endpoint)
+		return
+	}
+
+	if builder.provider.asyncRefreshOptions != nil {
+		p := builder.provider
+		p.asyncRefresher = newAsyncRefresher(p.asyncRefreshOptions, p.clock, func(ctx context.Context) error {
+			return p.refreshGroup.do(ctx, p.updateCredential)
+		}, p.getExpirationTimestamp)
+	}
+
 	provider = builder.provider
 	return
 }
@@ -83,14 +161,14 @@ func (provider *ECSRAMRoleCredentialsProvider) needUpdateCredential() bool {
 		return true
 	}
 
-	return provider.expirationTimestamp-time.Now().Unix() <= 180
+	return provider.expirationTimestamp-nowOf(provider.clock).Unix() <= provider.asyncRefresher.staleSeconds()
 }
 
-func (provider *ECSRAMRoleCredentialsProvider) getRoleName() (roleName string, err error) {
+func (provider *ECSRAMRoleCredentialsProvider) getRoleName(ctx context.Context) (roleName string, err error) {
 	req := &httputil.Request{
 		Method:   "GET",
-		Protocol: "http",
-		Host:     "100.100.100.200",
+		Protocol: provider.protocol,
+		Host:     provider.host,
 		Path:     "/latest/meta-data/ram/security-credentials/",
 		Headers:  map[string]string{},
 	}
@@ -107,10 +185,15 @@ func (provider *ECSRAMRoleCredentialsProvider) getRoleName() (roleName string, e
 	if provider.httpOptions != nil && provider.httpOptions.Proxy != "" {
 		req.Proxy = provider.httpOptions.Proxy
 	}
+	if provider.httpOptions != nil {
+		req.Client = provider.httpOptions.Client
+		req.Transport = provider.httpOptions.Transport
+		req.Logger = provider.httpOptions.Logger
+	}
 	req.ConnectTimeout = connectTimeout
 	req.ReadTimeout = readTimeout
 
-	metadataToken, err := provider.getMetadataToken()
+	metadataToken, err := provider.getMetadataToken(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -118,14 +201,14 @@ func (provider *ECSRAMRoleCredentialsProvider) getRoleName() (roleName string, e
 		req.Headers["x-aliyun-ecs-metadata-token"] = metadataToken
 	}
 
-	res, err := httpDo(req)
+	res, err := doWithRetry(ctx, req, provider.httpOptions, nil)
 	if err != nil {
-		err = fmt.Errorf("get role name failed: %s", err.Error())
+		err = fmt.Errorf("get role name failed: %w", err)
 		return
 	}
 
 	if res.StatusCode != 200 {
-		err = fmt.Errorf("get role name failed: %s %d", req.BuildRequestURL(), res.StatusCode)
+		err = newServiceError(req, res, fmt.Sprintf("get role name failed: %s %d", req.BuildRequestURL(), res.StatusCode))
 		return
 	}
 
@@ -133,10 +216,10 @@ func (provider *ECSRAMRoleCredentialsProvider) getRoleName() (roleName string, e
 	return
 }
 
-func (provider *ECSRAMRoleCredentialsProvider) getCredentials() (session *sessionCredentials, err error) {
+func (provider *ECSRAMRoleCredentialsProvider) getCredentials(ctx context.Context) (session *sessionCredentials, err error) {
 	roleName := provider.roleName
 	if roleName == "" {
-		roleName, err = provider.getRoleName()
+		roleName, err = provider.getRoleName(ctx)
 		if err != nil {
 			return
 		}
@@ -144,8 +227,8 @@ func (provider *ECSRAMRoleCredentialsProvider) getCredentials() (session *sessio
 
 	req := &httputil.Request{
 		Method:   "GET",
-		Protocol: "http",
-		Host:     "100.100.100.200",
+		Protocol: provider.protocol,
+		Host:     provider.host,
 		Path:     "/latest/meta-data/ram/security-credentials/" + roleName,
 		Headers:  map[string]string{},
 	}
@@ -162,10 +245,15 @@ func (provider *ECSRAMRoleCredentialsProvider) getCredentials() (session *sessio
 	if provider.httpOptions != nil && provider.httpOptions.Proxy != "" {
 		req.Proxy = provider.httpOptions.Proxy
 	}
+	if provider.httpOptions != nil {
+		req.Client = provider.httpOptions.Client
+		req.Transport = provider.httpOptions.Transport
+		req.Logger = provider.httpOptions.Logger
+	}
 	req.ConnectTimeout = connectTimeout
 	req.ReadTimeout = readTimeout
 
-	metadataToken, err := provider.getMetadataToken()
+	metadataToken, err := provider.getMetadataToken(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -173,14 +261,14 @@ func (provider *ECSRAMRoleCredentialsProvider) getCredentials() (session *sessio
 		req.Headers["x-aliyun-ecs-metadata-token"] = metadataToken
 	}
 
-	res, err := httpDo(req)
+	res, err := doWithRetry(ctx, req, provider.httpOptions, nil)
 	if err != nil {
-		err = fmt.Errorf("refresh Ecs sts token err: %s", err.Error())
+		err = fmt.Errorf("refresh Ecs sts token err: %w", err)
 		return
 	}
 
 	if res.StatusCode != 200 {
-		err = fmt.Errorf("refresh Ecs sts token err, httpStatus: %d, message = %s", res.StatusCode, string(res.Body))
+		err = newServiceError(req, res, fmt.Sprintf("refresh Ecs sts token err, httpStatus: %d, message = %s", res.StatusCode, string(res.Body)))
 		return
 	}
 
@@ -211,39 +299,91 @@ func (provider *ECSRAMRoleCredentialsProvider) getCredentials() (session *sessio
 }
 
 func (provider *ECSRAMRoleCredentialsProvider) GetCredentials() (cc *Credentials, err error) {
-	if provider.session == nil || provider.needUpdateCredential() {
-		session, err1 := provider.getCredentials()
-		if err1 != nil {
-			return nil, err1
-		}
+	return provider.GetCredentialsWithContext(context.Background())
+}
+
+func (provider *ECSRAMRoleCredentialsProvider) GetCredentialsWithContext(ctx context.Context) (cc *Credentials, err error) {
+	provider.mutex.RLock()
+	needUpdate := provider.session == nil || provider.needUpdateCredential()
+	provider.mutex.RUnlock()
 
-		provider.session = session
-		expirationTime, err2 := time.Parse("2006-01-02T15:04:05Z", session.Expiration)
-		if err2 != nil {
-			return nil, err2
+	// 刷新失败后的重试间隔内直接使用尚未过期的缓存凭证，避免每次调用都等待失败的刷新
+	if needUpdate && !provider.asyncRefresher.inRetryBackoff(provider.getExpirationTimestamp()) {
+		err = provider.refreshGroup.do(ctx, provider.updateCredential)
+		provider.asyncRefresher.recordRefresh(ctx, err)
+		if err != nil {
+			// 异步刷新模式下，刷新失败时继续使用尚未过期的缓存凭证
+			provider.mutex.RLock()
+			canUseStaleSession := provider.asyncRefresher.canUseStaleSession(provider.expirationTimestamp)
+			provider.mutex.RUnlock()
+			if !canUseStaleSession {
+				return
+			}
+			err = nil
 		}
-		provider.expirationTimestamp = expirationTime.Unix()
 	}
+	provider.asyncRefresher.start()
 
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
 	cc = &Credentials{
 		AccessKeyId:     provider.session.AccessKeyId,
 		AccessKeySecret: provider.session.AccessKeySecret,
 		SecurityToken:   provider.session.SecurityToken,
 		ProviderName:    provider.GetProviderName(),
+		Expiration:      time.Unix(provider.expirationTimestamp, 0).UTC(),
+	}
+	return
+}
+
+func (provider *ECSRAMRoleCredentialsProvider) updateCredential(ctx context.Context) (err error) {
+	ctx, endRefresh := startRefresh(ctx, getInstrumentation(provider.httpOptions), provider.clock, provider.GetProviderName())
+	defer func() {
+		expirationTimestamp := provider.getExpirationTimestamp()
+		endRefresh(expirationTimestamp, err)
+		provider.refreshListener.notify(provider.GetProviderName(), expirationTimestamp, err)
+	}()
+
+	session, err := provider.getCredentials(ctx)
+	if err != nil {
+		return
 	}
+
+	expirationTime, err := time.Parse("2006-01-02T15:04:05Z", session.Expiration)
+	if err != nil {
+		return
+	}
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.expirationTimestamp = expirationTime.Unix()
+	provider.session = session
 	return
 }
 
+func (provider *ECSRAMRoleCredentialsProvider) getExpirationTimestamp() int64 {
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
+	return provider.expirationTimestamp
+}
+
+// Invalidate drops the cached credentials, the next GetCredentials() gets new credentials from the service
+func (provider *ECSRAMRoleCredentialsProvider) Invalidate() {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.expirationTimestamp = 0
+}
+
 func (provider *ECSRAMRoleCredentialsProvider) GetProviderName() string {
 	return "ecs_ram_role"
 }
 
-func (provider *ECSRAMRoleCredentialsProvider) getMetadataToken() (metadataToken string, err error) {
+func (provider *ECSRAMRoleCredentialsProvider) getMetadataToken(ctx context.Context) (metadataToken string, err error) {
 	// PUT http://100.100.100.200/latest/api/token
 	req := &httputil.Request{
 		Method:   "PUT",
-		Protocol: "http",
-		Host:     "100.100.100.200",
+		Protocol: provider.protocol,
+		Host:     provider.host,
 		Path:     "/latest/api/token",
 		Headers: map[string]string{
 			"X-aliyun-ecs-metadata-token-ttl-seconds": strconv.Itoa(defaultMetadataTokenDuration),
@@ -262,22 +402,33 @@ func (provider *ECSRAMRoleCredentialsProvider) getMetadataToken() (metadataToken
 	if provider.httpOptions != nil && provider.httpOptions.Proxy != "" {
 		req.Proxy = provider.httpOptions.Proxy
 	}
+	if provider.httpOptions != nil {
+		req.Client = provider.httpOptions.Client
+		req.Transport = provider.httpOptions.Transport
+		req.Logger = provider.httpOptions.Logger
+	}
 	req.ConnectTimeout = connectTimeout
 	req.ReadTimeout = readTimeout
 
-	res, _err := httpDo(req)
+	res, _err := doWithRetry(ctx, req, provider.httpOptions, nil)
 	if _err != nil {
 		if provider.disableIMDSv1 {
-			err = fmt.Errorf("get metadata token failed: %s", _err.Error())
+			err = fmt.Errorf("get metadata token failed: %w", _err)
 		}
 		return
 	}
 	if res.StatusCode != 200 {
 		if provider.disableIMDSv1 {
-			err = fmt.Errorf("refresh Ecs sts token err, httpStatus: %d, message = %s", res.StatusCode, string(res.Body))
+			err = newServiceError(req, res, fmt.Sprintf("refresh Ecs sts token err, httpStatus: %d, message = %s", res.StatusCode, string(res.Body)))
 		}
 		return
 	}
 	metadataToken = string(res.Body)
 	return
 }
+
+// Close stops the background refresh, it is a no-op if async refresh is not enabled
+func (provider *ECSRAMRoleCredentialsProvider) Close() error {
+	provider.asyncRefresher.close()
+	return nil
+}
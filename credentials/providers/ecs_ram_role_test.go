@@ -1,8 +1,11 @@
 package providers
 
 import (
+	"context"
 	"errors"
+	"net/http"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -43,10 +46,60 @@ func TestNewECSRAMRoleCredentialsProvider(t *testing.T) {
 	os.Setenv("ALIBABA_CLOUD_ECS_METADATA_DISABLED", "True")
 	_, err = NewECSRAMRoleCredentialsProviderBuilder().Build()
 	assert.Equal(t, "IMDS credentials is disabled", err.Error())
+	assert.True(t, errors.Is(err, ErrIMDSDisabled))
 
 	assert.True(t, p.needUpdateCredential())
 }
 
+func TestNewECSRAMRoleCredentialsProviderWithEndpoint(t *testing.T) {
+	rollback := utils.Memory("ALIBABA_CLOUD_ECS_METADATA_DISABLED", "ALIBABA_CLOUD_IMDS_ENDPOINT")
+	defer rollback()
+	os.Unsetenv("ALIBABA_CLOUD_ECS_METADATA_DISABLED")
+
+	p, err := NewECSRAMRoleCredentialsProviderBuilder().Build()
+	assert.Nil(t, err)
+	assert.Equal(t, "http", p.protocol)
+	assert.Equal(t, "100.100.100.200", p.host)
+
+	os.Setenv("ALIBABA_CLOUD_IMDS_ENDPOINT", "127.0.0.1:8080")
+	p, err = NewECSRAMRoleCredentialsProviderBuilder().Build()
+	assert.Nil(t, err)
+	assert.Equal(t, "http", p.protocol)
+	assert.Equal(t, "127.0.0.1:8080", p.host)
+
+	p, err = NewECSRAMRoleCredentialsProviderBuilder().WithEndpoint("HTTPS://imds.example.com/").Build()
+	assert.Nil(t, err)
+	assert.Equal(t, "https", p.protocol)
+	assert.Equal(t, "imds.example.com", p.host)
+
+	_, err = NewECSRAMRoleCredentialsProviderBuilder().WithEndpoint("ftp://127.0.0.1").Build()
+	assert.EqualError(t, err, "invalid metadata endpoint: ftp://127.0.0.1")
+
+	_, err = NewECSRAMRoleCredentialsProviderBuilder().WithEndpoint("http://").Build()
+	assert.EqualError(t, err, "invalid metadata endpoint: http://")
+
+	originHttpDo := httpDo
+	defer func() { httpDo = originHttpDo }()
+	var urls []string
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
+		urls = append(urls, req.BuildRequestURL())
+		res = &httputil.Response{
+			StatusCode: 200,
+			Body:       []byte("rolename"),
+		}
+		return
+	}
+	p, err = NewECSRAMRoleCredentialsProviderBuilder().WithEndpoint("http://127.0.0.1:8080").Build()
+	assert.Nil(t, err)
+	roleName, err := p.getRoleName(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "rolename", roleName)
+	assert.Equal(t, []string{
+		"PUT http://127.0.0.1:8080/latest/api/token",
+		"GET http://127.0.0.1:8080/latest/meta-data/ram/security-credentials/",
+	}, urls)
+}
+
 func TestECSRAMRoleCredentialsProvider_getRoleName(t *testing.T) {
 	originHttpDo := httpDo
 	defer func() { httpDo = originHttpDo }()
@@ -55,17 +108,17 @@ func TestECSRAMRoleCredentialsProvider_getRoleName(t *testing.T) {
 	assert.Nil(t, err)
 
 	// case 1: server error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
 
-	_, err = p.getRoleName()
+	_, err = p.getRoleName(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get role name failed: mock server error", err.Error())
 
 	// case 2: 4xx error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 400,
 			Body:       []byte("4xx error"),
@@ -73,19 +126,19 @@ func TestECSRAMRoleCredentialsProvider_getRoleName(t *testing.T) {
 		return
 	}
 
-	_, err = p.getRoleName()
+	_, err = p.getRoleName(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get role name failed: GET http://100.100.100.200/latest/meta-data/ram/security-credentials/ 400", err.Error())
 
 	// case 3: ok
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte("rolename"),
 		}
 		return
 	}
-	roleName, err := p.getRoleName()
+	roleName, err := p.getRoleName(context.TODO())
 	assert.Nil(t, err)
 	assert.Equal(t, "rolename", roleName)
 }
@@ -98,17 +151,17 @@ func TestECSRAMRoleCredentialsProvider_getRoleNameWithMetadataV2(t *testing.T) {
 	assert.Nil(t, err)
 
 	// case 1: get metadata token failed
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
 
-	_, err = p.getRoleName()
+	_, err = p.getRoleName(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get metadata token failed: mock server error", err.Error())
 
 	// case 2: return token
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/latest/api/token" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -125,7 +178,7 @@ func TestECSRAMRoleCredentialsProvider_getRoleNameWithMetadataV2(t *testing.T) {
 		return
 	}
 
-	roleName, err := p.getRoleName()
+	roleName, err := p.getRoleName(context.TODO())
 	assert.Nil(t, err)
 	assert.Equal(t, "rolename", roleName)
 }
@@ -138,16 +191,16 @@ func TestECSRAMRoleCredentialsProvider_getCredentials(t *testing.T) {
 	assert.Nil(t, err)
 
 	// case 1: server error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get role name failed: mock server error", err.Error())
 
 	// case 2: get role name ok, get credentials failed with server error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/latest/meta-data/ram/security-credentials/" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -159,12 +212,12 @@ func TestECSRAMRoleCredentialsProvider_getCredentials(t *testing.T) {
 		return
 	}
 
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "refresh Ecs sts token err: mock server error", err.Error())
 
 	// case 3: 4xx error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/latest/meta-data/ram/security-credentials/" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -180,12 +233,12 @@ func TestECSRAMRoleCredentialsProvider_getCredentials(t *testing.T) {
 		return
 	}
 
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "refresh Ecs sts token err, httpStatus: 400, message = 4xx error", err.Error())
 
 	// case 4: invalid json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/latest/meta-data/ram/security-credentials/" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -201,12 +254,12 @@ func TestECSRAMRoleCredentialsProvider_getCredentials(t *testing.T) {
 		return
 	}
 
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "refresh Ecs sts token err, json.Unmarshal fail: invalid character 'i' looking for beginning of value", err.Error())
 
 	// case 5: empty response json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/latest/meta-data/ram/security-credentials/" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -222,12 +275,12 @@ func TestECSRAMRoleCredentialsProvider_getCredentials(t *testing.T) {
 		return
 	}
 
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "refresh Ecs sts token err, fail to get credentials", err.Error())
 
 	// case 6: empty session ak response json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/latest/meta-data/ram/security-credentials/" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -242,12 +295,12 @@ func TestECSRAMRoleCredentialsProvider_getCredentials(t *testing.T) {
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "refresh Ecs sts token err, fail to get credentials", err.Error())
 
 	// case 7: non-success response
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/latest/meta-data/ram/security-credentials/" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -262,12 +315,12 @@ func TestECSRAMRoleCredentialsProvider_getCredentials(t *testing.T) {
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "refresh Ecs sts token err, Code is not Success", err.Error())
 
 	// case 8: mock ok value
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/latest/meta-data/ram/security-credentials/" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -282,7 +335,7 @@ func TestECSRAMRoleCredentialsProvider_getCredentials(t *testing.T) {
 		}
 		return
 	}
-	creds, err := p.getCredentials()
+	creds, err := p.getCredentials(context.TODO())
 	assert.Nil(t, err)
 	assert.Equal(t, "saki", creds.AccessKeyId)
 	assert.Equal(t, "saks", creds.AccessKeySecret)
@@ -306,17 +359,17 @@ func TestECSRAMRoleCredentialsProvider_getCredentialsWithMetadataV2(t *testing.T
 	assert.Nil(t, err)
 
 	// case 1: get metadata token failed
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
 
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get metadata token failed: mock server error", err.Error())
 
 	// case 2: return token
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/latest/api/token" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -332,7 +385,7 @@ func TestECSRAMRoleCredentialsProvider_getCredentialsWithMetadataV2(t *testing.T
 		return
 	}
 
-	creds, err := p.getCredentials()
+	creds, err := p.getCredentials(context.TODO())
 	assert.Nil(t, err)
 	assert.Equal(t, "saki", creds.AccessKeyId)
 	assert.Equal(t, "saks", creds.AccessKeySecret)
@@ -355,7 +408,7 @@ func TestECSRAMRoleCredentialsProviderGetCredentials(t *testing.T) {
 	p, err := NewECSRAMRoleCredentialsProviderBuilder().WithRoleName("rolename").Build()
 	assert.Nil(t, err)
 	// case 1: get credentials failed
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
@@ -364,7 +417,7 @@ func TestECSRAMRoleCredentialsProviderGetCredentials(t *testing.T) {
 	assert.Equal(t, "refresh Ecs sts token err: mock server error", err.Error())
 
 	// case 2: get invalid expiration
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"invalidexpiration","SecurityToken":"token","Code":"Success"}`),
@@ -376,7 +429,7 @@ func TestECSRAMRoleCredentialsProviderGetCredentials(t *testing.T) {
 	assert.Equal(t, "parsing time \"invalidexpiration\" as \"2006-01-02T15:04:05Z\": cannot parse \"invalidexpiration\" as \"2006\"", err.Error())
 
 	// case 3: happy result
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"AccessKeyId":"akid","AccessKeySecret":"aksecret","Expiration":"2021-10-20T04:27:09Z","SecurityToken":"token","Code":"Success"}`),
@@ -404,51 +457,51 @@ func TestECSRAMRoleCredentialsProvider_getMetadataToken(t *testing.T) {
 	assert.Nil(t, err)
 
 	// case 1: server error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
 
-	_, err = p.getMetadataToken()
+	_, err = p.getMetadataToken(context.TODO())
 	assert.Nil(t, err)
 
 	p, err = NewECSRAMRoleCredentialsProviderBuilder().WithDisableIMDSv1(false).Build()
 	assert.Nil(t, err)
 
-	_, err = p.getMetadataToken()
+	_, err = p.getMetadataToken(context.TODO())
 	assert.Nil(t, err)
 
 	os.Setenv("ALIBABA_CLOUD_IMDSV1_DISABLED", "true")
 	p, err = NewECSRAMRoleCredentialsProviderBuilder().Build()
 	assert.Nil(t, err)
 
-	_, err = p.getMetadataToken()
+	_, err = p.getMetadataToken(context.TODO())
 	assert.NotNil(t, err)
 
 	os.Setenv("ALIBABA_CLOUD_IMDSV1_DISABLED", "")
 	p, err = NewECSRAMRoleCredentialsProviderBuilder().Build()
 	assert.Nil(t, err)
 
-	_, err = p.getMetadataToken()
+	_, err = p.getMetadataToken(context.TODO())
 	assert.Nil(t, err)
 
 	p, err = NewECSRAMRoleCredentialsProviderBuilder().WithDisableIMDSv1(true).Build()
 	assert.Nil(t, err)
 
-	_, err = p.getMetadataToken()
+	_, err = p.getMetadataToken(context.TODO())
 	assert.NotNil(t, err)
 
 	assert.Equal(t, "get metadata token failed: mock server error", err.Error())
 
 	// case 2: return token
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte("tokenxxxxx"),
 		}
 		return
 	}
-	metadataToken, err := p.getMetadataToken()
+	metadataToken, err := p.getMetadataToken(context.TODO())
 	assert.Nil(t, err)
 	assert.Equal(t, "tokenxxxxx", metadataToken)
 
@@ -456,21 +509,21 @@ func TestECSRAMRoleCredentialsProvider_getMetadataToken(t *testing.T) {
 	p, err = NewECSRAMRoleCredentialsProviderBuilder().WithDisableIMDSv1(false).Build()
 	assert.Nil(t, err)
 
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 404,
 			Body:       []byte("not found"),
 		}
 		return
 	}
-	metadataToken, err = p.getMetadataToken()
+	metadataToken, err = p.getMetadataToken(context.TODO())
 	assert.Nil(t, err)
 	assert.Equal(t, "", metadataToken)
 
 	p, err = NewECSRAMRoleCredentialsProviderBuilder().WithDisableIMDSv1(true).Build()
 	assert.Nil(t, err)
 
-	metadataToken, err = p.getMetadataToken()
+	metadataToken, err = p.getMetadataToken(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "", metadataToken)
 }
@@ -486,11 +539,11 @@ func TestNewECSRAMRoleCredentialsProviderWithHttpOptions(t *testing.T) {
 		Build()
 	assert.Nil(t, err)
 
-	_, err = p.getRoleName()
+	_, err = p.getRoleName(context.TODO())
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "proxyconnect tcp:")
 
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "proxyconnect tcp:")
 
@@ -498,3 +551,34 @@ func TestNewECSRAMRoleCredentialsProviderWithHttpOptions(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "proxyconnect tcp:")
 }
+
+func TestECSRAMRoleCredentialsProviderGetCredentialsConcurrently(t *testing.T) {
+	var count int32
+	_, rollback := mockLocalEndpoint(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/api/token":
+			w.Write([]byte("token"))
+		case "/latest/meta-data/ram/security-credentials/":
+			w.Write([]byte("rolename"))
+		case "/latest/meta-data/ram/security-credentials/rolename":
+			atomic.AddInt32(&count, 1)
+			time.Sleep(50 * time.Millisecond)
+			expiration := time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+			w.Write([]byte(`{"Code":"Success","AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"` + expiration + `","SecurityToken":"token","LastUpdated":"2021-10-20T03:27:09Z"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer rollback()
+
+	p, err := NewECSRAMRoleCredentialsProviderBuilder().Build()
+	assert.Nil(t, err)
+
+	hammer(t, p, func(cc *Credentials) {
+		assert.Equal(t, "saki", cc.AccessKeyId)
+		assert.Equal(t, "saks", cc.AccessKeySecret)
+		assert.Equal(t, "token", cc.SecurityToken)
+		assert.Equal(t, "ecs_ram_role", cc.ProviderName)
+	})
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
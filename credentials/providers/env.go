@@ -1,7 +1,7 @@
 package providers
 
 import (
-	"fmt"
+	"context"
 	"os"
 )
 
@@ -24,17 +24,21 @@ func (builder *EnvironmentVariableCredentialsProviderBuilder) Build() (provider
 }
 
 func (provider *EnvironmentVariableCredentialsProvider) GetCredentials() (cc *Credentials, err error) {
+	return provider.GetCredentialsWithContext(context.Background())
+}
+
+func (provider *EnvironmentVariableCredentialsProvider) GetCredentialsWithContext(ctx context.Context) (cc *Credentials, err error) {
 	accessKeyId := os.Getenv("ALIBABA_CLOUD_ACCESS_KEY_ID")
 
 	if accessKeyId == "" {
-		err = fmt.Errorf("unable to get credentials from enviroment variables, Access key ID must be specified via environment variable (ALIBABA_CLOUD_ACCESS_KEY_ID)")
+		err = newNotConfiguredError("unable to get credentials from enviroment variables, Access key ID must be specified via environment variable (ALIBABA_CLOUD_ACCESS_KEY_ID)")
 		return
 	}
 
 	accessKeySecret := os.Getenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET")
 
 	if accessKeySecret == "" {
-		err = fmt.Errorf("unable to get credentials from enviroment variables, Access key secret must be specified via environment variable (ALIBABA_CLOUD_ACCESS_KEY_SECRET)")
+		err = newNotConfiguredError("unable to get credentials from enviroment variables, Access key secret must be specified via environment variable (ALIBABA_CLOUD_ACCESS_KEY_SECRET)")
 		return
 	}
 
@@ -0,0 +1,124 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
+	"github.com/aliyun/credentials-go/credentials/internal/utils"
+)
+
+var (
+	// ErrProviderNotConfigured is matched by the errors caused by the missing configuration of a provider,
+	// such as an empty access key, role arn or url, a missing profile or a disabled provider.
+	ErrProviderNotConfigured = errors.New("credentials provider is not configured")
+	// ErrIMDSDisabled is matched by the errors of ECSRAMRoleCredentialsProvider when the ECS metadata service is disabled.
+	ErrIMDSDisabled = errors.New("IMDS is disabled")
+	// ErrThrottled is matched by the ServiceError caused by the flow control of the service.
+	ErrThrottled = errors.New("request is throttled")
+)
+
+// ServiceError is returned when STS, IMDS, SSO, OAuth or the credentials URI responds with an unexpected status.
+// Use errors.As to get it from the error returned by GetCredentials().
+type ServiceError struct {
+	// The HTTP status code
+	StatusCode int
+	// The error code in the response, such as EntityNotExist.Role or Throttling.User
+	Code string
+	// The error message in the response
+	Message string
+	// The request id in the response
+	RequestId string
+	// The host of the service
+	Host string
+	// The raw response body
+	Body string
+	// the error text, it keeps the format of the previous versions
+	errorMessage string
+}
+
+func newServiceError(req *httputil.Request, res *httputil.Response, errorMessage string) *ServiceError {
+	host := req.Host
+	if req.URL != "" {
+		if u, err := url.Parse(req.URL); err == nil {
+			host = u.Host
+		}
+	}
+
+	code, message, requestId := utils.ParseErrorBody(res.Body)
+	return &ServiceError{
+		StatusCode:   res.StatusCode,
+		Code:         code,
+		Message:      message,
+		RequestId:    requestId,
+		Host:         host,
+		Body:         string(res.Body),
+		errorMessage: errorMessage,
+	}
+}
+
+func (e *ServiceError) Error() string {
+	if e.errorMessage != "" {
+		return e.errorMessage
+	}
+	return fmt.Sprintf("httpStatus: %d, message = %s", e.StatusCode, e.Body)
+}
+
+// Is reports whether the error is caused by flow control when target is ErrThrottled
+func (e *ServiceError) Is(target error) bool {
+	return target == ErrThrottled && (e.StatusCode == 429 || strings.HasPrefix(e.Code, "Throttling"))
+}
+
+// sentinelError keeps the error text and matches a sentinel error by errors.Is
+type sentinelError struct {
+	message  string
+	sentinel error
+}
+
+func (e *sentinelError) Error() string {
+	return e.message
+}
+
+func (e *sentinelError) Unwrap() error {
+	return e.sentinel
+}
+
+func newNotConfiguredError(message string) error {
+	return &sentinelError{
+		message:  message,
+		sentinel: ErrProviderNotConfigured,
+	}
+}
+
+// chainError is returned when none of the providers in a chain can provide the credentials,
+// it matches a sentinel error by errors.Is only if the errors of all the providers match it.
+type chainError struct {
+	message string
+	errs    []error
+}
+
+func newChainError(messages []string, errs []error) *chainError {
+	return &chainError{
+		message: fmt.Sprintf("unable to get credentials from any of the providers in the chain: %s", strings.Join(messages, ", ")),
+		errs:    errs,
+	}
+}
+
+func (e *chainError) Error() string {
+	return e.message
+}
+
+func (e *chainError) Is(target error) bool {
+	if len(e.errs) == 0 {
+		return target == ErrProviderNotConfigured
+	}
+
+	for _, err := range e.errs {
+		if !errors.Is(err, target) {
+			return false
+		}
+	}
+	return true
+}
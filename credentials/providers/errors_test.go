@@ -0,0 +1,83 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceError(t *testing.T) {
+	req := &httputil.Request{
+		Protocol: "https",
+		Host:     "sts.aliyuncs.com",
+	}
+	res := &httputil.Response{
+		StatusCode: 404,
+		Body:       []byte(`{"Code":"EntityNotExist.Role","Message":"The role not exists","RequestId":"req-1"}`),
+	}
+	err := newServiceError(req, res, "refresh session token failed: not found")
+	assert.Equal(t, 404, err.StatusCode)
+	assert.Equal(t, "EntityNotExist.Role", err.Code)
+	assert.Equal(t, "The role not exists", err.Message)
+	assert.Equal(t, "req-1", err.RequestId)
+	assert.Equal(t, "sts.aliyuncs.com", err.Host)
+	assert.Equal(t, "refresh session token failed: not found", err.Error())
+	assert.False(t, errors.Is(err, ErrThrottled))
+
+	// the host is parsed from the url
+	req = &httputil.Request{
+		URL: "http://127.0.0.1:8080/credentials",
+	}
+	res = &httputil.Response{
+		StatusCode: 500,
+		Body:       []byte("internal error"),
+	}
+	err = newServiceError(req, res, "")
+	assert.Equal(t, "127.0.0.1:8080", err.Host)
+	assert.Equal(t, "", err.Code)
+	assert.Equal(t, "httpStatus: 500, message = internal error", err.Error())
+
+	// throttled
+	err = newServiceError(req, &httputil.Response{StatusCode: 429}, "")
+	assert.True(t, errors.Is(err, ErrThrottled))
+	err = newServiceError(req, &httputil.Response{StatusCode: 400, Body: []byte(`{"Code":"Throttling.User"}`)}, "")
+	assert.True(t, errors.Is(err, ErrThrottled))
+
+	var serviceErr *ServiceError
+	assert.True(t, errors.As(fmt.Errorf("wrapped: %w", err), &serviceErr))
+	assert.Equal(t, "Throttling.User", serviceErr.Code)
+}
+
+func TestNotConfiguredError(t *testing.T) {
+	err := newNotConfiguredError("the url is empty")
+	assert.Equal(t, "the url is empty", err.Error())
+	assert.True(t, errors.Is(err, ErrProviderNotConfigured))
+	assert.False(t, errors.Is(err, ErrIMDSDisabled))
+
+	_, err = NewStaticAKCredentialsProviderBuilder().Build()
+	assert.True(t, errors.Is(err, ErrProviderNotConfigured))
+}
+
+func TestChainError(t *testing.T) {
+	err := &chainError{message: "no providers"}
+	assert.True(t, errors.Is(err, ErrProviderNotConfigured))
+
+	err = &chainError{
+		message: "unable to get credentials from any of the providers in the chain",
+		errs: []error{
+			newNotConfiguredError("the access key id is empty"),
+			&sentinelError{message: "IMDS credentials is disabled", sentinel: ErrIMDSDisabled},
+		},
+	}
+	assert.False(t, errors.Is(err, ErrProviderNotConfigured))
+
+	err.errs = []error{
+		newNotConfiguredError("the access key id is empty"),
+		newNotConfiguredError("the url is empty"),
+	}
+	assert.True(t, errors.Is(err, ErrProviderNotConfigured))
+	assert.Equal(t, "unable to get credentials from any of the providers in the chain", err.Error())
+}
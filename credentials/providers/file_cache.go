@@ -0,0 +1,177 @@
+package providers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// stsFileCache caches the sts credentials on disk, so that they can be shared by processes
+type stsFileCache struct {
+	dir string
+}
+
+type stsFileCacheEntry struct {
+	AccessKeyId     string `json:"access_key_id"`
+	AccessKeySecret string `json:"access_key_secret"`
+	SecurityToken   string `json:"security_token"`
+	Expiration      string `json:"expiration"`
+}
+
+// newSTSFileCache creates the cache in dir, the default dir is ~/.aliyun/cache
+func newSTSFileCache(dir string) (cache *stsFileCache, err error) {
+	if dir == "" {
+		homeDir := getHomePath()
+		if homeDir == "" {
+			err = fmt.Errorf("cannot found home dir")
+			return
+		}
+		dir = path.Join(homeDir, ".aliyun/cache")
+	}
+
+	cache = &stsFileCache{
+		dir: dir,
+	}
+	return
+}
+
+// stsFileCacheKey hashes the parts which identify a session, the policy should be hashed by the caller
+func stsFileCacheKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// generatedSessionName replaces the generated role session name in the cache key,
+// so that the processes which use the default role session name share the cache
+const generatedSessionName = "<generated>"
+
+// cacheKeySessionName returns the role session name in the cache key
+func cacheKeySessionName(roleSessionName string, generated bool) string {
+	if generated {
+		return generatedSessionName
+	}
+	return roleSessionName
+}
+
+func hashPolicy(policy string) string {
+	if policy == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(policy))
+	return hex.EncodeToString(sum[:])
+}
+
+func (cache *stsFileCache) getPath(key string) string {
+	return path.Join(cache.dir, key+".json")
+}
+
+// get returns the cached session which is still valid after minRemainingSeconds from now, otherwise it returns nil
+func (cache *stsFileCache) get(key string, now time.Time, minRemainingSeconds int64) (session *sessionCredentials, expirationTimestamp int64) {
+	if cache == nil {
+		return
+	}
+
+	file, err := os.Open(cache.getPath(key))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	// 获取共享锁，避免读到其他进程写了一半的内容
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_SH)
+	if err != nil {
+		return
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return
+	}
+
+	var entry stsFileCacheEntry
+	err = json.Unmarshal(content, &entry)
+	if err != nil || entry.AccessKeyId == "" || entry.AccessKeySecret == "" || entry.SecurityToken == "" {
+		return
+	}
+
+	expirationTime, err := time.Parse("2006-01-02T15:04:05Z", entry.Expiration)
+	if err != nil {
+		return
+	}
+
+	if expirationTime.Unix()-now.Unix() <= minRemainingSeconds {
+		return
+	}
+
+	session = &sessionCredentials{
+		AccessKeyId:     entry.AccessKeyId,
+		AccessKeySecret: entry.AccessKeySecret,
+		SecurityToken:   entry.SecurityToken,
+		Expiration:      entry.Expiration,
+	}
+	expirationTimestamp = expirationTime.Unix()
+	return
+}
+
+// put writes the session atomically, the cache file is only readable by the owner
+func (cache *stsFileCache) put(key string, session *sessionCredentials) error {
+	if cache == nil {
+		return nil
+	}
+
+	err := os.MkdirAll(cache.dir, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create cache dir: %v", err)
+	}
+
+	content, err := json.Marshal(&stsFileCacheEntry{
+		AccessKeyId:     session.AccessKeyId,
+		AccessKeySecret: session.AccessKeySecret,
+		SecurityToken:   session.SecurityToken,
+		Expiration:      session.Expiration,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to serialize cache: %v", err)
+	}
+
+	cachePath := cache.getPath(key)
+	// 打开文件用于锁定
+	file, err := os.OpenFile(cachePath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open cache file: %v", err)
+	}
+	defer file.Close()
+
+	// 获取独占锁（阻塞其他进程）
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return fmt.Errorf("failed to acquire file lock: %v", err)
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	// 创建唯一临时文件
+	tempFile := cachePath + ".tmp-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	err = ioutil.WriteFile(tempFile, content, 0600)
+	if err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to write temp file: %v", err)
+	}
+
+	// 原子性重命名
+	err = os.Rename(tempFile, cachePath)
+	if err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to rename temp file: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package providers
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSTSFileCache(t *testing.T) {
+	originGetHomePath := getHomePath
+	defer func() {
+		getHomePath = originGetHomePath
+	}()
+
+	getHomePath = func() string {
+		return ""
+	}
+	_, err := newSTSFileCache("")
+	assert.EqualError(t, err, "cannot found home dir")
+
+	getHomePath = func() string {
+		return "/home/user"
+	}
+	cache, err := newSTSFileCache("")
+	assert.Nil(t, err)
+	assert.Equal(t, "/home/user/.aliyun/cache", cache.dir)
+
+	cache, err = newSTSFileCache("/tmp/cache")
+	assert.Nil(t, err)
+	assert.Equal(t, "/tmp/cache", cache.dir)
+}
+
+func TestSTSFileCacheKey(t *testing.T) {
+	key := stsFileCacheKey("ram_role_arn", "roleArn", "rsn", hashPolicy("policy"), "akid")
+	assert.Len(t, key, 64)
+	assert.Equal(t, key, stsFileCacheKey("ram_role_arn", "roleArn", "rsn", hashPolicy("policy"), "akid"))
+	assert.NotEqual(t, key, stsFileCacheKey("ram_role_arn", "roleArn", "rsn", hashPolicy("policy2"), "akid"))
+	assert.NotEqual(t, key, stsFileCacheKey("ram_role_arn", "roleArn", "rsn", hashPolicy("policy"), "akid2"))
+	assert.Equal(t, "", hashPolicy(""))
+	assert.Equal(t, "rsn", cacheKeySessionName("rsn", false))
+	assert.Equal(t, "<generated>", cacheKeySessionName("credentials-go-1704067200000000", true))
+}
+
+func TestSTSFileCache(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "sts_cache_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	// nil cache
+	var nilCache *stsFileCache
+	session, _ := nilCache.get("key", time.Now(), 180)
+	assert.Nil(t, session)
+	assert.Nil(t, nilCache.put("key", &sessionCredentials{}))
+
+	cache, err := newSTSFileCache(path.Join(tempDir, "cache"))
+	assert.Nil(t, err)
+
+	// not exist
+	session, _ = cache.get("key", time.Now(), 180)
+	assert.Nil(t, session)
+
+	expiration := time.Now().Add(time.Hour).UTC()
+	err = cache.put("key", &sessionCredentials{
+		AccessKeyId:     "akid",
+		AccessKeySecret: "aksecret",
+		SecurityToken:   "token",
+		Expiration:      expiration.Format("2006-01-02T15:04:05Z"),
+	})
+	assert.Nil(t, err)
+
+	stat, err := os.Stat(path.Join(tempDir, "cache"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0700), stat.Mode().Perm())
+	stat, err = os.Stat(path.Join(tempDir, "cache", "key.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+
+	session, expirationTimestamp := cache.get("key", time.Now(), 180)
+	assert.Equal(t, &sessionCredentials{
+		AccessKeyId:     "akid",
+		AccessKeySecret: "aksecret",
+		SecurityToken:   "token",
+		Expiration:      expiration.Format("2006-01-02T15:04:05Z"),
+	}, session)
+	assert.Equal(t, expiration.Unix(), expirationTimestamp)
+
+	// about to expire
+	session, _ = cache.get("key", time.Now(), 3600)
+	assert.Nil(t, session)
+	session, _ = cache.get("key", expiration.Add(-181*time.Second), 180)
+	assert.NotNil(t, session)
+	session, _ = cache.get("key", expiration.Add(-180*time.Second), 180)
+	assert.Nil(t, session)
+
+	// invalid content
+	err = ioutil.WriteFile(path.Join(tempDir, "cache", "invalid.json"), []byte("invalid"), 0600)
+	assert.Nil(t, err)
+	session, _ = cache.get("invalid", time.Now(), 180)
+	assert.Nil(t, session)
+
+	// invalid expiration
+	err = ioutil.WriteFile(path.Join(tempDir, "cache", "invalid.json"), []byte(`{"access_key_id":"akid","access_key_secret":"aksecret","security_token":"token","expiration":"invalid"}`), 0600)
+	assert.Nil(t, err)
+	session, _ = cache.get("invalid", time.Now(), 180)
+	assert.Nil(t, session)
+
+	// cache dir can not be created
+	err = ioutil.WriteFile(path.Join(tempDir, "file"), []byte(""), 0600)
+	assert.Nil(t, err)
+	cache, err = newSTSFileCache(path.Join(tempDir, "file"))
+	assert.Nil(t, err)
+	err = cache.put("key", &sessionCredentials{})
+	assert.Contains(t, err.Error(), "failed to create cache dir: ")
+}
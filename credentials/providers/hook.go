@@ -4,4 +4,4 @@ import (
 	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
 )
 
-var httpDo = httputil.Do
+var httpDo = httputil.DoWithContext
@@ -0,0 +1,176 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
+)
+
+// Instrumentation receives the events of the credential refreshes and the http requests sent by the providers,
+// it can be used to create the spans and metrics of OpenTelemetry or other systems.
+// The events never contain the credentials, the signatures or the query strings of the requests.
+// The implementations must be safe for concurrent use.
+type Instrumentation interface {
+	// StartRefresh is called before a provider refreshes the credentials, the returned context is used by the refresh,
+	// so that the http requests of the refresh can be traced as its children. end is called after the refresh.
+	StartRefresh(ctx context.Context, providerName string) (newCtx context.Context, end func(event *RefreshEvent))
+	// StartHTTPRequest is called before every attempt of an http request, end is called after the attempt.
+	StartHTTPRequest(ctx context.Context, providerName string, method string, host string) (newCtx context.Context, end func(event *HTTPRequestEvent))
+}
+
+// RefreshEvent is the result of a credential refresh
+type RefreshEvent struct {
+	// The name of the provider, such as ram_role_arn or ecs_ram_role
+	ProviderName string
+	// The time spent on the refresh
+	Duration time.Duration
+	// The status of the refresh, success or error
+	Status string
+	// The error code if the refresh failed, such as Throttling.User, timeout, canceled or network
+	ErrorCode string
+	// The time to the expiration of the new credentials, it is zero if the refresh failed
+	TimeToExpiry time.Duration
+}
+
+// HTTPRequestEvent is the result of an attempt of an http request
+type HTTPRequestEvent struct {
+	// The name of the provider which sends the request
+	ProviderName string
+	// The http method
+	Method string
+	// The host of the endpoint
+	Host string
+	// The http status code, it is zero if no response is received
+	StatusCode int
+	// The status of the attempt, success or error
+	Status string
+	// The error code if the attempt failed, such as Throttling.User, timeout, canceled or network
+	ErrorCode string
+	// The retry count of the request, it is 0 for the first attempt
+	RetryCount int
+	// The time spent on the attempt
+	Duration time.Duration
+}
+
+const (
+	instrumentationStatusSuccess = "success"
+	instrumentationStatusError   = "error"
+)
+
+type providerNameContextKey struct{}
+
+type clockContextKey struct{}
+
+func getInstrumentation(httpOptions *HttpOptions) Instrumentation {
+	if httpOptions == nil {
+		return nil
+	}
+	return httpOptions.Instrumentation
+}
+
+// startRefresh notifies the instrumentation, the returned function must be called with the result of the refresh
+func startRefresh(ctx context.Context, instrumentation Instrumentation, clock Clock, providerName string) (context.Context, func(expirationTimestamp int64, err error)) {
+	// 记录 provider 名称和时钟，用于 http 请求的事件
+	ctx = context.WithValue(ctx, providerNameContextKey{}, providerName)
+	ctx = context.WithValue(ctx, clockContextKey{}, clock)
+	if instrumentation == nil {
+		return ctx, func(expirationTimestamp int64, err error) {}
+	}
+
+	start := nowOf(clock)
+	ctx, end := instrumentation.StartRefresh(ctx, providerName)
+	return ctx, func(expirationTimestamp int64, err error) {
+		event := &RefreshEvent{
+			ProviderName: providerName,
+			Duration:     nowOf(clock).Sub(start),
+			Status:       instrumentationStatusSuccess,
+		}
+		if err != nil {
+			event.Status = instrumentationStatusError
+			event.ErrorCode = ErrorCode(err)
+		} else if expirationTimestamp > 0 {
+			event.TimeToExpiry = time.Unix(expirationTimestamp, 0).Sub(nowOf(clock))
+		}
+		end(event)
+	}
+}
+
+// startHTTPRequest notifies the instrumentation of httpOptions, the returned function must be called with the result of the attempt
+func startHTTPRequest(ctx context.Context, httpOptions *HttpOptions, req *httputil.Request, retryCount int) (context.Context, func(res *httputil.Response, err error)) {
+	instrumentation := getInstrumentation(httpOptions)
+	if instrumentation == nil {
+		return ctx, func(res *httputil.Response, err error) {}
+	}
+
+	providerName, _ := ctx.Value(providerNameContextKey{}).(string)
+	clock, _ := ctx.Value(clockContextKey{}).(Clock)
+	host := req.Host
+	if req.URL != "" {
+		if u, err := url.Parse(req.URL); err == nil {
+			host = u.Host
+		}
+	}
+
+	start := nowOf(clock)
+	ctx, end := instrumentation.StartHTTPRequest(ctx, providerName, req.Method, host)
+	return ctx, func(res *httputil.Response, err error) {
+		event := &HTTPRequestEvent{
+			ProviderName: providerName,
+			Method:       req.Method,
+			Host:         host,
+			Status:       instrumentationStatusSuccess,
+			RetryCount:   retryCount,
+			Duration:     nowOf(clock).Sub(start),
+		}
+		if err != nil {
+			event.Status = instrumentationStatusError
+			event.ErrorCode = ErrorCode(err)
+		} else {
+			event.StatusCode = res.StatusCode
+			if res.StatusCode >= 400 {
+				event.Status = instrumentationStatusError
+				event.ErrorCode = ErrorCode(newServiceError(req, res, ""))
+			}
+		}
+		end(event)
+	}
+}
+
+// ErrorCode classifies the error as the ErrorCode of the events, the message of the error is never used,
+// it may contain the signed url of the request
+func ErrorCode(err error) string {
+	var serviceErr *ServiceError
+	if errors.As(err, &serviceErr) {
+		if serviceErr.Code != "" {
+			return serviceErr.Code
+		}
+		return fmt.Sprintf("http_%d", serviceErr.StatusCode)
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+
+	if errors.Is(err, ErrProviderNotConfigured) {
+		return "not_configured"
+	}
+
+	return "error"
+}
@@ -0,0 +1,167 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/internal/clocktest"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingInstrumentation struct {
+	mutex         sync.Mutex
+	refreshes     []*RefreshEvent
+	httpRequests  []*HTTPRequestEvent
+	parentOfHTTPs []string
+}
+
+type spanContextKey struct{}
+
+func (i *recordingInstrumentation) StartRefresh(ctx context.Context, providerName string) (context.Context, func(event *RefreshEvent)) {
+	ctx = context.WithValue(ctx, spanContextKey{}, "refresh:"+providerName)
+	return ctx, func(event *RefreshEvent) {
+		i.mutex.Lock()
+		defer i.mutex.Unlock()
+		i.refreshes = append(i.refreshes, event)
+	}
+}
+
+func (i *recordingInstrumentation) StartHTTPRequest(ctx context.Context, providerName string, method string, host string) (context.Context, func(event *HTTPRequestEvent)) {
+	parent, _ := ctx.Value(spanContextKey{}).(string)
+	return ctx, func(event *HTTPRequestEvent) {
+		i.mutex.Lock()
+		defer i.mutex.Unlock()
+		i.httpRequests = append(i.httpRequests, event)
+		i.parentOfHTTPs = append(i.parentOfHTTPs, parent)
+	}
+}
+
+func TestInstrumentation(t *testing.T) {
+	var count int32
+	_, rollback := mockLocalEndpoint(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"Code":"Throttling.User","Message":"Request was denied due to user flow control."}`))
+			return
+		}
+		expiration := time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		w.Write([]byte(`{"Credentials": {"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"` + expiration + `","SecurityToken":"token"}}`))
+	})
+	defer rollback()
+
+	instrumentation := &recordingInstrumentation{}
+	p, err := NewRAMRoleARNCredentialsProviderBuilder().
+		WithAccessKeyId("akid").
+		WithAccessKeySecret("aksecret").
+		WithRoleArn("roleArn").
+		WithRoleSessionName("rsn").
+		WithHttpOptions(&HttpOptions{
+			Retry:           &RetryOptions{BaseBackoff: time.Millisecond},
+			Instrumentation: instrumentation,
+		}).
+		Build()
+	assert.Nil(t, err)
+
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+
+	assert.Len(t, instrumentation.refreshes, 1)
+	refresh := instrumentation.refreshes[0]
+	assert.Equal(t, "ram_role_arn", refresh.ProviderName)
+	assert.Equal(t, "success", refresh.Status)
+	assert.Equal(t, "", refresh.ErrorCode)
+	assert.True(t, refresh.TimeToExpiry > 59*time.Minute && refresh.TimeToExpiry <= time.Hour)
+
+	assert.Len(t, instrumentation.httpRequests, 2)
+	first := instrumentation.httpRequests[0]
+	assert.Equal(t, "ram_role_arn", first.ProviderName)
+	assert.Equal(t, "POST", first.Method)
+	assert.Equal(t, "sts.aliyuncs.com", first.Host)
+	assert.Equal(t, 400, first.StatusCode)
+	assert.Equal(t, "error", first.Status)
+	assert.Equal(t, "Throttling.User", first.ErrorCode)
+	assert.Equal(t, 0, first.RetryCount)
+	second := instrumentation.httpRequests[1]
+	assert.Equal(t, 200, second.StatusCode)
+	assert.Equal(t, "success", second.Status)
+	assert.Equal(t, 1, second.RetryCount)
+	// the http requests are the children of the refresh
+	assert.Equal(t, []string{"refresh:ram_role_arn", "refresh:ram_role_arn"}, instrumentation.parentOfHTTPs)
+
+	// the events never contain the credentials
+	for _, event := range []interface{}{refresh, first, second} {
+		text := fmt.Sprintf("%+v", event)
+		for _, secret := range []string{"akid", "aksecret", "saki", "saks", "token", "Signature"} {
+			assert.False(t, strings.Contains(text, secret), text)
+		}
+	}
+
+	// failed refresh
+	atomic.StoreInt32(&count, 0)
+	instrumentation = &recordingInstrumentation{}
+	p, err = NewRAMRoleARNCredentialsProviderBuilder().
+		WithAccessKeyId("akid").
+		WithAccessKeySecret("aksecret").
+		WithRoleArn("roleArn").
+		WithRoleSessionName("rsn").
+		WithHttpOptions(&HttpOptions{
+			Instrumentation: instrumentation,
+		}).
+		Build()
+	assert.Nil(t, err)
+	_, err = p.GetCredentials()
+	assert.NotNil(t, err)
+	assert.Len(t, instrumentation.refreshes, 1)
+	assert.Equal(t, "error", instrumentation.refreshes[0].Status)
+	assert.Equal(t, "Throttling.User", instrumentation.refreshes[0].ErrorCode)
+	assert.Equal(t, time.Duration(0), instrumentation.refreshes[0].TimeToExpiry)
+	assert.Len(t, instrumentation.httpRequests, 1)
+}
+
+func TestInstrumentationWithClock(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	_, rollback := mockLocalEndpoint(func(w http.ResponseWriter, r *http.Request) {
+		expiration := clock.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		w.Write([]byte(`{"Credentials": {"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"` + expiration + `","SecurityToken":"token"}}`))
+	})
+	defer rollback()
+
+	instrumentation := &recordingInstrumentation{}
+	p, err := NewRAMRoleARNCredentialsProviderBuilder().
+		WithAccessKeyId("akid").
+		WithAccessKeySecret("aksecret").
+		WithRoleArn("roleArn").
+		WithRoleSessionName("rsn").
+		WithClock(clock).
+		WithHttpOptions(&HttpOptions{
+			Instrumentation: instrumentation,
+		}).
+		Build()
+	assert.Nil(t, err)
+
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	// 时钟不动，耗时为 0，过期时间按时钟计算
+	assert.Len(t, instrumentation.refreshes, 1)
+	assert.Equal(t, time.Duration(0), instrumentation.refreshes[0].Duration)
+	assert.Equal(t, time.Hour, instrumentation.refreshes[0].TimeToExpiry)
+	assert.Len(t, instrumentation.httpRequests, 1)
+	assert.Equal(t, time.Duration(0), instrumentation.httpRequests[0].Duration)
+}
+
+func TestErrorCode(t *testing.T) {
+	assert.Equal(t, "EntityNotExist.Role", ErrorCode(&ServiceError{StatusCode: 404, Code: "EntityNotExist.Role"}))
+	assert.Equal(t, "http_500", ErrorCode(fmt.Errorf("refresh failed: %w", &ServiceError{StatusCode: 500})))
+	assert.Equal(t, "canceled", ErrorCode(context.Canceled))
+	assert.Equal(t, "timeout", ErrorCode(context.DeadlineExceeded))
+	assert.Equal(t, "timeout", ErrorCode(&timeoutError{}))
+	assert.Equal(t, "not_configured", ErrorCode(newNotConfiguredError("the url is empty")))
+	assert.Equal(t, "error", ErrorCode(errors.New("https://sts.aliyuncs.com/?SecurityToken=token")))
+}
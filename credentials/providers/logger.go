@@ -0,0 +1,25 @@
+package providers
+
+import (
+	"github.com/aliyun/credentials-go/credentials/internal/utils"
+)
+
+// Logger is the structured logger of the providers, it is compatible with *slog.Logger of log/slog,
+// so that a *slog.Logger can be used directly. The values of the sensitive keys, such as AccessKeySecret,
+// SecurityToken, OIDC tokens and Authorization headers, are redacted before they are passed to the logger.
+type Logger = utils.Logger
+
+// SetLogger sets the global logger which is used by the providers without the logger in HttpOptions.
+// The default logger writes the warnings and errors to stderr, the debug logs are written when
+// the environment variable DEBUG contains credential. The default logger is restored if logger is nil.
+func SetLogger(logger Logger) {
+	utils.SetLogger(logger)
+}
+
+// getLogger returns the logger in httpOptions or the global logger
+func getLogger(httpOptions *HttpOptions) Logger {
+	if httpOptions == nil {
+		return utils.GetLogger(nil)
+	}
+	return utils.GetLogger(httpOptions.Logger)
+}
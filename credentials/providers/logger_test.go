@@ -0,0 +1,52 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testLogger struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) record(level string, msg string, args []interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf("%s %s %v", level, msg, args))
+}
+
+func (l *testLogger) DebugContext(ctx context.Context, msg string, args ...interface{}) {
+	l.record("DEBUG", msg, args)
+}
+
+func (l *testLogger) InfoContext(ctx context.Context, msg string, args ...interface{}) {
+	l.record("INFO", msg, args)
+}
+
+func (l *testLogger) WarnContext(ctx context.Context, msg string, args ...interface{}) {
+	l.record("WARN", msg, args)
+}
+
+func (l *testLogger) ErrorContext(ctx context.Context, msg string, args ...interface{}) {
+	l.record("ERROR", msg, args)
+}
+
+func TestLogger(t *testing.T) {
+	defer SetLogger(nil)
+
+	global := &testLogger{}
+	SetLogger(global)
+	getLogger(nil).WarnContext(context.Background(), "warn", "SecurityToken", "token")
+	getLogger(&HttpOptions{}).InfoContext(context.Background(), "info")
+	assert.Equal(t, []string{"WARN warn [SecurityToken ******]", "INFO info []"}, global.lines)
+
+	custom := &testLogger{}
+	getLogger(&HttpOptions{Logger: custom}).DebugContext(context.Background(), "debug", "AccessKeySecret", "aksecret")
+	assert.Equal(t, []string{"DEBUG debug [AccessKeySecret ******]"}, custom.lines)
+	assert.Len(t, global.lines, 2)
+}
@@ -1,11 +1,12 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
@@ -42,8 +43,17 @@ type OAuthCredentialsProvider struct {
 	sessionCredentials  *sessionCredentials
 	// for http options
 	httpOptions *HttpOptions
+	// for expiry and refresh decisions
+	clock Clock
 	// OAuth token call back
 	tokenUpdateCallback OAuthTokenUpdateCallback
+	// guards the inner session fields
+	mutex        sync.RWMutex
+	refreshGroup refreshGroup
+	// for async refresh
+	asyncRefreshOptions *AsyncRefreshOptions
+	asyncRefresher      *asyncRefresher
+	refreshListener     refreshListener
 }
 
 type OAuthCredentialsProviderBuilder struct {
@@ -81,6 +91,18 @@ func (b *OAuthCredentialsProviderBuilder) WithAccessTokenExpire(accessTokenExpir
 	return b
 }
 
+// WithOnRefresh sets the callback which is called after the credentials are refreshed
+func (b *OAuthCredentialsProviderBuilder) WithOnRefresh(onRefresh OnRefreshFunc) *OAuthCredentialsProviderBuilder {
+	b.provider.refreshListener.onRefresh = onRefresh
+	return b
+}
+
+// WithOnRefreshError sets the callback which is called after the credentials failed to refresh
+func (b *OAuthCredentialsProviderBuilder) WithOnRefreshError(onRefreshError OnRefreshErrorFunc) *OAuthCredentialsProviderBuilder {
+	b.provider.refreshListener.onRefreshError = onRefreshError
+	return b
+}
+
 func (b *OAuthCredentialsProviderBuilder) WithHttpOptions(httpOptions *HttpOptions) *OAuthCredentialsProviderBuilder {
 	b.provider.httpOptions = httpOptions
 	return b
@@ -91,30 +113,49 @@ func (b *OAuthCredentialsProviderBuilder) WithTokenUpdateCallback(callback OAuth
 	return b
 }
 
+// WithAsyncRefresh enables refreshing the credentials in background, call Close() to stop it
+func (b *OAuthCredentialsProviderBuilder) WithAsyncRefresh(options *AsyncRefreshOptions) *OAuthCredentialsProviderBuilder {
+	b.provider.asyncRefreshOptions = options
+	return b
+}
+
+// WithClock sets the clock to decide whether the credentials are expired or due for a refresh, default is the system clock
+func (b *OAuthCredentialsProviderBuilder) WithClock(clock Clock) *OAuthCredentialsProviderBuilder {
+	b.provider.clock = clock
+	return b
+}
+
 func (b *OAuthCredentialsProviderBuilder) Build() (provider *OAuthCredentialsProvider, err error) {
 	if b.provider.clientId == "" {
-		err = errors.New("the ClientId is empty")
+		err = newNotConfiguredError("the ClientId is empty")
 		return
 	}
 
 	if b.provider.signInUrl == "" {
-		err = errors.New("the url for sign-in is empty")
+		err = newNotConfiguredError("the url for sign-in is empty")
 		return
 	}
 
 	if b.provider.refreshToken == "" {
-		err = errors.New("OAuth access token is empty or expired, please re-login with cli")
+		err = newNotConfiguredError("OAuth access token is empty or expired, please re-login with cli")
 		return
 	}
 
+	if b.provider.asyncRefreshOptions != nil {
+		p := b.provider
+		p.asyncRefresher = newAsyncRefresher(p.asyncRefreshOptions, p.clock, func(ctx context.Context) error {
+			return p.refreshGroup.do(ctx, p.updateCredential)
+		}, p.getExpirationTimestamp)
+	}
+
 	provider = b.provider
 	return
 }
 
-func (provider *OAuthCredentialsProvider) getCredentials() (session *sessionCredentials, err error) {
+func (provider *OAuthCredentialsProvider) getCredentials(ctx context.Context) (session *sessionCredentials, err error) {
 
-	if provider.accessToken == "" || provider.accessTokenExpire == 0 || provider.accessTokenExpire-time.Now().Unix() <= 180 {
-		err = provider.tryRefreshOauthToken()
+	if provider.accessToken == "" || provider.accessTokenExpire == 0 || provider.accessTokenExpire-nowOf(provider.clock).Unix() <= 180 {
+		err = provider.tryRefreshOauthToken(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -145,20 +186,25 @@ func (provider *OAuthCredentialsProvider) getCredentials() (session *sessionCred
 	if provider.httpOptions != nil && provider.httpOptions.Proxy != "" {
 		req.Proxy = provider.httpOptions.Proxy
 	}
+	if provider.httpOptions != nil {
+		req.Client = provider.httpOptions.Client
+		req.Transport = provider.httpOptions.Transport
+		req.Logger = provider.httpOptions.Logger
+	}
 	req.ConnectTimeout = connectTimeout
 	req.ReadTimeout = readTimeout
 
 	// set headers
 	req.Headers["Content-Type"] = "application/json"
 	req.Headers["Authorization"] = fmt.Sprintf("Bearer %s", provider.accessToken)
-	res, err := httpDo(req)
+	res, err := doWithRetry(ctx, req, provider.httpOptions, nil)
 	if err != nil {
 		return
 	}
 
 	if res.StatusCode != http.StatusOK {
 		message := "get session token from OAuth failed: "
-		err = errors.New(message + string(res.Body))
+		err = newServiceError(req, res, message+string(res.Body))
 		return
 	}
 	var data oauthCredentialResponse
@@ -182,7 +228,7 @@ func (provider *OAuthCredentialsProvider) getCredentials() (session *sessionCred
 	return
 }
 
-func (provider *OAuthCredentialsProvider) tryRefreshOauthToken() (err error) {
+func (provider *OAuthCredentialsProvider) tryRefreshOauthToken(ctx context.Context) (err error) {
 	refreshToken := provider.refreshToken
 	clientId := provider.clientId
 
@@ -211,6 +257,11 @@ func (provider *OAuthCredentialsProvider) tryRefreshOauthToken() (err error) {
 	if provider.httpOptions != nil && provider.httpOptions.Proxy != "" {
 		req.Proxy = provider.httpOptions.Proxy
 	}
+	if provider.httpOptions != nil {
+		req.Client = provider.httpOptions.Client
+		req.Transport = provider.httpOptions.Transport
+		req.Logger = provider.httpOptions.Logger
+	}
 	req.ConnectTimeout = connectTimeout
 	req.ReadTimeout = readTimeout
 
@@ -218,16 +269,16 @@ func (provider *OAuthCredentialsProvider) tryRefreshOauthToken() (err error) {
 	bodyForm["grant_type"] = "refresh_token"
 	bodyForm["refresh_token"] = refreshToken
 	bodyForm["client_id"] = clientId
-	bodyForm["Timestamp"] = utils.GetTimeInFormatISO8601()
+	bodyForm["Timestamp"] = utils.FormatTimeInISO8601(nowOf(provider.clock))
 	req.Form = bodyForm
 
 	req.Headers["Content-Type"] = "application/x-www-form-urlencoded"
-	resp, err := httpDo(req)
+	resp, err := doWithRetry(ctx, req, provider.httpOptions, nil)
 	if err != nil {
 		return
 	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to refresh token, status code: %d", resp.StatusCode)
+		return newServiceError(req, resp, fmt.Sprintf("failed to refresh token, status code: %d", resp.StatusCode))
 	}
 	var tokenResp oauthRefreshTokenResponse
 	err = json.Unmarshal(resp.Body, &tokenResp)
@@ -241,7 +292,7 @@ func (provider *OAuthCredentialsProvider) tryRefreshOauthToken() (err error) {
 	}
 	provider.accessToken = tokenResp.AccessToken
 	provider.refreshToken = tokenResp.RefreshToken
-	provider.accessTokenExpire = time.Now().Unix() + tokenResp.ExpiresIn
+	provider.accessTokenExpire = nowOf(provider.clock).Unix() + tokenResp.ExpiresIn
 
 	return nil
 }
@@ -251,43 +302,101 @@ func (provider *OAuthCredentialsProvider) needUpdateCredential() (result bool) {
 		return true
 	}
 
-	return provider.expirationTimestamp-time.Now().Unix() <= 180
+	return provider.expirationTimestamp-nowOf(provider.clock).Unix() <= provider.asyncRefresher.staleSeconds()
 }
 
 func (provider *OAuthCredentialsProvider) GetCredentials() (cc *Credentials, err error) {
-	if provider.sessionCredentials == nil || provider.needUpdateCredential() {
-		sessionCredentials, err1 := provider.getCredentials()
-		if err1 != nil {
-			return nil, err1
-		}
-
-		provider.sessionCredentials = sessionCredentials
-		expirationTime, err2 := time.Parse("2006-01-02T15:04:05Z", sessionCredentials.Expiration)
-		if err2 != nil {
-			return nil, err2
-		}
+	return provider.GetCredentialsWithContext(context.Background())
+}
 
-		provider.lastUpdateTimestamp = time.Now().Unix()
-		provider.expirationTimestamp = expirationTime.Unix()
+func (provider *OAuthCredentialsProvider) GetCredentialsWithContext(ctx context.Context) (cc *Credentials, err error) {
+	provider.mutex.RLock()
+	needUpdate := provider.sessionCredentials == nil || provider.needUpdateCredential()
+	provider.mutex.RUnlock()
 
-		// 如果设置了回调函数，则调用回调函数写回配置文件
-		if provider.tokenUpdateCallback != nil {
-			err1 := provider.tokenUpdateCallback(provider.refreshToken, provider.accessToken, sessionCredentials.AccessKeyId, sessionCredentials.AccessKeySecret, sessionCredentials.SecurityToken, provider.accessTokenExpire, provider.expirationTimestamp)
-			if err1 != nil {
-				fmt.Printf("Warning: failed to update OAuth tokens in config file: %v\n", err)
+	// 刷新失败后的重试间隔内直接使用尚未过期的缓存凭证，避免每次调用都等待失败的刷新
+	if needUpdate && !provider.asyncRefresher.inRetryBackoff(provider.getExpirationTimestamp()) {
+		err = provider.refreshGroup.do(ctx, provider.updateCredential)
+		provider.asyncRefresher.recordRefresh(ctx, err)
+		if err != nil {
+			// 异步刷新模式下，刷新失败时继续使用尚未过期的缓存凭证
+			provider.mutex.RLock()
+			canUseStaleSession := provider.asyncRefresher.canUseStaleSession(provider.expirationTimestamp)
+			provider.mutex.RUnlock()
+			if !canUseStaleSession {
+				return
 			}
+			err = nil
 		}
 	}
+	provider.asyncRefresher.start()
 
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
 	cc = &Credentials{
 		AccessKeyId:     provider.sessionCredentials.AccessKeyId,
 		AccessKeySecret: provider.sessionCredentials.AccessKeySecret,
 		SecurityToken:   provider.sessionCredentials.SecurityToken,
 		ProviderName:    provider.GetProviderName(),
+		Expiration:      time.Unix(provider.expirationTimestamp, 0).UTC(),
 	}
 	return
 }
 
+func (provider *OAuthCredentialsProvider) updateCredential(ctx context.Context) (err error) {
+	ctx, endRefresh := startRefresh(ctx, getInstrumentation(provider.httpOptions), provider.clock, provider.GetProviderName())
+	defer func() {
+		expirationTimestamp := provider.getExpirationTimestamp()
+		endRefresh(expirationTimestamp, err)
+		provider.refreshListener.notify(provider.GetProviderName(), expirationTimestamp, err)
+	}()
+
+	sessionCredentials, err := provider.getCredentials(ctx)
+	if err != nil {
+		return
+	}
+
+	expirationTime, err := time.Parse("2006-01-02T15:04:05Z", sessionCredentials.Expiration)
+	if err != nil {
+		return
+	}
+
+	provider.mutex.Lock()
+	provider.lastUpdateTimestamp = nowOf(provider.clock).Unix()
+	provider.expirationTimestamp = expirationTime.Unix()
+	provider.sessionCredentials = sessionCredentials
+	provider.mutex.Unlock()
+
+	// 如果设置了回调函数，则调用回调函数写回配置文件
+	// 只有正在刷新的调用方会执行到这里，refresh token 等字段不会被并发修改
+	if provider.tokenUpdateCallback != nil {
+		err1 := provider.tokenUpdateCallback(provider.refreshToken, provider.accessToken, sessionCredentials.AccessKeyId, sessionCredentials.AccessKeySecret, sessionCredentials.SecurityToken, provider.accessTokenExpire, expirationTime.Unix())
+		if err1 != nil {
+			getLogger(provider.httpOptions).WarnContext(ctx, "failed to update OAuth tokens in config file", "error", err1.Error())
+		}
+	}
+	return
+}
+
+func (provider *OAuthCredentialsProvider) getExpirationTimestamp() int64 {
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
+	return provider.expirationTimestamp
+}
+
+// Invalidate drops the cached credentials, the next GetCredentials() gets new credentials from the service
+func (provider *OAuthCredentialsProvider) Invalidate() {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.expirationTimestamp = 0
+}
+
 func (provider *OAuthCredentialsProvider) GetProviderName() string {
 	return "oauth"
 }
+
+// Close stops the background refresh, it is a no-op if async refresh is not enabled
+func (provider *OAuthCredentialsProvider) Close() error {
+	provider.asyncRefresher.close()
+	return nil
+}
@@ -1,7 +1,10 @@
 package providers
 
 import (
+	"context"
 	"errors"
+	"net/http"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -55,83 +58,83 @@ func TestOAuthCredentialsProvider_getCredentials(t *testing.T) {
 	assert.Nil(t, err)
 
 	// case 1: mock new http request failed
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "mock server error", err.Error())
 
 	// case 2: 4xx error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 400,
 			Body:       []byte("4xx error"),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get session token from OAuth failed: 4xx error", err.Error())
 
 	// case 3: invalid json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte("invalid json"),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get session token from OAuth failed, json.Unmarshal fail: invalid character 'i' looking for beginning of value", err.Error())
 
 	// case 4: empty access key id
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"accessKeyId":"","accessKeySecret":"sk","securityToken":"token","expiration":"2021-10-20T04:27:09Z"}`),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "refresh session token err, fail to get credentials from OAuth")
 
 	// case 5: empty access key secret
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"accessKeyId":"ak","accessKeySecret":"","securityToken":"token","expiration":"2021-10-20T04:27:09Z"}`),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "refresh session token err, fail to get credentials from OAuth")
 
 	// case 6: empty security token
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"accessKeyId":"ak","accessKeySecret":"sk","securityToken":"","expiration":"2021-10-20T04:27:09Z"}`),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "refresh session token err, fail to get credentials from OAuth")
 
 	// case 7: mock ok value
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"accessKeyId":"ak","accessKeySecret":"sk","securityToken":"token","expiration":"2021-10-20T04:27:09Z","requestId":"123"}`),
 		}
 		return
 	}
-	creds, err := p.getCredentials()
+	creds, err := p.getCredentials(context.TODO())
 	assert.Nil(t, err)
 	assert.Equal(t, "ak", creds.AccessKeyId)
 	assert.Equal(t, "sk", creds.AccessKeySecret)
@@ -170,7 +173,7 @@ func TestOAuthCredentialsProviderGetCredentials(t *testing.T) {
 	defer func() { httpDo = originHttpDo }()
 
 	// case 1: mock new http request failed
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
@@ -179,7 +182,7 @@ func TestOAuthCredentialsProviderGetCredentials(t *testing.T) {
 	assert.Equal(t, "mock server error", err.Error())
 
 	// case 2: get invalid expiration
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"accessKeyId":"akid","accessKeySecret":"aksecret","securityToken":"ststoken","expiration":"invalidexpiration"}`),
@@ -191,7 +194,7 @@ func TestOAuthCredentialsProviderGetCredentials(t *testing.T) {
 	assert.Equal(t, "parsing time \"invalidexpiration\" as \"2006-01-02T15:04:05Z\": cannot parse \"invalidexpiration\" as \"2006\"", err.Error())
 
 	// case 3: happy result
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"accessKeyId":"akid","accessKeySecret":"aksecret","securityToken":"ststoken","expiration":"2021-10-20T04:27:09Z"}`),
@@ -276,7 +279,7 @@ func TestOAuthCredentialsProviderCredentialCaching(t *testing.T) {
 	assert.Nil(t, err)
 
 	// Mock successful response
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"accessKeyId":"akid","accessKeySecret":"aksecret","securityToken":"ststoken","expiration":"2021-10-20T04:27:09Z"}`),
@@ -336,7 +339,7 @@ func TestOAuthCredentialsProviderTryRefreshOauthToken(t *testing.T) {
 	assert.Nil(t, err)
 
 	// Test successful token refresh
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"access_token":"new_access_token","refresh_token":"new_refresh_token","expires_in":3600,"token_type":"Bearer"}`),
@@ -344,24 +347,24 @@ func TestOAuthCredentialsProviderTryRefreshOauthToken(t *testing.T) {
 		return
 	}
 
-	err = p.tryRefreshOauthToken()
+	err = p.tryRefreshOauthToken(context.TODO())
 	assert.Nil(t, err)
 	assert.Equal(t, "new_access_token", p.accessToken)
 	assert.Equal(t, "new_refresh_token", p.refreshToken)
 	assert.True(t, p.accessTokenExpire > time.Now().Unix())
 
 	// Test refresh token failure - HTTP error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("network error")
 		return
 	}
 
-	err = p.tryRefreshOauthToken()
+	err = p.tryRefreshOauthToken(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "network error", err.Error())
 
 	// Test refresh token failure - non-200 status
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 400,
 			Body:       []byte("bad request"),
@@ -369,12 +372,12 @@ func TestOAuthCredentialsProviderTryRefreshOauthToken(t *testing.T) {
 		return
 	}
 
-	err = p.tryRefreshOauthToken()
+	err = p.tryRefreshOauthToken(context.TODO())
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "failed to refresh token, status code: 400")
 
 	// Test refresh token failure - invalid JSON
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte("invalid json"),
@@ -382,12 +385,12 @@ func TestOAuthCredentialsProviderTryRefreshOauthToken(t *testing.T) {
 		return
 	}
 
-	err = p.tryRefreshOauthToken()
+	err = p.tryRefreshOauthToken(context.TODO())
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "get refresh token from OAuth failed, json.Unmarshal fail")
 
 	// Test refresh token failure - empty tokens
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"access_token":"","refresh_token":"","expires_in":3600,"token_type":"Bearer"}`),
@@ -395,7 +398,7 @@ func TestOAuthCredentialsProviderTryRefreshOauthToken(t *testing.T) {
 		return
 	}
 
-	err = p.tryRefreshOauthToken()
+	err = p.tryRefreshOauthToken(context.TODO())
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "failed to refresh token from OAuth")
 }
@@ -416,7 +419,7 @@ func TestOAuthCredentialsProviderTokenRefreshIntegration(t *testing.T) {
 
 	// Mock refresh token response
 	refreshTokenCallCount := 0
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/v1/token" {
 			refreshTokenCallCount++
 			res = &httputil.Response{
@@ -482,7 +485,7 @@ func TestOAuthCredentialsProvider_TokenUpdateCallback(t *testing.T) {
 	originHttpDo := httpDo
 	defer func() { httpDo = originHttpDo }()
 
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/v1/token" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -517,6 +520,7 @@ func TestOAuthCredentialsProvider_TokenUpdateCallback_Error(t *testing.T) {
 		return errors.New("callback error")
 	}
 
+	logger := &testLogger{}
 	p, err := NewOAuthCredentialsProviderBuilder().
 		WithClientId("clientId").
 		WithSignInUrl("https://oauth.aliyun.com").
@@ -524,13 +528,14 @@ func TestOAuthCredentialsProvider_TokenUpdateCallback_Error(t *testing.T) {
 		WithAccessToken("accessToken").
 		WithAccessTokenExpire(time.Now().Unix() + 1000).
 		WithTokenUpdateCallback(callback).
+		WithHttpOptions(&HttpOptions{Logger: logger}).
 		Build()
 	assert.Nil(t, err)
 
 	originHttpDo := httpDo
 	defer func() { httpDo = originHttpDo }()
 
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/v1/token" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -552,6 +557,7 @@ func TestOAuthCredentialsProvider_TokenUpdateCallback_Error(t *testing.T) {
 	// Should still succeed even if callback fails (callback error is logged but not returned)
 	_, err = p.GetCredentials()
 	assert.Nil(t, err)
+	assert.Contains(t, logger.lines, "WARN failed to update OAuth tokens in config file [error callback error]")
 }
 
 func TestOAuthCredentialsProvider_WithoutTokenUpdateCallback(t *testing.T) {
@@ -569,7 +575,7 @@ func TestOAuthCredentialsProvider_WithoutTokenUpdateCallback(t *testing.T) {
 	originHttpDo := httpDo
 	defer func() { httpDo = originHttpDo }()
 
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/v1/token" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -614,7 +620,7 @@ func TestOAuthCredentialsProvider_TryRefreshOauthToken_WithCallback(t *testing.T
 	assert.Nil(t, err)
 
 	// Test successful token refresh
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"access_token":"new_access_token","refresh_token":"new_refresh_token","expires_in":3600,"token_type":"Bearer"}`),
@@ -622,7 +628,7 @@ func TestOAuthCredentialsProvider_TryRefreshOauthToken_WithCallback(t *testing.T
 		return
 	}
 
-	err = p.tryRefreshOauthToken()
+	err = p.tryRefreshOauthToken(context.TODO())
 	assert.Nil(t, err)
 	// 注意：tryRefreshOauthToken 本身不会调用回调函数，回调函数是在 GetCredentials 中调用的
 	assert.False(t, callbackCalled) // 这里应该是 false，因为 tryRefreshOauthToken 不调用回调
@@ -644,7 +650,7 @@ func TestOAuthCredentialsProvider_GetCredentials_WithExpiredToken(t *testing.T)
 	assert.Nil(t, err)
 
 	// Mock refresh token response
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/v1/token" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -682,7 +688,7 @@ func TestOAuthCredentialsProvider_GetCredentials_WithEmptyAccessToken(t *testing
 	assert.Nil(t, err)
 
 	// Mock refresh token response
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/v1/token" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -720,7 +726,7 @@ func TestOAuthCredentialsProvider_GetCredentials_WithZeroAccessTokenExpire(t *te
 	assert.Nil(t, err)
 
 	// Mock refresh token response
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/v1/token" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -758,7 +764,7 @@ func TestOAuthCredentialsProvider_GetCredentials_WithNearExpiredToken(t *testing
 	assert.Nil(t, err)
 
 	// Mock refresh token response
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		if req.Path == "/v1/token" {
 			res = &httputil.Response{
 				StatusCode: 200,
@@ -792,7 +798,7 @@ func TestOAuthCredentialsProvider_TryRefreshOauthToken_InvalidURL(t *testing.T)
 		Build()
 	assert.Nil(t, err)
 
-	err = p.tryRefreshOauthToken()
+	err = p.tryRefreshOauthToken(context.TODO())
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "parse")
 }
@@ -811,11 +817,11 @@ func TestOAuthCredentialsProvider_TryRefreshOauthToken_NetworkError(t *testing.T
 	assert.Nil(t, err)
 
 	// Mock network error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		return nil, errors.New("network error")
 	}
 
-	err = p.tryRefreshOauthToken()
+	err = p.tryRefreshOauthToken(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "network error", err.Error())
 }
@@ -834,7 +840,7 @@ func TestOAuthCredentialsProvider_TryRefreshOauthToken_Non200Status(t *testing.T
 	assert.Nil(t, err)
 
 	// Mock non-200 status
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 401,
 			Body:       []byte("unauthorized"),
@@ -842,7 +848,7 @@ func TestOAuthCredentialsProvider_TryRefreshOauthToken_Non200Status(t *testing.T
 		return
 	}
 
-	err = p.tryRefreshOauthToken()
+	err = p.tryRefreshOauthToken(context.TODO())
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "failed to refresh token, status code: 401")
 }
@@ -861,7 +867,7 @@ func TestOAuthCredentialsProvider_TryRefreshOauthToken_InvalidJSON(t *testing.T)
 	assert.Nil(t, err)
 
 	// Mock invalid JSON response
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte("invalid json"),
@@ -869,7 +875,7 @@ func TestOAuthCredentialsProvider_TryRefreshOauthToken_InvalidJSON(t *testing.T)
 		return
 	}
 
-	err = p.tryRefreshOauthToken()
+	err = p.tryRefreshOauthToken(context.TODO())
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "get refresh token from OAuth failed, json.Unmarshal fail")
 }
@@ -888,7 +894,7 @@ func TestOAuthCredentialsProvider_TryRefreshOauthToken_EmptyTokens(t *testing.T)
 	assert.Nil(t, err)
 
 	// Mock empty tokens response
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"access_token":"","refresh_token":"","expires_in":3600,"token_type":"Bearer"}`),
@@ -896,7 +902,7 @@ func TestOAuthCredentialsProvider_TryRefreshOauthToken_EmptyTokens(t *testing.T)
 		return
 	}
 
-	err = p.tryRefreshOauthToken()
+	err = p.tryRefreshOauthToken(context.TODO())
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "failed to refresh token from OAuth")
 }
@@ -972,7 +978,7 @@ func TestOAuthCredentialsProvider_GetCredentials_CachedCredentials(t *testing.T)
 	assert.Nil(t, err)
 
 	// Mock successful response
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"accessKeyId":"akid","accessKeySecret":"aksecret","securityToken":"ststoken","expiration":"2021-10-20T04:27:09Z"}`),
@@ -996,3 +1002,46 @@ func TestOAuthCredentialsProvider_GetCredentials_CachedCredentials(t *testing.T)
 	assert.Equal(t, cc1.AccessKeySecret, cc2.AccessKeySecret)
 	assert.Equal(t, cc1.SecurityToken, cc2.SecurityToken)
 }
+
+func TestOAuthCredentialsProviderGetCredentialsConcurrently(t *testing.T) {
+	var tokenCount, exchangeCount int32
+	server, rollback := mockLocalEndpoint(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/token":
+			atomic.AddInt32(&tokenCount, 1)
+			w.Write([]byte(`{"access_token":"newaccesstoken","refresh_token":"newrefreshtoken","expires_in":3600,"token_type":"Bearer"}`))
+		case "/v1/exchange":
+			atomic.AddInt32(&exchangeCount, 1)
+			time.Sleep(50 * time.Millisecond)
+			expiration := time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+			w.Write([]byte(`{"accessKeyId":"saki","accessKeySecret":"saks","expiration":"` + expiration + `","securityToken":"token"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer rollback()
+
+	var callbackCount int32
+	p, err := NewOAuthCredentialsProviderBuilder().
+		WithClientId("clientid").
+		WithSignInUrl(server.URL).
+		WithRefreshToken("refreshtoken").
+		WithTokenUpdateCallback(func(refreshToken, accessToken, accessKey, secret, securityToken string, accessTokenExpire, stsExpire int64) error {
+			atomic.AddInt32(&callbackCount, 1)
+			assert.Equal(t, "newrefreshtoken", refreshToken)
+			assert.Equal(t, "newaccesstoken", accessToken)
+			return nil
+		}).
+		Build()
+	assert.Nil(t, err)
+
+	hammer(t, p, func(cc *Credentials) {
+		assert.Equal(t, "saki", cc.AccessKeyId)
+		assert.Equal(t, "saks", cc.AccessKeySecret)
+		assert.Equal(t, "token", cc.SecurityToken)
+		assert.Equal(t, "oauth", cc.ProviderName)
+	})
+	assert.Equal(t, int32(1), atomic.LoadInt32(&tokenCount))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&exchangeCount))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&callbackCount))
+}
@@ -1,6 +1,7 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
@@ -32,6 +34,23 @@ type OIDCCredentialsProvider struct {
 	sessionCredentials  *sessionCredentials
 	// for http options
 	httpOptions *HttpOptions
+	// for expiry and refresh decisions
+	clock Clock
+	// guards the inner session fields
+	mutex        sync.RWMutex
+	refreshGroup refreshGroup
+	// for async refresh
+	asyncRefreshOptions *AsyncRefreshOptions
+	asyncRefresher      *asyncRefresher
+	refreshListener     refreshListener
+	// for file cache
+	fileCacheEnabled bool
+	fileCacheDir     string
+	fileCache        *stsFileCache
+	// the role session name is generated, it is replaced by a fixed marker in the cache key
+	roleSessionNameGenerated bool
+	// the cached credentials are rejected by the service, the file cache is skipped until the next refresh
+	invalidated bool
 }
 
 type OIDCCredentialsProviderBuilder struct {
@@ -89,14 +108,49 @@ func (b *OIDCCredentialsProviderBuilder) WithSTSEndpoint(stsEndpoint string) *OI
 	return b
 }
 
+// WithOnRefresh sets the callback which is called after the credentials are refreshed
+func (b *OIDCCredentialsProviderBuilder) WithOnRefresh(onRefresh OnRefreshFunc) *OIDCCredentialsProviderBuilder {
+	b.provider.refreshListener.onRefresh = onRefresh
+	return b
+}
+
+// WithOnRefreshError sets the callback which is called after the credentials failed to refresh
+func (b *OIDCCredentialsProviderBuilder) WithOnRefreshError(onRefreshError OnRefreshErrorFunc) *OIDCCredentialsProviderBuilder {
+	b.provider.refreshListener.onRefreshError = onRefreshError
+	return b
+}
+
 func (b *OIDCCredentialsProviderBuilder) WithHttpOptions(httpOptions *HttpOptions) *OIDCCredentialsProviderBuilder {
 	b.provider.httpOptions = httpOptions
 	return b
 }
 
+// WithAsyncRefresh enables refreshing the credentials in background, call Close() to stop it
+func (b *OIDCCredentialsProviderBuilder) WithAsyncRefresh(options *AsyncRefreshOptions) *OIDCCredentialsProviderBuilder {
+	b.provider.asyncRefreshOptions = options
+	return b
+}
+
+// WithFileCache enables caching the sts credentials on disk to share them between processes, dir defaults to ~/.aliyun/cache.
+// The cache is keyed by the role session name, the processes which use the generated role session name share the cache,
+// so the cached credentials may have the role session name generated by another process.
+// The processes which read the token from different files do not share the cache.
+func (b *OIDCCredentialsProviderBuilder) WithFileCache(dir string) *OIDCCredentialsProviderBuilder {
+	b.provider.fileCacheEnabled = true
+	b.provider.fileCacheDir = dir
+	return b
+}
+
+// WithClock sets the clock to decide whether the credentials are expired or due for a refresh, default is the system clock
+func (b *OIDCCredentialsProviderBuilder) WithClock(clock Clock) *OIDCCredentialsProviderBuilder {
+	b.provider.clock = clock
+	return b
+}
+
 func (b *OIDCCredentialsProviderBuilder) Build() (provider *OIDCCredentialsProvider, err error) {
 	if b.provider.roleSessionName == "" {
-		b.provider.roleSessionName = "credentials-go-" + strconv.FormatInt(time.Now().UnixNano()/1000, 10)
+		b.provider.roleSessionName = "credentials-go-" + strconv.FormatInt(nowOf(b.provider.clock).UnixNano()/1000, 10)
+		b.provider.roleSessionNameGenerated = true
 	}
 
 	if b.provider.oidcTokenFilePath == "" {
@@ -104,7 +158,7 @@ func (b *OIDCCredentialsProviderBuilder) Build() (provider *OIDCCredentialsProvi
 	}
 
 	if b.provider.oidcTokenFilePath == "" {
-		err = errors.New("the OIDCTokenFilePath is empty")
+		err = newNotConfiguredError("the OIDCTokenFilePath is empty")
 		return
 	}
 
@@ -113,7 +167,7 @@ func (b *OIDCCredentialsProviderBuilder) Build() (provider *OIDCCredentialsProvi
 	}
 
 	if b.provider.oidcProviderARN == "" {
-		err = errors.New("the OIDCProviderARN is empty")
+		err = newNotConfiguredError("the OIDCProviderARN is empty")
 		return
 	}
 
@@ -122,7 +176,7 @@ func (b *OIDCCredentialsProviderBuilder) Build() (provider *OIDCCredentialsProvi
 	}
 
 	if b.provider.roleArn == "" {
-		err = errors.New("the RoleArn is empty")
+		err = newNotConfiguredError("the RoleArn is empty")
 		return
 	}
 
@@ -151,11 +205,27 @@ func (b *OIDCCredentialsProviderBuilder) Build() (provider *OIDCCredentialsProvi
 		}
 	}
 
+	if b.provider.fileCacheEnabled {
+		fileCache, err1 := newSTSFileCache(b.provider.fileCacheDir)
+		if err1 != nil {
+			err = err1
+			return
+		}
+		b.provider.fileCache = fileCache
+	}
+
+	if b.provider.asyncRefreshOptions != nil {
+		p := b.provider
+		p.asyncRefresher = newAsyncRefresher(p.asyncRefreshOptions, p.clock, func(ctx context.Context) error {
+			return p.refreshGroup.do(ctx, p.updateCredential)
+		}, p.getExpirationTimestamp)
+	}
+
 	provider = b.provider
 	return
 }
 
-func (provider *OIDCCredentialsProvider) getCredentials() (session *sessionCredentials, err error) {
+func (provider *OIDCCredentialsProvider) getCredentials(ctx context.Context) (session *sessionCredentials, err error) {
 	req := &httputil.Request{
 		Method:   "POST",
 		Protocol: "https",
@@ -175,6 +245,11 @@ func (provider *OIDCCredentialsProvider) getCredentials() (session *sessionCrede
 	if provider.httpOptions != nil && provider.httpOptions.Proxy != "" {
 		req.Proxy = provider.httpOptions.Proxy
 	}
+	if provider.httpOptions != nil {
+		req.Client = provider.httpOptions.Client
+		req.Transport = provider.httpOptions.Transport
+		req.Logger = provider.httpOptions.Logger
+	}
 	req.ConnectTimeout = connectTimeout
 	req.ReadTimeout = readTimeout
 
@@ -182,7 +257,7 @@ func (provider *OIDCCredentialsProvider) getCredentials() (session *sessionCrede
 	queries["Version"] = "2015-04-01"
 	queries["Action"] = "AssumeRoleWithOIDC"
 	queries["Format"] = "JSON"
-	queries["Timestamp"] = utils.GetTimeInFormatISO8601()
+	queries["Timestamp"] = utils.FormatTimeInISO8601(nowOf(provider.clock))
 	req.Queries = queries
 
 	bodyForm := make(map[string]string)
@@ -204,14 +279,14 @@ func (provider *OIDCCredentialsProvider) getCredentials() (session *sessionCrede
 
 	// set headers
 	req.Headers["Accept-Encoding"] = "identity"
-	res, err := httpDo(req)
+	res, err := doWithRetry(ctx, req, provider.httpOptions, nil)
 	if err != nil {
 		return
 	}
 
 	if res.StatusCode != http.StatusOK {
 		message := "get session token failed: "
-		err = errors.New(message + string(res.Body))
+		err = newServiceError(req, res, message+string(res.Body))
 		return
 	}
 	var data assumeRoleResponse
@@ -244,35 +319,110 @@ func (provider *OIDCCredentialsProvider) needUpdateCredential() (result bool) {
 		return true
 	}
 
-	return provider.expirationTimestamp-time.Now().Unix() <= 180
+	return provider.expirationTimestamp-nowOf(provider.clock).Unix() <= provider.asyncRefresher.staleSeconds()
 }
 
 func (provider *OIDCCredentialsProvider) GetCredentials() (cc *Credentials, err error) {
-	if provider.sessionCredentials == nil || provider.needUpdateCredential() {
-		sessionCredentials, err1 := provider.getCredentials()
-		if err1 != nil {
-			return nil, err1
-		}
+	return provider.GetCredentialsWithContext(context.Background())
+}
 
-		provider.sessionCredentials = sessionCredentials
-		expirationTime, err2 := time.Parse("2006-01-02T15:04:05Z", sessionCredentials.Expiration)
-		if err2 != nil {
-			return nil, err2
+func (provider *OIDCCredentialsProvider) GetCredentialsWithContext(ctx context.Context) (cc *Credentials, err error) {
+	provider.mutex.RLock()
+	needUpdate := provider.sessionCredentials == nil || provider.needUpdateCredential()
+	provider.mutex.RUnlock()
+
+	// 刷新失败后的重试间隔内直接使用尚未过期的缓存凭证，避免每次调用都等待失败的刷新
+	if needUpdate && !provider.asyncRefresher.inRetryBackoff(provider.getExpirationTimestamp()) {
+		err = provider.refreshGroup.do(ctx, provider.updateCredential)
+		provider.asyncRefresher.recordRefresh(ctx, err)
+		if err != nil {
+			// 异步刷新模式下，刷新失败时继续使用尚未过期的缓存凭证
+			provider.mutex.RLock()
+			canUseStaleSession := provider.asyncRefresher.canUseStaleSession(provider.expirationTimestamp)
+			provider.mutex.RUnlock()
+			if !canUseStaleSession {
+				return
+			}
+			err = nil
 		}
-
-		provider.lastUpdateTimestamp = time.Now().Unix()
-		provider.expirationTimestamp = expirationTime.Unix()
 	}
+	provider.asyncRefresher.start()
 
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
 	cc = &Credentials{
 		AccessKeyId:     provider.sessionCredentials.AccessKeyId,
 		AccessKeySecret: provider.sessionCredentials.AccessKeySecret,
 		SecurityToken:   provider.sessionCredentials.SecurityToken,
 		ProviderName:    provider.GetProviderName(),
+		Expiration:      time.Unix(provider.expirationTimestamp, 0).UTC(),
 	}
 	return
 }
 
+func (provider *OIDCCredentialsProvider) updateCredential(ctx context.Context) (err error) {
+	ctx, endRefresh := startRefresh(ctx, getInstrumentation(provider.httpOptions), provider.clock, provider.GetProviderName())
+	defer func() {
+		expirationTimestamp := provider.getExpirationTimestamp()
+		endRefresh(expirationTimestamp, err)
+		provider.refreshListener.notify(provider.GetProviderName(), expirationTimestamp, err)
+	}()
+
+	// 优先使用其他进程缓存在磁盘上的凭证，缓存的凭证被服务端拒绝后不再使用
+	provider.mutex.RLock()
+	invalidated := provider.invalidated
+	provider.mutex.RUnlock()
+	cacheKey := stsFileCacheKey(provider.GetProviderName(), provider.roleArn, cacheKeySessionName(provider.roleSessionName, provider.roleSessionNameGenerated), hashPolicy(provider.policy), provider.oidcProviderARN, provider.oidcTokenFilePath)
+	var sessionCredentials *sessionCredentials
+	var expirationTimestamp int64
+	if !invalidated {
+		sessionCredentials, expirationTimestamp = provider.fileCache.get(cacheKey, nowOf(provider.clock), provider.asyncRefresher.prefetchSeconds())
+	}
+	if sessionCredentials == nil {
+		sessionCredentials, err = provider.getCredentials(ctx)
+		if err != nil {
+			return
+		}
+
+		expirationTime, err1 := time.Parse("2006-01-02T15:04:05Z", sessionCredentials.Expiration)
+		if err1 != nil {
+			err = err1
+			return
+		}
+		expirationTimestamp = expirationTime.Unix()
+		// 缓存写入失败不影响获取凭证
+		provider.fileCache.put(cacheKey, sessionCredentials)
+	}
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.lastUpdateTimestamp = nowOf(provider.clock).Unix()
+	provider.expirationTimestamp = expirationTimestamp
+	provider.sessionCredentials = sessionCredentials
+	provider.invalidated = false
+	return
+}
+
+func (provider *OIDCCredentialsProvider) getExpirationTimestamp() int64 {
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
+	return provider.expirationTimestamp
+}
+
+// Invalidate drops the cached credentials, the next GetCredentials() gets new credentials from the service
+func (provider *OIDCCredentialsProvider) Invalidate() {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.expirationTimestamp = 0
+	provider.invalidated = true
+}
+
 func (provider *OIDCCredentialsProvider) GetProviderName() string {
 	return "oidc_role_arn"
 }
+
+// Close stops the background refresh, it is a no-op if async refresh is not enabled
+func (provider *OIDCCredentialsProvider) Close() error {
+	provider.asyncRefresher.close()
+	return nil
+}
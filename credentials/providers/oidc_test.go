@@ -1,10 +1,14 @@
 package providers
 
 import (
+	"context"
 	"errors"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"path"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -149,7 +153,7 @@ func TestOIDCCredentialsProvider_getCredentials(t *testing.T) {
 		Build()
 	assert.Nil(t, err)
 
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "open /path/to/invalid/oidc.token: no such file or directory", err.Error())
 
@@ -167,71 +171,71 @@ func TestOIDCCredentialsProvider_getCredentials(t *testing.T) {
 	assert.Nil(t, err)
 
 	// case 2: server error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "mock server error", err.Error())
 
 	// case 3: 4xx error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 400,
 			Body:       []byte("4xx error"),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get session token failed: 4xx error", err.Error())
 
 	// case 4: invalid json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte("invalid json"),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get oidc sts token err, json.Unmarshal fail: invalid character 'i' looking for beginning of value", err.Error())
 
 	// case 5: empty response json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte("null"),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get oidc sts token err, fail to get credentials", err.Error())
 
 	// case 6: empty session ak response json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"Credentials": {}}`),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "refresh RoleArn sts token err, fail to get credentials", err.Error())
 
 	// case 7: mock ok value
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"Credentials": {"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"2021-10-20T04:27:09Z","SecurityToken":"token"}}`),
 		}
 		return
 	}
-	creds, err := p.getCredentials()
+	creds, err := p.getCredentials(context.TODO())
 	assert.Nil(t, err)
 	assert.Equal(t, "saki", creds.AccessKeyId)
 	assert.Equal(t, "saks", creds.AccessKeySecret)
@@ -266,7 +270,7 @@ func TestOIDCCredentialsProvider_getCredentialsWithRequestCheck(t *testing.T) {
 	assert.Nil(t, err)
 
 	// case 1: server error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		assert.Equal(t, "sts.aliyuncs.com", req.Host)
 		assert.Equal(t, "AssumeRoleWithOIDC", req.Queries["Action"])
 		assert.Equal(t, "policy", req.Form["Policy"])
@@ -277,7 +281,7 @@ func TestOIDCCredentialsProvider_getCredentialsWithRequestCheck(t *testing.T) {
 		err = errors.New("mock server error")
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "mock server error", err.Error())
 }
@@ -301,7 +305,7 @@ func TestOIDCCredentialsProviderGetCredentials(t *testing.T) {
 	assert.Nil(t, err)
 
 	// case 2: get credentials failed
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
@@ -310,7 +314,7 @@ func TestOIDCCredentialsProviderGetCredentials(t *testing.T) {
 	assert.Equal(t, "mock server error", err.Error())
 
 	// case 2: get invalid expiration
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"Credentials": {"AccessKeyId":"akid","AccessKeySecret":"aksecret","Expiration":"invalidexpiration","SecurityToken":"ststoken"}}`),
@@ -322,7 +326,7 @@ func TestOIDCCredentialsProviderGetCredentials(t *testing.T) {
 	assert.Equal(t, "parsing time \"invalidexpiration\" as \"2006-01-02T15:04:05Z\": cannot parse \"invalidexpiration\" as \"2006\"", err.Error())
 
 	// case 3: happy result
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"Credentials": {"AccessKeyId":"akid","AccessKeySecret":"aksecret","Expiration":"2021-10-20T04:27:09Z","SecurityToken":"ststoken"}}`),
@@ -360,3 +364,95 @@ func TestOIDCCredentialsProviderGetCredentialsWithHttpOptions(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "proxyconnect tcp:")
 }
+
+func TestOIDCCredentialsProviderGetCredentialsConcurrently(t *testing.T) {
+	var count int32
+	_, rollback := mockLocalEndpoint(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		time.Sleep(50 * time.Millisecond)
+		expiration := time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		w.Write([]byte(`{"Credentials": {"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"` + expiration + `","SecurityToken":"token"}}`))
+	})
+	defer rollback()
+
+	wd, _ := os.Getwd()
+	p, err := NewOIDCCredentialsProviderBuilder().
+		WithOIDCTokenFilePath(path.Join(wd, "fixtures/mock_oidctoken")).
+		WithOIDCProviderARN("oidcproviderarn").
+		WithRoleArn("rolearn").
+		Build()
+	assert.Nil(t, err)
+
+	hammer(t, p, func(cc *Credentials) {
+		assert.Equal(t, "saki", cc.AccessKeyId)
+		assert.Equal(t, "saks", cc.AccessKeySecret)
+		assert.Equal(t, "token", cc.SecurityToken)
+		assert.Equal(t, "oidc_role_arn", cc.ProviderName)
+	})
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+func TestOIDCCredentialsProviderGetCredentialsWithFileCache(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "oidc_cache_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	var count int32
+	_, rollback := mockLocalEndpoint(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		expiration := time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		w.Write([]byte(`{"Credentials": {"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"` + expiration + `","SecurityToken":"token"}}`))
+	})
+	defer rollback()
+
+	wd, _ := os.Getwd()
+	for i := 0; i < 2; i++ {
+		p, err := NewOIDCCredentialsProviderBuilder().
+			WithOIDCTokenFilePath(path.Join(wd, "fixtures/mock_oidctoken")).
+			WithOIDCProviderARN("oidcproviderarn").
+			WithRoleArn("rolearn").
+			WithRoleSessionName("rsn").
+			WithFileCache(tempDir).
+			Build()
+		assert.Nil(t, err)
+
+		cc, err := p.GetCredentials()
+		assert.Nil(t, err)
+		assert.Equal(t, "saki", cc.AccessKeyId)
+		assert.Equal(t, "oidc_role_arn", cc.ProviderName)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+
+	// the invalidated credentials are not read from the cache again
+	p, err := NewOIDCCredentialsProviderBuilder().
+		WithOIDCTokenFilePath(path.Join(wd, "fixtures/mock_oidctoken")).
+		WithOIDCProviderARN("oidcproviderarn").
+		WithRoleArn("rolearn").
+		WithRoleSessionName("rsn").
+		WithFileCache(tempDir).
+		Build()
+	assert.Nil(t, err)
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+	p.Invalidate()
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+	assert.False(t, p.invalidated)
+
+	// the credentials of another token file (another service account) are not shared
+	otherTokenFile := path.Join(tempDir, "other_oidctoken")
+	assert.Nil(t, ioutil.WriteFile(otherTokenFile, []byte("other token"), 0600))
+	p, err = NewOIDCCredentialsProviderBuilder().
+		WithOIDCTokenFilePath(otherTokenFile).
+		WithOIDCProviderARN("oidcproviderarn").
+		WithRoleArn("rolearn").
+		WithRoleSessionName("rsn").
+		WithFileCache(tempDir).
+		Build()
+	assert.Nil(t, err)
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&count))
+}
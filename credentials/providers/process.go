@@ -0,0 +1,386 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProcessTimeout       = time.Minute
+	defaultProcessMaxOutputSize = 64 * 1024
+	// the only supported version of the process output
+	processOutputVersion = 1
+)
+
+// ProcessCredentialsProvider gets the credentials from the output of an external command.
+// The command must print a JSON document to stdout, such as:
+//
+//	{
+//	  "Version": 1,
+//	  "AccessKeyId": "<access key id>",
+//	  "AccessKeySecret": "<access key secret>",
+//	  "SecurityToken": "<security token>",
+//	  "Expiration": "2006-01-02T15:04:05Z"
+//	}
+//
+// SecurityToken and Expiration are optional, credentials without Expiration are cached forever.
+type ProcessCredentialsProvider struct {
+	command       string
+	timeout       time.Duration
+	maxOutputSize int
+	// the output is the profile printed by the process_command of the External mode of aliyun cli
+	cliExternalOutput bool
+	// for expiry and refresh decisions
+	clock           Clock
+	instrumentation Instrumentation
+	// inner
+	sessionCredentials  *sessionCredentials
+	expirationTimestamp int64
+	// the command is run again on the next call, the credentials without expiration can not be expired by expirationTimestamp
+	invalidated bool
+	// guards the inner session fields
+	mutex           sync.RWMutex
+	refreshGroup    refreshGroup
+	refreshListener refreshListener
+}
+
+type ProcessCredentialsProviderBuilder struct {
+	provider *ProcessCredentialsProvider
+}
+
+func NewProcessCredentialsProviderBuilder() *ProcessCredentialsProviderBuilder {
+	return &ProcessCredentialsProviderBuilder{
+		provider: &ProcessCredentialsProvider{},
+	}
+}
+
+// WithCommand sets the command line, it is run by the shell of the system (sh -c or cmd /C)
+func (builder *ProcessCredentialsProviderBuilder) WithCommand(command string) *ProcessCredentialsProviderBuilder {
+	builder.provider.command = command
+	return builder
+}
+
+// WithTimeout sets how long the command can run before it is killed, default is 1 minute
+func (builder *ProcessCredentialsProviderBuilder) WithTimeout(timeout time.Duration) *ProcessCredentialsProviderBuilder {
+	builder.provider.timeout = timeout
+	return builder
+}
+
+// WithMaxOutputSize sets the max size of the output of the command in bytes, default is 64 KiB
+func (builder *ProcessCredentialsProviderBuilder) WithMaxOutputSize(maxOutputSize int) *ProcessCredentialsProviderBuilder {
+	builder.provider.maxOutputSize = maxOutputSize
+	return builder
+}
+
+// WithOnRefresh sets the callback which is called after the credentials are refreshed
+func (builder *ProcessCredentialsProviderBuilder) WithOnRefresh(onRefresh OnRefreshFunc) *ProcessCredentialsProviderBuilder {
+	builder.provider.refreshListener.onRefresh = onRefresh
+	return builder
+}
+
+// WithOnRefreshError sets the callback which is called after the credentials failed to refresh
+func (builder *ProcessCredentialsProviderBuilder) WithOnRefreshError(onRefreshError OnRefreshErrorFunc) *ProcessCredentialsProviderBuilder {
+	builder.provider.refreshListener.onRefreshError = onRefreshError
+	return builder
+}
+
+// WithClock sets the clock to decide whether the credentials are expired or due for a refresh, default is the system clock
+func (builder *ProcessCredentialsProviderBuilder) WithClock(clock Clock) *ProcessCredentialsProviderBuilder {
+	builder.provider.clock = clock
+	return builder
+}
+
+// WithInstrumentation sets the instrumentation which receives the events of the refreshes, the command sends no http requests
+func (builder *ProcessCredentialsProviderBuilder) WithInstrumentation(instrumentation Instrumentation) *ProcessCredentialsProviderBuilder {
+	builder.provider.instrumentation = instrumentation
+	return builder
+}
+
+func (builder *ProcessCredentialsProviderBuilder) Build() (provider *ProcessCredentialsProvider, err error) {
+	if strings.TrimSpace(builder.provider.command) == "" {
+		err = newNotConfiguredError("the command is empty")
+		return
+	}
+
+	if builder.provider.timeout <= 0 {
+		builder.provider.timeout = defaultProcessTimeout
+	}
+
+	if builder.provider.maxOutputSize <= 0 {
+		builder.provider.maxOutputSize = defaultProcessMaxOutputSize
+	}
+
+	provider = builder.provider
+	return
+}
+
+type processResponse struct {
+	Version         int     `json:"Version"`
+	AccessKeyId     *string `json:"AccessKeyId"`
+	AccessKeySecret *string `json:"AccessKeySecret"`
+	SecurityToken   *string `json:"SecurityToken"`
+	Expiration      *string `json:"Expiration"`
+}
+
+// cliExternalResponse is the output of the process_command of aliyun cli, the fields are the same as the cli profile
+type cliExternalResponse struct {
+	Mode            string `json:"mode"`
+	AccessKeyId     string `json:"access_key_id"`
+	AccessKeySecret string `json:"access_key_secret"`
+	StsToken        string `json:"sts_token"`
+	StsExpire       int64  `json:"sts_expiration"`
+}
+
+// limitedBuffer keeps at most limit bytes, the rest of the output is discarded
+type limitedBuffer struct {
+	buffer    bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (n int, err error) {
+	n = len(p)
+	if remain := b.limit - b.buffer.Len(); len(p) > remain {
+		p = p[:remain]
+		b.truncated = true
+	}
+	b.buffer.Write(p)
+	return
+}
+
+// newProcessCommand runs the command by the shell of the system
+func newProcessCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+func (provider *ProcessCredentialsProvider) getCredentials(ctx context.Context) (session *sessionCredentials, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, provider.timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: provider.maxOutputSize}
+	stderr := &limitedBuffer{limit: provider.maxOutputSize}
+	cmd := newProcessCommand(ctx, provider.command)
+	cmd.Env = os.Environ()
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		err = fmt.Errorf("run credential process failed: %s", err.Error())
+		return
+	}
+
+	// 子进程可能一直持有输出管道，超时后不再等待读取结束，由 Wait 关闭管道
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		done := make(chan struct{})
+		go func() {
+			io.Copy(stderr, stderrPipe)
+			close(done)
+		}()
+		io.Copy(stdout, stdoutPipe)
+		<-done
+	}()
+	select {
+	case <-outputDone:
+	case <-ctx.Done():
+	}
+	err = cmd.Wait()
+	<-outputDone
+
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("run credential process failed: timeout after %s", provider.timeout)
+		return
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("run credential process failed: %s, stderr: %s", err.Error(), strings.TrimSpace(stderr.buffer.String()))
+		return
+	}
+
+	if stdout.truncated {
+		err = fmt.Errorf("run credential process failed: the output exceeds the limit of %d bytes", provider.maxOutputSize)
+		return
+	}
+
+	if provider.cliExternalOutput {
+		return parseCLIExternalOutput(stdout.buffer.Bytes())
+	}
+
+	var resp processResponse
+	err = json.Unmarshal(stdout.buffer.Bytes(), &resp)
+	if err != nil {
+		err = fmt.Errorf("get credentials from credential process failed with error, json unmarshal fail: %s", err.Error())
+		return
+	}
+
+	if resp.Version != processOutputVersion {
+		err = fmt.Errorf("unsupported credential process output version %d, support: %d", resp.Version, processOutputVersion)
+		return
+	}
+
+	if resp.AccessKeyId == nil || resp.AccessKeySecret == nil || *resp.AccessKeyId == "" || *resp.AccessKeySecret == "" {
+		err = errors.New("get credentials from credential process failed: the AccessKeyId or AccessKeySecret is empty")
+		return
+	}
+
+	session = &sessionCredentials{
+		AccessKeyId:     *resp.AccessKeyId,
+		AccessKeySecret: *resp.AccessKeySecret,
+	}
+	if resp.SecurityToken != nil {
+		session.SecurityToken = *resp.SecurityToken
+	}
+	if resp.Expiration != nil {
+		session.Expiration = *resp.Expiration
+	}
+	return
+}
+
+// parseCLIExternalOutput parses the output of the External mode of aliyun cli, only the AK and StsToken modes are supported
+func parseCLIExternalOutput(output []byte) (session *sessionCredentials, err error) {
+	var resp cliExternalResponse
+	err = json.Unmarshal(output, &resp)
+	if err != nil {
+		err = fmt.Errorf("get credentials from external process failed with error, json unmarshal fail: %s", err.Error())
+		return
+	}
+
+	switch resp.Mode {
+	case "AK":
+	case "StsToken":
+		if resp.StsToken == "" {
+			err = errors.New("get credentials from external process failed: the sts_token is empty")
+			return
+		}
+	default:
+		err = fmt.Errorf("unsupported external process output mode '%s', support: AK, StsToken", resp.Mode)
+		return
+	}
+
+	if resp.AccessKeyId == "" || resp.AccessKeySecret == "" {
+		err = errors.New("get credentials from external process failed: the access_key_id or access_key_secret is empty")
+		return
+	}
+
+	session = &sessionCredentials{
+		AccessKeyId:     resp.AccessKeyId,
+		AccessKeySecret: resp.AccessKeySecret,
+		SecurityToken:   resp.StsToken,
+	}
+	if resp.StsExpire > 0 {
+		session.Expiration = time.Unix(resp.StsExpire, 0).UTC().Format(time.RFC3339)
+	}
+	return
+}
+
+func (provider *ProcessCredentialsProvider) needUpdateCredential() (result bool) {
+	if provider.sessionCredentials == nil || provider.invalidated {
+		return true
+	}
+
+	// 没有过期时间的凭证一直有效
+	if provider.expirationTimestamp == 0 {
+		return false
+	}
+
+	return provider.expirationTimestamp-nowOf(provider.clock).Unix() <= 180
+}
+
+func (provider *ProcessCredentialsProvider) GetCredentials() (cc *Credentials, err error) {
+	return provider.GetCredentialsWithContext(context.Background())
+}
+
+func (provider *ProcessCredentialsProvider) GetCredentialsWithContext(ctx context.Context) (cc *Credentials, err error) {
+	provider.mutex.RLock()
+	needUpdate := provider.needUpdateCredential()
+	provider.mutex.RUnlock()
+
+	if needUpdate {
+		err = provider.refreshGroup.do(ctx, provider.updateCredential)
+		if err != nil {
+			return
+		}
+	}
+
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
+	cc = &Credentials{
+		AccessKeyId:     provider.sessionCredentials.AccessKeyId,
+		AccessKeySecret: provider.sessionCredentials.AccessKeySecret,
+		SecurityToken:   provider.sessionCredentials.SecurityToken,
+		ProviderName:    provider.GetProviderName(),
+	}
+	if provider.expirationTimestamp != 0 {
+		cc.Expiration = time.Unix(provider.expirationTimestamp, 0).UTC()
+	}
+	return
+}
+
+func (provider *ProcessCredentialsProvider) updateCredential(ctx context.Context) (err error) {
+	ctx, endRefresh := startRefresh(ctx, provider.instrumentation, provider.clock, provider.GetProviderName())
+	var expirationTimestamp int64
+	defer func() {
+		endRefresh(expirationTimestamp, err)
+		provider.refreshListener.notify(provider.GetProviderName(), expirationTimestamp, err)
+	}()
+
+	sessionCredentials, err := provider.getCredentials(ctx)
+	if err != nil {
+		return
+	}
+
+	if sessionCredentials.Expiration != "" {
+		expirationTime, err1 := time.Parse(time.RFC3339, sessionCredentials.Expiration)
+		if err1 != nil {
+			err = fmt.Errorf("get credentials from credential process failed: invalid Expiration: %s", err1.Error())
+			return
+		}
+		expirationTimestamp = expirationTime.Unix()
+	}
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.expirationTimestamp = expirationTimestamp
+	provider.sessionCredentials = sessionCredentials
+	provider.invalidated = false
+	return
+}
+
+// Invalidate drops the cached credentials, the next GetCredentials() runs the command again
+func (provider *ProcessCredentialsProvider) Invalidate() {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.invalidated = true
+}
+
+func (provider *ProcessCredentialsProvider) GetProviderName() string {
+	return "credential_process"
+}
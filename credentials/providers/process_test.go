@@ -0,0 +1,305 @@
+package providers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/internal/clocktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func skipProcessTestOnWindows(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test commands require sh")
+	}
+}
+
+func TestNewProcessCredentialsProvider(t *testing.T) {
+	_, err := NewProcessCredentialsProviderBuilder().Build()
+	assert.EqualError(t, err, "the command is empty")
+
+	_, err = NewProcessCredentialsProviderBuilder().WithCommand("  ").Build()
+	assert.EqualError(t, err, "the command is empty")
+
+	p, err := NewProcessCredentialsProviderBuilder().WithCommand("broker").Build()
+	assert.Nil(t, err)
+	assert.Equal(t, "broker", p.command)
+	assert.Equal(t, time.Minute, p.timeout)
+	assert.Equal(t, 64*1024, p.maxOutputSize)
+	assert.Equal(t, "credential_process", p.GetProviderName())
+
+	p, err = NewProcessCredentialsProviderBuilder().
+		WithCommand("broker").
+		WithTimeout(time.Second).
+		WithMaxOutputSize(1024).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second, p.timeout)
+	assert.Equal(t, 1024, p.maxOutputSize)
+}
+
+func TestProcessCredentialsProvider_getCredentials(t *testing.T) {
+	skipProcessTestOnWindows(t)
+
+	// case 1: command failed
+	p, err := NewProcessCredentialsProviderBuilder().WithCommand("echo oops >&2; exit 3").Build()
+	assert.Nil(t, err)
+	_, err = p.getCredentials(context.TODO())
+	assert.EqualError(t, err, "run credential process failed: exit status 3, stderr: oops")
+
+	// case 2: timeout
+	p, err = NewProcessCredentialsProviderBuilder().WithCommand("sleep 5").WithTimeout(100 * time.Millisecond).Build()
+	assert.Nil(t, err)
+	_, err = p.getCredentials(context.TODO())
+	assert.EqualError(t, err, "run credential process failed: timeout after 100ms")
+
+	// case 3: cancelled by caller
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = p.getCredentials(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	// case 4: output too large
+	p, err = NewProcessCredentialsProviderBuilder().WithCommand("echo 0123456789").WithMaxOutputSize(5).Build()
+	assert.Nil(t, err)
+	_, err = p.getCredentials(context.TODO())
+	assert.EqualError(t, err, "run credential process failed: the output exceeds the limit of 5 bytes")
+
+	// case 5: invalid json
+	p, err = NewProcessCredentialsProviderBuilder().WithCommand("echo invalid").Build()
+	assert.Nil(t, err)
+	_, err = p.getCredentials(context.TODO())
+	assert.EqualError(t, err, "get credentials from credential process failed with error, json unmarshal fail: invalid character 'i' looking for beginning of value")
+
+	// case 6: unsupported version
+	p, err = NewProcessCredentialsProviderBuilder().WithCommand(`echo '{"Version":2,"AccessKeyId":"akid","AccessKeySecret":"aksecret"}'`).Build()
+	assert.Nil(t, err)
+	_, err = p.getCredentials(context.TODO())
+	assert.EqualError(t, err, "unsupported credential process output version 2, support: 1")
+
+	// case 7: missing ak
+	p, err = NewProcessCredentialsProviderBuilder().WithCommand(`echo '{"Version":1,"AccessKeySecret":"aksecret"}'`).Build()
+	assert.Nil(t, err)
+	_, err = p.getCredentials(context.TODO())
+	assert.EqualError(t, err, "get credentials from credential process failed: the AccessKeyId or AccessKeySecret is empty")
+
+	// case 8: success
+	p, err = NewProcessCredentialsProviderBuilder().
+		WithCommand(`echo '{"Version":1,"AccessKeyId":"akid","AccessKeySecret":"aksecret","SecurityToken":"ststoken","Expiration":"2021-10-20T04:27:09Z"}'`).
+		Build()
+	assert.Nil(t, err)
+	session, err := p.getCredentials(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, &sessionCredentials{
+		AccessKeyId:     "akid",
+		AccessKeySecret: "aksecret",
+		SecurityToken:   "ststoken",
+		Expiration:      "2021-10-20T04:27:09Z",
+	}, session)
+}
+
+func TestParseCLIExternalOutput(t *testing.T) {
+	_, err := parseCLIExternalOutput([]byte("invalid"))
+	assert.EqualError(t, err, "get credentials from external process failed with error, json unmarshal fail: invalid character 'i' looking for beginning of value")
+
+	_, err = parseCLIExternalOutput([]byte(`{"mode":"RamRoleArn","access_key_id":"akid","access_key_secret":"aksecret"}`))
+	assert.EqualError(t, err, "unsupported external process output mode 'RamRoleArn', support: AK, StsToken")
+
+	_, err = parseCLIExternalOutput([]byte(`{"mode":"AK","access_key_id":"akid"}`))
+	assert.EqualError(t, err, "get credentials from external process failed: the access_key_id or access_key_secret is empty")
+
+	_, err = parseCLIExternalOutput([]byte(`{"mode":"StsToken","access_key_id":"STS.akid","access_key_secret":"aksecret"}`))
+	assert.EqualError(t, err, "get credentials from external process failed: the sts_token is empty")
+
+	// the process-provider format is not accepted
+	_, err = parseCLIExternalOutput([]byte(`{"Version":1,"AccessKeyId":"akid","AccessKeySecret":"aksecret"}`))
+	assert.EqualError(t, err, "unsupported external process output mode '', support: AK, StsToken")
+
+	session, err := parseCLIExternalOutput([]byte(`{"mode":"AK","access_key_id":"akid","access_key_secret":"aksecret"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, &sessionCredentials{AccessKeyId: "akid", AccessKeySecret: "aksecret"}, session)
+
+	session, err = parseCLIExternalOutput([]byte(`{"mode":"StsToken","access_key_id":"STS.akid","access_key_secret":"aksecret","sts_token":"ststoken","sts_expiration":1634704029}`))
+	assert.Nil(t, err)
+	assert.Equal(t, &sessionCredentials{
+		AccessKeyId:     "STS.akid",
+		AccessKeySecret: "aksecret",
+		SecurityToken:   "ststoken",
+		Expiration:      "2021-10-20T04:27:09Z",
+	}, session)
+}
+
+func TestProcessCredentialsProviderGetCredentials(t *testing.T) {
+	skipProcessTestOnWindows(t)
+
+	tempDir, err := ioutil.TempDir("", "process_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	counter := path.Join(tempDir, "counter")
+
+	// invalid expiration
+	p, err := NewProcessCredentialsProviderBuilder().
+		WithCommand(`echo '{"Version":1,"AccessKeyId":"akid","AccessKeySecret":"aksecret","Expiration":"invalid"}'`).
+		Build()
+	assert.Nil(t, err)
+	_, err = p.GetCredentials()
+	assert.True(t, strings.HasPrefix(err.Error(), "get credentials from credential process failed: invalid Expiration: "))
+
+	// credentials without expiration are cached forever
+	p, err = NewProcessCredentialsProviderBuilder().
+		WithCommand(`echo x >> ` + counter + `; echo '{"Version":1,"AccessKeyId":"akid","AccessKeySecret":"aksecret"}'`).
+		Build()
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		cc, err := p.GetCredentials()
+		assert.Nil(t, err)
+		assert.Equal(t, &Credentials{AccessKeyId: "akid", AccessKeySecret: "aksecret", ProviderName: "credential_process"}, cc)
+	}
+	content, err := ioutil.ReadFile(counter)
+	assert.Nil(t, err)
+	assert.Equal(t, "x\n", string(content))
+
+	// credentials are cached until expiration
+	os.Remove(counter)
+	expiration := time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+	p, err = NewProcessCredentialsProviderBuilder().
+		WithCommand(`echo x >> ` + counter + `; echo '{"Version":1,"AccessKeyId":"akid","AccessKeySecret":"aksecret","SecurityToken":"ststoken","Expiration":"` + expiration + `"}'`).
+		Build()
+	assert.Nil(t, err)
+	cc, err := p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "ststoken", cc.SecurityToken)
+	assert.Equal(t, expiration, cc.Expiration.Format("2006-01-02T15:04:05Z"))
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	content, err = ioutil.ReadFile(counter)
+	assert.Nil(t, err)
+	assert.Equal(t, "x\n", string(content))
+
+	// refresh when it is about to expire
+	p.mutex.Lock()
+	p.expirationTimestamp = time.Now().Unix() + 60
+	p.mutex.Unlock()
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	content, err = ioutil.ReadFile(counter)
+	assert.Nil(t, err)
+	assert.Equal(t, "x\nx\n", string(content))
+}
+
+func TestProcessCredentialsProviderInvalidate(t *testing.T) {
+	skipProcessTestOnWindows(t)
+
+	tempDir, err := ioutil.TempDir("", "process_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	counter := path.Join(tempDir, "counter")
+
+	p, err := NewProcessCredentialsProviderBuilder().
+		WithCommand(`echo x >> ` + counter + `; echo '{"Version":1,"AccessKeyId":"akid","AccessKeySecret":"aksecret"}'`).
+		Build()
+	assert.Nil(t, err)
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+
+	// the credentials without expiration are got again after invalidated
+	p.Invalidate()
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.False(t, p.invalidated)
+	content, err := ioutil.ReadFile(counter)
+	assert.Nil(t, err)
+	assert.Equal(t, "x\nx\n", string(content))
+
+	// the cached credentials are kept while invalidated concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			cc, err := p.GetCredentials()
+			assert.Nil(t, err)
+			assert.Equal(t, "akid", cc.AccessKeyId)
+		}()
+		go func() {
+			defer wg.Done()
+			p.Invalidate()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestProcessCredentialsProviderRefreshListener(t *testing.T) {
+	skipProcessTestOnWindows(t)
+
+	var refreshErr error
+	p, err := NewProcessCredentialsProviderBuilder().
+		WithCommand("exit 1").
+		WithOnRefreshError(func(providerName string, err error) {
+			assert.Equal(t, "credential_process", providerName)
+			refreshErr = err
+		}).
+		Build()
+	assert.Nil(t, err)
+	_, err = p.GetCredentials()
+	assert.NotNil(t, err)
+	assert.Equal(t, err, refreshErr)
+
+	var refreshed int
+	var expiration time.Time
+	p, err = NewProcessCredentialsProviderBuilder().
+		WithCommand(`echo '{"Version":1,"AccessKeyId":"akid","AccessKeySecret":"aksecret","SecurityToken":"ststoken","Expiration":"2121-10-20T04:27:09Z"}'`).
+		WithOnRefresh(func(providerName string, exp time.Time) {
+			assert.Equal(t, "credential_process", providerName)
+			refreshed++
+			expiration = exp
+		}).
+		Build()
+	assert.Nil(t, err)
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, refreshed)
+	assert.Equal(t, time.Date(2121, 10, 20, 4, 27, 9, 0, time.UTC), expiration)
+}
+
+func TestProcessCredentialsProviderInstrumentation(t *testing.T) {
+	skipProcessTestOnWindows(t)
+
+	instrumentation := &recordingInstrumentation{}
+	p, err := NewProcessCredentialsProviderBuilder().
+		WithCommand("exit 1").
+		WithInstrumentation(instrumentation).
+		Build()
+	assert.Nil(t, err)
+	_, err = p.GetCredentials()
+	assert.NotNil(t, err)
+
+	clock := clocktest.NewFakeClock(time.Date(2121, 10, 20, 3, 27, 9, 0, time.UTC))
+	p, err = NewProcessCredentialsProviderBuilder().
+		WithCommand(`echo '{"Version":1,"AccessKeyId":"akid","AccessKeySecret":"aksecret","SecurityToken":"ststoken","Expiration":"2121-10-20T04:27:09Z"}'`).
+		WithInstrumentation(instrumentation).
+		WithClock(clock).
+		Build()
+	assert.Nil(t, err)
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+
+	// 命令不发送 http 请求，只记录刷新事件
+	assert.Len(t, instrumentation.refreshes, 2)
+	assert.Equal(t, "credential_process", instrumentation.refreshes[0].ProviderName)
+	assert.Equal(t, "error", instrumentation.refreshes[0].Status)
+	assert.Equal(t, "error", instrumentation.refreshes[0].ErrorCode)
+	assert.Equal(t, "credential_process", instrumentation.refreshes[1].ProviderName)
+	assert.Equal(t, "success", instrumentation.refreshes[1].Status)
+	assert.Equal(t, time.Hour, instrumentation.refreshes[1].TimeToExpiry)
+	assert.Len(t, instrumentation.httpRequests, 0)
+}
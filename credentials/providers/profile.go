@@ -1,10 +1,12 @@
 package providers
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path"
+	"sync"
 
 	"github.com/aliyun/credentials-go/credentials/internal/utils"
 	"gopkg.in/ini.v1"
@@ -12,7 +14,10 @@ import (
 
 type ProfileCredentialsProvider struct {
 	profileName   string
+	httpOptions   *HttpOptions
 	innerProvider CredentialsProvider
+	// 保护 innerProvider 的延迟初始化
+	mutex sync.Mutex
 }
 
 type ProfileCredentialsProviderBuilder struct {
@@ -30,6 +35,12 @@ func (b *ProfileCredentialsProviderBuilder) WithProfileName(profileName string)
 	return b
 }
 
+// WithHttpOptions sets the http options of the providers which are created from the profile
+func (b *ProfileCredentialsProviderBuilder) WithHttpOptions(httpOptions *HttpOptions) *ProfileCredentialsProviderBuilder {
+	b.provider.httpOptions = httpOptions
+	return b
+}
+
 func (b *ProfileCredentialsProviderBuilder) Build() (provider *ProfileCredentialsProvider, err error) {
 	// 优先级：
 	// 1. 使用显示指定的 profileName
@@ -44,13 +55,13 @@ func (b *ProfileCredentialsProviderBuilder) Build() (provider *ProfileCredential
 func (provider *ProfileCredentialsProvider) getCredentialsProvider(ini *ini.File) (credentialsProvider CredentialsProvider, err error) {
 	section, err := ini.GetSection(provider.profileName)
 	if err != nil {
-		err = errors.New("ERROR: Can not load section" + err.Error())
+		err = newNotConfiguredError("ERROR: Can not load section" + err.Error())
 		return
 	}
 
 	value, err := section.GetKey("type")
 	if err != nil {
-		err = errors.New("ERROR: Can not find credential type" + err.Error())
+		err = newNotConfiguredError("ERROR: Can not find credential type" + err.Error())
 		return
 	}
 
@@ -59,12 +70,12 @@ func (provider *ProfileCredentialsProvider) getCredentialsProvider(ini *ini.File
 		value1, err1 := section.GetKey("access_key_id")
 		value2, err2 := section.GetKey("access_key_secret")
 		if err1 != nil || err2 != nil {
-			err = errors.New("ERROR: Failed to get value")
+			err = newNotConfiguredError("ERROR: Failed to get value")
 			return
 		}
 
 		if value1.String() == "" || value2.String() == "" {
-			err = errors.New("ERROR: Value can't be empty")
+			err = newNotConfiguredError("ERROR: Value can't be empty")
 			return
 		}
 
@@ -75,21 +86,21 @@ func (provider *ProfileCredentialsProvider) getCredentialsProvider(ini *ini.File
 	case "ecs_ram_role":
 		value1, err1 := section.GetKey("role_name")
 		if err1 != nil {
-			err = errors.New("ERROR: Failed to get value")
+			err = newNotConfiguredError("ERROR: Failed to get value")
 			return
 		}
-		credentialsProvider, err = NewECSRAMRoleCredentialsProviderBuilder().WithRoleName(value1.String()).Build()
+		credentialsProvider, err = NewECSRAMRoleCredentialsProviderBuilder().WithRoleName(value1.String()).WithHttpOptions(provider.httpOptions).Build()
 	case "ram_role_arn":
 		value1, err1 := section.GetKey("access_key_id")
 		value2, err2 := section.GetKey("access_key_secret")
 		value3, err3 := section.GetKey("role_arn")
 		value4, err4 := section.GetKey("role_session_name")
 		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
-			err = errors.New("ERROR: Failed to get value")
+			err = newNotConfiguredError("ERROR: Failed to get value")
 			return
 		}
 		if value1.String() == "" || value2.String() == "" || value3.String() == "" || value4.String() == "" {
-			err = errors.New("ERROR: Value can't be empty")
+			err = newNotConfiguredError("ERROR: Value can't be empty")
 			return
 		}
 		previous, err5 := NewStaticAKCredentialsProviderBuilder().
@@ -112,6 +123,20 @@ func (provider *ProfileCredentialsProvider) getCredentialsProvider(ini *ini.File
 			WithRoleSessionName(value4.String()).
 			WithPolicy(policy).
 			WithDurationSeconds(3600).
+			WithHttpOptions(provider.httpOptions).
+			Build()
+	case "credential_process":
+		value1, err1 := section.GetKey("credential_process")
+		if err1 != nil {
+			err = newNotConfiguredError("ERROR: Failed to get value")
+			return
+		}
+		if value1.String() == "" {
+			err = newNotConfiguredError("ERROR: Value can't be empty")
+			return
+		}
+		credentialsProvider, err = NewProcessCredentialsProviderBuilder().
+			WithCommand(value1.String()).
 			Build()
 	default:
 		err = errors.New("ERROR: Failed to get credential")
@@ -120,38 +145,23 @@ func (provider *ProfileCredentialsProvider) getCredentialsProvider(ini *ini.File
 }
 
 func (provider *ProfileCredentialsProvider) GetCredentials() (cc *Credentials, err error) {
-	if provider.innerProvider == nil {
-		sharedCfgPath := os.Getenv("ALIBABA_CLOUD_CREDENTIALS_FILE")
-		if sharedCfgPath == "" {
-			homeDir := getHomePath()
-			if homeDir == "" {
-				err = fmt.Errorf("cannot found home dir")
-				return
-			}
-
-			sharedCfgPath = path.Join(homeDir, ".alibabacloud/credentials")
-		}
-
-		ini, err1 := ini.Load(sharedCfgPath)
-		if err1 != nil {
-			err = errors.New("ERROR: Can not open file" + err1.Error())
-			return
-		}
+	return provider.GetCredentialsWithContext(context.Background())
+}
 
-		provider.innerProvider, err = provider.getCredentialsProvider(ini)
-		if err != nil {
-			return
-		}
+func (provider *ProfileCredentialsProvider) GetCredentialsWithContext(ctx context.Context) (cc *Credentials, err error) {
+	innerProvider, err := provider.getInnerProvider()
+	if err != nil {
+		return
 	}
 
-	innerCC, err := provider.innerProvider.GetCredentials()
+	innerCC, err := GetCredentialsWithContext(ctx, innerProvider)
 	if err != nil {
 		return
 	}
 
 	providerName := innerCC.ProviderName
 	if providerName == "" {
-		providerName = provider.innerProvider.GetProviderName()
+		providerName = innerProvider.GetProviderName()
 	}
 
 	cc = &Credentials{
@@ -159,11 +169,73 @@ func (provider *ProfileCredentialsProvider) GetCredentials() (cc *Credentials, e
 		AccessKeySecret: innerCC.AccessKeySecret,
 		SecurityToken:   innerCC.SecurityToken,
 		ProviderName:    fmt.Sprintf("%s/%s", provider.GetProviderName(), providerName),
+		Expiration:      innerCC.Expiration,
+	}
+
+	return
+}
+
+// Invalidate drops the cached credentials of the provider of the profile
+func (provider *ProfileCredentialsProvider) Invalidate() {
+	provider.mutex.Lock()
+	innerProvider := provider.innerProvider
+	provider.mutex.Unlock()
+	if innerProvider != nil {
+		InvalidateCredentials(innerProvider)
+	}
+}
+
+func (provider *ProfileCredentialsProvider) getInnerProvider() (innerProvider CredentialsProvider, err error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if provider.innerProvider == nil {
+		sharedCfgPath, err1 := getProfileFilePath()
+		if err1 != nil {
+			err = err1
+			return
+		}
+
+		ini, err1 := ini.Load(sharedCfgPath)
+		if err1 != nil {
+			err = errors.New("ERROR: Can not open file" + err1.Error())
+			if os.IsNotExist(err1) {
+				err = newNotConfiguredError(err.Error())
+			}
+			return
+		}
+
+		provider.innerProvider, err = provider.getCredentialsProvider(ini)
+		if err != nil {
+			return
+		}
 	}
 
+	innerProvider = provider.innerProvider
 	return
 }
 
+// getProfileFilePath returns the path of the profile file, it is specified by ALIBABA_CLOUD_CREDENTIALS_FILE or ~/.alibabacloud/credentials
+func getProfileFilePath() (profileFile string, err error) {
+	profileFile = os.Getenv("ALIBABA_CLOUD_CREDENTIALS_FILE")
+	if profileFile == "" {
+		homeDir := getHomePath()
+		if homeDir == "" {
+			err = fmt.Errorf("cannot found home dir")
+			return
+		}
+
+		profileFile = path.Join(homeDir, ".alibabacloud/credentials")
+	}
+	return
+}
+
+// profileInfo returns the profile name and the path of the profile file for the chain report
+func (provider *ProfileCredentialsProvider) profileInfo() (profileName, profileFile string) {
+	profileFile, _ = getProfileFilePath()
+	return provider.profileName, profileFile
+}
+
 func (provider *ProfileCredentialsProvider) GetProviderName() string {
 	return "profile"
 }
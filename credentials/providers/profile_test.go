@@ -1,6 +1,7 @@
 package providers
 
 import (
+	"context"
 	"os"
 	"path"
 	"testing"
@@ -82,6 +83,17 @@ type = rsa_key_pair
 public_key_id = publicKeyId
 private_key_file = ./pk_error.pem
 
+[process]
+type = credential_process
+credential_process = /path/to/broker --profile dev
+
+[noprocess]
+type = credential_process
+
+[emptyprocess]
+type = credential_process
+credential_process =
+
 [error_type]
 type = error_type
 public_key_id = publicKeyId
@@ -188,6 +200,27 @@ func TestProfileCredentialsProvider_getCredentialsProvider(t *testing.T) {
 	_, ok = cp.(*RAMRoleARNCredentialsProvider)
 	assert.True(t, ok)
 
+	// credential_process without command
+	provider, err = NewProfileCredentialsProviderBuilder().WithProfileName("noprocess").Build()
+	assert.Nil(t, err)
+	_, err = provider.getCredentialsProvider(file)
+	assert.EqualError(t, err, "ERROR: Failed to get value")
+
+	// credential_process with empty command
+	provider, err = NewProfileCredentialsProviderBuilder().WithProfileName("emptyprocess").Build()
+	assert.Nil(t, err)
+	_, err = provider.getCredentialsProvider(file)
+	assert.EqualError(t, err, "ERROR: Value can't be empty")
+
+	// normal credential_process
+	provider, err = NewProfileCredentialsProviderBuilder().WithProfileName("process").Build()
+	assert.Nil(t, err)
+	cp, err = provider.getCredentialsProvider(file)
+	assert.Nil(t, err)
+	pcp, ok := cp.(*ProcessCredentialsProvider)
+	assert.True(t, ok)
+	assert.Equal(t, "/path/to/broker --profile dev", pcp.command)
+
 	// unsupported type
 	provider, err = NewProfileCredentialsProviderBuilder().WithProfileName("error_type").Build()
 	assert.Nil(t, err)
@@ -253,7 +286,7 @@ func TestProfileCredentialsProviderGetCredentials(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, &Credentials{AccessKeyId: "foo", AccessKeySecret: "bar", SecurityToken: "", ProviderName: "profile/static_ak"}, cc)
 
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"Credentials": {"AccessKeyId":"akid","AccessKeySecret":"aksecret","Expiration":"2021-10-20T04:27:09Z","SecurityToken":"ststoken"}}`),
@@ -1,14 +1,15 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
@@ -44,6 +45,17 @@ type HttpOptions struct {
 	ConnectTimeout int
 	// Read timeout, in milliseconds.
 	ReadTimeout int
+	// The client to send the requests, Proxy, ConnectTimeout and ReadTimeout are ignored if it is set, its own timeout is used.
+	Client *http.Client
+	// The transport to send the requests if Client is not set, Proxy and ConnectTimeout are ignored if it is set.
+	// A shared pooled transport is used by default.
+	Transport http.RoundTripper
+	// The retry policy, the requests are not retried if it is not set.
+	Retry *RetryOptions
+	// The instrumentation which receives the events of the refreshes and the http requests.
+	Instrumentation Instrumentation
+	// The logger of the provider, the global logger is used if it is not set.
+	Logger Logger
 }
 
 type RAMRoleARNCredentialsProvider struct {
@@ -64,11 +76,28 @@ type RAMRoleARNCredentialsProvider struct {
 	stsEndpoint string
 	// for http options
 	httpOptions *HttpOptions
+	// for expiry and refresh decisions
+	clock Clock
 	// inner
 	expirationTimestamp  int64
 	lastUpdateTimestamp  int64
 	previousProviderName string
 	sessionCredentials   *sessionCredentials
+	// guards the inner session fields
+	mutex        sync.RWMutex
+	refreshGroup refreshGroup
+	// for async refresh
+	asyncRefreshOptions *AsyncRefreshOptions
+	asyncRefresher      *asyncRefresher
+	refreshListener     refreshListener
+	// for file cache
+	fileCacheEnabled bool
+	fileCacheDir     string
+	fileCache        *stsFileCache
+	// the role session name is generated, it is replaced by a fixed marker in the cache key
+	roleSessionNameGenerated bool
+	// the cached credentials are rejected by the service, the file cache is skipped until the next refresh
+	invalidated bool
 }
 
 type RAMRoleARNCredentialsProviderBuilder struct {
@@ -141,11 +170,44 @@ func (builder *RAMRoleARNCredentialsProviderBuilder) WithDurationSeconds(duratio
 	return builder
 }
 
+// WithOnRefresh sets the callback which is called after the credentials are refreshed
+func (builder *RAMRoleARNCredentialsProviderBuilder) WithOnRefresh(onRefresh OnRefreshFunc) *RAMRoleARNCredentialsProviderBuilder {
+	builder.provider.refreshListener.onRefresh = onRefresh
+	return builder
+}
+
+// WithOnRefreshError sets the callback which is called after the credentials failed to refresh
+func (builder *RAMRoleARNCredentialsProviderBuilder) WithOnRefreshError(onRefreshError OnRefreshErrorFunc) *RAMRoleARNCredentialsProviderBuilder {
+	builder.provider.refreshListener.onRefreshError = onRefreshError
+	return builder
+}
+
 func (builder *RAMRoleARNCredentialsProviderBuilder) WithHttpOptions(httpOptions *HttpOptions) *RAMRoleARNCredentialsProviderBuilder {
 	builder.provider.httpOptions = httpOptions
 	return builder
 }
 
+// WithAsyncRefresh enables refreshing the credentials in background, call Close() to stop it
+func (builder *RAMRoleARNCredentialsProviderBuilder) WithAsyncRefresh(options *AsyncRefreshOptions) *RAMRoleARNCredentialsProviderBuilder {
+	builder.provider.asyncRefreshOptions = options
+	return builder
+}
+
+// WithFileCache enables caching the sts credentials on disk to share them between processes, dir defaults to ~/.aliyun/cache.
+// The cache is keyed by the role session name, the processes which use the generated role session name share the cache,
+// so the cached credentials may have the role session name generated by another process.
+func (builder *RAMRoleARNCredentialsProviderBuilder) WithFileCache(dir string) *RAMRoleARNCredentialsProviderBuilder {
+	builder.provider.fileCacheEnabled = true
+	builder.provider.fileCacheDir = dir
+	return builder
+}
+
+// WithClock sets the clock to decide whether the credentials are expired or due for a refresh, default is the system clock
+func (builder *RAMRoleARNCredentialsProviderBuilder) WithClock(clock Clock) *RAMRoleARNCredentialsProviderBuilder {
+	builder.provider.clock = clock
+	return builder
+}
+
 func (builder *RAMRoleARNCredentialsProviderBuilder) Build() (provider *RAMRoleARNCredentialsProvider, err error) {
 	if builder.provider.credentialsProvider == nil {
 		if builder.provider.accessKeyId != "" && builder.provider.accessKeySecret != "" && builder.provider.securityToken != "" {
@@ -166,7 +228,7 @@ func (builder *RAMRoleARNCredentialsProviderBuilder) Build() (provider *RAMRoleA
 				return
 			}
 		} else {
-			err = errors.New("must specify a previous credentials provider to assume role")
+			err = newNotConfiguredError("must specify a previous credentials provider to assume role")
 			return
 		}
 	}
@@ -175,7 +237,7 @@ func (builder *RAMRoleARNCredentialsProviderBuilder) Build() (provider *RAMRoleA
 		if roleArn := os.Getenv("ALIBABA_CLOUD_ROLE_ARN"); roleArn != "" {
 			builder.provider.roleArn = roleArn
 		} else {
-			err = errors.New("the RoleArn is empty")
+			err = newNotConfiguredError("the RoleArn is empty")
 			return
 		}
 	}
@@ -184,7 +246,8 @@ func (builder *RAMRoleARNCredentialsProviderBuilder) Build() (provider *RAMRoleA
 		if roleSessionName := os.Getenv("ALIBABA_CLOUD_ROLE_SESSION_NAME"); roleSessionName != "" {
 			builder.provider.roleSessionName = roleSessionName
 		} else {
-			builder.provider.roleSessionName = "credentials-go-" + strconv.FormatInt(time.Now().UnixNano()/1000, 10)
+			builder.provider.roleSessionName = "credentials-go-" + strconv.FormatInt(nowOf(builder.provider.clock).UnixNano()/1000, 10)
+			builder.provider.roleSessionNameGenerated = true
 		}
 	}
 
@@ -217,11 +280,27 @@ func (builder *RAMRoleARNCredentialsProviderBuilder) Build() (provider *RAMRoleA
 		}
 	}
 
+	if builder.provider.fileCacheEnabled {
+		fileCache, err1 := newSTSFileCache(builder.provider.fileCacheDir)
+		if err1 != nil {
+			err = err1
+			return
+		}
+		builder.provider.fileCache = fileCache
+	}
+
+	if builder.provider.asyncRefreshOptions != nil {
+		p := builder.provider
+		p.asyncRefresher = newAsyncRefresher(p.asyncRefreshOptions, p.clock, func(ctx context.Context) error {
+			return p.refreshGroup.do(ctx, p.updateCredential)
+		}, p.getExpirationTimestamp)
+	}
+
 	provider = builder.provider
 	return
 }
 
-func (provider *RAMRoleARNCredentialsProvider) getCredentials(cc *Credentials) (session *sessionCredentials, err error) {
+func (provider *RAMRoleARNCredentialsProvider) getCredentials(ctx context.Context, cc *Credentials) (session *sessionCredentials, err error) {
 	method := "POST"
 	req := &httputil.Request{
 		Method:   method,
@@ -234,15 +313,14 @@ func (provider *RAMRoleARNCredentialsProvider) getCredentials(cc *Credentials) (
 	queries["Version"] = "2015-04-01"
 	queries["Action"] = "AssumeRole"
 	queries["Format"] = "JSON"
-	queries["Timestamp"] = utils.GetTimeInFormatISO8601()
 	queries["SignatureMethod"] = "HMAC-SHA1"
 	queries["SignatureVersion"] = "1.0"
-	queries["SignatureNonce"] = utils.GetNonce()
 	queries["AccessKeyId"] = cc.AccessKeyId
 
 	if cc.SecurityToken != "" {
 		queries["SecurityToken"] = cc.SecurityToken
 	}
+	req.Queries = queries
 
 	bodyForm := make(map[string]string)
 	bodyForm["RoleArn"] = provider.roleArn
@@ -256,25 +334,24 @@ func (provider *RAMRoleARNCredentialsProvider) getCredentials(cc *Credentials) (
 	bodyForm["DurationSeconds"] = strconv.Itoa(provider.durationSeconds)
 	req.Form = bodyForm
 
-	// caculate signature
-	signParams := make(map[string]string)
-	for key, value := range queries {
-		signParams[key] = value
-	}
-	for key, value := range bodyForm {
-		signParams[key] = value
-	}
+	// 每次重试都需要更新时间戳和随机数，并重新计算签名
+	sign := func(req *httputil.Request) {
+		req.Queries["Timestamp"] = utils.FormatTimeInISO8601(nowOf(provider.clock))
+		req.Queries["SignatureNonce"] = utils.GetNonce()
+		delete(req.Queries, "Signature")
 
-	stringToSign := utils.GetURLFormedMap(signParams)
-	stringToSign = strings.Replace(stringToSign, "+", "%20", -1)
-	stringToSign = strings.Replace(stringToSign, "*", "%2A", -1)
-	stringToSign = strings.Replace(stringToSign, "%7E", "~", -1)
-	stringToSign = url.QueryEscape(stringToSign)
-	stringToSign = method + "&%2F&" + stringToSign
-	secret := cc.AccessKeySecret + "&"
-	queries["Signature"] = utils.ShaHmac1(stringToSign, secret)
+		// caculate signature
+		signParams := make(map[string]string)
+		for key, value := range req.Queries {
+			signParams[key] = value
+		}
+		for key, value := range req.Form {
+			signParams[key] = value
+		}
 
-	req.Queries = queries
+		stringToSign := utils.GetRPCStringToSign(method, signParams)
+		req.Queries["Signature"] = utils.GetRPCSignature(stringToSign, cc.AccessKeySecret)
+	}
 
 	// set headers
 	req.Headers["Accept-Encoding"] = "identity"
@@ -293,16 +370,21 @@ func (provider *RAMRoleARNCredentialsProvider) getCredentials(cc *Credentials) (
 	if provider.httpOptions != nil && provider.httpOptions.Proxy != "" {
 		req.Proxy = provider.httpOptions.Proxy
 	}
+	if provider.httpOptions != nil {
+		req.Client = provider.httpOptions.Client
+		req.Transport = provider.httpOptions.Transport
+		req.Logger = provider.httpOptions.Logger
+	}
 	req.ConnectTimeout = connectTimeout
 	req.ReadTimeout = readTimeout
 
-	res, err := httpDo(req)
+	res, err := doWithRetry(ctx, req, provider.httpOptions, sign)
 	if err != nil {
 		return
 	}
 
 	if res.StatusCode != http.StatusOK {
-		err = errors.New("refresh session token failed: " + string(res.Body))
+		err = newServiceError(req, res, "refresh session token failed: "+string(res.Body))
 		return
 	}
 	var data assumeRoleResponse
@@ -335,41 +417,117 @@ func (provider *RAMRoleARNCredentialsProvider) needUpdateCredential() (result bo
 		return true
 	}
 
-	return provider.expirationTimestamp-time.Now().Unix() <= 180
+	return provider.expirationTimestamp-nowOf(provider.clock).Unix() <= provider.asyncRefresher.staleSeconds()
 }
 
 func (provider *RAMRoleARNCredentialsProvider) GetCredentials() (cc *Credentials, err error) {
-	if provider.sessionCredentials == nil || provider.needUpdateCredential() {
-		// 获取前置凭证
-		previousCredentials, err1 := provider.credentialsProvider.GetCredentials()
-		if err1 != nil {
-			return nil, err1
-		}
-		sessionCredentials, err2 := provider.getCredentials(previousCredentials)
-		if err2 != nil {
-			return nil, err2
-		}
+	return provider.GetCredentialsWithContext(context.Background())
+}
+
+func (provider *RAMRoleARNCredentialsProvider) GetCredentialsWithContext(ctx context.Context) (cc *Credentials, err error) {
+	provider.mutex.RLock()
+	needUpdate := provider.sessionCredentials == nil || provider.needUpdateCredential()
+	provider.mutex.RUnlock()
 
-		expirationTime, err := time.Parse("2006-01-02T15:04:05Z", sessionCredentials.Expiration)
+	// 刷新失败后的重试间隔内直接使用尚未过期的缓存凭证，避免每次调用都等待失败的刷新
+	if needUpdate && !provider.asyncRefresher.inRetryBackoff(provider.getExpirationTimestamp()) {
+		err = provider.refreshGroup.do(ctx, provider.updateCredential)
+		provider.asyncRefresher.recordRefresh(ctx, err)
 		if err != nil {
-			return nil, err
+			// 异步刷新模式下，刷新失败时继续使用尚未过期的缓存凭证
+			provider.mutex.RLock()
+			canUseStaleSession := provider.asyncRefresher.canUseStaleSession(provider.expirationTimestamp)
+			provider.mutex.RUnlock()
+			if !canUseStaleSession {
+				return
+			}
+			err = nil
 		}
-
-		provider.expirationTimestamp = expirationTime.Unix()
-		provider.lastUpdateTimestamp = time.Now().Unix()
-		provider.previousProviderName = previousCredentials.ProviderName
-		provider.sessionCredentials = sessionCredentials
 	}
+	provider.asyncRefresher.start()
 
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
 	cc = &Credentials{
 		AccessKeyId:     provider.sessionCredentials.AccessKeyId,
 		AccessKeySecret: provider.sessionCredentials.AccessKeySecret,
 		SecurityToken:   provider.sessionCredentials.SecurityToken,
 		ProviderName:    fmt.Sprintf("%s/%s", provider.GetProviderName(), provider.previousProviderName),
+		Expiration:      time.Unix(provider.expirationTimestamp, 0).UTC(),
 	}
 	return
 }
 
+func (provider *RAMRoleARNCredentialsProvider) updateCredential(ctx context.Context) (err error) {
+	ctx, endRefresh := startRefresh(ctx, getInstrumentation(provider.httpOptions), provider.clock, provider.GetProviderName())
+	defer func() {
+		expirationTimestamp := provider.getExpirationTimestamp()
+		endRefresh(expirationTimestamp, err)
+		provider.refreshListener.notify(provider.GetProviderName(), expirationTimestamp, err)
+	}()
+
+	// 获取前置凭证
+	previousCredentials, err := GetCredentialsWithContext(ctx, provider.credentialsProvider)
+	if err != nil {
+		return
+	}
+
+	// 优先使用其他进程缓存在磁盘上的凭证，缓存的凭证被服务端拒绝后不再使用
+	provider.mutex.RLock()
+	invalidated := provider.invalidated
+	provider.mutex.RUnlock()
+	cacheKey := stsFileCacheKey(provider.GetProviderName(), provider.roleArn, cacheKeySessionName(provider.roleSessionName, provider.roleSessionNameGenerated), hashPolicy(provider.policy), provider.externalId, previousCredentials.AccessKeyId)
+	var sessionCredentials *sessionCredentials
+	var expirationTimestamp int64
+	if !invalidated {
+		sessionCredentials, expirationTimestamp = provider.fileCache.get(cacheKey, nowOf(provider.clock), provider.asyncRefresher.prefetchSeconds())
+	}
+	if sessionCredentials == nil {
+		sessionCredentials, err = provider.getCredentials(ctx, previousCredentials)
+		if err != nil {
+			return
+		}
+
+		expirationTime, err1 := time.Parse("2006-01-02T15:04:05Z", sessionCredentials.Expiration)
+		if err1 != nil {
+			err = err1
+			return
+		}
+		expirationTimestamp = expirationTime.Unix()
+		// 缓存写入失败不影响获取凭证
+		provider.fileCache.put(cacheKey, sessionCredentials)
+	}
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.expirationTimestamp = expirationTimestamp
+	provider.lastUpdateTimestamp = nowOf(provider.clock).Unix()
+	provider.previousProviderName = previousCredentials.ProviderName
+	provider.sessionCredentials = sessionCredentials
+	provider.invalidated = false
+	return
+}
+
+func (provider *RAMRoleARNCredentialsProvider) getExpirationTimestamp() int64 {
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
+	return provider.expirationTimestamp
+}
+
+// Invalidate drops the cached credentials, the next GetCredentials() gets new credentials from the service
+func (provider *RAMRoleARNCredentialsProvider) Invalidate() {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.expirationTimestamp = 0
+	provider.invalidated = true
+}
+
 func (provider *RAMRoleARNCredentialsProvider) GetProviderName() string {
 	return "ram_role_arn"
 }
+
+// Close stops the background refresh, it is a no-op if async refresh is not enabled
+func (provider *RAMRoleARNCredentialsProvider) Close() error {
+	provider.asyncRefresher.close()
+	return nil
+}
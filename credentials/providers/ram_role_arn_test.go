@@ -1,12 +1,17 @@
 package providers
 
 import (
+	"context"
 	"errors"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/aliyun/credentials-go/credentials/internal/clocktest"
 	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
 	"github.com/aliyun/credentials-go/credentials/internal/utils"
 	"github.com/stretchr/testify/assert"
@@ -189,16 +194,16 @@ func TestRAMRoleARNCredentialsProvider_getCredentials(t *testing.T) {
 	assert.Nil(t, err)
 
 	// case 1: server error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
-	_, err = p.getCredentials(cc)
+	_, err = p.getCredentials(context.TODO(), cc)
 	assert.NotNil(t, err)
 	assert.Equal(t, "mock server error", err.Error())
 
 	// case 2: 4xx error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 400,
 			Body:       []byte("4xx error"),
@@ -206,55 +211,58 @@ func TestRAMRoleARNCredentialsProvider_getCredentials(t *testing.T) {
 		return
 	}
 
-	_, err = p.getCredentials(cc)
+	_, err = p.getCredentials(context.TODO(), cc)
 	assert.NotNil(t, err)
 	assert.Equal(t, "refresh session token failed: 4xx error", err.Error())
+	var serviceErr *ServiceError
+	assert.True(t, errors.As(err, &serviceErr))
+	assert.Equal(t, 400, serviceErr.StatusCode)
 
 	// case 3: invalid json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte("invalid json"),
 		}
 		return
 	}
-	_, err = p.getCredentials(cc)
+	_, err = p.getCredentials(context.TODO(), cc)
 	assert.NotNil(t, err)
 	assert.Equal(t, "refresh RoleArn sts token err, json.Unmarshal fail: invalid character 'i' looking for beginning of value", err.Error())
 
 	// case 4: empty response json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte("null"),
 		}
 		return
 	}
-	_, err = p.getCredentials(cc)
+	_, err = p.getCredentials(context.TODO(), cc)
 	assert.NotNil(t, err)
 	assert.Equal(t, "refresh RoleArn sts token err, fail to get credentials", err.Error())
 
 	// case 5: empty session ak response json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"Credentials": {}}`),
 		}
 		return
 	}
-	_, err = p.getCredentials(cc)
+	_, err = p.getCredentials(context.TODO(), cc)
 	assert.NotNil(t, err)
 	assert.Equal(t, "refresh RoleArn sts token err, fail to get credentials", err.Error())
 
 	// case 6: mock ok value
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"Credentials": {"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"2021-10-20T04:27:09Z","SecurityToken":"token"}}`),
 		}
 		return
 	}
-	creds, err := p.getCredentials(cc)
+	creds, err := p.getCredentials(context.TODO(), cc)
 	assert.Nil(t, err)
 	assert.Equal(t, "saki", creds.AccessKeyId)
 	assert.Equal(t, "saks", creds.AccessKeySecret)
@@ -292,7 +300,7 @@ func TestRAMRoleARNCredentialsProvider_getCredentialsWithRequestCheck(t *testing
 	assert.Nil(t, err)
 
 	// case 1: server error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		assert.Equal(t, "sts.cn-beijing.aliyuncs.com", req.Host)
 		assert.Equal(t, "ststoken", req.Queries["SecurityToken"])
 		assert.Equal(t, "policy", req.Form["Policy"])
@@ -306,7 +314,7 @@ func TestRAMRoleARNCredentialsProvider_getCredentialsWithRequestCheck(t *testing
 
 	cc, err := stsProvider.GetCredentials()
 	assert.Nil(t, err)
-	_, err = p.getCredentials(cc)
+	_, err = p.getCredentials(context.TODO(), cc)
 	assert.NotNil(t, err)
 	assert.Equal(t, "mock server error", err.Error())
 }
@@ -353,7 +361,7 @@ func TestRAMRoleARNCredentialsProviderGetCredentials(t *testing.T) {
 	assert.Nil(t, err)
 
 	// case 1: get credentials failed
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
@@ -362,7 +370,7 @@ func TestRAMRoleARNCredentialsProviderGetCredentials(t *testing.T) {
 	assert.Equal(t, "mock server error", err.Error())
 
 	// case 2: get invalid expiration
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"Credentials": {"AccessKeyId":"akid","AccessKeySecret":"aksecret","Expiration":"invalidexpiration","SecurityToken":"ststoken"}}`),
@@ -374,7 +382,7 @@ func TestRAMRoleARNCredentialsProviderGetCredentials(t *testing.T) {
 	assert.Equal(t, "parsing time \"invalidexpiration\" as \"2006-01-02T15:04:05Z\": cannot parse \"invalidexpiration\" as \"2006\"", err.Error())
 
 	// case 3: happy result
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"Credentials": {"AccessKeyId":"akid","AccessKeySecret":"aksecret","Expiration":"2021-10-20T04:27:09Z","SecurityToken":"ststoken"}}`),
@@ -387,6 +395,7 @@ func TestRAMRoleARNCredentialsProviderGetCredentials(t *testing.T) {
 	assert.Equal(t, "aksecret", cc.AccessKeySecret)
 	assert.Equal(t, "ststoken", cc.SecurityToken)
 	assert.Equal(t, "ram_role_arn/static_ak", cc.ProviderName)
+	assert.Equal(t, time.Date(2021, 10, 20, 4, 27, 9, 0, time.UTC), cc.Expiration)
 	assert.True(t, p.needUpdateCredential())
 	// get credentials again
 	cc, err = p.GetCredentials()
@@ -453,3 +462,147 @@ func TestRAMRoleARNCredentialsProviderWithHttpOptions(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "proxyconnect tcp:")
 }
+
+func TestRAMRoleARNCredentialsProviderGetCredentialsConcurrently(t *testing.T) {
+	var count int32
+	_, rollback := mockLocalEndpoint(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		time.Sleep(50 * time.Millisecond)
+		expiration := time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		w.Write([]byte(`{"Credentials": {"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"` + expiration + `","SecurityToken":"token"}}`))
+	})
+	defer rollback()
+
+	p, err := NewRAMRoleARNCredentialsProviderBuilder().
+		WithAccessKeyId("akid").
+		WithAccessKeySecret("aksecret").
+		WithRoleArn("roleArn").
+		WithRoleSessionName("rsn").
+		Build()
+	assert.Nil(t, err)
+
+	hammer(t, p, func(cc *Credentials) {
+		assert.Equal(t, "saki", cc.AccessKeyId)
+		assert.Equal(t, "saks", cc.AccessKeySecret)
+		assert.Equal(t, "token", cc.SecurityToken)
+		assert.Equal(t, "ram_role_arn/static_ak", cc.ProviderName)
+	})
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+func TestRAMRoleARNCredentialsProviderGetCredentialsWithFileCache(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ram_role_arn_cache_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	var count int32
+	_, rollback := mockLocalEndpoint(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		expiration := time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		w.Write([]byte(`{"Credentials": {"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"` + expiration + `","SecurityToken":"token"}}`))
+	})
+	defer rollback()
+
+	newProvider := func(accessKeyId string) *RAMRoleARNCredentialsProvider {
+		p, err := NewRAMRoleARNCredentialsProviderBuilder().
+			WithAccessKeyId(accessKeyId).
+			WithAccessKeySecret("aksecret").
+			WithRoleArn("roleArn").
+			WithRoleSessionName("rsn").
+			WithFileCache(tempDir).
+			Build()
+		assert.Nil(t, err)
+		return p
+	}
+
+	// the first provider calls sts and writes the cache
+	cc, err := newProvider("akid").GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "saki", cc.AccessKeyId)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+
+	// another provider, like a new process, reads the cache
+	cc, err = newProvider("akid").GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "saki", cc.AccessKeyId)
+	assert.Equal(t, "token", cc.SecurityToken)
+	assert.Equal(t, "ram_role_arn/static_ak", cc.ProviderName)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+
+	// a different source identity does not share the cache
+	_, err = newProvider("akid2").GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+
+	// the invalidated credentials are not read from the cache again
+	p := newProvider("akid")
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+	p.Invalidate()
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&count))
+	assert.False(t, p.invalidated)
+
+	// the providers with the generated role session names share the cache
+	for i := 0; i < 2; i++ {
+		p, err := NewRAMRoleARNCredentialsProviderBuilder().
+			WithAccessKeyId("akid").
+			WithAccessKeySecret("aksecret").
+			WithRoleArn("roleArn").
+			WithClock(clocktest.NewFakeClock(time.Now().Add(time.Duration(i) * time.Second))).
+			WithFileCache(tempDir).
+			Build()
+		assert.Nil(t, err)
+		assert.True(t, p.roleSessionNameGenerated)
+		_, err = p.GetCredentials()
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&count))
+}
+
+func TestRAMRoleARNCredentialsProviderWithClock(t *testing.T) {
+	originHttpDo := httpDo
+	defer func() { httpDo = originHttpDo }()
+
+	clock := clocktest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	p, err := NewRAMRoleARNCredentialsProviderBuilder().
+		WithAccessKeyId("akid").
+		WithAccessKeySecret("aksecret").
+		WithRoleArn("roleArn").
+		WithClock(clock).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, "credentials-go-1704067200000000", p.roleSessionName)
+
+	var calls int
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
+		calls++
+		assert.Equal(t, utils.FormatTimeInISO8601(clock.Now()), req.Queries["Timestamp"])
+		expiration := clock.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		res = &httputil.Response{
+			StatusCode: 200,
+			Body:       []byte(`{"Credentials":{"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"` + expiration + `","SecurityToken":"token"}}`),
+		}
+		return
+	}
+
+	cc, err := p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC), cc.Expiration)
+	assert.Equal(t, int64(1704067200), p.lastUpdateTimestamp)
+	assert.Equal(t, 1, calls)
+
+	// the credentials are refreshed when they expire in 180 seconds
+	clock.Advance(57*time.Minute - time.Millisecond)
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+
+	clock.Advance(time.Millisecond)
+	cc, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, time.Date(2024, 1, 1, 1, 57, 0, 0, time.UTC), cc.Expiration)
+}
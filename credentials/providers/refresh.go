@@ -0,0 +1,273 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type refreshCall struct {
+	done chan struct{}
+	err  error
+}
+
+// refreshGroup collapses concurrent refreshes into a single in-flight call
+type refreshGroup struct {
+	mutex sync.Mutex
+	call  *refreshCall
+}
+
+// do runs fn if there is no refresh in flight, otherwise it waits for the in-flight one and shares its result.
+// A waiter whose ctx is done returns early. If the in-flight call was aborted by the context of its own caller,
+// the waiter retries with its own ctx instead of sharing that error.
+func (group *refreshGroup) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	for {
+		group.mutex.Lock()
+		call := group.call
+		if call == nil {
+			call = &refreshCall{
+				done: make(chan struct{}),
+			}
+			group.call = call
+			group.mutex.Unlock()
+
+			call.err = fn(ctx)
+
+			group.mutex.Lock()
+			group.call = nil
+			group.mutex.Unlock()
+			close(call.done)
+			return call.err
+		}
+		group.mutex.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if ctx.Err() == nil && (errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded)) {
+			continue
+		}
+		return call.err
+	}
+}
+
+// OnRefreshFunc is called after a provider refreshed the credentials, expiration is the expiration of the new credentials.
+// It is called synchronously after the new credentials are cached, so GetCredentials() of the provider returns them,
+// the refresh is not finished until it returns, so it should return quickly.
+type OnRefreshFunc func(providerName string, expiration time.Time)
+
+// OnRefreshErrorFunc is called after a provider failed to refresh the credentials
+type OnRefreshErrorFunc func(providerName string, err error)
+
+// refreshListener holds the callbacks of the refreshes of a provider
+type refreshListener struct {
+	onRefresh      OnRefreshFunc
+	onRefreshError OnRefreshErrorFunc
+}
+
+func (listener *refreshListener) notify(providerName string, expirationTimestamp int64, err error) {
+	if err != nil {
+		if listener.onRefreshError != nil {
+			listener.onRefreshError(providerName, err)
+		}
+		return
+	}
+
+	if listener.onRefresh != nil {
+		var expiration time.Time
+		if expirationTimestamp != 0 {
+			expiration = time.Unix(expirationTimestamp, 0).UTC()
+		}
+		listener.onRefresh(providerName, expiration)
+	}
+}
+
+// AsyncRefreshOptions enables refreshing the session credentials in background ahead of their expiration.
+// In this mode a failed refresh does not fail GetCredentials(), the cached credentials are returned until they are expired,
+// and the refresh is not tried again by GetCredentials() until the retry interval (10 seconds) elapses.
+type AsyncRefreshOptions struct {
+	// How long before the expiration the credentials are refreshed in background, default is 5 minutes.
+	// A random jitter of up to 20% of this window is added to spread the refreshes of many processes.
+	PrefetchTime time.Duration
+	// How long before the expiration the cached credentials become stale, default is 3 minutes.
+	// Stale credentials are refreshed synchronously by the caller.
+	StaleTime time.Duration
+}
+
+const (
+	defaultPrefetchTime = 5 * time.Minute
+	defaultStaleTime    = 180 * time.Second
+)
+
+// the delay before retrying a failed background refresh
+var asyncRefreshRetryInterval = 10 * time.Second
+
+type asyncRefresher struct {
+	prefetchTime time.Duration
+	staleTime    time.Duration
+	clock        Clock
+	// refresh the session, it should be collapsed with the synchronous refreshes
+	refresh func(ctx context.Context) error
+	// get the expiration timestamp of the cached session
+	expiration func() int64
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	mutex   sync.Mutex
+	started bool
+	closed  bool
+	// the time of the last failed refresh, guarded by mutex
+	failedAt time.Time
+}
+
+func newAsyncRefresher(options *AsyncRefreshOptions, clock Clock, refresh func(ctx context.Context) error, expiration func() int64) *asyncRefresher {
+	ctx, cancel := context.WithCancel(context.Background())
+	refresher := &asyncRefresher{
+		prefetchTime: options.PrefetchTime,
+		staleTime:    options.StaleTime,
+		clock:        clock,
+		refresh:      refresh,
+		expiration:   expiration,
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}
+
+	if refresher.staleTime <= 0 {
+		refresher.staleTime = defaultStaleTime
+	}
+
+	if refresher.prefetchTime <= 0 {
+		refresher.prefetchTime = defaultPrefetchTime
+	}
+
+	if refresher.prefetchTime < refresher.staleTime {
+		refresher.prefetchTime = refresher.staleTime
+	}
+
+	return refresher
+}
+
+// staleSeconds returns how many seconds before the expiration the session needs a synchronous refresh
+func (refresher *asyncRefresher) staleSeconds() int64 {
+	if refresher == nil {
+		return int64(defaultStaleTime / time.Second)
+	}
+	return int64(refresher.staleTime / time.Second)
+}
+
+// prefetchSeconds returns how many seconds before the expiration the session is due for a refresh
+func (refresher *asyncRefresher) prefetchSeconds() int64 {
+	if refresher == nil {
+		return int64(defaultStaleTime / time.Second)
+	}
+	return int64(refresher.prefetchTime / time.Second)
+}
+
+// canUseStaleSession reports whether a session which failed to refresh can still be returned
+func (refresher *asyncRefresher) canUseStaleSession(expirationTimestamp int64) bool {
+	return refresher != nil && expirationTimestamp > nowOf(refresher.clock).Unix()
+}
+
+// inRetryBackoff reports whether a refresh failed within the retry interval and the session is not expired yet,
+// the stale session is returned without refreshing again, so that a failing service does not add latency to every call
+func (refresher *asyncRefresher) inRetryBackoff(expirationTimestamp int64) bool {
+	if !refresher.canUseStaleSession(expirationTimestamp) {
+		return false
+	}
+
+	refresher.mutex.Lock()
+	defer refresher.mutex.Unlock()
+	return !refresher.failedAt.IsZero() && nowOf(refresher.clock).Sub(refresher.failedAt) < asyncRefreshRetryInterval
+}
+
+// recordRefresh records the result of a refresh, the refresh aborted by ctx is not counted as a failure
+func (refresher *asyncRefresher) recordRefresh(ctx context.Context, err error) {
+	if refresher == nil || (err != nil && ctx.Err() != nil) {
+		return
+	}
+
+	refresher.mutex.Lock()
+	defer refresher.mutex.Unlock()
+	if err != nil {
+		refresher.failedAt = nowOf(refresher.clock)
+	} else {
+		refresher.failedAt = time.Time{}
+	}
+}
+
+// start the background refresh loop, it is a no-op if the loop is running or closed
+func (refresher *asyncRefresher) start() {
+	if refresher == nil {
+		return
+	}
+
+	refresher.mutex.Lock()
+	defer refresher.mutex.Unlock()
+	if refresher.started || refresher.closed {
+		return
+	}
+	refresher.started = true
+	go refresher.loop()
+}
+
+func (refresher *asyncRefresher) loop() {
+	defer close(refresher.done)
+
+	delay := refresher.nextRefreshDelay(0)
+	for {
+		// 由时钟驱动等待，使用假时钟时推进时钟即可触发刷新
+		wait, stop := afterOf(refresher.clock, delay)
+		select {
+		case <-refresher.ctx.Done():
+			stop()
+			return
+		case <-wait:
+		}
+
+		err := refresher.refresh(refresher.ctx)
+		refresher.recordRefresh(refresher.ctx, err)
+		if err != nil {
+			delay = asyncRefreshRetryInterval
+			continue
+		}
+		// 避免服务端返回的有效期短于预取窗口时频繁刷新
+		delay = refresher.nextRefreshDelay(asyncRefreshRetryInterval)
+	}
+}
+
+func (refresher *asyncRefresher) nextRefreshDelay(minDelay time.Duration) time.Duration {
+	jitter := time.Duration(rand.Int63n(int64(refresher.prefetchTime)/5 + 1))
+	delay := time.Unix(refresher.expiration(), 0).Sub(nowOf(refresher.clock)) - refresher.prefetchTime - jitter
+	if delay < minDelay {
+		delay = minDelay
+	}
+	return delay
+}
+
+// close stops the background refresh loop and waits for it to exit
+func (refresher *asyncRefresher) close() {
+	if refresher == nil {
+		return
+	}
+
+	refresher.mutex.Lock()
+	if refresher.closed {
+		refresher.mutex.Unlock()
+		return
+	}
+	refresher.closed = true
+	started := refresher.started
+	refresher.mutex.Unlock()
+
+	refresher.cancel()
+	if started {
+		<-refresher.done
+	}
+}
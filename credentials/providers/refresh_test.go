@@ -0,0 +1,330 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/internal/clocktest"
+	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockLocalEndpoint starts a local server with handler, and sends all requests of the providers to it.
+// The returned rollback stops the server and restores httpDo.
+func mockLocalEndpoint(handler http.HandlerFunc) (server *httptest.Server, rollback func()) {
+	server = httptest.NewServer(handler)
+	serverURL, _ := url.Parse(server.URL)
+	originHttpDo := httpDo
+	httpDo = func(ctx context.Context, req *httputil.Request) (*httputil.Response, error) {
+		if req.URL == "" {
+			req.Protocol = "http"
+			req.Host = serverURL.Host
+		}
+		return httputil.DoWithContext(ctx, req)
+	}
+	rollback = func() {
+		httpDo = originHttpDo
+		server.Close()
+	}
+	return
+}
+
+// hammer calls GetCredentials from many goroutines at the same time and checks every result
+func hammer(t *testing.T, provider CredentialsProvider, check func(cc *Credentials)) {
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cc, err := provider.GetCredentials()
+			assert.Nil(t, err)
+			if cc != nil {
+				check(cc)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRefreshGroup(t *testing.T) {
+	var group refreshGroup
+	var calls int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			err := group.do(context.Background(), func(ctx context.Context) error {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(50 * time.Millisecond)
+				return errors.New("refresh failed")
+			})
+			assert.EqualError(t, err, "refresh failed")
+		}()
+	}
+	close(start)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// the next call after the in-flight one finished will refresh again
+	err := group.do(context.Background(), func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestRefreshGroupWithContext(t *testing.T) {
+	var group refreshGroup
+	leaderStarted := make(chan struct{})
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- group.do(leaderCtx, func(ctx context.Context) error {
+			close(leaderStarted)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	<-leaderStarted
+
+	// case 1: waiter gives up when its own ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := group.do(ctx, func(ctx context.Context) error {
+		return nil
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// case 2: waiter retries by itself when the leader was cancelled
+	var calls int32
+	waiter := make(chan error)
+	go func() {
+		waiter <- group.do(context.Background(), func(ctx context.Context) error {
+			atomic.AddInt32(&calls, 1)
+			return nil
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancelLeader()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Nil(t, <-waiter)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestNewAsyncRefresher(t *testing.T) {
+	refresher := newAsyncRefresher(&AsyncRefreshOptions{}, nil, nil, nil)
+	assert.Equal(t, defaultPrefetchTime, refresher.prefetchTime)
+	assert.Equal(t, defaultStaleTime, refresher.staleTime)
+	assert.Equal(t, int64(180), refresher.staleSeconds())
+
+	refresher = newAsyncRefresher(&AsyncRefreshOptions{
+		PrefetchTime: time.Minute,
+		StaleTime:    2 * time.Minute,
+	}, nil, nil, nil)
+	assert.Equal(t, 2*time.Minute, refresher.prefetchTime)
+	assert.Equal(t, int64(120), refresher.staleSeconds())
+
+	// nil refresher works as synchronous mode
+	var nilRefresher *asyncRefresher
+	assert.Equal(t, int64(180), nilRefresher.staleSeconds())
+	assert.False(t, nilRefresher.canUseStaleSession(time.Now().Unix()+100))
+	nilRefresher.start()
+	nilRefresher.close()
+
+	assert.True(t, refresher.canUseStaleSession(time.Now().Unix()+100))
+	assert.False(t, refresher.canUseStaleSession(time.Now().Unix()-1))
+	assert.False(t, refresher.canUseStaleSession(0))
+}
+
+func TestAsyncRefresherNextRefreshDelay(t *testing.T) {
+	expiration := time.Now().Unix() + 3600
+	refresher := newAsyncRefresher(&AsyncRefreshOptions{
+		PrefetchTime: 10 * time.Minute,
+	}, nil, nil, func() int64 {
+		return expiration
+	})
+	for i := 0; i < 10; i++ {
+		delay := refresher.nextRefreshDelay(0)
+		assert.True(t, delay <= 50*time.Minute)
+		assert.True(t, delay >= 48*time.Minute-time.Second)
+	}
+
+	// in the prefetch window
+	expiration = time.Now().Unix() + 60
+	assert.Equal(t, time.Duration(0), refresher.nextRefreshDelay(0))
+	assert.Equal(t, time.Second, refresher.nextRefreshDelay(time.Second))
+}
+
+func TestAsyncRefresherWithClock(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	expiration := time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC).Unix()
+	refresher := newAsyncRefresher(&AsyncRefreshOptions{
+		PrefetchTime: 10 * time.Minute,
+	}, clock, nil, func() int64 {
+		return expiration
+	})
+	for i := 0; i < 10; i++ {
+		delay := refresher.nextRefreshDelay(0)
+		assert.True(t, delay <= 50*time.Minute)
+		assert.True(t, delay >= 48*time.Minute)
+	}
+
+	clock.Advance(59*time.Minute + 59*time.Second + 999*time.Millisecond)
+	assert.Equal(t, time.Duration(0), refresher.nextRefreshDelay(0))
+	assert.True(t, refresher.canUseStaleSession(expiration))
+	clock.Advance(time.Millisecond)
+	assert.False(t, refresher.canUseStaleSession(expiration))
+}
+
+func TestAsyncRefresherRetryBackoff(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	expiration := time.Date(2024, 1, 1, 0, 2, 0, 0, time.UTC).Unix()
+	refresher := newAsyncRefresher(&AsyncRefreshOptions{}, clock, nil, nil)
+
+	var nilRefresher *asyncRefresher
+	nilRefresher.recordRefresh(context.Background(), errors.New("refresh failed"))
+	assert.False(t, nilRefresher.inRetryBackoff(expiration))
+
+	assert.False(t, refresher.inRetryBackoff(expiration))
+	// the refresh aborted by the caller is not a failure
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	refresher.recordRefresh(ctx, context.Canceled)
+	assert.False(t, refresher.inRetryBackoff(expiration))
+
+	refresher.recordRefresh(context.Background(), errors.New("refresh failed"))
+	assert.True(t, refresher.inRetryBackoff(expiration))
+	// the expired session can not be used
+	assert.False(t, refresher.inRetryBackoff(clock.Now().Unix()))
+	clock.Advance(asyncRefreshRetryInterval)
+	assert.False(t, refresher.inRetryBackoff(expiration))
+
+	refresher.recordRefresh(context.Background(), errors.New("refresh failed"))
+	refresher.recordRefresh(context.Background(), nil)
+	assert.False(t, refresher.inRetryBackoff(expiration))
+}
+
+func TestAsyncRefresherLoop(t *testing.T) {
+	originRetryInterval := asyncRefreshRetryInterval
+	defer func() { asyncRefreshRetryInterval = originRetryInterval }()
+	asyncRefreshRetryInterval = 10 * time.Millisecond
+
+	var calls int32
+	var expiration int64
+	refreshed := make(chan struct{})
+	refresher := newAsyncRefresher(&AsyncRefreshOptions{
+		PrefetchTime: time.Hour,
+	}, nil, func(ctx context.Context) error {
+		// fail twice, then succeed
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return errors.New("refresh failed")
+		}
+		atomic.StoreInt64(&expiration, time.Now().Unix()+7200)
+		close(refreshed)
+		return nil
+	}, func() int64 {
+		return atomic.LoadInt64(&expiration)
+	})
+
+	refresher.start()
+	// start twice is no-op
+	refresher.start()
+	select {
+	case <-refreshed:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "background refresh was not done")
+	}
+	refresher.close()
+	refresher.close()
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// start after close is no-op
+	refresher.start()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestRefreshListener(t *testing.T) {
+	var count int32
+	_, rollback := mockLocalEndpoint(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"Code":"EntityNotExist.Role","Message":"The role not exists."}`))
+			return
+		}
+		w.Write([]byte(`{"Credentials": {"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"2121-10-20T04:27:09Z","SecurityToken":"token"}}`))
+	})
+	defer rollback()
+
+	var refreshedProvider, failedProvider string
+	var expiration time.Time
+	var refreshErr error
+	var p *RAMRoleARNCredentialsProvider
+	p, err := NewRAMRoleARNCredentialsProviderBuilder().
+		WithAccessKeyId("akid").
+		WithAccessKeySecret("aksecret").
+		WithRoleArn("roleArn").
+		WithOnRefresh(func(providerName string, exp time.Time) {
+			refreshedProvider = providerName
+			expiration = exp
+			// 回调时新的凭证已经可用
+			cc, err := p.GetCredentials()
+			assert.Nil(t, err)
+			assert.Equal(t, "saki", cc.AccessKeyId)
+		}).
+		WithOnRefreshError(func(providerName string, err error) {
+			failedProvider = providerName
+			refreshErr = err
+		}).
+		Build()
+	assert.Nil(t, err)
+
+	_, err = p.GetCredentials()
+	assert.NotNil(t, err)
+	assert.Equal(t, "ram_role_arn", failedProvider)
+	assert.Equal(t, err, refreshErr)
+	assert.Equal(t, "", refreshedProvider)
+
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "ram_role_arn", refreshedProvider)
+	assert.Equal(t, time.Date(2121, 10, 20, 4, 27, 9, 0, time.UTC), expiration)
+}
+
+func TestRefreshListenerNotify(t *testing.T) {
+	listener := &refreshListener{}
+	// no callbacks
+	listener.notify("test", 0, nil)
+	listener.notify("test", 0, errors.New("failed"))
+
+	var expiration time.Time
+	var refreshErr error
+	listener = &refreshListener{
+		onRefresh: func(providerName string, exp time.Time) {
+			assert.Equal(t, "test", providerName)
+			expiration = exp
+		},
+		onRefreshError: func(providerName string, err error) {
+			assert.Equal(t, "test", providerName)
+			refreshErr = err
+		},
+	}
+	listener.notify("test", 1634704029, nil)
+	assert.Equal(t, time.Unix(1634704029, 0).UTC(), expiration)
+	// the credentials without expiration
+	listener.notify("test", 0, nil)
+	assert.True(t, expiration.IsZero())
+	listener.notify("test", 0, errors.New("failed"))
+	assert.Equal(t, "failed", refreshErr.Error())
+}
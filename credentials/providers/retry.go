@@ -0,0 +1,145 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
+)
+
+// RetryOptions is the retry policy for the requests to STS, IMDS, SSO and the credentials URI.
+// The connection errors, the responses with retryable status codes or retryable error codes are retried.
+type RetryOptions struct {
+	// Max attempts including the first one, default is 3.
+	MaxAttempts int
+	// The backoff before the first retry, it is doubled for each retry, default is 100ms.
+	BaseBackoff time.Duration
+	// The max backoff between two attempts, default is 3s.
+	MaxBackoff time.Duration
+	// Disable the random jitter of the backoff, the jitter is up to 50% of the backoff.
+	DisableJitter bool
+	// Default is 429, 500, 502, 503 and 504.
+	RetryableStatusCodes []int
+	// The error codes in the response body, default is Throttling, Throttling.User, Throttling.Api and ServiceUnavailable.
+	RetryableErrorCodes []string
+}
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBaseBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff  = 3 * time.Second
+)
+
+var defaultRetryableStatusCodes = []int{429, 500, 502, 503, 504}
+
+var defaultRetryableErrorCodes = []string{"Throttling", "Throttling.User", "Throttling.Api", "ServiceUnavailable"}
+
+func (options *RetryOptions) maxAttempts() int {
+	if options.MaxAttempts <= 0 {
+		return defaultRetryMaxAttempts
+	}
+	return options.MaxAttempts
+}
+
+// backoff returns the delay before the attempt, the attempt starts from 1
+func (options *RetryOptions) backoff(attempt int) time.Duration {
+	base := options.BaseBackoff
+	if base <= 0 {
+		base = defaultRetryBaseBackoff
+	}
+	max := options.MaxBackoff
+	if max <= 0 {
+		max = defaultRetryMaxBackoff
+	}
+
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	if !options.DisableJitter {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+	return delay
+}
+
+type errorCodeResponse struct {
+	Code      string `json:"Code"`
+	ErrorCode string `json:"ErrorCode"`
+}
+
+func (options *RetryOptions) isRetryable(res *httputil.Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+
+	statusCodes := options.RetryableStatusCodes
+	if statusCodes == nil {
+		statusCodes = defaultRetryableStatusCodes
+	}
+	for _, code := range statusCodes {
+		if res.StatusCode == code {
+			return true
+		}
+	}
+
+	if res.StatusCode < 400 {
+		return false
+	}
+
+	// 限流等错误码可能和其他错误使用相同的状态码，需要解析响应体
+	var data errorCodeResponse
+	if json.Unmarshal(res.Body, &data) != nil {
+		return false
+	}
+
+	errorCodes := options.RetryableErrorCodes
+	if errorCodes == nil {
+		errorCodes = defaultRetryableErrorCodes
+	}
+	for _, code := range errorCodes {
+		if code != "" && (data.Code == code || data.ErrorCode == code) {
+			return true
+		}
+	}
+	return false
+}
+
+// doWithRetry sends the request with the retry policy in httpOptions, it is sent once if the policy is not set.
+// sign is called before every attempt if it is not nil, so that the timestamp and nonce of a signed request are refreshed.
+func doWithRetry(ctx context.Context, req *httputil.Request, httpOptions *HttpOptions, sign func(req *httputil.Request)) (res *httputil.Response, err error) {
+	var retry *RetryOptions
+	if httpOptions != nil {
+		retry = httpOptions.Retry
+	}
+
+	for attempt := 1; ; attempt++ {
+		if sign != nil {
+			sign(req)
+		}
+
+		attemptCtx, endHTTPRequest := startHTTPRequest(ctx, httpOptions, req, attempt-1)
+		res, err = httpDo(attemptCtx, req)
+		endHTTPRequest(res, err)
+		if retry == nil || attempt >= retry.maxAttempts() || ctx.Err() != nil || !retry.isRetryable(res, err) {
+			return
+		}
+
+		timer := time.NewTimer(retry.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
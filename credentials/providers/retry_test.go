@@ -0,0 +1,155 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryOptionsBackoff(t *testing.T) {
+	options := &RetryOptions{DisableJitter: true}
+	assert.Equal(t, 3, options.maxAttempts())
+	assert.Equal(t, 100*time.Millisecond, options.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, options.backoff(2))
+	assert.Equal(t, 400*time.Millisecond, options.backoff(3))
+	assert.Equal(t, 3*time.Second, options.backoff(10))
+	assert.Equal(t, 3*time.Second, options.backoff(100))
+
+	options = &RetryOptions{
+		MaxAttempts: 5,
+		BaseBackoff: time.Second,
+		MaxBackoff:  10 * time.Second,
+	}
+	assert.Equal(t, 5, options.maxAttempts())
+	for i := 0; i < 100; i++ {
+		delay := options.backoff(2)
+		assert.True(t, delay >= time.Second && delay <= 2*time.Second)
+	}
+}
+
+func TestRetryOptionsIsRetryable(t *testing.T) {
+	options := &RetryOptions{}
+	// connection errors
+	assert.True(t, options.isRetryable(nil, &timeoutError{}))
+	assert.True(t, options.isRetryable(nil, io.EOF))
+	assert.False(t, options.isRetryable(nil, errors.New("invalid request")))
+	// status codes
+	assert.True(t, options.isRetryable(&httputil.Response{StatusCode: 503}, nil))
+	assert.True(t, options.isRetryable(&httputil.Response{StatusCode: 429}, nil))
+	assert.False(t, options.isRetryable(&httputil.Response{StatusCode: 200}, nil))
+	assert.False(t, options.isRetryable(&httputil.Response{StatusCode: 404}, nil))
+	// error codes
+	assert.True(t, options.isRetryable(&httputil.Response{StatusCode: 400, Body: []byte(`{"Code":"Throttling.User"}`)}, nil))
+	assert.True(t, options.isRetryable(&httputil.Response{StatusCode: 400, Body: []byte(`{"ErrorCode":"Throttling"}`)}, nil))
+	assert.False(t, options.isRetryable(&httputil.Response{StatusCode: 400, Body: []byte(`{"Code":"InvalidParameter"}`)}, nil))
+	assert.False(t, options.isRetryable(&httputil.Response{StatusCode: 400, Body: []byte(`invalid json`)}, nil))
+
+	// custom
+	options = &RetryOptions{
+		RetryableStatusCodes: []int{404},
+		RetryableErrorCodes:  []string{"InvalidParameter"},
+	}
+	assert.True(t, options.isRetryable(&httputil.Response{StatusCode: 404}, nil))
+	assert.False(t, options.isRetryable(&httputil.Response{StatusCode: 503}, nil))
+	assert.True(t, options.isRetryable(&httputil.Response{StatusCode: 400, Body: []byte(`{"Code":"InvalidParameter"}`)}, nil))
+	assert.False(t, options.isRetryable(&httputil.Response{StatusCode: 400, Body: []byte(`{"Code":"Throttling.User"}`)}, nil))
+}
+
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "i/o timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+func TestDoWithRetry(t *testing.T) {
+	originHttpDo := httpDo
+	defer func() { httpDo = originHttpDo }()
+
+	var count int32
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
+		if atomic.AddInt32(&count, 1) < 3 {
+			res = &httputil.Response{StatusCode: 503, Body: []byte("unavailable")}
+			return
+		}
+		res = &httputil.Response{StatusCode: 200, Body: []byte("ok")}
+		return
+	}
+
+	// no retry policy
+	res, err := doWithRetry(context.TODO(), &httputil.Request{}, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 503, res.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+
+	// retry until success
+	atomic.StoreInt32(&count, 0)
+	signed := 0
+	httpOptions := &HttpOptions{
+		Retry: &RetryOptions{BaseBackoff: time.Millisecond},
+	}
+	res, err = doWithRetry(context.TODO(), &httputil.Request{}, httpOptions, func(req *httputil.Request) {
+		signed++
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", string(res.Body))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&count))
+	assert.Equal(t, 3, signed)
+
+	// exceed max attempts
+	atomic.StoreInt32(&count, 0)
+	httpOptions.Retry.MaxAttempts = 2
+	res, err = doWithRetry(context.TODO(), &httputil.Request{}, httpOptions, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 503, res.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+
+	// cancelled during backoff
+	atomic.StoreInt32(&count, 0)
+	httpOptions.Retry.BaseBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = doWithRetry(ctx, &httputil.Request{}, httpOptions, nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+func TestRAMRoleARNCredentialsProviderGetCredentialsWithRetry(t *testing.T) {
+	var count int32
+	nonces := make(map[string]bool)
+	_, rollback := mockLocalEndpoint(func(w http.ResponseWriter, r *http.Request) {
+		nonces[r.URL.Query().Get("SignatureNonce")] = true
+		if atomic.AddInt32(&count, 1) == 1 {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"Code":"Throttling.User","Message":"Request was denied due to user flow control."}`))
+			return
+		}
+		expiration := time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		w.Write([]byte(`{"Credentials": {"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"` + expiration + `","SecurityToken":"token"}}`))
+	})
+	defer rollback()
+
+	p, err := NewRAMRoleARNCredentialsProviderBuilder().
+		WithAccessKeyId("akid").
+		WithAccessKeySecret("aksecret").
+		WithRoleArn("roleArn").
+		WithRoleSessionName("rsn").
+		WithHttpOptions(&HttpOptions{
+			Retry: &RetryOptions{BaseBackoff: time.Millisecond},
+		}).
+		Build()
+	assert.Nil(t, err)
+
+	cc, err := p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "saki", cc.AccessKeyId)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+	// the request is signed again with a new nonce
+	assert.Len(t, nonces, 2)
+}
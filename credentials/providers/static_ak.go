@@ -1,7 +1,7 @@
 package providers
 
 import (
-	"errors"
+	"context"
 	"os"
 )
 
@@ -36,7 +36,7 @@ func (builder *StaticAKCredentialsProviderBuilder) Build() (provider *StaticAKCr
 	}
 
 	if builder.provider.accessKeyId == "" {
-		err = errors.New("the access key id is empty")
+		err = newNotConfiguredError("the access key id is empty")
 		return
 	}
 
@@ -45,7 +45,7 @@ func (builder *StaticAKCredentialsProviderBuilder) Build() (provider *StaticAKCr
 	}
 
 	if builder.provider.accessKeySecret == "" {
-		err = errors.New("the access key secret is empty")
+		err = newNotConfiguredError("the access key secret is empty")
 		return
 	}
 
@@ -54,6 +54,10 @@ func (builder *StaticAKCredentialsProviderBuilder) Build() (provider *StaticAKCr
 }
 
 func (provider *StaticAKCredentialsProvider) GetCredentials() (cc *Credentials, err error) {
+	return provider.GetCredentialsWithContext(context.Background())
+}
+
+func (provider *StaticAKCredentialsProvider) GetCredentialsWithContext(ctx context.Context) (cc *Credentials, err error) {
 	cc = &Credentials{
 		AccessKeyId:     provider.accessKeyId,
 		AccessKeySecret: provider.accessKeySecret,
@@ -30,6 +30,7 @@ func TestStaticAKCredentialsProvider(t *testing.T) {
 	assert.Equal(t, "accessKeySecret", cred.AccessKeySecret)
 	assert.Equal(t, "", cred.SecurityToken)
 	assert.Equal(t, "static_ak", cred.ProviderName)
+	assert.True(t, cred.Expiration.IsZero())
 }
 
 func TestStaticAKCredentialsProviderWithEnv(t *testing.T) {
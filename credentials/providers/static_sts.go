@@ -1,7 +1,7 @@
 package providers
 
 import (
-	"errors"
+	"context"
 	"os"
 )
 
@@ -42,7 +42,7 @@ func (builder *StaticSTSCredentialsProviderBuilder) Build() (provider *StaticSTS
 	}
 
 	if builder.provider.accessKeyId == "" {
-		err = errors.New("the access key id is empty")
+		err = newNotConfiguredError("the access key id is empty")
 		return
 	}
 
@@ -51,7 +51,7 @@ func (builder *StaticSTSCredentialsProviderBuilder) Build() (provider *StaticSTS
 	}
 
 	if builder.provider.accessKeySecret == "" {
-		err = errors.New("the access key secret is empty")
+		err = newNotConfiguredError("the access key secret is empty")
 		return
 	}
 
@@ -60,7 +60,7 @@ func (builder *StaticSTSCredentialsProviderBuilder) Build() (provider *StaticSTS
 	}
 
 	if builder.provider.securityToken == "" {
-		err = errors.New("the security token is empty")
+		err = newNotConfiguredError("the security token is empty")
 		return
 	}
 
@@ -69,6 +69,10 @@ func (builder *StaticSTSCredentialsProviderBuilder) Build() (provider *StaticSTS
 }
 
 func (provider *StaticSTSCredentialsProvider) GetCredentials() (cc *Credentials, err error) {
+	return provider.GetCredentialsWithContext(context.Background())
+}
+
+func (provider *StaticSTSCredentialsProvider) GetCredentialsWithContext(ctx context.Context) (cc *Credentials, err error) {
 	cc = &Credentials{
 		AccessKeyId:     provider.accessKeyId,
 		AccessKeySecret: provider.accessKeySecret,
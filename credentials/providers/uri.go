@@ -1,11 +1,12 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
@@ -13,12 +14,23 @@ import (
 
 type URLCredentialsProvider struct {
 	url string
+	// the bearer token sent in the Authorization header
+	bearerToken string
 	// for sts
 	sessionCredentials *sessionCredentials
 	// for http options
 	httpOptions *HttpOptions
+	// for expiry and refresh decisions
+	clock Clock
 	// inner
 	expirationTimestamp int64
+	// guards the inner session fields
+	mutex        sync.RWMutex
+	refreshGroup refreshGroup
+	// for async refresh
+	asyncRefreshOptions *AsyncRefreshOptions
+	asyncRefresher      *asyncRefresher
+	refreshListener     refreshListener
 }
 
 type URLCredentialsProviderBuilder struct {
@@ -36,11 +48,41 @@ func (builder *URLCredentialsProviderBuilder) WithUrl(url string) *URLCredential
 	return builder
 }
 
+// WithBearerToken sets the token which is sent as "Authorization: Bearer <token>" to the url
+func (builder *URLCredentialsProviderBuilder) WithBearerToken(bearerToken string) *URLCredentialsProviderBuilder {
+	builder.provider.bearerToken = bearerToken
+	return builder
+}
+
+// WithOnRefresh sets the callback which is called after the credentials are refreshed
+func (builder *URLCredentialsProviderBuilder) WithOnRefresh(onRefresh OnRefreshFunc) *URLCredentialsProviderBuilder {
+	builder.provider.refreshListener.onRefresh = onRefresh
+	return builder
+}
+
+// WithOnRefreshError sets the callback which is called after the credentials failed to refresh
+func (builder *URLCredentialsProviderBuilder) WithOnRefreshError(onRefreshError OnRefreshErrorFunc) *URLCredentialsProviderBuilder {
+	builder.provider.refreshListener.onRefreshError = onRefreshError
+	return builder
+}
+
 func (builder *URLCredentialsProviderBuilder) WithHttpOptions(httpOptions *HttpOptions) *URLCredentialsProviderBuilder {
 	builder.provider.httpOptions = httpOptions
 	return builder
 }
 
+// WithAsyncRefresh enables refreshing the credentials in background, call Close() to stop it
+func (builder *URLCredentialsProviderBuilder) WithAsyncRefresh(options *AsyncRefreshOptions) *URLCredentialsProviderBuilder {
+	builder.provider.asyncRefreshOptions = options
+	return builder
+}
+
+// WithClock sets the clock to decide whether the credentials are expired or due for a refresh, default is the system clock
+func (builder *URLCredentialsProviderBuilder) WithClock(clock Clock) *URLCredentialsProviderBuilder {
+	builder.provider.clock = clock
+	return builder
+}
+
 func (builder *URLCredentialsProviderBuilder) Build() (provider *URLCredentialsProvider, err error) {
 
 	if builder.provider.url == "" {
@@ -48,10 +90,21 @@ func (builder *URLCredentialsProviderBuilder) Build() (provider *URLCredentialsP
 	}
 
 	if builder.provider.url == "" {
-		err = errors.New("the url is empty")
+		err = newNotConfiguredError("the url is empty")
 		return
 	}
 
+	if builder.provider.bearerToken == "" {
+		builder.provider.bearerToken = os.Getenv("ALIBABA_CLOUD_CREDENTIALS_URI_TOKEN")
+	}
+
+	if builder.provider.asyncRefreshOptions != nil {
+		p := builder.provider
+		p.asyncRefresher = newAsyncRefresher(p.asyncRefreshOptions, p.clock, func(ctx context.Context) error {
+			return p.refreshGroup.do(ctx, p.updateCredential)
+		}, p.getExpirationTimestamp)
+	}
+
 	provider = builder.provider
 	return
 }
@@ -63,10 +116,15 @@ type urlResponse struct {
 	Expiration      *string `json:"Expiration"`
 }
 
-func (provider *URLCredentialsProvider) getCredentials() (session *sessionCredentials, err error) {
+func (provider *URLCredentialsProvider) getCredentials(ctx context.Context) (session *sessionCredentials, err error) {
 	req := &httputil.Request{
-		Method: "GET",
-		URL:    provider.url,
+		Method:  "GET",
+		URL:     provider.url,
+		Headers: map[string]string{},
+	}
+
+	if provider.bearerToken != "" {
+		req.Headers["Authorization"] = "Bearer " + provider.bearerToken
 	}
 
 	connectTimeout := 5 * time.Second
@@ -81,16 +139,21 @@ func (provider *URLCredentialsProvider) getCredentials() (session *sessionCreden
 	if provider.httpOptions != nil && provider.httpOptions.Proxy != "" {
 		req.Proxy = provider.httpOptions.Proxy
 	}
+	if provider.httpOptions != nil {
+		req.Client = provider.httpOptions.Client
+		req.Transport = provider.httpOptions.Transport
+		req.Logger = provider.httpOptions.Logger
+	}
 	req.ConnectTimeout = connectTimeout
 	req.ReadTimeout = readTimeout
 
-	res, err := httpDo(req)
+	res, err := doWithRetry(ctx, req, provider.httpOptions, nil)
 	if err != nil {
 		return
 	}
 
 	if res.StatusCode != http.StatusOK {
-		err = fmt.Errorf("get credentials from %s failed: %s", req.BuildRequestURL(), string(res.Body))
+		err = newServiceError(req, res, fmt.Sprintf("get credentials from %s failed: %s", req.BuildRequestURL(), string(res.Body)))
 		return
 	}
 
@@ -120,33 +183,91 @@ func (provider *URLCredentialsProvider) needUpdateCredential() (result bool) {
 		return true
 	}
 
-	return provider.expirationTimestamp-time.Now().Unix() <= 180
+	return provider.expirationTimestamp-nowOf(provider.clock).Unix() <= provider.asyncRefresher.staleSeconds()
 }
 
 func (provider *URLCredentialsProvider) GetCredentials() (cc *Credentials, err error) {
-	if provider.sessionCredentials == nil || provider.needUpdateCredential() {
-		sessionCredentials, err1 := provider.getCredentials()
-		if err1 != nil {
-			return nil, err1
-		}
+	return provider.GetCredentialsWithContext(context.Background())
+}
+
+func (provider *URLCredentialsProvider) GetCredentialsWithContext(ctx context.Context) (cc *Credentials, err error) {
+	provider.mutex.RLock()
+	needUpdate := provider.sessionCredentials == nil || provider.needUpdateCredential()
+	provider.mutex.RUnlock()
 
-		provider.sessionCredentials = sessionCredentials
-		expirationTime, err2 := time.Parse("2006-01-02T15:04:05Z", sessionCredentials.Expiration)
-		if err2 != nil {
-			return nil, err2
+	// 刷新失败后的重试间隔内直接使用尚未过期的缓存凭证，避免每次调用都等待失败的刷新
+	if needUpdate && !provider.asyncRefresher.inRetryBackoff(provider.getExpirationTimestamp()) {
+		err = provider.refreshGroup.do(ctx, provider.updateCredential)
+		provider.asyncRefresher.recordRefresh(ctx, err)
+		if err != nil {
+			// 异步刷新模式下，刷新失败时继续使用尚未过期的缓存凭证
+			provider.mutex.RLock()
+			canUseStaleSession := provider.asyncRefresher.canUseStaleSession(provider.expirationTimestamp)
+			provider.mutex.RUnlock()
+			if !canUseStaleSession {
+				return
+			}
+			err = nil
 		}
-		provider.expirationTimestamp = expirationTime.Unix()
 	}
+	provider.asyncRefresher.start()
 
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
 	cc = &Credentials{
 		AccessKeyId:     provider.sessionCredentials.AccessKeyId,
 		AccessKeySecret: provider.sessionCredentials.AccessKeySecret,
 		SecurityToken:   provider.sessionCredentials.SecurityToken,
 		ProviderName:    provider.GetProviderName(),
+		Expiration:      time.Unix(provider.expirationTimestamp, 0).UTC(),
 	}
 	return
 }
 
+func (provider *URLCredentialsProvider) updateCredential(ctx context.Context) (err error) {
+	ctx, endRefresh := startRefresh(ctx, getInstrumentation(provider.httpOptions), provider.clock, provider.GetProviderName())
+	defer func() {
+		expirationTimestamp := provider.getExpirationTimestamp()
+		endRefresh(expirationTimestamp, err)
+		provider.refreshListener.notify(provider.GetProviderName(), expirationTimestamp, err)
+	}()
+
+	sessionCredentials, err := provider.getCredentials(ctx)
+	if err != nil {
+		return
+	}
+
+	expirationTime, err := time.Parse("2006-01-02T15:04:05Z", sessionCredentials.Expiration)
+	if err != nil {
+		return
+	}
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.expirationTimestamp = expirationTime.Unix()
+	provider.sessionCredentials = sessionCredentials
+	return
+}
+
+func (provider *URLCredentialsProvider) getExpirationTimestamp() int64 {
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
+	return provider.expirationTimestamp
+}
+
+// Invalidate drops the cached credentials, the next GetCredentials() gets new credentials from the service
+func (provider *URLCredentialsProvider) Invalidate() {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.expirationTimestamp = 0
+}
+
 func (provider *URLCredentialsProvider) GetProviderName() string {
 	return "credential_uri"
 }
+
+// Close stops the background refresh, it is a no-op if async refresh is not enabled
+func (provider *URLCredentialsProvider) Close() error {
+	provider.asyncRefresher.close()
+	return nil
+}
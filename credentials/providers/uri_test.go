@@ -1,12 +1,18 @@
 package providers
 
 import (
+	"context"
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/aliyun/credentials-go/credentials/internal/clocktest"
 	httputil "github.com/aliyun/credentials-go/credentials/internal/http"
 	"github.com/aliyun/credentials-go/credentials/internal/utils"
 	"github.com/stretchr/testify/assert"
@@ -46,16 +52,16 @@ func TestURLCredentialsProvider_getCredentials(t *testing.T) {
 	assert.Nil(t, err)
 
 	// case 1: server error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "mock server error", err.Error())
 
 	// case 2: 4xx error
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 400,
 			Body:       []byte("4xx error"),
@@ -63,55 +69,55 @@ func TestURLCredentialsProvider_getCredentials(t *testing.T) {
 		return
 	}
 
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get credentials from GET http://localhost:8080 failed: 4xx error", err.Error())
 
 	// case 3: invalid json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte("invalid json"),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "get credentials from GET http://localhost:8080 failed with error, json unmarshal fail: invalid character 'i' looking for beginning of value", err.Error())
 
 	// case 4: empty response json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte("null"),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "refresh credentials from GET http://localhost:8080 failed: null", err.Error())
 
 	// case 5: empty session ak response json
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{}`),
 		}
 		return
 	}
-	_, err = p.getCredentials()
+	_, err = p.getCredentials(context.TODO())
 	assert.NotNil(t, err)
 	assert.Equal(t, "refresh credentials from GET http://localhost:8080 failed: {}", err.Error())
 
 	// case 6: mock ok value
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"2021-10-20T04:27:09Z","SecurityToken":"token"}`),
 		}
 		return
 	}
-	creds, err := p.getCredentials()
+	creds, err := p.getCredentials(context.TODO())
 	assert.Nil(t, err)
 	assert.Equal(t, "saki", creds.AccessKeyId)
 	assert.Equal(t, "saks", creds.AccessKeySecret)
@@ -127,6 +133,51 @@ func TestURLCredentialsProvider_getCredentials(t *testing.T) {
 	assert.False(t, p.needUpdateCredential())
 }
 
+func TestURLCredentialsProviderWithBearerToken(t *testing.T) {
+	rollback := utils.Memory("ALIBABA_CLOUD_CREDENTIALS_URI_TOKEN")
+	defer rollback()
+	originHttpDo := httpDo
+	defer func() { httpDo = originHttpDo }()
+
+	var authorization string
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
+		authorization = req.Headers["Authorization"]
+		res = &httputil.Response{
+			StatusCode: 200,
+			Body:       []byte(`{"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"2021-10-20T04:27:09Z","SecurityToken":"token"}`),
+		}
+		return
+	}
+
+	// no token
+	p, err := NewURLCredentialsProviderBuilder().
+		WithUrl("http://localhost:8080").
+		Build()
+	assert.Nil(t, err)
+	_, err = p.getCredentials(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "", authorization)
+
+	// the token from the environment variable
+	os.Setenv("ALIBABA_CLOUD_CREDENTIALS_URI_TOKEN", "envtoken")
+	p, err = NewURLCredentialsProviderBuilder().
+		WithUrl("http://localhost:8080").
+		Build()
+	assert.Nil(t, err)
+	_, err = p.getCredentials(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer envtoken", authorization)
+
+	p, err = NewURLCredentialsProviderBuilder().
+		WithUrl("http://localhost:8080").
+		WithBearerToken("token").
+		Build()
+	assert.Nil(t, err)
+	_, err = p.getCredentials(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer token", authorization)
+}
+
 func TestURLCredentialsProvider_GetCredentials(t *testing.T) {
 	originHttpDo := httpDo
 	defer func() { httpDo = originHttpDo }()
@@ -138,7 +189,7 @@ func TestURLCredentialsProvider_GetCredentials(t *testing.T) {
 	assert.Nil(t, err)
 
 	// case 1: get credentials failed
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		err = errors.New("mock server error")
 		return
 	}
@@ -147,7 +198,7 @@ func TestURLCredentialsProvider_GetCredentials(t *testing.T) {
 	assert.Equal(t, "mock server error", err.Error())
 
 	// case 2: get invalid expiration
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"AccessKeyId":"akid","AccessKeySecret":"aksecret","Expiration":"invalidexpiration","SecurityToken":"ststoken"}`),
@@ -159,7 +210,7 @@ func TestURLCredentialsProvider_GetCredentials(t *testing.T) {
 	assert.Equal(t, "parsing time \"invalidexpiration\" as \"2006-01-02T15:04:05Z\": cannot parse \"invalidexpiration\" as \"2006\"", err.Error())
 
 	// case 3: happy result
-	httpDo = func(req *httputil.Request) (res *httputil.Response, err error) {
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
 		res = &httputil.Response{
 			StatusCode: 200,
 			Body:       []byte(`{"AccessKeyId":"akid","AccessKeySecret":"aksecret","Expiration":"2021-10-20T04:27:09Z","SecurityToken":"ststoken"}`),
@@ -197,3 +248,288 @@ func TestURLCredentialsProviderWithHttpOptions(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "proxyconnect tcp:")
 }
+
+func TestURLCredentialsProvider_GetCredentialsWithContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	p, err := NewURLCredentialsProviderBuilder().
+		WithUrl(server.URL).
+		Build()
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = p.GetCredentialsWithContext(ctx)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "context deadline exceeded")
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestURLCredentialsProvider_GetCredentialsConcurrently(t *testing.T) {
+	var count int32
+	server, rollback := mockLocalEndpoint(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		time.Sleep(50 * time.Millisecond)
+		expiration := time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		w.Write([]byte(`{"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"` + expiration + `","SecurityToken":"token"}`))
+	})
+	defer rollback()
+
+	p, err := NewURLCredentialsProviderBuilder().
+		WithUrl(server.URL).
+		Build()
+	assert.Nil(t, err)
+
+	hammer(t, p, func(cc *Credentials) {
+		assert.Equal(t, "saki", cc.AccessKeyId)
+		assert.Equal(t, "saks", cc.AccessKeySecret)
+		assert.Equal(t, "token", cc.SecurityToken)
+		assert.Equal(t, "credential_uri", cc.ProviderName)
+	})
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+func TestURLCredentialsProvider_GetCredentialsWithAsyncRefresh(t *testing.T) {
+	originRetryInterval := asyncRefreshRetryInterval
+	defer func() { asyncRefreshRetryInterval = originRetryInterval }()
+	asyncRefreshRetryInterval = 20 * time.Millisecond
+
+	var count int32
+	var failed int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&count, 1)
+		if atomic.LoadInt32(&failed) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		expiration := time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		w.Write([]byte(`{"AccessKeyId":"saki` + strconv.Itoa(int(n)) + `","AccessKeySecret":"saks","Expiration":"` + expiration + `","SecurityToken":"token"}`))
+	}))
+	defer server.Close()
+
+	// the prefetch window is longer than the session, so it will be refreshed in background continually
+	p, err := NewURLCredentialsProviderBuilder().
+		WithUrl(server.URL).
+		WithAsyncRefresh(&AsyncRefreshOptions{
+			PrefetchTime: 2 * time.Hour,
+		}).
+		Build()
+	assert.Nil(t, err)
+	defer p.Close()
+
+	cc, err := p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "saki1", cc.AccessKeyId)
+
+	// wait for the background refresh
+	for i := 0; i < 100 && atomic.LoadInt32(&count) < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, atomic.LoadInt32(&count) >= 3)
+	cc, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.NotEqual(t, "saki1", cc.AccessKeyId)
+
+	// the cached credentials are used when refresh failed
+	p.Close()
+	atomic.StoreInt32(&failed, 1)
+	p.mutex.Lock()
+	p.expirationTimestamp = time.Now().Unix() + 60
+	p.mutex.Unlock()
+	cc, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "saks", cc.AccessKeySecret)
+
+	// but not after they are expired
+	p.mutex.Lock()
+	p.expirationTimestamp = time.Now().Unix() - 1
+	p.mutex.Unlock()
+	_, err = p.GetCredentials()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "failed")
+
+	// no more background refresh after closed
+	current := atomic.LoadInt32(&count)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, current, atomic.LoadInt32(&count))
+}
+
+func TestURLCredentialsProvider_GetCredentialsWithStaleSession(t *testing.T) {
+	var failed int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failed) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		expiration := time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		w.Write([]byte(`{"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"` + expiration + `","SecurityToken":"token"}`))
+	}))
+	defer server.Close()
+
+	// without async refresh, the refresh error is returned
+	p, err := NewURLCredentialsProviderBuilder().
+		WithUrl(server.URL).
+		Build()
+	assert.Nil(t, err)
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	atomic.StoreInt32(&failed, 1)
+	p.expirationTimestamp = time.Now().Unix() + 60
+	_, err = p.GetCredentials()
+	assert.NotNil(t, err)
+	assert.Nil(t, p.Close())
+}
+
+func TestURLCredentialsProviderWithClock(t *testing.T) {
+	originHttpDo := httpDo
+	defer func() { httpDo = originHttpDo }()
+
+	clock := clocktest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	p, err := NewURLCredentialsProviderBuilder().
+		WithUrl("http://localhost:8080").
+		WithClock(clock).
+		WithAsyncRefresh(&AsyncRefreshOptions{StaleTime: time.Minute}).
+		Build()
+	assert.Nil(t, err)
+	defer p.Close()
+
+	var failed bool
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
+		if failed {
+			err = errors.New("mock server error")
+			return
+		}
+		res = &httputil.Response{
+			StatusCode: 200,
+			Body:       []byte(`{"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"2024-01-01T01:00:00Z","SecurityToken":"token"}`),
+		}
+		return
+	}
+	cc, err := p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "saki", cc.AccessKeyId)
+
+	// the stale session is returned until it expires
+	failed = true
+	clock.Advance(59*time.Minute + 59*time.Second + 999*time.Millisecond)
+	cc, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "saki", cc.AccessKeyId)
+
+	clock.Advance(time.Millisecond)
+	_, err = p.GetCredentials()
+	assert.EqualError(t, err, "mock server error")
+}
+
+func TestURLCredentialsProviderWithAsyncRefreshBackoff(t *testing.T) {
+	originHttpDo := httpDo
+	defer func() { httpDo = originHttpDo }()
+
+	clock := clocktest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	// only the synchronous refreshes are tested, the background refresh waits on the system timer
+	p, err := NewURLCredentialsProviderBuilder().
+		WithUrl("http://localhost:8080").
+		WithClock(nowOnlyClock{clock}).
+		WithAsyncRefresh(&AsyncRefreshOptions{}).
+		Build()
+	assert.Nil(t, err)
+	defer p.Close()
+
+	var hits int32
+	var failed int32
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&failed) == 1 {
+			err = errors.New("mock server error")
+			return
+		}
+		res = &httputil.Response{
+			StatusCode: 200,
+			Body:       []byte(`{"AccessKeyId":"saki","AccessKeySecret":"saks","Expiration":"2024-01-01T01:00:00Z","SecurityToken":"token"}`),
+		}
+		return
+	}
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+
+	// in the stale window, the failed refresh is not tried again until the retry interval elapses
+	atomic.StoreInt32(&failed, 1)
+	clock.Advance(58 * time.Minute)
+	for i := 0; i < 5; i++ {
+		cc, err := p.GetCredentials()
+		assert.Nil(t, err)
+		assert.Equal(t, "saki", cc.AccessKeyId)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+
+	clock.Advance(asyncRefreshRetryInterval - time.Millisecond)
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+
+	clock.Advance(time.Millisecond)
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&hits))
+
+	// the refresh succeeds again
+	atomic.StoreInt32(&failed, 0)
+	clock.Advance(asyncRefreshRetryInterval)
+	_, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&hits))
+	assert.False(t, p.asyncRefresher.inRetryBackoff(p.getExpirationTimestamp()))
+}
+
+func TestURLCredentialsProviderWithAsyncRefreshAndTimerClock(t *testing.T) {
+	originHttpDo := httpDo
+	defer func() { httpDo = originHttpDo }()
+
+	clock := clocktest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	var hits int32
+	httpDo = func(ctx context.Context, req *httputil.Request) (res *httputil.Response, err error) {
+		n := atomic.AddInt32(&hits, 1)
+		expiration := clock.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		res = &httputil.Response{
+			StatusCode: 200,
+			Body:       []byte(`{"AccessKeyId":"saki` + strconv.Itoa(int(n)) + `","AccessKeySecret":"saks","Expiration":"` + expiration + `","SecurityToken":"token"}`),
+		}
+		return
+	}
+
+	p, err := NewURLCredentialsProviderBuilder().
+		WithUrl("http://localhost:8080").
+		WithClock(clock).
+		WithAsyncRefresh(&AsyncRefreshOptions{
+			PrefetchTime: 5 * time.Minute,
+		}).
+		Build()
+	assert.Nil(t, err)
+	defer p.Close()
+
+	cc, err := p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "saki1", cc.AccessKeyId)
+
+	// the background refresh waits on the clock, it is due 5 to 6 minutes before the expiration
+	clock.BlockUntil(1)
+	clock.Advance(53 * time.Minute)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+
+	clock.Advance(2 * time.Minute)
+	// the next wait starts after the refresh is done
+	clock.BlockUntil(1)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+	cc, err = p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "saki2", cc.AccessKeyId)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+}
@@ -94,6 +94,7 @@ func (e *RAMRoleArnCredentialsProvider) GetCredential() (*CredentialModel, error
 		AccessKeySecret: tea.String(e.sessionCredential.AccessKeySecret),
 		SecurityToken:   tea.String(e.sessionCredential.SecurityToken),
 		Type:            tea.String("ram_role_arn"),
+		Expiration:      e.expiration(),
 	}
 	return credential, nil
 }
@@ -182,21 +183,21 @@ func (r *RAMRoleArnCredentialsProvider) updateCredential() (err error) {
 	request.QueryParams["SignatureMethod"] = "HMAC-SHA1"
 	request.QueryParams["SignatureVersion"] = "1.0"
 	request.QueryParams["Version"] = "2015-04-01"
-	request.QueryParams["Timestamp"] = utils.GetTimeInFormatISO8601()
+	request.QueryParams["Timestamp"] = utils.FormatTimeInISO8601(r.now())
 	request.QueryParams["SignatureNonce"] = utils.GetUUID()
-	signature := utils.ShaHmac1(request.BuildStringToSign(), r.AccessKeySecret+"&")
+	signature := utils.GetRPCSignature(request.BuildStringToSign(), r.AccessKeySecret)
 	request.QueryParams["Signature"] = signature
 	request.Headers["Host"] = request.Domain
 	request.Headers["Accept-Encoding"] = "identity"
 	request.URL = request.BuildURL()
 	content, err := doAction(request, r.runtime)
 	if err != nil {
-		return fmt.Errorf("refresh RoleArn sts token err: %s", err.Error())
+		return fmt.Errorf("refresh RoleArn sts token err: %w", err)
 	}
 	var resp *ramRoleArnResponse
 	err = json.Unmarshal(content, &resp)
 	if err != nil {
-		return fmt.Errorf("refresh RoleArn sts token err: Json.Unmarshal fail: %s", err.Error())
+		return fmt.Errorf("refresh RoleArn sts token err: Json.Unmarshal fail: %w", err)
 	}
 	if resp == nil || resp.Credentials == nil {
 		return fmt.Errorf("refresh RoleArn sts token err: Credentials is empty")
@@ -207,8 +208,8 @@ func (r *RAMRoleArnCredentialsProvider) updateCredential() (err error) {
 	}
 
 	expirationTime, err := time.Parse("2006-01-02T15:04:05Z", respCredentials.Expiration)
-	r.lastUpdateTimestamp = time.Now().Unix()
-	r.credentialExpiration = int(expirationTime.Unix() - time.Now().Unix())
+	r.lastUpdateTimestamp = r.now().Unix()
+	r.credentialExpiration = int(expirationTime.Unix() - r.now().Unix())
 	r.sessionCredential = &sessionCredential{
 		AccessKeyId:     respCredentials.AccessKeyId,
 		AccessKeySecret: respCredentials.AccessKeySecret,
@@ -2,10 +2,10 @@ package request
 
 import (
 	"fmt"
-	"net/url"
 	"strings"
 	"time"
 
+	internalutils "github.com/aliyun/credentials-go/credentials/internal/utils"
 	"github.com/aliyun/credentials-go/credentials/utils"
 )
 
@@ -53,11 +53,6 @@ func (request *CommonRequest) BuildStringToSign() (stringToSign string) {
 	for key, value := range request.BodyParams {
 		signParams[key] = value
 	}
-	stringToSign = utils.GetURLFormedMap(signParams)
-	stringToSign = strings.Replace(stringToSign, "+", "%20", -1)
-	stringToSign = strings.Replace(stringToSign, "*", "%2A", -1)
-	stringToSign = strings.Replace(stringToSign, "%7E", "~", -1)
-	stringToSign = url.QueryEscape(stringToSign)
-	stringToSign = request.Method + "&%2F&" + stringToSign
+	stringToSign = internalutils.GetRPCStringToSign(request.Method, signParams)
 	return
 }
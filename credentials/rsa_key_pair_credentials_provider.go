@@ -1,14 +1,17 @@
 package credentials
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/alibabacloud-go/tea/tea"
 	"github.com/aliyun/credentials-go/credentials/internal/utils"
+	"github.com/aliyun/credentials-go/credentials/providers"
 	"github.com/aliyun/credentials-go/credentials/request"
 )
 
@@ -21,6 +24,9 @@ type RsaKeyPairCredentialsProvider struct {
 	SessionExpiration int
 	sessionCredential *sessionCredential
 	runtime           *utils.Runtime
+	onRefresh         providers.OnRefreshFunc
+	onRefreshError    providers.OnRefreshErrorFunc
+	instrumentation   providers.Instrumentation
 }
 
 type rsaKeyPairResponse struct {
@@ -55,6 +61,7 @@ func (e *RsaKeyPairCredentialsProvider) GetCredential() (*CredentialModel, error
 		AccessKeySecret: tea.String(e.sessionCredential.AccessKeySecret),
 		SecurityToken:   tea.String(e.sessionCredential.SecurityToken),
 		Type:            tea.String("rsa_key_pair"),
+		Expiration:      e.expiration(),
 	}
 	return credential, nil
 }
@@ -96,7 +103,81 @@ func (r *RsaKeyPairCredentialsProvider) GetType() *string {
 	return tea.String("rsa_key_pair")
 }
 
+// notifyRefresh calls the callbacks with the result of the refresh
+func (r *RsaKeyPairCredentialsProvider) notifyRefresh(err error) {
+	if err != nil {
+		if r.onRefreshError != nil {
+			r.onRefreshError("rsa_key_pair", err)
+		}
+		return
+	}
+
+	if r.onRefresh != nil {
+		r.onRefresh("rsa_key_pair", *r.expiration())
+	}
+}
+
+// startRefresh notifies the instrumentation like the session providers, the returned function must be called with the result of the refresh
+func (r *RsaKeyPairCredentialsProvider) startRefresh(ctx context.Context) (context.Context, func(err error)) {
+	if r.instrumentation == nil {
+		return ctx, func(err error) {}
+	}
+
+	start := r.now()
+	ctx, end := r.instrumentation.StartRefresh(ctx, "rsa_key_pair")
+	return ctx, func(err error) {
+		event := &providers.RefreshEvent{
+			ProviderName: "rsa_key_pair",
+			Duration:     r.now().Sub(start),
+			Status:       "success",
+		}
+		if err != nil {
+			event.Status = "error"
+			event.ErrorCode = providers.ErrorCode(err)
+		} else {
+			event.TimeToExpiry = r.expiration().Sub(r.now())
+		}
+		end(event)
+	}
+}
+
+// startHTTPRequest notifies the instrumentation, the returned function must be called with the result of the request
+func (r *RsaKeyPairCredentialsProvider) startHTTPRequest(ctx context.Context, method string, host string) func(err error) {
+	if r.instrumentation == nil {
+		return func(err error) {}
+	}
+
+	start := r.now()
+	_, end := r.instrumentation.StartHTTPRequest(ctx, "rsa_key_pair", method, host)
+	return func(err error) {
+		event := &providers.HTTPRequestEvent{
+			ProviderName: "rsa_key_pair",
+			Method:       method,
+			Host:         host,
+			StatusCode:   http.StatusOK,
+			Status:       "success",
+			Duration:     r.now().Sub(start),
+		}
+		if err != nil {
+			event.StatusCode = 0
+			event.Status = "error"
+			event.ErrorCode = providers.ErrorCode(err)
+			var serviceErr *ServiceError
+			if errors.As(err, &serviceErr) {
+				event.StatusCode = serviceErr.StatusCode
+			}
+		}
+		end(event)
+	}
+}
+
 func (r *RsaKeyPairCredentialsProvider) updateCredential() (err error) {
+	ctx, endRefresh := r.startRefresh(context.Background())
+	defer func() {
+		endRefresh(err)
+		r.notifyRefresh(err)
+	}()
+
 	if r.runtime == nil {
 		r.runtime = new(utils.Runtime)
 	}
@@ -126,21 +207,23 @@ func (r *RsaKeyPairCredentialsProvider) updateCredential() (err error) {
 	request.QueryParams["SignatureType"] = "PRIVATEKEY"
 	request.QueryParams["SignatureVersion"] = "1.0"
 	request.QueryParams["Version"] = "2015-04-01"
-	request.QueryParams["Timestamp"] = utils.GetTimeInFormatISO8601()
+	request.QueryParams["Timestamp"] = utils.FormatTimeInISO8601(r.now())
 	request.QueryParams["SignatureNonce"] = utils.GetUUID()
 	signature := utils.Sha256WithRsa(request.BuildStringToSign(), r.PrivateKey)
 	request.QueryParams["Signature"] = signature
 	request.Headers["Host"] = request.Domain
 	request.Headers["Accept-Encoding"] = "identity"
 	request.URL = request.BuildURL()
+	endHTTPRequest := r.startHTTPRequest(ctx, request.Method, request.Domain)
 	content, err := doAction(request, r.runtime)
+	endHTTPRequest(err)
 	if err != nil {
-		return fmt.Errorf("refresh KeyPair err: %s", err.Error())
+		return fmt.Errorf("refresh KeyPair err: %w", err)
 	}
 	var resp *rsaKeyPairResponse
 	err = json.Unmarshal(content, &resp)
 	if err != nil {
-		return fmt.Errorf("refresh KeyPair err: Json Unmarshal fail: %s", err.Error())
+		return fmt.Errorf("refresh KeyPair err: Json Unmarshal fail: %w", err)
 	}
 	if resp == nil || resp.SessionAccessKey == nil {
 		return fmt.Errorf("refresh KeyPair err: SessionAccessKey is empty")
@@ -151,8 +234,8 @@ func (r *RsaKeyPairCredentialsProvider) updateCredential() (err error) {
 	}
 
 	expirationTime, err := time.Parse("2006-01-02T15:04:05Z", sessionAccessKey.Expiration)
-	r.lastUpdateTimestamp = time.Now().Unix()
-	r.credentialExpiration = int(expirationTime.Unix() - time.Now().Unix())
+	r.lastUpdateTimestamp = r.now().Unix()
+	r.credentialExpiration = int(expirationTime.Unix() - r.now().Unix())
 	r.sessionCredential = &sessionCredential{
 		AccessKeyId:     sessionAccessKey.SessionAccessKeyId,
 		AccessKeySecret: sessionAccessKey.SessionAccessKeySecret,
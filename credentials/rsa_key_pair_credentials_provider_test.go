@@ -1,17 +1,20 @@
 package credentials
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"testing"
+	"time"
 
+	"github.com/aliyun/credentials-go/credentials/credentialstest"
 	"github.com/aliyun/credentials-go/credentials/internal/utils"
+	"github.com/aliyun/credentials-go/credentials/providers"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func Test_KeyPairCredential(t *testing.T) {
-	privatekey := `
+const testPrivateKey = `
 MIICeQIBADANBgkqhkiG9w0BAQEFAASCAmMwggJfAgEAAoGBAOJC+2WXtkXZ+6sa
 3+qJp4mDOsiZb3BghHT9nVbjTeaw4hsZWHYxQ6l6XDmTg4twPB59LOGAlAjYrT31
 3pdwEawnmdf6zyF93Zvxxpy7lO2HoxYKSjbtXO4I0pcq3WTnw2xlbhqHvrcuWwt+
@@ -26,6 +29,9 @@ Fe0zIJp5e69zK+W2Mvt4bL7OgBROeoECQQDsE+4uLw0gFln0tosmovhmp60NcfX7
 bLbtzL2MbwbXlbOztF7ssgzUWAHgKI6hK3g0LhsqBuo3jzmSVO43giZvAkEA08Nm
 2TI9EvX6DfCVfPOiKZM+Pijh0xLN4Dn8qUgt3Tcew/vfj4WA2ZV6qiJqL01vMsHc
 vftlY0Hs1vNXcaBgEA==`
+
+func Test_KeyPairCredential(t *testing.T) {
+	privatekey := testPrivateKey
 	auth := newRsaKeyPairCredential(privatekey, "publicKeyId", 100, &utils.Runtime{Host: "www.aliyun.com", Proxy: "www.aliyuncs.com"})
 	origTestHookDo := hookDo
 	defer func() { hookDo = origTestHookDo }()
@@ -142,3 +148,99 @@ vftlY0Hs1vNXcaBgEA==`
 	assert.Equal(t, "refresh KeyPair err: SessionAccessKey is empty", err.Error())
 	assert.Nil(t, accesskeyId)
 }
+
+func Test_KeyPairCredentialRefreshCallbacks(t *testing.T) {
+	origTestHookDo := hookDo
+	defer func() { hookDo = origTestHookDo }()
+	hookDo = func(fn func(req *http.Request) (*http.Response, error)) func(req *http.Request) (*http.Response, error) {
+		return func(req *http.Request) (*http.Response, error) {
+			return mockResponse(200, `{}`, nil)
+		}
+	}
+
+	var refreshErr error
+	var expiration time.Time
+	auth := newRsaKeyPairCredential(testPrivateKey, "publicKeyId", 3600, nil)
+	auth.onRefresh = func(providerName string, exp time.Time) {
+		assert.Equal(t, "rsa_key_pair", providerName)
+		expiration = exp
+	}
+	auth.onRefreshError = func(providerName string, err error) {
+		assert.Equal(t, "rsa_key_pair", providerName)
+		refreshErr = err
+	}
+	_, err := auth.GetCredential()
+	assert.NotNil(t, err)
+	assert.Equal(t, err, refreshErr)
+	assert.True(t, expiration.IsZero())
+
+	hookDo = func(fn func(req *http.Request) (*http.Response, error)) func(req *http.Request) (*http.Response, error) {
+		return func(req *http.Request) (*http.Response, error) {
+			return mockResponse(200, `{"SessionAccessKey":{"SessionAccessKeyId":"accessKeyId","SessionAccessKeySecret":"accessKeySecret","Expiration":"2121-01-02T15:04:05Z"}}`, nil)
+		}
+	}
+	cred, err := auth.GetCredential()
+	assert.Nil(t, err)
+	assert.Equal(t, *cred.Expiration, expiration)
+	assert.WithinDuration(t, time.Date(2121, 1, 2, 15, 4, 5, 0, time.UTC), expiration, time.Second)
+}
+
+type recordingInstrumentation struct {
+	refreshes    []*providers.RefreshEvent
+	httpRequests []*providers.HTTPRequestEvent
+}
+
+func (i *recordingInstrumentation) StartRefresh(ctx context.Context, providerName string) (context.Context, func(event *providers.RefreshEvent)) {
+	return ctx, func(event *providers.RefreshEvent) {
+		i.refreshes = append(i.refreshes, event)
+	}
+}
+
+func (i *recordingInstrumentation) StartHTTPRequest(ctx context.Context, providerName string, method string, host string) (context.Context, func(event *providers.HTTPRequestEvent)) {
+	return ctx, func(event *providers.HTTPRequestEvent) {
+		i.httpRequests = append(i.httpRequests, event)
+	}
+}
+
+func Test_KeyPairCredentialInstrumentation(t *testing.T) {
+	origTestHookDo := hookDo
+	defer func() { hookDo = origTestHookDo }()
+	hookDo = func(fn func(req *http.Request) (*http.Response, error)) func(req *http.Request) (*http.Response, error) {
+		return func(req *http.Request) (*http.Response, error) {
+			return mockResponse(400, `{"Code":"Throttling.User","Message":"Request was denied due to user flow control."}`, nil)
+		}
+	}
+
+	instrumentation := &recordingInstrumentation{}
+	auth := newRsaKeyPairCredential(testPrivateKey, "publicKeyId", 3600, nil)
+	auth.clock = credentialstest.NewFakeClock(time.Date(2121, 1, 2, 14, 4, 5, 0, time.UTC))
+	auth.instrumentation = instrumentation
+	_, err := auth.GetCredential()
+	assert.NotNil(t, err)
+
+	hookDo = func(fn func(req *http.Request) (*http.Response, error)) func(req *http.Request) (*http.Response, error) {
+		return func(req *http.Request) (*http.Response, error) {
+			return mockResponse(200, `{"SessionAccessKey":{"SessionAccessKeyId":"accessKeyId","SessionAccessKeySecret":"accessKeySecret","Expiration":"2121-01-02T15:04:05Z"}}`, nil)
+		}
+	}
+	_, err = auth.GetCredential()
+	assert.Nil(t, err)
+
+	assert.Len(t, instrumentation.refreshes, 2)
+	assert.Equal(t, "rsa_key_pair", instrumentation.refreshes[0].ProviderName)
+	assert.Equal(t, "error", instrumentation.refreshes[0].Status)
+	assert.Equal(t, "Throttling.User", instrumentation.refreshes[0].ErrorCode)
+	assert.Equal(t, "success", instrumentation.refreshes[1].Status)
+	assert.Equal(t, time.Hour, instrumentation.refreshes[1].TimeToExpiry)
+
+	assert.Len(t, instrumentation.httpRequests, 2)
+	assert.Equal(t, "rsa_key_pair", instrumentation.httpRequests[0].ProviderName)
+	assert.Equal(t, "GET", instrumentation.httpRequests[0].Method)
+	assert.Equal(t, "sts.aliyuncs.com", instrumentation.httpRequests[0].Host)
+	assert.Equal(t, 400, instrumentation.httpRequests[0].StatusCode)
+	assert.Equal(t, "error", instrumentation.httpRequests[0].Status)
+	assert.Equal(t, "Throttling.User", instrumentation.httpRequests[0].ErrorCode)
+	assert.Equal(t, 200, instrumentation.httpRequests[1].StatusCode)
+	assert.Equal(t, "success", instrumentation.httpRequests[1].Status)
+	assert.Equal(t, "", instrumentation.httpRequests[1].ErrorCode)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/providers"
+)
+
+const (
+	// the credentials are got from the credentials provider again when they expire in refreshAhead
+	refreshAhead = 5 * time.Minute
+	// the expiration returned for the credentials without expiration, such as the access key
+	defaultExpiration = time.Hour
+)
+
+// credentialsCache caches the credentials of the credentials provider until they are about to expire
+type credentialsCache struct {
+	credentialsProvider providers.CredentialsProvider
+	// decides whether the cached credentials are about to expire, the system clock is used if it is nil
+	clock providers.Clock
+	// guards the cached credentials
+	mutex       sync.Mutex
+	cached      *providers.Credentials
+	expiration  time.Time
+	lastUpdated time.Time
+}
+
+// getCredentials returns the cached credentials and their expiration,
+// the expiration of the credentials without expiration is defaultExpiration later
+func (cache *credentialsCache) getCredentials(ctx context.Context) (cc *providers.Credentials, expiration time.Time, err error) {
+	cc, expiration, _, err = cache.getCredentialsWithLastUpdated(ctx)
+	return
+}
+
+// getCredentialsWithLastUpdated returns the cached credentials, their expiration and the time they are got
+func (cache *credentialsCache) getCredentialsWithLastUpdated(ctx context.Context) (cc *providers.Credentials, expiration time.Time, lastUpdated time.Time, err error) {
+	cc, expiration, lastUpdated = cache.getCached()
+	if cc != nil {
+		return
+	}
+
+	// 获取凭证时不持有锁，并发的刷新由 provider 去重，慢的刷新不阻塞其他请求
+	cc, err = providers.GetCredentialsWithContext(ctx, cache.credentialsProvider)
+	if err != nil {
+		return
+	}
+
+	lastUpdated = cache.now()
+	expiration = cc.Expiration
+	if expiration.IsZero() {
+		expiration = lastUpdated.Add(defaultExpiration)
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.cached = cc
+	cache.expiration = expiration
+	cache.lastUpdated = lastUpdated
+	return
+}
+
+// getCached returns the cached credentials if they are not about to expire
+func (cache *credentialsCache) getCached() (cc *providers.Credentials, expiration time.Time, lastUpdated time.Time) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.cached != nil && cache.now().Add(refreshAhead).Before(cache.expiration) {
+		return cache.cached, cache.expiration, cache.lastUpdated
+	}
+	return
+}
+
+func (cache *credentialsCache) now() time.Time {
+	if cache.clock == nil {
+		return time.Now()
+	}
+	return cache.clock.Now()
+}
@@ -0,0 +1,187 @@
+// Package server serves the credentials of a credentials provider in the format of the credentials URI,
+// so that the processes and the containers on the same host can share one identity
+// by providers.URLCredentialsProvider (ALIBABA_CLOUD_CREDENTIALS_URI).
+// It also emulates the ECS instance metadata service for providers.ECSRAMRoleCredentialsProvider (ALIBABA_CLOUD_IMDS_ENDPOINT).
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/providers"
+)
+
+type connContextKey struct{}
+
+// ConnContext saves the connection in the context of the requests, it must be set as the ConnContext of http.Server
+// when the peer uid check is enabled and the handler is served by a custom server
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, conn)
+}
+
+// Handler is an http.Handler which returns the credentials in the JSON format of the credentials URI:
+//
+//	{"AccessKeyId":"...","AccessKeySecret":"...","SecurityToken":"...","Expiration":"2006-01-02T15:04:05Z"}
+//
+// The callers are authenticated by the bearer token in the Authorization header, or by the uid of the peer process
+// of the unix socket (Linux only), at least one of them is required unless WithInsecureNoAuth() is set. The credentials are cached until they are about to expire.
+type Handler struct {
+	cache       credentialsCache
+	bearerToken string
+	allowedUIDs map[int]bool
+	// serve the credentials to any caller
+	insecureNoAuth bool
+}
+
+type HandlerBuilder struct {
+	handler *Handler
+}
+
+func NewHandlerBuilder() *HandlerBuilder {
+	return &HandlerBuilder{
+		handler: &Handler{},
+	}
+}
+
+func (builder *HandlerBuilder) WithCredentialsProvider(credentialsProvider providers.CredentialsProvider) *HandlerBuilder {
+	builder.handler.cache.credentialsProvider = credentialsProvider
+	return builder
+}
+
+// WithBearerToken requires the callers to send "Authorization: Bearer <token>"
+func (builder *HandlerBuilder) WithBearerToken(bearerToken string) *HandlerBuilder {
+	builder.handler.bearerToken = bearerToken
+	return builder
+}
+
+// WithAllowedUIDs only allows the processes of the uids to connect by the unix socket, it is only supported on Linux
+func (builder *HandlerBuilder) WithAllowedUIDs(uids ...int) *HandlerBuilder {
+	builder.handler.allowedUIDs = make(map[int]bool)
+	for _, uid := range uids {
+		builder.handler.allowedUIDs[uid] = true
+	}
+	return builder
+}
+
+// WithClock sets the clock to decide whether the cached credentials are about to expire, default is the system clock
+func (builder *HandlerBuilder) WithClock(clock providers.Clock) *HandlerBuilder {
+	builder.handler.cache.clock = clock
+	return builder
+}
+
+// WithInsecureNoAuth allows building the handler without the bearer token and the allowed uids,
+// the credentials are served to anyone who can connect, it should only be used in tests
+func (builder *HandlerBuilder) WithInsecureNoAuth() *HandlerBuilder {
+	builder.handler.insecureNoAuth = true
+	return builder
+}
+
+func (builder *HandlerBuilder) Build() (handler *Handler, err error) {
+	if builder.handler.cache.credentialsProvider == nil {
+		err = errors.New("the credentials provider is nil")
+		return
+	}
+
+	if builder.handler.allowedUIDs != nil && len(builder.handler.allowedUIDs) == 0 {
+		err = errors.New("the allowed uids are empty")
+		return
+	}
+
+	if builder.handler.bearerToken == "" && builder.handler.allowedUIDs == nil && !builder.handler.insecureNoAuth {
+		err = errors.New("the bearer token or the allowed uids are required to authenticate the callers")
+		return
+	}
+
+	handler = builder.handler
+	return
+}
+
+// NewServer returns an http.Server which serves the handler and supports the peer uid check
+func (handler *Handler) NewServer() *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ConnContext:       ConnContext,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+type credentialsResponse struct {
+	AccessKeyId     string `json:"AccessKeyId"`
+	AccessKeySecret string `json:"AccessKeySecret"`
+	SecurityToken   string `json:"SecurityToken"`
+	Expiration      string `json:"Expiration"`
+}
+
+type errorResponse struct {
+	Code    string `json:"Code"`
+	Message string `json:"Message"`
+}
+
+func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, &errorResponse{Code: "MethodNotAllowed", Message: "only GET is allowed"})
+		return
+	}
+
+	if status, err := handler.authenticate(r); err != nil {
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		writeJSON(w, status, &errorResponse{Code: http.StatusText(status), Message: err.Error()})
+		return
+	}
+
+	cc, expiration, err := handler.cache.getCredentials(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, &errorResponse{Code: "GetCredentialsFailed", Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &credentialsResponse{
+		AccessKeyId:     cc.AccessKeyId,
+		AccessKeySecret: cc.AccessKeySecret,
+		SecurityToken:   cc.SecurityToken,
+		Expiration:      expiration.UTC().Format("2006-01-02T15:04:05Z"),
+	})
+}
+
+// authenticate returns the status code and the reason if r is not allowed
+func (handler *Handler) authenticate(r *http.Request) (status int, err error) {
+	if handler.allowedUIDs != nil {
+		conn, ok := r.Context().Value(connContextKey{}).(net.Conn)
+		if !ok {
+			return http.StatusForbidden, errors.New("the connection of the request is unknown")
+		}
+
+		uid, err := getPeerUID(conn)
+		if err != nil {
+			return http.StatusForbidden, err
+		}
+
+		if !handler.allowedUIDs[uid] {
+			return http.StatusForbidden, errors.New("the uid of the peer process is not allowed")
+		}
+	}
+
+	if handler.bearerToken != "" {
+		expected := "Bearer " + handler.bearerToken
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+			return http.StatusUnauthorized, errors.New("the bearer token is invalid")
+		}
+	}
+	return
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	content, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(content)
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/internal/clocktest"
+	"github.com/aliyun/credentials-go/credentials/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingProvider struct {
+	count       int
+	credentials *providers.Credentials
+	err         error
+}
+
+func (provider *countingProvider) GetCredentials() (*providers.Credentials, error) {
+	provider.count++
+	return provider.credentials, provider.err
+}
+
+func (provider *countingProvider) GetProviderName() string {
+	return "counting"
+}
+
+func doGet(t *testing.T, handler http.Handler, authorization string) (status int, body string) {
+	req := httptest.NewRequest("GET", "http://localhost/credentials", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	content, err := ioutil.ReadAll(w.Result().Body)
+	assert.Nil(t, err)
+	return w.Code, string(content)
+}
+
+func TestNewHandler(t *testing.T) {
+	_, err := NewHandlerBuilder().Build()
+	assert.EqualError(t, err, "the credentials provider is nil")
+
+	_, err = NewHandlerBuilder().WithCredentialsProvider(&countingProvider{}).WithAllowedUIDs().Build()
+	assert.EqualError(t, err, "the allowed uids are empty")
+
+	_, err = NewHandlerBuilder().WithCredentialsProvider(&countingProvider{}).Build()
+	assert.EqualError(t, err, "the bearer token or the allowed uids are required to authenticate the callers")
+
+	h, err := NewHandlerBuilder().
+		WithCredentialsProvider(&countingProvider{}).
+		WithBearerToken("token").
+		WithAllowedUIDs(0, 1000).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, "token", h.bearerToken)
+	assert.Equal(t, map[int]bool{0: true, 1000: true}, h.allowedUIDs)
+
+	server := h.NewServer()
+	assert.Equal(t, h, server.Handler)
+	assert.NotNil(t, server.ConnContext)
+}
+
+func TestHandlerServeHTTP(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	provider := &countingProvider{
+		credentials: &providers.Credentials{
+			AccessKeyId:     "akid",
+			AccessKeySecret: "aksecret",
+			SecurityToken:   "ststoken",
+			Expiration:      time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC),
+		},
+	}
+	h, err := NewHandlerBuilder().WithCredentialsProvider(provider).WithClock(clock).WithInsecureNoAuth().Build()
+	assert.Nil(t, err)
+
+	status, body := doGet(t, h, "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, `{"AccessKeyId":"akid","AccessKeySecret":"aksecret","SecurityToken":"ststoken","Expiration":"2024-01-01T01:00:00Z"}`, body)
+	assert.Equal(t, 1, provider.count)
+
+	// the cached credentials are returned
+	status, _ = doGet(t, h, "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, 1, provider.count)
+
+	// the credentials are about to expire
+	clock.Set(time.Date(2024, 1, 1, 0, 56, 0, 0, time.UTC))
+	provider.credentials = &providers.Credentials{
+		AccessKeyId:     "akid2",
+		AccessKeySecret: "aksecret2",
+		SecurityToken:   "ststoken2",
+		Expiration:      time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC),
+	}
+	status, body = doGet(t, h, "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, `{"AccessKeyId":"akid2","AccessKeySecret":"aksecret2","SecurityToken":"ststoken2","Expiration":"2024-01-01T02:00:00Z"}`, body)
+	assert.Equal(t, 2, provider.count)
+
+	req := httptest.NewRequest("POST", "http://localhost/credentials", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, 405, w.Code)
+	assert.Equal(t, "GET", w.Header().Get("Allow"))
+}
+
+func TestHandlerServeHTTPWithoutExpiration(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	provider := &countingProvider{
+		credentials: &providers.Credentials{
+			AccessKeyId:     "akid",
+			AccessKeySecret: "aksecret",
+		},
+	}
+	h, err := NewHandlerBuilder().WithCredentialsProvider(provider).WithClock(clock).WithInsecureNoAuth().Build()
+	assert.Nil(t, err)
+
+	status, body := doGet(t, h, "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, `{"AccessKeyId":"akid","AccessKeySecret":"aksecret","SecurityToken":"","Expiration":"2024-01-01T01:00:00Z"}`, body)
+}
+
+func TestHandlerServeHTTPError(t *testing.T) {
+	provider := &countingProvider{err: errors.New("get credentials failed")}
+	h, err := NewHandlerBuilder().WithCredentialsProvider(provider).WithInsecureNoAuth().Build()
+	assert.Nil(t, err)
+
+	status, body := doGet(t, h, "")
+	assert.Equal(t, 500, status)
+	assert.Equal(t, `{"Code":"GetCredentialsFailed","Message":"get credentials failed"}`, body)
+
+	// the error is not cached
+	doGet(t, h, "")
+	assert.Equal(t, 2, provider.count)
+}
+
+// blockingProvider blocks until the context is done or release is closed
+type blockingProvider struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (provider *blockingProvider) GetCredentials() (*providers.Credentials, error) {
+	return provider.GetCredentialsWithContext(context.Background())
+}
+
+func (provider *blockingProvider) GetCredentialsWithContext(ctx context.Context) (*providers.Credentials, error) {
+	provider.started <- struct{}{}
+	select {
+	case <-provider.release:
+		return &providers.Credentials{AccessKeyId: "akid", AccessKeySecret: "aksecret"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (provider *blockingProvider) GetProviderName() string {
+	return "blocking"
+}
+
+func TestHandlerServeHTTPWithSlowProvider(t *testing.T) {
+	provider := &blockingProvider{
+		started: make(chan struct{}, 2),
+		release: make(chan struct{}),
+	}
+	h, err := NewHandlerBuilder().WithCredentialsProvider(provider).WithInsecureNoAuth().Build()
+	assert.Nil(t, err)
+
+	done := make(chan int, 1)
+	go func() {
+		status, _ := doGet(t, h, "")
+		done <- status
+	}()
+	<-provider.started
+
+	// the slow refresh does not block the other requests, and the cancelled request stops waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "http://localhost/credentials", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	go func() {
+		<-provider.started
+		cancel()
+	}()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, 500, w.Code)
+	assert.Equal(t, `{"Code":"GetCredentialsFailed","Message":"context canceled"}`, w.Body.String())
+
+	close(provider.release)
+	assert.Equal(t, 200, <-done)
+}
+
+func TestHandlerBearerToken(t *testing.T) {
+	provider := &countingProvider{
+		credentials: &providers.Credentials{
+			AccessKeyId:     "akid",
+			AccessKeySecret: "aksecret",
+		},
+	}
+	h, err := NewHandlerBuilder().WithCredentialsProvider(provider).WithBearerToken("token").Build()
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest("GET", "http://localhost/credentials", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, `{"Code":"Unauthorized","Message":"the bearer token is invalid"}`, w.Body.String())
+
+	status, _ := doGet(t, h, "Bearer invalid")
+	assert.Equal(t, 401, status)
+	status, _ = doGet(t, h, "token")
+	assert.Equal(t, 401, status)
+	assert.Equal(t, 0, provider.count)
+
+	status, _ = doGet(t, h, "Bearer token")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, 1, provider.count)
+}
+
+func TestHandlerAllowedUIDsWithoutConn(t *testing.T) {
+	h, err := NewHandlerBuilder().WithCredentialsProvider(&countingProvider{}).WithAllowedUIDs(0).Build()
+	assert.Nil(t, err)
+
+	status, body := doGet(t, h, "")
+	assert.Equal(t, 403, status)
+	assert.Equal(t, `{"Code":"Forbidden","Message":"the connection of the request is unknown"}`, body)
+}
+
+func TestHandlerWithURLCredentialsProvider(t *testing.T) {
+	staticProvider, err := providers.NewStaticSTSCredentialsProviderBuilder().
+		WithAccessKeyId("akid").
+		WithAccessKeySecret("aksecret").
+		WithSecurityToken("ststoken").
+		Build()
+	assert.Nil(t, err)
+	h, err := NewHandlerBuilder().WithCredentialsProvider(staticProvider).WithBearerToken("token").Build()
+	assert.Nil(t, err)
+
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	p, err := providers.NewURLCredentialsProviderBuilder().
+		WithUrl(server.URL).
+		WithBearerToken("token").
+		Build()
+	assert.Nil(t, err)
+	cc, err := p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "akid", cc.AccessKeyId)
+	assert.Equal(t, "aksecret", cc.AccessKeySecret)
+	assert.Equal(t, "ststoken", cc.SecurityToken)
+	assert.Equal(t, "credential_uri", cc.ProviderName)
+
+	p, err = providers.NewURLCredentialsProviderBuilder().
+		WithUrl(server.URL).
+		WithBearerToken("invalid").
+		Build()
+	assert.Nil(t, err)
+	_, err = p.GetCredentials()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "the bearer token is invalid")
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/providers"
+)
+
+const (
+	imdsTokenPath            = "/latest/api/token"
+	imdsSecurityCredentials  = "/latest/meta-data/ram/security-credentials/"
+	imdsTokenHeader          = "X-aliyun-ecs-metadata-token"
+	imdsTokenTTLHeader       = "X-aliyun-ecs-metadata-token-ttl-seconds"
+	imdsMaxTokenTTLInSeconds = 21600
+)
+
+// IMDSHandler is an http.Handler which emulates the RAM role part of the ECS instance metadata service (IMDS),
+// so that providers.ECSRAMRoleCredentialsProvider can be used out of ECS by ALIBABA_CLOUD_IMDS_ENDPOINT:
+//
+//	PUT /latest/api/token                               returns a metadata token which expires in the ttl seconds
+//	GET /latest/meta-data/ram/security-credentials/     returns the role name
+//	GET /latest/meta-data/ram/security-credentials/role returns the credentials of the role
+//
+// The credentials are got from the credentials provider and cached until they are about to expire.
+type IMDSHandler struct {
+	cache         credentialsCache
+	roleName      string
+	disableIMDSv1 bool
+	// guards the metadata tokens
+	mutex  sync.Mutex
+	tokens map[string]time.Time
+}
+
+type IMDSHandlerBuilder struct {
+	handler *IMDSHandler
+}
+
+func NewIMDSHandlerBuilder() *IMDSHandlerBuilder {
+	return &IMDSHandlerBuilder{
+		handler: &IMDSHandler{
+			tokens: make(map[string]time.Time),
+		},
+	}
+}
+
+func (builder *IMDSHandlerBuilder) WithCredentialsProvider(credentialsProvider providers.CredentialsProvider) *IMDSHandlerBuilder {
+	builder.handler.cache.credentialsProvider = credentialsProvider
+	return builder
+}
+
+// WithRoleName sets the name of the RAM role attached to the emulated instance
+func (builder *IMDSHandlerBuilder) WithRoleName(roleName string) *IMDSHandlerBuilder {
+	builder.handler.roleName = roleName
+	return builder
+}
+
+// WithDisableIMDSv1 requires the metadata token in the requests, as the security hardening mode of ECS
+func (builder *IMDSHandlerBuilder) WithDisableIMDSv1(disableIMDSv1 bool) *IMDSHandlerBuilder {
+	builder.handler.disableIMDSv1 = disableIMDSv1
+	return builder
+}
+
+// WithClock sets the clock to decide whether the cached credentials and the metadata tokens are expired, default is the system clock
+func (builder *IMDSHandlerBuilder) WithClock(clock providers.Clock) *IMDSHandlerBuilder {
+	builder.handler.cache.clock = clock
+	return builder
+}
+
+func (builder *IMDSHandlerBuilder) Build() (handler *IMDSHandler, err error) {
+	if builder.handler.cache.credentialsProvider == nil {
+		err = errors.New("the credentials provider is nil")
+		return
+	}
+
+	if builder.handler.roleName == "" || strings.Contains(builder.handler.roleName, "/") {
+		err = errors.New("the role name is invalid")
+		return
+	}
+
+	handler = builder.handler
+	return
+}
+
+type imdsCredentialsResponse struct {
+	AccessKeyId     string `json:"AccessKeyId"`
+	AccessKeySecret string `json:"AccessKeySecret"`
+	Expiration      string `json:"Expiration"`
+	SecurityToken   string `json:"SecurityToken"`
+	LastUpdated     string `json:"LastUpdated"`
+	Code            string `json:"Code"`
+}
+
+func (handler *IMDSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == imdsTokenPath {
+		if r.Method != http.MethodPut {
+			w.Header().Set("Allow", http.MethodPut)
+			http.Error(w, "only PUT is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler.serveToken(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "only GET is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if status, err := handler.checkToken(r.Header.Get(imdsTokenHeader)); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	switch r.URL.Path {
+	case imdsSecurityCredentials:
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(handler.roleName))
+	case imdsSecurityCredentials + handler.roleName:
+		cc, expiration, lastUpdated, err := handler.cache.getCredentialsWithLastUpdated(r.Context())
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, &errorResponse{Code: "GetCredentialsFailed", Message: err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, &imdsCredentialsResponse{
+			AccessKeyId:     cc.AccessKeyId,
+			AccessKeySecret: cc.AccessKeySecret,
+			Expiration:      expiration.UTC().Format("2006-01-02T15:04:05Z"),
+			SecurityToken:   cc.SecurityToken,
+			LastUpdated:     lastUpdated.UTC().Format("2006-01-02T15:04:05Z"),
+			Code:            "Success",
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (handler *IMDSHandler) serveToken(w http.ResponseWriter, r *http.Request) {
+	ttl, err := strconv.Atoi(r.Header.Get(imdsTokenTTLHeader))
+	if err != nil || ttl < 1 || ttl > imdsMaxTokenTTLInSeconds {
+		http.Error(w, "the header "+imdsTokenTTLHeader+" must be in the range of 1 - 21600", http.StatusBadRequest)
+		return
+	}
+
+	content := make([]byte, 32)
+	_, err = rand.Read(content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(content)
+
+	handler.mutex.Lock()
+	// 清理已过期的 token
+	for key, expiration := range handler.tokens {
+		if !handler.cache.now().Before(expiration) {
+			delete(handler.tokens, key)
+		}
+	}
+	handler.tokens[token] = handler.cache.now().Add(time.Duration(ttl) * time.Second)
+	handler.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set(imdsTokenTTLHeader, strconv.Itoa(ttl))
+	w.Write([]byte(token))
+}
+
+// checkToken returns the status code and the reason if the metadata token is not accepted
+func (handler *IMDSHandler) checkToken(token string) (status int, err error) {
+	if token == "" {
+		if handler.disableIMDSv1 {
+			return http.StatusForbidden, errors.New("the metadata token is required")
+		}
+		return
+	}
+
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	expiration, ok := handler.tokens[token]
+	if !ok || !handler.cache.now().Before(expiration) {
+		return http.StatusUnauthorized, errors.New("the metadata token is invalid or expired")
+	}
+	return
+}
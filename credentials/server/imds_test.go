@@ -0,0 +1,180 @@
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/internal/clocktest"
+	"github.com/aliyun/credentials-go/credentials/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+func doIMDSRequest(t *testing.T, handler http.Handler, method string, path string, headers map[string]string) (status int, body string) {
+	req := httptest.NewRequest(method, "http://100.100.100.200"+path, nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	content, err := ioutil.ReadAll(w.Result().Body)
+	assert.Nil(t, err)
+	return w.Code, string(content)
+}
+
+func newTestIMDSProvider() *countingProvider {
+	return &countingProvider{
+		credentials: &providers.Credentials{
+			AccessKeyId:     "akid",
+			AccessKeySecret: "aksecret",
+			SecurityToken:   "ststoken",
+			Expiration:      time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC),
+		},
+	}
+}
+
+func TestNewIMDSHandler(t *testing.T) {
+	_, err := NewIMDSHandlerBuilder().Build()
+	assert.EqualError(t, err, "the credentials provider is nil")
+
+	_, err = NewIMDSHandlerBuilder().WithCredentialsProvider(&countingProvider{}).Build()
+	assert.EqualError(t, err, "the role name is invalid")
+
+	_, err = NewIMDSHandlerBuilder().WithCredentialsProvider(&countingProvider{}).WithRoleName("a/b").Build()
+	assert.EqualError(t, err, "the role name is invalid")
+
+	h, err := NewIMDSHandlerBuilder().
+		WithCredentialsProvider(&countingProvider{}).
+		WithRoleName("role").
+		WithDisableIMDSv1(true).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, "role", h.roleName)
+	assert.True(t, h.disableIMDSv1)
+}
+
+func TestIMDSHandlerIMDSv1(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	provider := newTestIMDSProvider()
+	h, err := NewIMDSHandlerBuilder().WithCredentialsProvider(provider).WithRoleName("role").WithClock(clock).Build()
+	assert.Nil(t, err)
+
+	status, body := doIMDSRequest(t, h, "GET", "/latest/meta-data/ram/security-credentials/", nil)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "role", body)
+
+	status, body = doIMDSRequest(t, h, "GET", "/latest/meta-data/ram/security-credentials/role", nil)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, `{"AccessKeyId":"akid","AccessKeySecret":"aksecret","Expiration":"2024-01-01T01:00:00Z","SecurityToken":"ststoken","LastUpdated":"2024-01-01T00:00:00Z","Code":"Success"}`, body)
+
+	status, _ = doIMDSRequest(t, h, "GET", "/latest/meta-data/ram/security-credentials/other", nil)
+	assert.Equal(t, 404, status)
+
+	status, _ = doIMDSRequest(t, h, "GET", "/latest/meta-data/instance-id", nil)
+	assert.Equal(t, 404, status)
+
+	status, _ = doIMDSRequest(t, h, "POST", "/latest/meta-data/ram/security-credentials/", nil)
+	assert.Equal(t, 405, status)
+
+	status, body = doIMDSRequest(t, h, "GET", "/latest/meta-data/ram/security-credentials/", map[string]string{
+		"X-aliyun-ecs-metadata-token": "invalid",
+	})
+	assert.Equal(t, 401, status)
+	assert.Equal(t, "the metadata token is invalid or expired\n", body)
+}
+
+func TestIMDSHandlerIMDSv2(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	h, err := NewIMDSHandlerBuilder().
+		WithCredentialsProvider(newTestIMDSProvider()).
+		WithRoleName("role").
+		WithClock(clock).
+		WithDisableIMDSv1(true).
+		Build()
+	assert.Nil(t, err)
+
+	status, body := doIMDSRequest(t, h, "GET", "/latest/meta-data/ram/security-credentials/", nil)
+	assert.Equal(t, 403, status)
+	assert.Equal(t, "the metadata token is required\n", body)
+
+	status, _ = doIMDSRequest(t, h, "GET", "/latest/api/token", nil)
+	assert.Equal(t, 405, status)
+
+	for _, ttl := range []string{"", "0", "21601", "invalid"} {
+		status, _ = doIMDSRequest(t, h, "PUT", "/latest/api/token", map[string]string{
+			"X-aliyun-ecs-metadata-token-ttl-seconds": ttl,
+		})
+		assert.Equal(t, 400, status)
+	}
+
+	status, token := doIMDSRequest(t, h, "PUT", "/latest/api/token", map[string]string{
+		"X-aliyun-ecs-metadata-token-ttl-seconds": "60",
+	})
+	assert.Equal(t, 200, status)
+	assert.NotEqual(t, "", token)
+
+	status, body = doIMDSRequest(t, h, "GET", "/latest/meta-data/ram/security-credentials/", map[string]string{
+		"X-aliyun-ecs-metadata-token": token,
+	})
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "role", body)
+
+	// the token expires
+	clock.Advance(time.Minute)
+	status, _ = doIMDSRequest(t, h, "GET", "/latest/meta-data/ram/security-credentials/", map[string]string{
+		"X-aliyun-ecs-metadata-token": token,
+	})
+	assert.Equal(t, 401, status)
+
+	// the expired tokens are removed when a new token is created
+	status, _ = doIMDSRequest(t, h, "PUT", "/latest/api/token", map[string]string{
+		"X-aliyun-ecs-metadata-token-ttl-seconds": "21600",
+	})
+	assert.Equal(t, 200, status)
+	assert.Len(t, h.tokens, 1)
+}
+
+func TestIMDSHandlerError(t *testing.T) {
+	h, err := NewIMDSHandlerBuilder().
+		WithCredentialsProvider(&countingProvider{err: errors.New("get credentials failed")}).
+		WithRoleName("role").
+		Build()
+	assert.Nil(t, err)
+
+	status, body := doIMDSRequest(t, h, "GET", "/latest/meta-data/ram/security-credentials/role", nil)
+	assert.Equal(t, 500, status)
+	assert.Equal(t, `{"Code":"GetCredentialsFailed","Message":"get credentials failed"}`, body)
+}
+
+func TestIMDSHandlerWithECSRAMRoleCredentialsProvider(t *testing.T) {
+	staticProvider, err := providers.NewStaticSTSCredentialsProviderBuilder().
+		WithAccessKeyId("akid").
+		WithAccessKeySecret("aksecret").
+		WithSecurityToken("ststoken").
+		Build()
+	assert.Nil(t, err)
+	h, err := NewIMDSHandlerBuilder().
+		WithCredentialsProvider(staticProvider).
+		WithRoleName("role").
+		WithDisableIMDSv1(true).
+		Build()
+	assert.Nil(t, err)
+
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	p, err := providers.NewECSRAMRoleCredentialsProviderBuilder().
+		WithEndpoint(server.URL).
+		WithDisableIMDSv1(true).
+		Build()
+	assert.Nil(t, err)
+	cc, err := p.GetCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "akid", cc.AccessKeyId)
+	assert.Equal(t, "aksecret", cc.AccessKeySecret)
+	assert.Equal(t, "ststoken", cc.SecurityToken)
+	assert.Equal(t, "ecs_ram_role", cc.ProviderName)
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// getPeerUID returns the uid of the process on the other side of the unix socket
+func getPeerUID(conn net.Conn) (uid int, err error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		err = errors.New("the peer uid check is only supported on the unix socket")
+		return
+	}
+
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	var ucred *syscall.Ucred
+	var ucredErr error
+	err = rawConn.Control(func(fd uintptr) {
+		ucred, ucredErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return
+	}
+	if ucredErr != nil {
+		err = ucredErr
+		return
+	}
+
+	uid = int(ucred.Uid)
+	return
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/aliyun/credentials-go/credentials/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+func getFromUnixSocket(t *testing.T, socket string) (status int, body string) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	res, err := client.Get("http://localhost/credentials")
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	content, err := ioutil.ReadAll(res.Body)
+	assert.Nil(t, err)
+	return res.StatusCode, string(content)
+}
+
+func TestHandlerAllowedUIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials-server")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	provider := &countingProvider{
+		credentials: &providers.Credentials{
+			AccessKeyId:     "akid",
+			AccessKeySecret: "aksecret",
+		},
+	}
+
+	// the uid of the current process is allowed
+	socket := path.Join(dir, "allowed.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.Nil(t, err)
+	h, err := NewHandlerBuilder().WithCredentialsProvider(provider).WithAllowedUIDs(os.Getuid()).Build()
+	assert.Nil(t, err)
+	server := h.NewServer()
+	go server.Serve(listener)
+	defer server.Close()
+
+	status, _ := getFromUnixSocket(t, socket)
+	assert.Equal(t, 200, status)
+
+	// the uid of the current process is not allowed
+	socket = path.Join(dir, "denied.sock")
+	listener, err = net.Listen("unix", socket)
+	assert.Nil(t, err)
+	h, err = NewHandlerBuilder().WithCredentialsProvider(provider).WithAllowedUIDs(os.Getuid() + 1).Build()
+	assert.Nil(t, err)
+	server = h.NewServer()
+	go server.Serve(listener)
+	defer server.Close()
+
+	status, body := getFromUnixSocket(t, socket)
+	assert.Equal(t, 403, status)
+	assert.Equal(t, `{"Code":"Forbidden","Message":"the uid of the peer process is not allowed"}`, body)
+}
+
+func TestGetPeerUIDWithTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	_, err = getPeerUID(conn)
+	assert.EqualError(t, err, "the peer uid check is only supported on the unix socket")
+}
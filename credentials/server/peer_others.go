@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func getPeerUID(conn net.Conn) (uid int, err error) {
+	err = fmt.Errorf("the peer uid check is not supported on %s", runtime.GOOS)
+	return
+}
@@ -0,0 +1,157 @@
+package signer
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/internal/clocktest"
+	"github.com/aliyun/credentials-go/credentials/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+type errorProvider struct {
+}
+
+func (provider *errorProvider) GetCredentials() (*providers.Credentials, error) {
+	return nil, errors.New("get credentials failed")
+}
+
+func (provider *errorProvider) GetProviderName() string {
+	return "error"
+}
+
+// mockSignContext fixes the nonce of the signatures, the returned clock fixes the time of them
+func mockSignContext(date time.Time, nonce string) (clock *clocktest.FakeClock, rollback func()) {
+	originGetNonce := getNonce
+	getNonce = func() string {
+		return nonce
+	}
+	return clocktest.NewFakeClock(date), func() {
+		getNonce = originGetNonce
+	}
+}
+
+func newStaticAKProvider(t *testing.T) providers.CredentialsProvider {
+	provider, err := providers.NewStaticAKCredentialsProviderBuilder().
+		WithAccessKeyId("YourAccessKeyId").
+		WithAccessKeySecret("YourAccessKeySecret").
+		Build()
+	assert.Nil(t, err)
+	return provider
+}
+
+func TestNewACS3Signer(t *testing.T) {
+	_, err := NewACS3SignerBuilder().Build()
+	assert.EqualError(t, err, "the credentials provider is nil")
+
+	s, err := NewACS3SignerBuilder().WithCredentialsProvider(newStaticAKProvider(t)).Build()
+	assert.Nil(t, err)
+	assert.NotNil(t, s.credentialsProvider)
+}
+
+func TestACS3SignerSign(t *testing.T) {
+	clock, rollback := mockSignContext(time.Date(2023, 10, 26, 10, 22, 32, 0, time.UTC), "3156853299f313e23d1673dc12e1703d")
+	defer rollback()
+
+	s, err := NewACS3SignerBuilder().WithCredentialsProvider(newStaticAKProvider(t)).WithClock(clock).Build()
+	assert.Nil(t, err)
+
+	// the request of the example of the V3 signature in the document of OpenAPI
+	req, err := http.NewRequest("POST", "https://ecs.cn-beijing.aliyuncs.com/?ImageId=win2019_1809_x64_dtc_zh-cn_40G_alibase_20230811.vhd&RegionId=cn-shanghai", nil)
+	assert.Nil(t, err)
+	req.Header.Set("x-acs-action", "RunInstances")
+	req.Header.Set("x-acs-version", "2014-05-26")
+	err = s.Sign(context.Background(), req)
+	assert.Nil(t, err)
+
+	canonicalRequest, signedHeaders := buildACS3CanonicalRequest(req)
+	assert.Equal(t, "POST\n"+
+		"/\n"+
+		"ImageId=win2019_1809_x64_dtc_zh-cn_40G_alibase_20230811.vhd&RegionId=cn-shanghai\n"+
+		"host:ecs.cn-beijing.aliyuncs.com\n"+
+		"x-acs-action:RunInstances\n"+
+		"x-acs-content-sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n"+
+		"x-acs-date:2023-10-26T10:22:32Z\n"+
+		"x-acs-signature-nonce:3156853299f313e23d1673dc12e1703d\n"+
+		"x-acs-version:2014-05-26\n"+
+		"\n"+
+		"host;x-acs-action;x-acs-content-sha256;x-acs-date;x-acs-signature-nonce;x-acs-version\n"+
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", canonicalRequest)
+	assert.Equal(t, "host;x-acs-action;x-acs-content-sha256;x-acs-date;x-acs-signature-nonce;x-acs-version", signedHeaders)
+	assert.Equal(t, "ACS3-HMAC-SHA256 Credential=YourAccessKeyId,SignedHeaders=host;x-acs-action;x-acs-content-sha256;x-acs-date;x-acs-signature-nonce;x-acs-version,Signature=f58128ac4f117728d3c557020de6e063bfde363b287c1ea00687a8d3895b313f", req.Header.Get("Authorization"))
+}
+
+func TestACS3SignerSignWithBody(t *testing.T) {
+	clock, rollback := mockSignContext(time.Date(2023, 10, 26, 10, 22, 32, 0, time.UTC), "nonce")
+	defer rollback()
+
+	provider, err := providers.NewStaticSTSCredentialsProviderBuilder().
+		WithAccessKeyId("akid").
+		WithAccessKeySecret("aksecret").
+		WithSecurityToken("ststoken").
+		Build()
+	assert.Nil(t, err)
+	s, err := NewACS3SignerBuilder().WithCredentialsProvider(provider).WithClock(clock).Build()
+	assert.Nil(t, err)
+
+	req, err := http.NewRequest("PUT", "https://example.aliyuncs.com/a b/c*~?b=2&a=1&a=0&empty&k-1=x", strings.NewReader(`{"key":"value"}`))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Acs-Action", "Test")
+	req.Header.Set("User-Agent", "test")
+	err = s.Sign(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, "ststoken", req.Header.Get("x-acs-security-token"))
+	assert.Equal(t, hashSHA256([]byte(`{"key":"value"}`)), req.Header.Get("x-acs-content-sha256"))
+
+	canonicalRequest, signedHeaders := buildACS3CanonicalRequest(req)
+	assert.Equal(t, "PUT\n"+
+		"/a%20b/c%2A~\n"+
+		"a=0&a=1&b=2&empty=&k-1=x\n"+
+		"content-type:application/json\n"+
+		"host:example.aliyuncs.com\n"+
+		"x-acs-action:Test\n"+
+		"x-acs-content-sha256:"+hashSHA256([]byte(`{"key":"value"}`))+"\n"+
+		"x-acs-date:2023-10-26T10:22:32Z\n"+
+		"x-acs-security-token:ststoken\n"+
+		"x-acs-signature-nonce:nonce\n"+
+		"\n"+
+		"content-type;host;x-acs-action;x-acs-content-sha256;x-acs-date;x-acs-security-token;x-acs-signature-nonce\n"+
+		hashSHA256([]byte(`{"key":"value"}`)), canonicalRequest)
+	assert.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "ACS3-HMAC-SHA256 Credential=akid,SignedHeaders="+signedHeaders+",Signature="))
+
+	// the body can still be sent
+	body, err := ioutil.ReadAll(req.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"key":"value"}`, string(body))
+	bodyReader, err := req.GetBody()
+	assert.Nil(t, err)
+	body, err = ioutil.ReadAll(bodyReader)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"key":"value"}`, string(body))
+
+	// sign again with the access key, the security token is removed
+	authorization := req.Header.Get("Authorization")
+	s, err = NewACS3SignerBuilder().WithCredentialsProvider(newStaticAKProvider(t)).Build()
+	assert.Nil(t, err)
+	err = s.Sign(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, "", req.Header.Get("x-acs-security-token"))
+	assert.NotEqual(t, authorization, req.Header.Get("Authorization"))
+	assert.Len(t, req.Header.Values("Authorization"), 1)
+}
+
+func TestACS3SignerSignError(t *testing.T) {
+	s, err := NewACS3SignerBuilder().WithCredentialsProvider(&errorProvider{}).Build()
+	assert.Nil(t, err)
+	req, err := http.NewRequest("GET", "https://example.aliyuncs.com/", nil)
+	assert.Nil(t, err)
+	err = s.Sign(context.Background(), req)
+	assert.EqualError(t, err, "get credentials failed")
+	assert.Equal(t, "", req.Header.Get("Authorization"))
+}
@@ -0,0 +1,317 @@
+package signer
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/internal/utils"
+	"github.com/aliyun/credentials-go/credentials/providers"
+)
+
+const (
+	ossV4Algorithm        = "OSS4-HMAC-SHA256"
+	ossV4Product          = "oss"
+	ossV4UnsignedPayload  = "UNSIGNED-PAYLOAD"
+	ossV4MaxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// the query parameters added by Presign()
+var ossV4PresignParams = []string{
+	"x-oss-security-token",
+	"x-oss-signature-version",
+	"x-oss-date",
+	"x-oss-expires",
+	"x-oss-credential",
+	"x-oss-additional-headers",
+	"x-oss-signature",
+}
+
+// OSSSigner signs the requests of OSS with the V4 signature (OSS4-HMAC-SHA256).
+// The bucket is got from the host of the virtual hosted style endpoint, such as bucket.oss-cn-hangzhou.aliyuncs.com,
+// or from the path of the path style endpoint. Set it by WithBucket() when a custom domain is used.
+type OSSSigner struct {
+	credentialsProvider providers.CredentialsProvider
+	region              string
+	bucket              string
+	additionalHeaders   []string
+	// the time of the signatures
+	clock providers.Clock
+}
+
+type OSSSignerBuilder struct {
+	signer *OSSSigner
+}
+
+func NewOSSSignerBuilder() *OSSSignerBuilder {
+	return &OSSSignerBuilder{
+		signer: &OSSSigner{},
+	}
+}
+
+func (builder *OSSSignerBuilder) WithCredentialsProvider(credentialsProvider providers.CredentialsProvider) *OSSSignerBuilder {
+	builder.signer.credentialsProvider = credentialsProvider
+	return builder
+}
+
+// WithClock sets the clock of the time of the signatures, default is the system clock
+func (builder *OSSSignerBuilder) WithClock(clock providers.Clock) *OSSSignerBuilder {
+	builder.signer.clock = clock
+	return builder
+}
+
+// WithRegion sets the region of the bucket, such as cn-hangzhou
+func (builder *OSSSignerBuilder) WithRegion(region string) *OSSSignerBuilder {
+	builder.signer.region = region
+	return builder
+}
+
+// WithBucket sets the bucket of the requests which are sent to a custom domain bound to the bucket
+func (builder *OSSSignerBuilder) WithBucket(bucket string) *OSSSignerBuilder {
+	builder.signer.bucket = bucket
+	return builder
+}
+
+// WithAdditionalHeaders sets the names of the headers which are signed besides Content-Type, Content-MD5 and x-oss-*
+func (builder *OSSSignerBuilder) WithAdditionalHeaders(additionalHeaders ...string) *OSSSignerBuilder {
+	builder.signer.additionalHeaders = additionalHeaders
+	return builder
+}
+
+func (builder *OSSSignerBuilder) Build() (signer *OSSSigner, err error) {
+	if builder.signer.credentialsProvider == nil {
+		err = errors.New("the credentials provider is nil")
+		return
+	}
+
+	if builder.signer.region == "" {
+		err = errors.New("the region is empty")
+		return
+	}
+
+	signer = builder.signer
+	return
+}
+
+func (signer *OSSSigner) getCredentialsProvider() providers.CredentialsProvider {
+	return signer.credentialsProvider
+}
+
+// Sign gets the credentials from the credentials provider and signs req with the Authorization header
+func (signer *OSSSigner) Sign(ctx context.Context, req *http.Request) (err error) {
+	cc, err := getCredentials(ctx, signer.credentialsProvider)
+	if err != nil {
+		return
+	}
+
+	signTime := nowOf(signer.clock).UTC()
+	scope := signer.getScope(signTime)
+
+	// 重新签名时清除上一次签名的结果
+	req.Header.Del("Authorization")
+	req.Header.Del("x-oss-security-token")
+	req.Header.Set("x-oss-date", signTime.Format("20060102T150405Z"))
+	req.Header.Set("x-oss-content-sha256", ossV4UnsignedPayload)
+	if cc.SecurityToken != "" {
+		req.Header.Set("x-oss-security-token", cc.SecurityToken)
+	}
+
+	additionalHeaders := signer.getAdditionalHeaders(req)
+	signature := signer.calculateSignature(req, cc.AccessKeySecret, signTime, additionalHeaders)
+
+	authorization := fmt.Sprintf("%s Credential=%s/%s", ossV4Algorithm, cc.AccessKeyId, scope)
+	if len(additionalHeaders) > 0 {
+		authorization += ",AdditionalHeaders=" + strings.Join(additionalHeaders, ";")
+	}
+	req.Header.Set("Authorization", authorization+",Signature="+signature)
+	return
+}
+
+// Presign adds the signature to the query string of req, so that req.URL can be used without credentials until it expires.
+// expires is at most 7 days.
+func (signer *OSSSigner) Presign(ctx context.Context, req *http.Request, expires time.Duration) (err error) {
+	if expires <= 0 || expires > ossV4MaxPresignExpiry {
+		err = fmt.Errorf("the expires must be in the range of 1s - 7d, got %s", expires)
+		return
+	}
+
+	cc, err := getCredentials(ctx, signer.credentialsProvider)
+	if err != nil {
+		return
+	}
+
+	signTime := nowOf(signer.clock).UTC()
+	scope := signer.getScope(signTime)
+	additionalHeaders := signer.getAdditionalHeaders(req)
+
+	query := req.URL.Query()
+	// 重新签名时清除上一次签名的结果
+	for _, key := range ossV4PresignParams {
+		query.Del(key)
+	}
+	if cc.SecurityToken != "" {
+		query.Set("x-oss-security-token", cc.SecurityToken)
+	}
+	query.Set("x-oss-signature-version", ossV4Algorithm)
+	query.Set("x-oss-date", signTime.Format("20060102T150405Z"))
+	query.Set("x-oss-expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	query.Set("x-oss-credential", cc.AccessKeyId+"/"+scope)
+	if len(additionalHeaders) > 0 {
+		query.Set("x-oss-additional-headers", strings.Join(additionalHeaders, ";"))
+	}
+	req.URL.RawQuery = encodeOSSQuery(query)
+
+	query.Set("x-oss-signature", signer.calculateSignature(req, cc.AccessKeySecret, signTime, additionalHeaders))
+	req.URL.RawQuery = encodeOSSQuery(query)
+	return
+}
+
+func (signer *OSSSigner) getScope(signTime time.Time) string {
+	return signTime.Format("20060102") + "/" + signer.region + "/" + ossV4Product + "/aliyun_v4_request"
+}
+
+// getAdditionalHeaders returns the sorted lower case names of the additional headers which are present in req
+func (signer *OSSSigner) getAdditionalHeaders(req *http.Request) (additionalHeaders []string) {
+	names := make(map[string]bool)
+	for _, name := range signer.additionalHeaders {
+		name = strings.ToLower(name)
+		if isOSSDefaultSignedHeader(name) || req.Header.Get(name) == "" {
+			continue
+		}
+		names[name] = true
+	}
+
+	for name := range names {
+		additionalHeaders = append(additionalHeaders, name)
+	}
+	sort.Strings(additionalHeaders)
+	return
+}
+
+func (signer *OSSSigner) calculateSignature(req *http.Request, accessKeySecret string, signTime time.Time, additionalHeaders []string) string {
+	canonicalRequest := signer.buildCanonicalRequest(req, additionalHeaders)
+	stringToSign := strings.Join([]string{
+		ossV4Algorithm,
+		signTime.Format("20060102T150405Z"),
+		signer.getScope(signTime),
+		hashSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("aliyun_v4"+accessKeySecret), signTime.Format("20060102"))
+	signingKey = hmacSHA256(signingKey, signer.region)
+	signingKey = hmacSHA256(signingKey, ossV4Product)
+	signingKey = hmacSHA256(signingKey, "aliyun_v4_request")
+	return hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+}
+
+// buildCanonicalRequest returns the canonical request of req, the Content-Type, Content-MD5, x-oss-* and additional headers are signed
+func (signer *OSSSigner) buildCanonicalRequest(req *http.Request, additionalHeaders []string) string {
+	signedHeaders := make(map[string]bool)
+	for _, name := range additionalHeaders {
+		signedHeaders[name] = true
+	}
+
+	headerNames := []string{}
+	for key := range req.Header {
+		key = strings.ToLower(key)
+		if isOSSDefaultSignedHeader(key) || signedHeaders[key] {
+			headerNames = append(headerNames, key)
+		}
+	}
+	sort.Strings(headerNames)
+
+	var canonicalHeaders strings.Builder
+	for _, key := range headerNames {
+		values := []string{}
+		for _, value := range req.Header.Values(key) {
+			values = append(values, strings.TrimSpace(value))
+		}
+		canonicalHeaders.WriteString(key + ":" + strings.Join(values, ",") + "\n")
+	}
+
+	hashedPayload := req.Header.Get("x-oss-content-sha256")
+	if hashedPayload == "" {
+		hashedPayload = ossV4UnsignedPayload
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		buildCanonicalURI(signer.getCanonicalPath(req)),
+		buildOSSCanonicalQueryString(req.URL.Query()),
+		canonicalHeaders.String(),
+		strings.Join(additionalHeaders, ";"),
+		hashedPayload,
+	}, "\n")
+}
+
+// getCanonicalPath returns /bucket/object of req
+func (signer *OSSSigner) getCanonicalPath(req *http.Request) string {
+	bucket := signer.bucket
+	if bucket == "" {
+		host := getHost(req)
+		// 虚拟托管风格的域名：bucket.oss-cn-hangzhou.aliyuncs.com
+		if index := strings.Index(host, "."); index > 0 && strings.HasPrefix(host[index+1:], "oss-") {
+			bucket = host[:index]
+		}
+	}
+
+	path := req.URL.Path
+	if bucket != "" {
+		path = "/" + bucket + "/" + strings.TrimPrefix(path, "/")
+	}
+	if path == "" {
+		path = "/"
+	}
+	return path
+}
+
+// buildOSSCanonicalQueryString sorts the encoded parameters by key, only the key is kept for the parameters without value
+func buildOSSCanonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	encodedKeys := make(map[string]string, len(query))
+	for key := range query {
+		encodedKey := utils.PercentEncode(key)
+		keys = append(keys, encodedKey)
+		encodedKeys[encodedKey] = key
+	}
+	sort.Strings(keys)
+
+	params := make([]string, 0, len(keys))
+	for _, encodedKey := range keys {
+		value := query.Get(encodedKeys[encodedKey])
+		if value == "" {
+			params = append(params, encodedKey)
+			continue
+		}
+		params = append(params, encodedKey+"="+utils.PercentEncode(value))
+	}
+	return strings.Join(params, "&")
+}
+
+// encodeOSSQuery encodes the query by RFC 3986, the parameters are sorted by key
+func encodeOSSQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	params := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range query[key] {
+			params = append(params, utils.PercentEncode(key)+"="+utils.PercentEncode(value))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func isOSSDefaultSignedHeader(name string) bool {
+	return name == "content-type" || name == "content-md5" || strings.HasPrefix(name, "x-oss-")
+}
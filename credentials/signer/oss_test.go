@@ -0,0 +1,172 @@
+package signer
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+func newOSSTestRequest(t *testing.T) *http.Request {
+	req, err := http.NewRequest("PUT", "http://bucket.oss-cn-hangzhou.aliyuncs.com/1234+-/123/1.txt", nil)
+	assert.Nil(t, err)
+	req.Header.Add("x-oss-head1", "value")
+	req.Header.Add("abc", "value")
+	req.Header.Add("ZAbc", "value")
+	req.Header.Add("XYZ", "value")
+	req.Header.Add("content-type", "text/plain")
+	req.Header.Add("x-oss-content-sha256", "UNSIGNED-PAYLOAD")
+
+	query := url.Values{}
+	query.Add("param1", "value1")
+	query.Add("+param1", "value3")
+	query.Add("|param1", "value4")
+	query.Add("+param2", "")
+	query.Add("|param2", "")
+	query.Add("param2", "")
+	req.URL.RawQuery = query.Encode()
+	return req
+}
+
+func newOSSTestSigner(t *testing.T, clock providers.Clock, additionalHeaders ...string) *OSSSigner {
+	provider, err := providers.NewStaticAKCredentialsProviderBuilder().
+		WithAccessKeyId("ak").
+		WithAccessKeySecret("sk").
+		Build()
+	assert.Nil(t, err)
+	s, err := NewOSSSignerBuilder().
+		WithCredentialsProvider(provider).
+		WithRegion("cn-hangzhou").
+		WithAdditionalHeaders(additionalHeaders...).
+		WithClock(clock).
+		Build()
+	assert.Nil(t, err)
+	return s
+}
+
+func TestOSSSignerSign(t *testing.T) {
+	clock, rollback := mockSignContext(time.Unix(1702743657, 0), "")
+	defer rollback()
+
+	// the test case of the V4 signature of OSS SDK
+	req := newOSSTestRequest(t)
+	err := newOSSTestSigner(t, clock).Sign(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, "20231216T162057Z", req.Header.Get("x-oss-date"))
+	assert.Equal(t, "OSS4-HMAC-SHA256 Credential=ak/20231216/cn-hangzhou/oss/aliyun_v4_request,Signature=e21d18daa82167720f9b1047ae7e7f1ce7cb77a31e8203a7d5f4624fa0284afe", req.Header.Get("Authorization"))
+
+	// the additional headers are signed, the absent and the default signed ones are ignored
+	req = newOSSTestRequest(t)
+	err = newOSSTestSigner(t, clock, "ZAbc", "abc", "Content-Type", "absent").Sign(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, "OSS4-HMAC-SHA256 Credential=ak/20231216/cn-hangzhou/oss/aliyun_v4_request,AdditionalHeaders=abc;zabc,Signature=47c9e374f165ecb7e92d150f3e9639082b74d3578a56e388f81a2ad652f4d254", req.Header.Get("Authorization"))
+}
+
+func TestOSSSignerPresign(t *testing.T) {
+	clock, rollback := mockSignContext(time.Unix(1702781677, 0), "")
+	defer rollback()
+
+	req := newOSSTestRequest(t)
+	err := newOSSTestSigner(t, clock).Presign(context.Background(), req, 599*time.Second)
+	assert.Nil(t, err)
+	query := req.URL.Query()
+	assert.Equal(t, "OSS4-HMAC-SHA256", query.Get("x-oss-signature-version"))
+	assert.Equal(t, "599", query.Get("x-oss-expires"))
+	assert.Equal(t, "20231217T025437Z", query.Get("x-oss-date"))
+	assert.Equal(t, "ak/20231217/cn-hangzhou/oss/aliyun_v4_request", query.Get("x-oss-credential"))
+	assert.Equal(t, "68d37151554dd059c0bb4083e0ae81fedaf28c9c44443c8ae5bdec00e4cdb8ba", query.Get("x-oss-signature"))
+	assert.Equal(t, "", query.Get("x-oss-additional-headers"))
+}
+
+func TestOSSSignerSignWithSecurityToken(t *testing.T) {
+	clock, rollback := mockSignContext(time.Unix(1702743657, 0), "")
+	defer rollback()
+
+	provider, err := providers.NewStaticSTSCredentialsProviderBuilder().
+		WithAccessKeyId("ak").
+		WithAccessKeySecret("sk").
+		WithSecurityToken("token").
+		Build()
+	assert.Nil(t, err)
+	s, err := NewOSSSignerBuilder().WithCredentialsProvider(provider).WithRegion("cn-hangzhou").WithClock(clock).Build()
+	assert.Nil(t, err)
+
+	req, err := http.NewRequest("GET", "https://bucket.oss-cn-hangzhou.aliyuncs.com/a/b.txt?x-oss-process=image/resize,w_100", nil)
+	assert.Nil(t, err)
+	err = s.Sign(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, "token", req.Header.Get("x-oss-security-token"))
+	assert.Equal(t, "UNSIGNED-PAYLOAD", req.Header.Get("x-oss-content-sha256"))
+	assert.Equal(t, "GET\n"+
+		"/bucket/a/b.txt\n"+
+		"x-oss-process=image%2Fresize%2Cw_100\n"+
+		"x-oss-content-sha256:UNSIGNED-PAYLOAD\n"+
+		"x-oss-date:20231216T162057Z\n"+
+		"x-oss-security-token:token\n"+
+		"\n"+
+		"\n"+
+		"UNSIGNED-PAYLOAD", s.buildCanonicalRequest(req, nil))
+
+	err = s.Presign(context.Background(), req, time.Hour)
+	assert.Nil(t, err)
+	query := req.URL.Query()
+	assert.Equal(t, "token", query.Get("x-oss-security-token"))
+	assert.Equal(t, "3600", query.Get("x-oss-expires"))
+	assert.Equal(t, "image/resize,w_100", query.Get("x-oss-process"))
+	signature := query.Get("x-oss-signature")
+	assert.Len(t, signature, 64)
+
+	// presign again, the previous signature is replaced
+	err = s.Presign(context.Background(), req, 2*time.Hour)
+	assert.Nil(t, err)
+	query = req.URL.Query()
+	assert.Len(t, query["x-oss-signature"], 1)
+	assert.Equal(t, "7200", query.Get("x-oss-expires"))
+	assert.NotEqual(t, signature, query.Get("x-oss-signature"))
+
+	err = s.Presign(context.Background(), req, 0)
+	assert.EqualError(t, err, "the expires must be in the range of 1s - 7d, got 0s")
+	err = s.Presign(context.Background(), req, 8*24*time.Hour)
+	assert.EqualError(t, err, "the expires must be in the range of 1s - 7d, got 192h0m0s")
+}
+
+func TestOSSSignerCanonicalPath(t *testing.T) {
+	s := newOSSTestSigner(t, nil)
+	for rawURL, path := range map[string]string{
+		"https://bucket.oss-cn-hangzhou.aliyuncs.com":           "/bucket/",
+		"https://bucket.oss-cn-hangzhou.aliyuncs.com/a/b.txt":   "/bucket/a/b.txt",
+		"https://oss-cn-hangzhou.aliyuncs.com":                  "/",
+		"https://oss-cn-hangzhou.aliyuncs.com/bucket/a/b.txt":   "/bucket/a/b.txt",
+		"https://bucket.oss-cn-hangzhou-internal.aliyuncs.com/": "/bucket/",
+	} {
+		req, err := http.NewRequest("GET", rawURL, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, path, s.getCanonicalPath(req), rawURL)
+	}
+
+	s.bucket = "custom"
+	req, err := http.NewRequest("GET", "https://static.example.com/a/b.txt", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "/custom/a/b.txt", s.getCanonicalPath(req))
+}
+
+func TestNewOSSSigner(t *testing.T) {
+	_, err := NewOSSSignerBuilder().Build()
+	assert.EqualError(t, err, "the credentials provider is nil")
+
+	_, err = NewOSSSignerBuilder().WithCredentialsProvider(newStaticAKProvider(t)).Build()
+	assert.EqualError(t, err, "the region is empty")
+
+	s, err := NewOSSSignerBuilder().WithCredentialsProvider(&errorProvider{}).WithRegion("cn-hangzhou").Build()
+	assert.Nil(t, err)
+	req, err := http.NewRequest("GET", "https://bucket.oss-cn-hangzhou.aliyuncs.com/", nil)
+	assert.Nil(t, err)
+	err = s.Sign(context.Background(), req)
+	assert.EqualError(t, err, "get credentials failed")
+	err = s.Presign(context.Background(), req, time.Hour)
+	assert.EqualError(t, err, "get credentials failed")
+}
@@ -0,0 +1,145 @@
+package signer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aliyun/credentials-go/credentials/internal/utils"
+	"github.com/aliyun/credentials-go/credentials/providers"
+)
+
+// RPCSigner signs the requests of the RPC style OpenAPI with the HMAC-SHA1 signature (the V1 signature).
+// The caller sets the Action, Version and Format parameters, and the signer adds AccessKeyId, SignatureMethod,
+// SignatureVersion, SignatureNonce, Timestamp, SecurityToken (for STS credentials) and Signature.
+// The parameters can be signed again, such as before a retry.
+type RPCSigner struct {
+	credentialsProvider providers.CredentialsProvider
+	// the time of the signatures
+	clock providers.Clock
+}
+
+type RPCSignerBuilder struct {
+	signer *RPCSigner
+}
+
+func NewRPCSignerBuilder() *RPCSignerBuilder {
+	return &RPCSignerBuilder{
+		signer: &RPCSigner{},
+	}
+}
+
+func (builder *RPCSignerBuilder) WithCredentialsProvider(credentialsProvider providers.CredentialsProvider) *RPCSignerBuilder {
+	builder.signer.credentialsProvider = credentialsProvider
+	return builder
+}
+
+// WithClock sets the clock of the time of the signatures, default is the system clock
+func (builder *RPCSignerBuilder) WithClock(clock providers.Clock) *RPCSignerBuilder {
+	builder.signer.clock = clock
+	return builder
+}
+
+func (builder *RPCSignerBuilder) Build() (signer *RPCSigner, err error) {
+	if builder.signer.credentialsProvider == nil {
+		err = errors.New("the credentials provider is nil")
+		return
+	}
+
+	signer = builder.signer
+	return
+}
+
+func (signer *RPCSigner) getCredentialsProvider() providers.CredentialsProvider {
+	return signer.credentialsProvider
+}
+
+// SignParams adds the parameters of the signature to queries, form is the parameters in the body and it can be nil
+func (signer *RPCSigner) SignParams(ctx context.Context, method string, queries map[string]string, form map[string]string) (err error) {
+	cc, err := getCredentials(ctx, signer.credentialsProvider)
+	if err != nil {
+		return
+	}
+
+	delete(queries, "Signature")
+	delete(queries, "SecurityToken")
+	queries["AccessKeyId"] = cc.AccessKeyId
+	queries["SignatureMethod"] = "HMAC-SHA1"
+	queries["SignatureVersion"] = "1.0"
+	queries["SignatureNonce"] = getNonce()
+	queries["Timestamp"] = nowOf(signer.clock).UTC().Format("2006-01-02T15:04:05Z")
+	if cc.SecurityToken != "" {
+		queries["SecurityToken"] = cc.SecurityToken
+	}
+
+	signParams := make(map[string]string)
+	for key, value := range queries {
+		signParams[key] = value
+	}
+	for key, value := range form {
+		signParams[key] = value
+	}
+
+	stringToSign := utils.GetRPCStringToSign(method, signParams)
+	queries["Signature"] = utils.GetRPCSignature(stringToSign, cc.AccessKeySecret)
+	return
+}
+
+// getSingleValues returns the parameters of values, the RPC style OpenAPI flattens the lists as Key.1, Key.2 and so on,
+// so a repeated parameter can not be signed
+func getSingleValues(values url.Values, location string) (params map[string]string, err error) {
+	params = make(map[string]string)
+	for key, value := range values {
+		if len(value) > 1 {
+			err = fmt.Errorf("the %s parameter %s is repeated, the RPC style OpenAPI requires a single value, such as %s.1 and %s.2 for a list", location, key, key, key)
+			return
+		}
+		params[key] = value[0]
+	}
+	return
+}
+
+// Sign signs the parameters in the query string and the form body (application/x-www-form-urlencoded) of req,
+// the query string of req is replaced with the signed one. The repeated parameters are not supported.
+func (signer *RPCSigner) Sign(ctx context.Context, req *http.Request) (err error) {
+	queries, err := getSingleValues(req.URL.Query(), "query")
+	if err != nil {
+		return
+	}
+
+	var form map[string]string
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		body, err := readBody(req)
+		if err != nil {
+			return err
+		}
+
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return err
+		}
+
+		form, err = getSingleValues(values, "form")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = signer.SignParams(ctx, req.Method, queries, form)
+	if err != nil {
+		return
+	}
+
+	pairs := make([]string, 0, len(queries))
+	for key, value := range queries {
+		pairs = append(pairs, utils.PercentEncode(key)+"="+utils.PercentEncode(value))
+	}
+	sort.Strings(pairs)
+	req.URL.RawQuery = strings.Join(pairs, "&")
+	return
+}
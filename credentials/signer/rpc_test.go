@@ -0,0 +1,157 @@
+package signer
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/internal/utils"
+	"github.com/aliyun/credentials-go/credentials/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRPCSigner(t *testing.T) {
+	_, err := NewRPCSignerBuilder().Build()
+	assert.EqualError(t, err, "the credentials provider is nil")
+
+	s, err := NewRPCSignerBuilder().WithCredentialsProvider(newStaticAKProvider(t)).Build()
+	assert.Nil(t, err)
+	assert.NotNil(t, s.credentialsProvider)
+}
+
+func TestRPCSignerSignParams(t *testing.T) {
+	clock, rollback := mockSignContext(time.Date(2016, 2, 23, 12, 46, 24, 0, time.UTC), "3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf")
+	defer rollback()
+
+	provider, err := providers.NewStaticAKCredentialsProviderBuilder().
+		WithAccessKeyId("testid").
+		WithAccessKeySecret("testsecret").
+		Build()
+	assert.Nil(t, err)
+	s, err := NewRPCSignerBuilder().WithCredentialsProvider(provider).WithClock(clock).Build()
+	assert.Nil(t, err)
+
+	// the example of the V1 signature in the document of ECS
+	queries := map[string]string{
+		"Action":  "DescribeRegions",
+		"Format":  "XML",
+		"Version": "2014-05-26",
+	}
+	err = s.SignParams(context.Background(), "GET", queries, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"AccessKeyId":      "testid",
+		"Action":           "DescribeRegions",
+		"Format":           "XML",
+		"Signature":        "OLeaidS1JvxuMvnyHOwuJ+uX5qY=",
+		"SignatureMethod":  "HMAC-SHA1",
+		"SignatureNonce":   "3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf",
+		"SignatureVersion": "1.0",
+		"Timestamp":        "2016-02-23T12:46:24Z",
+		"Version":          "2014-05-26",
+	}, queries)
+
+	// the form parameters are signed too
+	queries = map[string]string{
+		"Action": "AssumeRole",
+	}
+	form := map[string]string{
+		"RoleArn": "acs:ram::123:role/test",
+	}
+	err = s.SignParams(context.Background(), "POST", queries, form)
+	assert.Nil(t, err)
+	signParams := map[string]string{
+		"RoleArn": "acs:ram::123:role/test",
+	}
+	for key, value := range queries {
+		if key != "Signature" {
+			signParams[key] = value
+		}
+	}
+	assert.Equal(t, utils.GetRPCSignature(utils.GetRPCStringToSign("POST", signParams), "testsecret"), queries["Signature"])
+	_, ok := queries["RoleArn"]
+	assert.False(t, ok)
+
+	s, err = NewRPCSignerBuilder().WithCredentialsProvider(&errorProvider{}).Build()
+	assert.Nil(t, err)
+	err = s.SignParams(context.Background(), "GET", queries, nil)
+	assert.EqualError(t, err, "get credentials failed")
+}
+
+func TestRPCSignerSign(t *testing.T) {
+	clock, rollback := mockSignContext(time.Date(2016, 2, 23, 12, 46, 24, 0, time.UTC), "nonce")
+	defer rollback()
+
+	provider, err := providers.NewStaticSTSCredentialsProviderBuilder().
+		WithAccessKeyId("akid").
+		WithAccessKeySecret("aksecret").
+		WithSecurityToken("ststoken").
+		Build()
+	assert.Nil(t, err)
+	s, err := NewRPCSignerBuilder().WithCredentialsProvider(provider).WithClock(clock).Build()
+	assert.Nil(t, err)
+
+	req, err := http.NewRequest("POST", "https://sts.aliyuncs.com/?Action=AssumeRole&Version=2015-04-01&Format=JSON", strings.NewReader("RoleArn=acs%3Aram%3A%3A123%3Arole%2Ftest&RoleSessionName=a+b"))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	err = s.Sign(context.Background(), req)
+	assert.Nil(t, err)
+
+	query := req.URL.Query()
+	assert.Equal(t, "akid", query.Get("AccessKeyId"))
+	assert.Equal(t, "ststoken", query.Get("SecurityToken"))
+	assert.Equal(t, "2016-02-23T12:46:24Z", query.Get("Timestamp"))
+	assert.Equal(t, "", query.Get("RoleArn"))
+	signParams := map[string]string{
+		"RoleArn":         "acs:ram::123:role/test",
+		"RoleSessionName": "a b",
+	}
+	for key := range query {
+		if key != "Signature" {
+			signParams[key] = query.Get(key)
+		}
+	}
+	assert.Equal(t, utils.GetRPCSignature(utils.GetRPCStringToSign("POST", signParams), "aksecret"), query.Get("Signature"))
+	assert.True(t, strings.HasPrefix(req.URL.RawQuery, "AccessKeyId=akid&Action=AssumeRole&Format=JSON&SecurityToken=ststoken&Signature="))
+
+	// the body can still be sent
+	body, err := ioutil.ReadAll(req.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, "RoleArn=acs%3Aram%3A%3A123%3Arole%2Ftest&RoleSessionName=a+b", string(body))
+
+	// sign again with the access key, the signature of the previous credentials is replaced
+	signature := query.Get("Signature")
+	s, err = NewRPCSignerBuilder().WithCredentialsProvider(newStaticAKProvider(t)).Build()
+	assert.Nil(t, err)
+	err = s.Sign(context.Background(), req)
+	assert.Nil(t, err)
+	query = req.URL.Query()
+	assert.Equal(t, "YourAccessKeyId", query.Get("AccessKeyId"))
+	assert.Equal(t, "", query.Get("SecurityToken"))
+	assert.Len(t, query["Signature"], 1)
+	assert.NotEqual(t, signature, query.Get("Signature"))
+
+	// invalid form body
+	req, err = http.NewRequest("POST", "https://sts.aliyuncs.com/", strings.NewReader("%zz"))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	err = s.Sign(context.Background(), req)
+	assert.EqualError(t, err, `invalid URL escape "%zz"`)
+
+	// repeated parameters
+	req, err = http.NewRequest("GET", "https://ecs.aliyuncs.com/?Action=DescribeInstances&InstanceIds=i-1&InstanceIds=i-2", nil)
+	assert.Nil(t, err)
+	err = s.Sign(context.Background(), req)
+	assert.EqualError(t, err, "the query parameter InstanceIds is repeated, the RPC style OpenAPI requires a single value, such as InstanceIds.1 and InstanceIds.2 for a list")
+	assert.Equal(t, "Action=DescribeInstances&InstanceIds=i-1&InstanceIds=i-2", req.URL.RawQuery)
+
+	req, err = http.NewRequest("POST", "https://ecs.aliyuncs.com/?Action=DescribeInstances", strings.NewReader("Tag=a&Tag=b"))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	err = s.Sign(context.Background(), req)
+	assert.EqualError(t, err, "the form parameter Tag is repeated, the RPC style OpenAPI requires a single value, such as Tag.1 and Tag.2 for a list")
+	assert.Equal(t, "Action=DescribeInstances", req.URL.RawQuery)
+}
@@ -0,0 +1,86 @@
+// Package signer signs the http requests to Alibaba Cloud with the credentials from a credentials provider.
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/aliyun/credentials-go/credentials/internal/utils"
+	"github.com/aliyun/credentials-go/credentials/providers"
+)
+
+// Signer signs the http request in place, the credentials are got from its credentials provider.
+type Signer interface {
+	Sign(ctx context.Context, req *http.Request) error
+}
+
+// the hook for test
+var getNonce = utils.GetNonce
+
+// nowOf returns the current time of clock, the system time is used if clock is nil
+func nowOf(clock providers.Clock) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+	return clock.Now()
+}
+
+// getCredentials gets the credentials from provider, the access key is required by the signature
+func getCredentials(ctx context.Context, provider providers.CredentialsProvider) (cc *providers.Credentials, err error) {
+	cc, err = providers.GetCredentialsWithContext(ctx, provider)
+	if err != nil {
+		return
+	}
+
+	if cc.AccessKeyId == "" || cc.AccessKeySecret == "" {
+		err = errors.New("the access key id or access key secret of the credentials is empty")
+		return
+	}
+	return
+}
+
+// readBody reads the body of req and resets it, so that the request can still be sent
+func readBody(req *http.Request) (body []byte, err error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+
+	body, err = ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return
+}
+
+func hashSHA256(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+// getHost returns the host of req, it is the value of the Host header sent by net/http
+func getHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+func hmacSHA256(key []byte, content string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(content))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,140 @@
+package signer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/aliyun/credentials-go/credentials/internal/utils"
+	"github.com/aliyun/credentials-go/credentials/providers"
+)
+
+// the max size of the error response which is read to check whether the credentials are expired
+const maxErrorBodySize = 64 * 1024
+
+// the error codes returned by the services when the credentials are expired
+var expiredCredentialsCodes = map[string]bool{
+	"InvalidSecurityToken.Expired": true,
+	"SecurityToken.Expired":        true,
+	"SecurityTokenExpired":         true,
+	"InvalidAccessKeyId.Expired":   true,
+}
+
+// credentialsProviderGetter is implemented by the signers of this package, so that Transport can invalidate the credentials
+type credentialsProviderGetter interface {
+	getCredentialsProvider() providers.CredentialsProvider
+}
+
+// Transport is an http.RoundTripper which signs every request with its signer before the request is sent.
+// When the service rejects the credentials as expired, the cached credentials of the credentials provider
+// are invalidated, and the request is signed with the new credentials and sent again once.
+// The body of the request is kept in memory for the signature and the retry.
+type Transport struct {
+	signer Signer
+	base   http.RoundTripper
+}
+
+type TransportBuilder struct {
+	transport *Transport
+}
+
+func NewTransportBuilder() *TransportBuilder {
+	return &TransportBuilder{
+		transport: &Transport{},
+	}
+}
+
+// WithSigner sets the signer, such as ACS3Signer, RPCSigner or OSSSigner
+func (builder *TransportBuilder) WithSigner(signer Signer) *TransportBuilder {
+	builder.transport.signer = signer
+	return builder
+}
+
+// WithBase sets the transport to send the signed requests, default is http.DefaultTransport
+func (builder *TransportBuilder) WithBase(base http.RoundTripper) *TransportBuilder {
+	builder.transport.base = base
+	return builder
+}
+
+func (builder *TransportBuilder) Build() (transport *Transport, err error) {
+	if builder.transport.signer == nil {
+		err = errors.New("the signer is nil")
+		return
+	}
+
+	if builder.transport.base == nil {
+		builder.transport.base = http.DefaultTransport
+	}
+
+	transport = builder.transport
+	return
+}
+
+// RoundTrip signs a copy of req and sends it, req is not modified
+func (transport *Transport) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	ctx := req.Context()
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return
+		}
+	}
+
+	for retried := false; ; retried = true {
+		signedReq := req.Clone(ctx)
+		if body != nil {
+			signedReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+			signedReq.GetBody = func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(body)), nil
+			}
+		}
+
+		err = transport.signer.Sign(ctx, signedReq)
+		if err != nil {
+			return
+		}
+
+		res, err = transport.base.RoundTrip(signedReq)
+		if err != nil || retried || !isExpiredCredentialsResponse(res) {
+			return
+		}
+
+		res.Body.Close()
+		if getter, ok := transport.signer.(credentialsProviderGetter); ok {
+			providers.InvalidateCredentials(getter.getCredentialsProvider())
+		}
+	}
+}
+
+// isExpiredCredentialsResponse checks the error code in the response, the body of res is kept for the caller
+func isExpiredCredentialsResponse(res *http.Response) bool {
+	if res.StatusCode != http.StatusBadRequest && res.StatusCode != http.StatusUnauthorized && res.StatusCode != http.StatusForbidden {
+		return false
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+	res.Body = &readCloser{
+		Reader: io.MultiReader(bytes.NewReader(content), res.Body),
+		Closer: res.Body,
+	}
+	if err != nil {
+		return false
+	}
+
+	code, message, _ := utils.ParseErrorBody(content)
+	if expiredCredentialsCodes[code] {
+		return true
+	}
+	message = strings.ToLower(message)
+	return strings.Contains(message, "security token") && strings.Contains(message, "expired")
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
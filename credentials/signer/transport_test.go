@@ -0,0 +1,147 @@
+package signer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aliyun/credentials-go/credentials/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+// rotatingProvider returns a new security token after it is invalidated
+type rotatingProvider struct {
+	version int32
+}
+
+func (provider *rotatingProvider) GetCredentials() (*providers.Credentials, error) {
+	return &providers.Credentials{
+		AccessKeyId:     "akid",
+		AccessKeySecret: "aksecret",
+		SecurityToken:   "token" + strconv.Itoa(int(atomic.LoadInt32(&provider.version))),
+		ProviderName:    provider.GetProviderName(),
+	}, nil
+}
+
+func (provider *rotatingProvider) GetProviderName() string {
+	return "rotating"
+}
+
+func (provider *rotatingProvider) Invalidate() {
+	atomic.AddInt32(&provider.version, 1)
+}
+
+func TestNewTransport(t *testing.T) {
+	_, err := NewTransportBuilder().Build()
+	assert.EqualError(t, err, "the signer is nil")
+
+	s, err := NewACS3SignerBuilder().WithCredentialsProvider(newStaticAKProvider(t)).Build()
+	assert.Nil(t, err)
+	transport, err := NewTransportBuilder().WithSigner(s).Build()
+	assert.Nil(t, err)
+	assert.Equal(t, http.DefaultTransport, transport.base)
+}
+
+func TestTransportRoundTrip(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.Header.Get("x-acs-security-token") {
+		case "token0":
+			w.WriteHeader(400)
+			w.Write([]byte(`{"RequestId":"reqid","Code":"InvalidSecurityToken.Expired","Message":"Specified SecurityToken is expired."}`))
+		case "token1":
+			assert.NotEqual(t, "", r.Header.Get("Authorization"))
+			w.Write(body)
+		default:
+			w.WriteHeader(403)
+			w.Write([]byte(`{"RequestId":"reqid","Code":"Forbidden.RAM","Message":"User not authorized to operate on the specified resource."}`))
+		}
+	}))
+	defer server.Close()
+
+	provider := &rotatingProvider{}
+	s, err := NewACS3SignerBuilder().WithCredentialsProvider(provider).Build()
+	assert.Nil(t, err)
+	transport, err := NewTransportBuilder().WithSigner(s).Build()
+	assert.Nil(t, err)
+	client := &http.Client{Transport: transport}
+
+	// the expired credentials are invalidated and the request is sent again
+	req, err := http.NewRequest("POST", server.URL+"/?RegionId=cn-hangzhou", strings.NewReader("body"))
+	assert.Nil(t, err)
+	res, err := client.Do(req)
+	assert.Nil(t, err)
+	content, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "body", string(content))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&provider.version))
+	// the request of the caller is not modified
+	assert.Equal(t, "", req.Header.Get("Authorization"))
+
+	// the other errors are returned to the caller with the body
+	atomic.StoreInt32(&provider.version, 2)
+	req, err = http.NewRequest("GET", server.URL, nil)
+	assert.Nil(t, err)
+	res, err = client.Do(req)
+	assert.Nil(t, err)
+	content, err = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, 403, res.StatusCode)
+	assert.Equal(t, `{"RequestId":"reqid","Code":"Forbidden.RAM","Message":"User not authorized to operate on the specified resource."}`, string(content))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&count))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&provider.version))
+}
+
+func TestTransportRetryOnce(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.WriteHeader(403)
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Error>
+  <Code>InvalidAccessKeyId</Code>
+  <Message>The security token you provided has expired.</Message>
+</Error>`))
+	}))
+	defer server.Close()
+
+	provider := &rotatingProvider{}
+	s, err := NewOSSSignerBuilder().WithCredentialsProvider(provider).WithRegion("cn-hangzhou").Build()
+	assert.Nil(t, err)
+	transport, err := NewTransportBuilder().WithSigner(s).WithBase(http.DefaultTransport).Build()
+	assert.Nil(t, err)
+
+	req, err := http.NewRequest("PUT", server.URL+"/bucket/object", strings.NewReader("content"))
+	assert.Nil(t, err)
+	res, err := transport.RoundTrip(req)
+	assert.Nil(t, err)
+	content, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, 403, res.StatusCode)
+	assert.True(t, strings.Contains(string(content), "<Code>InvalidAccessKeyId</Code>"))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&provider.version))
+}
+
+func TestTransportSignError(t *testing.T) {
+	s, err := NewACS3SignerBuilder().WithCredentialsProvider(&errorProvider{}).Build()
+	assert.Nil(t, err)
+	transport, err := NewTransportBuilder().WithSigner(s).Build()
+	assert.Nil(t, err)
+
+	req, err := http.NewRequest("GET", "http://localhost/", nil)
+	assert.Nil(t, err)
+	_, err = transport.RoundTrip(req)
+	assert.EqualError(t, err, "get credentials failed")
+}
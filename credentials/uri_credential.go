@@ -49,6 +49,7 @@ func (e *URLCredentialsProvider) GetCredential() (*CredentialModel, error) {
 		AccessKeySecret: tea.String(e.sessionCredential.AccessKeySecret),
 		SecurityToken:   tea.String(e.sessionCredential.SecurityToken),
 		Type:            tea.String("credential_uri"),
+		Expiration:      e.expiration(),
 	}
 	return credential, nil
 }
@@ -105,20 +106,20 @@ func (e *URLCredentialsProvider) updateCredential() (err error) {
 	request.Method = "GET"
 	content, err := doAction(request, e.runtime)
 	if err != nil {
-		return fmt.Errorf("get credentials from %s failed with error: %s", e.URL, err.Error())
+		return fmt.Errorf("get credentials from %s failed with error: %w", e.URL, err)
 	}
 	var resp *URLResponse
 	err = json.Unmarshal(content, &resp)
 	if err != nil {
-		return fmt.Errorf("get credentials from %s failed with error, json unmarshal fail: %s", e.URL, err.Error())
+		return fmt.Errorf("get credentials from %s failed with error, json unmarshal fail: %w", e.URL, err)
 	}
 	if resp.AccessKeyId == "" || resp.AccessKeySecret == "" || resp.SecurityToken == "" || resp.Expiration == "" {
 		return fmt.Errorf("get credentials failed: AccessKeyId: %s, AccessKeySecret: %s, SecurityToken: %s, Expiration: %s", resp.AccessKeyId, resp.AccessKeySecret, resp.SecurityToken, resp.Expiration)
 	}
 
 	expirationTime, err := time.Parse("2006-01-02T15:04:05Z", resp.Expiration)
-	e.lastUpdateTimestamp = time.Now().Unix()
-	e.credentialExpiration = int(expirationTime.Unix() - time.Now().Unix())
+	e.lastUpdateTimestamp = e.now().Unix()
+	e.credentialExpiration = int(expirationTime.Unix() - e.now().Unix())
 	e.sessionCredential = &sessionCredential{
 		AccessKeyId:     resp.AccessKeyId,
 		AccessKeySecret: resp.AccessKeySecret,
@@ -3,7 +3,7 @@ module github.com/aliyun/credentials-go
 go 1.14
 
 require (
-	github.com/alibabacloud-go/debug v1.0.1
+	github.com/alibabacloud-go/debug v1.0.1 // indirect
 	github.com/alibabacloud-go/tea v1.2.2
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
 	github.com/stretchr/testify v1.5.1